@@ -0,0 +1,143 @@
+package luna
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var updateAPI = flag.Bool("update-api", false, "rewrite testdata/api.txt with the current exported API")
+
+// TestExportedAPI compares the exported API of the package against the golden
+// file in testdata/api.txt, so that changes to the public surface are always
+// deliberate. Run `go test -run TestExportedAPI -update-api` to accept them.
+func TestExportedAPI(t *testing.T) {
+	golden := filepath.Join("testdata", "api.txt")
+	got := exportedAPI(t)
+
+	if *updateAPI {
+		if err := os.WriteFile(golden, []byte(strings.Join(got, "\n")+"\n"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", golden, err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", golden, err)
+	}
+	want := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	gotSet := make(map[string]bool, len(got))
+	for _, line := range got {
+		gotSet[line] = true
+	}
+	wantSet := make(map[string]bool, len(want))
+	for _, line := range want {
+		wantSet[line] = true
+	}
+
+	for _, line := range want {
+		if !gotSet[line] {
+			t.Errorf("removed or changed: %s", line)
+		}
+	}
+	for _, line := range got {
+		if !wantSet[line] {
+			t.Errorf("added: %s", line)
+		}
+	}
+	if t.Failed() {
+		t.Log("if the change is intended, run: go test -run TestExportedAPI -update-api")
+	}
+}
+
+// exportedAPI returns one sorted line per exported declaration of the package:
+// functions, methods on exported types, types, struct fields, vars and consts.
+func exportedAPI(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var api []string
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", name, err)
+		}
+
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if !d.Name.IsExported() {
+					continue
+				}
+				if d.Recv == nil {
+					api = append(api, "func "+d.Name.Name+strings.TrimPrefix(types.ExprString(d.Type), "func"))
+					continue
+				}
+				recv := types.ExprString(d.Recv.List[0].Type)
+				if !ast.IsExported(strings.TrimPrefix(recv, "*")) {
+					continue
+				}
+				api = append(api, fmt.Sprintf("method (%s) %s%s", recv, d.Name.Name, strings.TrimPrefix(types.ExprString(d.Type), "func")))
+			case *ast.GenDecl:
+				api = append(api, exportedSpecs(d)...)
+			}
+		}
+	}
+
+	sort.Strings(api)
+	return api
+}
+
+func exportedSpecs(d *ast.GenDecl) []string {
+	var api []string
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			if !s.Name.IsExported() {
+				continue
+			}
+			st, ok := s.Type.(*ast.StructType)
+			if !ok {
+				api = append(api, "type "+s.Name.Name+" "+types.ExprString(s.Type))
+				continue
+			}
+			api = append(api, "type "+s.Name.Name+" struct")
+			for _, field := range st.Fields.List {
+				for _, n := range field.Names {
+					if n.IsExported() {
+						api = append(api, fmt.Sprintf("field %s.%s %s", s.Name.Name, n.Name, types.ExprString(field.Type)))
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for _, n := range s.Names {
+				if !n.IsExported() {
+					continue
+				}
+				line := d.Tok.String() + " " + n.Name
+				if s.Type != nil {
+					line += " " + types.ExprString(s.Type)
+				}
+				api = append(api, line)
+			}
+		}
+	}
+	return api
+}
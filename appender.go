@@ -0,0 +1,146 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Size of the INSERT statement above which an Appender sends its rows.
+const appenderFlushSize = 1 << 20
+
+// Appender loads rows into a table, buffering them client-side and inserting them
+// with a multi-row INSERT statement of up to about a megabyte at a time, which is
+// far faster than an INSERT per row. Create it with NewAppender, add rows with
+// AppendRow and call Close to insert the rest. An Appender isn't safe for
+// concurrent use.
+//
+// It isn't a bulk-load path: the server has no command to receive Arrow record
+// batches, so rows are sent as SQL literals, there are no typed per-column
+// Append methods, and loads are bounded by the server's INSERT speed.
+type Appender struct {
+	ctx     context.Context
+	conn    *sql.Conn
+	table   string
+	columns []string
+	// Number of values in each row, set by the first row if no columns were given.
+	width int
+
+	// Statement with the buffered rows, or empty, and the number of rows in it.
+	stmt strings.Builder
+	rows int
+	// Number of rows inserted so far.
+	inserted int64
+	// Size of the statement at which the rows are sent.
+	flushSize int
+	closed    bool
+}
+
+// NewAppender returns an Appender that inserts rows into table on conn, a
+// connection from a database/sql pool opened with the luna driver. Table may be
+// qualified with a schema, e.g. "main.events". With columns, rows hold values for
+// these columns only; without, for every column of the table in order. As with a
+// transaction, ctx applies to the appender's whole life: the statements sent
+// once it's done fail. Rows are inserted with multi-row INSERT statements of up
+// to about a megabyte, not with a bulk-load command, which the server lacks.
+func NewAppender(ctx context.Context, conn *sql.Conn, table string, columns ...string) (*Appender, error) {
+	for _, part := range strings.Split(table, ".") {
+		if !isSQLIdentifier(part) {
+			return nil, fmt.Errorf("luna: invalid table name %q", table)
+		}
+	}
+	for _, col := range columns {
+		if col == "" {
+			return nil, fmt.Errorf("luna: empty column name for table %s", table)
+		}
+	}
+
+	return &Appender{
+		ctx:       ctx,
+		conn:      conn,
+		table:     table,
+		columns:   columns,
+		width:     len(columns),
+		flushSize: appenderFlushSize,
+	}, nil
+}
+
+// AppendRow adds a row. Values may be nil for NULL, booleans, integers, floats,
+// strings, []byte, time.Time, UUIDs, sql.Null* types or other driver.Valuer
+// types, or pointers to them. Rows are sent once enough have been buffered; an
+// error from sending them is returned here, and those rows are lost.
+func (a *Appender) AppendRow(values ...any) error {
+	if a.closed {
+		return fmt.Errorf("luna: appender for %s is closed", a.table)
+	}
+	if a.width == 0 {
+		a.width = len(values)
+	}
+	if len(values) != a.width || len(values) == 0 {
+		return fmt.Errorf("luna: appending to %s: expected %d values, got %d", a.table, a.width, len(values))
+	}
+
+	// Values are formatted before the row is added, so a bad value leaves no
+	// partial row in the statement
+	lits := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			lits[i] = "NULL"
+			continue
+		}
+		lit, err := sqlLiteral(reflect.ValueOf(v))
+		if err != nil {
+			return fmt.Errorf("luna: appending to %s: value %d: %w", a.table, i, err)
+		}
+		lits[i] = lit
+	}
+
+	if a.rows == 0 {
+		a.stmt.WriteString("INSERT INTO " + a.table)
+		if len(a.columns) > 0 {
+			quoted := make([]string, len(a.columns))
+			for i, col := range a.columns {
+				quoted[i] = quoteSQLIdentifier(col)
+			}
+			a.stmt.WriteString(" (" + strings.Join(quoted, ", ") + ")")
+		}
+		a.stmt.WriteString(" VALUES ")
+	} else {
+		a.stmt.WriteString(", ")
+	}
+	a.stmt.WriteString("(" + strings.Join(lits, ", ") + ")")
+	a.rows++
+
+	if a.stmt.Len() >= a.flushSize {
+		return a.Flush()
+	}
+	return nil
+}
+
+// Flush inserts the buffered rows. They're dropped even if the insert fails.
+func (a *Appender) Flush() error {
+	if a.rows == 0 {
+		return nil
+	}
+
+	stmt, rows := a.stmt.String(), a.rows
+	a.stmt.Reset()
+	a.rows = 0
+	if _, err := a.conn.ExecContext(a.ctx, stmt); err != nil {
+		return fmt.Errorf("luna: appending %d rows to %s: %w", rows, a.table, err)
+	}
+	a.inserted += int64(rows)
+	return nil
+}
+
+// Close inserts the buffered rows. The appender can't be used afterwards; the
+// connection stays open.
+func (a *Appender) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	return a.Flush()
+}
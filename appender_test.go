@@ -0,0 +1,101 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestAppender(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			if cmd == "x:INSERT INTO missing VALUES (1)" {
+				conn.Write([]byte("-Catalog Error: Table with name missing does not exist!\r\n"))
+				continue
+			}
+			conn.Write([]byte(":2\r\n"))
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	a, err := NewAppender(ctx, conn, "main.t", "id", "name")
+	if err != nil {
+		t.Fatalf("NewAppender failed: %v", err)
+	}
+	// Sent once the statement reaches the flush size
+	a.flushSize = 70
+
+	name := "o'brien"
+	rows := [][]any{{1, "a"}, {int64(2), nil}, {3, &name}, {4, sql.NullString{}}}
+	for _, row := range rows {
+		if err := a.AppendRow(row...); err != nil {
+			t.Fatalf("AppendRow failed: %v", err)
+		}
+	}
+	if err := a.AppendRow(5); err == nil {
+		t.Error("expected an error for a row with too few values")
+	}
+	if err := a.AppendRow(5, struct{}{}); err == nil {
+		t.Error("expected an error for an unsupported value")
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := a.AppendRow(6, "f"); err == nil {
+		t.Error("expected an error after Close")
+	}
+
+	expected := []string{
+		`x:INSERT INTO main.t ("id", "name") VALUES (1, 'a'), (2, NULL), (3, 'o''brien')`,
+		`x:INSERT INTO main.t ("id", "name") VALUES (4, NULL)`,
+	}
+	var got []string
+	for range expected {
+		got = append(got, <-commands)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected commands %q, got %q", expected, got)
+	}
+
+	// Without columns, the first row sets the number of values
+	a, err = NewAppender(ctx, conn, "missing")
+	if err != nil {
+		t.Fatalf("NewAppender failed: %v", err)
+	}
+	if err := a.AppendRow(1); err != nil {
+		t.Fatalf("AppendRow failed: %v", err)
+	}
+	if err := a.AppendRow(1, 2); err == nil {
+		t.Error("expected an error for a row with too many values")
+	}
+	var lerr *Error
+	if err := a.Close(); !errors.As(err, &lerr) || lerr.Code != "Catalog" {
+		t.Errorf("expected a Catalog error, got %v", err)
+	}
+
+	if _, err := NewAppender(ctx, conn, "t; DROP TABLE t"); err == nil {
+		t.Error("expected an error for an invalid table name")
+	}
+}
@@ -0,0 +1,92 @@
+package luna
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// interpolateArgs replaces the placeholders of query, ? for the next argument or
+// $N for the Nth, with the arguments as SQL literals, since the protocol has no
+// way to send them separately. Placeholders in quoted strings, including dollar
+// quoted ones, quoted identifiers and comments are left alone. It returns an error for named
+// arguments, and unless every argument is used and every placeholder has one.
+func interpolateArgs(query string, args []driver.NamedValue) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+
+	literals := make([]string, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return "", fmt.Errorf("luna: named argument %s isn't supported, use ? or $N placeholders", arg.Name)
+		}
+		if arg.Value == nil {
+			literals[i] = "NULL"
+			continue
+		}
+		lit, err := sqlLiteral(reflect.ValueOf(arg.Value))
+		if err != nil {
+			return "", fmt.Errorf("luna: argument %d: %w", i+1, err)
+		}
+		literals[i] = lit
+	}
+
+	var b strings.Builder
+	used := make([]bool, len(args))
+	next := 0
+	start := 0
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == '\'' || c == '"':
+			i = skipSQLQuoted(query, i)
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(query)
+			}
+		case c == '?':
+			if next >= len(args) {
+				return "", fmt.Errorf("luna: query has more placeholders than the %d arguments", len(args))
+			}
+			b.WriteString(query[start:i])
+			b.WriteString(literals[next])
+			used[next] = true
+			next++
+			i++
+			start = i
+		case c == '$' && isSQLDollarQuote(query, i):
+			i = skipSQLDollarQuoted(query, i)
+		case c == '$' && i+1 < len(query) && isSQLDigit(query[i+1]):
+			end := i + 1
+			for end < len(query) && isSQLDigit(query[end]) {
+				end++
+			}
+			n, err := strconv.Atoi(query[i+1 : end])
+			if err != nil || n < 1 || n > len(args) {
+				return "", fmt.Errorf("luna: placeholder %s has no argument", query[i:end])
+			}
+			b.WriteString(query[start:i])
+			b.WriteString(literals[n-1])
+			used[n-1] = true
+			i, start = end, end
+		default:
+			i++
+		}
+	}
+	b.WriteString(query[start:])
+
+	for i, ok := range used {
+		if !ok {
+			return "", fmt.Errorf("luna: argument %d has no placeholder", i+1)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,177 @@
+package luna
+
+import (
+	"bufio"
+	"database/sql"
+	"database/sql/driver"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterpolateArgs(t *testing.T) {
+	args := func(values ...any) []driver.NamedValue {
+		nvs := make([]driver.NamedValue, len(values))
+		for i, v := range values {
+			nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+		}
+		return nvs
+	}
+
+	testCases := []struct {
+		name     string
+		query    string
+		args     []driver.NamedValue
+		expected string
+		err      string
+	}{
+		{
+			name:     "no args",
+			query:    "SELECT '?' FROM t WHERE a = ?",
+			expected: "SELECT '?' FROM t WHERE a = ?",
+		},
+		{
+			name:     "question marks",
+			query:    "SELECT * FROM t WHERE a = ? AND b = ? AND c IS ?",
+			args:     args(int64(1), "it's", nil),
+			expected: "SELECT * FROM t WHERE a = 1 AND b = 'it''s' AND c IS NULL",
+		},
+		{
+			name:     "positional",
+			query:    "SELECT $2, $1, $2",
+			args:     args(true, 1.5),
+			expected: "SELECT 1.5, TRUE, 1.5",
+		},
+		{
+			name:     "quoted and comments",
+			query:    "SELECT '?', \"a?\", ? -- ?\n/* $1 ? */",
+			args:     args([]byte{0x01, 0xff}),
+			expected: "SELECT '?', \"a?\", '\\x01\\xFF'::BLOB -- ?\n/* $1 ? */",
+		},
+		{
+			name:     "dollar quotes",
+			query:    "SELECT $$it's $1?$$, $tag$ ? $$ $2 $tag$, $1, $2",
+			args:     args(int64(1), "b"),
+			expected: "SELECT $$it's $1?$$, $tag$ ? $$ $2 $tag$, 1, 'b'",
+		},
+		{
+			name:     "quotes and comments around blobs",
+			query:    "INSERT INTO t VALUES (? /* ? */, '$1', ?) -- ?",
+			args:     args([]byte("a'b"), []byte{}),
+			expected: "INSERT INTO t VALUES ('\\x61\\x27\\x62'::BLOB /* ? */, '$1', ''::BLOB) -- ?",
+		},
+		{
+			name:  "unterminated dollar quote",
+			query: "SELECT $q$ ?",
+			args:  args(int64(1)),
+			err:   "argument 1 has no placeholder",
+		},
+		{
+			name:     "time",
+			query:    "SELECT * FROM t WHERE ts < ?",
+			args:     args(time.Date(2025, 1, 2, 4, 4, 5, 0, time.FixedZone("", 3600))),
+			expected: "SELECT * FROM t WHERE ts < '2025-01-02 03:04:05'",
+		},
+		{
+			name:  "too many placeholders",
+			query: "SELECT ?, ?",
+			args:  args(int64(1)),
+			err:   "more placeholders than the 1 arguments",
+		},
+		{
+			name:  "unused argument",
+			query: "SELECT ?",
+			args:  args(int64(1), int64(2)),
+			err:   "argument 2 has no placeholder",
+		},
+		{
+			name:  "positional out of range",
+			query: "SELECT $3",
+			args:  args(int64(1)),
+			err:   "placeholder $3 has no argument",
+		},
+		{
+			name:  "named",
+			query: "SELECT @id",
+			args:  []driver.NamedValue{{Name: "id", Ordinal: 1, Value: int64(1)}},
+			err:   "named argument id isn't supported",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, err := interpolateArgs(tc.query, tc.args)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error with %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("interpolateArgs failed: %v", err)
+			}
+			if query != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, query)
+			}
+		})
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			if strings.HasPrefix(cmd, "x:") {
+				conn.Write([]byte(":1\r\n"))
+				continue
+			}
+			conn.Write(arrowReply(t, "n", 1))
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	// UUIDs are converted by CheckNamedValue, decimals by their Value method
+	price := Decimal{Coefficient: big.NewInt(12345), Exp: -2}
+	if _, err := db.Exec("INSERT INTO items VALUES (?, ?)", testUUID, price); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	expected := "x:INSERT INTO items VALUES ('" + testUUIDString + "', '123.45')"
+	if got := <-commands; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	stmt, err := db.Prepare("SELECT n FROM t WHERE id = $1")
+	if err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+	defer stmt.Close()
+	var n int64
+	if err := stmt.QueryRow(42).Scan(&n); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if got := <-commands; got != "q:SELECT n FROM t WHERE id = 42" {
+		t.Errorf("unexpected command %q", got)
+	}
+
+	if _, err := db.Exec("DELETE FROM t WHERE id = ?"); err != nil {
+		t.Fatalf("exec without args failed: %v", err)
+	}
+	if got := <-commands; got != "x:DELETE FROM t WHERE id = ?" {
+		t.Errorf("expected the statement unchanged without arguments, got %q", got)
+	}
+	if _, err := db.Exec("DELETE FROM t", 1); err == nil {
+		t.Error("expected an error for an argument without a placeholder")
+	}
+}
@@ -0,0 +1,196 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/arrio"
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// QueryArrow runs a query and returns its result as the Arrow record batches
+// decoded from the server's reply, without converting each value to a
+// driver.Value, e.g. to hand them to Arrow compute kernels or a Parquet writer.
+// The result is read in full before QueryArrow returns. A query of several
+// statements returns the result of the last one, and fails if any of them does.
+// The caller must release the reader; records retained from it stay valid after
+// that.
+func (c *Conn) QueryArrow(ctx context.Context, query string) (*RecordReader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.beforeQuery(ctx, "arrow", query)
+	if err != nil {
+		return nil, err
+	}
+	mem := newTrackingAllocator(c.mem)
+	reader, err := c.queryArrowReader(ctx, e.Query, mem)
+	var rows int64
+	if reader != nil {
+		rows = countRows(reader.records)
+	}
+	e.Memory = mem.stats()
+	c.afterQuery(ctx, e, rows, err)
+	return reader, err
+}
+
+// queryArrowReader runs a query for QueryArrow, decoding its result with mem.
+// The caller must hold c.mu.
+func (c *Conn) queryArrowReader(ctx context.Context, query string, mem *trackingAllocator) (*RecordReader, error) {
+	if c.closed || c.bad {
+		return nil, errBadConn
+	}
+
+	query = c.dialect.translate(query)
+	c.logger.Debug("QueryArrow called", c.queryAttr(query))
+
+	start := c.clock.Now()
+	replies, err := c.queryStatements(ctx, query, mem)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range replies {
+		if r.err != nil {
+			// Records sent before a failure partway are dropped
+			releaseStatementResults(replies)
+			return nil, r.err
+		}
+	}
+	// The result of a multi-statement query is that of its last statement
+	last := replies[len(replies)-1]
+	releaseStatementResults(replies[:len(replies)-1])
+	schema, records := last.schema, last.records
+	if err := c.checkSchema(last.query, schema); err != nil {
+		wire.ReleaseRecords(records)
+		return nil, err
+	}
+	schema, records, err = applyClientSide(ctx, schema, records, mem)
+	if err != nil {
+		return nil, err
+	}
+	c.statementDone(ctx, query, start, countRows(records), last.stats, mem.stats())
+	return newRecordReader(schema, records), nil
+}
+
+// QueryArrow runs a query on a connection from a database/sql pool opened with the
+// luna driver, and returns its result as Arrow record batches. See Conn.QueryArrow.
+func QueryArrow(ctx context.Context, conn *sql.Conn, query string) (*RecordReader, error) {
+	var reader *RecordReader
+	err := conn.Raw(func(dc any) error {
+		c, ok := dc.(*Conn)
+		if !ok {
+			return fmt.Errorf("luna: QueryArrow needs a luna connection, got %T", dc)
+		}
+
+		var err error
+		reader, err = c.QueryArrow(ctx, query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// RecordReader reads the record batches of a query result, in the order the
+// server sent them. It implements array.RecordReader, and arrio.Reader so that
+// arrio.Copy can pipe a result into an ipc.Writer or a pqarrow writer.
+type RecordReader struct {
+	refs    atomic.Int64
+	schema  *arrow.Schema
+	records []arrow.Record
+	next    int
+	cur     arrow.Record
+}
+
+var (
+	_ array.RecordReader = (*RecordReader)(nil)
+	_ arrio.Reader       = (*RecordReader)(nil)
+)
+
+// newRecordReader creates a reader over records, taking over the caller's
+// references to them. If schema is nil, the schema of the first record is used.
+func newRecordReader(schema *arrow.Schema, records []arrow.Record) *RecordReader {
+	if schema == nil && len(records) > 0 {
+		schema = records[0].Schema()
+	}
+	if schema == nil {
+		// No result set, e.g. a null reply
+		schema = arrow.NewSchema(nil, nil)
+	}
+
+	r := &RecordReader{schema: schema, records: records}
+	r.refs.Store(1)
+	return r
+}
+
+// Retain increases the reference count of the reader.
+func (r *RecordReader) Retain() {
+	r.refs.Add(1)
+}
+
+// Release decreases the reference count of the reader, and releases the records
+// it holds when the count reaches zero.
+func (r *RecordReader) Release() {
+	if r.refs.Add(-1) != 0 {
+		return
+	}
+
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	wire.ReleaseRecords(r.records[r.next:])
+	r.records = nil
+	r.next = 0
+}
+
+// Schema returns the schema of the result.
+func (r *RecordReader) Schema() *arrow.Schema {
+	return r.schema
+}
+
+// Next moves to the next record batch, releasing the current one, and reports
+// whether there is one.
+func (r *RecordReader) Next() bool {
+	if r.cur != nil {
+		r.cur.Release()
+		r.cur = nil
+	}
+	if r.next >= len(r.records) {
+		return false
+	}
+
+	r.cur = r.records[r.next]
+	r.records[r.next] = nil
+	r.next++
+	return true
+}
+
+// Record returns the current record batch. It's valid until the next call to
+// Next or Read, unless the caller retains it.
+func (r *RecordReader) Record() arrow.Record {
+	return r.cur
+}
+
+// Err always returns nil, since results are read in full before the reader is
+// created.
+func (r *RecordReader) Err() error {
+	return nil
+}
+
+// Read moves to the next record batch and returns it, or io.EOF at the end of
+// the result. The record is valid until the next call to Next or Read, unless
+// the caller retains it.
+func (r *RecordReader) Read() (arrow.Record, error) {
+	if !r.Next() {
+		return nil, io.EOF
+	}
+	return r.cur, nil
+}
@@ -0,0 +1,175 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/arrio"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestQueryArrow(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			if cmd != "q:SELECT n FROM t" {
+				conn.Write([]byte("$-1\r\n"))
+				continue
+			}
+
+			schema := arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil)
+			var recs []arrow.Record
+			for batch := 0; batch < 2; batch++ {
+				b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+				b.Field(0).(*array.Int64Builder).AppendValues([]int64{int64(batch*2 + 1), int64(batch*2 + 2)}, nil)
+				recs = append(recs, b.NewRecord())
+				b.Release()
+			}
+			writeArrowReply(conn, schema, recs...)
+			releaseTestRecords(recs)
+		}
+	})
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	connector, err := NewConnectorWithOptions(addr, WithAllocator(mem))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	reader, err := QueryArrow(context.Background(), conn, "SELECT n FROM t")
+	if err != nil {
+		t.Fatalf("QueryArrow failed: %v", err)
+	}
+
+	if reader.Schema().NumFields() != 1 || reader.Schema().Field(0).Name != "n" {
+		t.Errorf("unexpected schema %v", reader.Schema())
+	}
+
+	// Batches are returned as decoded, without being merged
+	var values []int64
+	batches := 0
+	for reader.Next() {
+		batches++
+		values = append(values, reader.Record().Column(0).(*array.Int64).Int64Values()...)
+	}
+	if batches != 2 || len(values) != 4 || values[0] != 1 || values[3] != 4 {
+		t.Errorf("expected 2 batches with values 1..4, got %d batches with %v", batches, values)
+	}
+	reader.Release()
+
+	// Replies without a result set yield an empty reader
+	reader, err = QueryArrow(context.Background(), conn, "SELECT NULL")
+	if err != nil {
+		t.Fatalf("QueryArrow failed: %v", err)
+	}
+	if reader.Next() || reader.Schema().NumFields() != 0 {
+		t.Error("expected an empty result")
+	}
+	reader.Release()
+}
+
+func TestRowsRecordReader(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	schema := arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	var recs []arrow.Record
+	for batch := 0; batch < 2; batch++ {
+		b := array.NewRecordBuilder(mem, schema)
+		b.Field(0).(*array.Int64Builder).AppendValues([]int64{int64(batch*2 + 1), int64(batch*2 + 2)}, nil)
+		recs = append(recs, b.NewRecord())
+		b.Release()
+	}
+	rows := newRowsFromArrow(schema, recs)
+
+	// The reader starts after the rows already read
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	reader := rows.RecordReader()
+	rows.Close()
+
+	// Pipe the rest of the result into an IPC stream and read it back
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(reader.Schema()), ipc.WithAllocator(mem))
+	if _, err := arrio.Copy(w, reader); err != nil {
+		t.Fatalf("arrio.Copy failed: %v", err)
+	}
+	w.Close()
+	reader.Release()
+
+	ipcReader, err := ipc.NewReader(&buf, ipc.WithAllocator(mem))
+	if err != nil {
+		t.Fatalf("failed to read IPC stream: %v", err)
+	}
+	defer ipcReader.Release()
+
+	var values []int64
+	for ipcReader.Next() {
+		values = append(values, ipcReader.Record().Column(0).(*array.Int64).Int64Values()...)
+	}
+	if len(values) != 3 || values[0] != 2 || values[2] != 4 {
+		t.Errorf("expected values 2..4, got %v", values)
+	}
+}
+
+func TestRecordReaderRelease(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	schema := arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	b := array.NewRecordBuilder(mem, schema)
+	b.Field(0).(*array.Int64Builder).Append(1)
+	rec := b.NewRecord()
+	b.Release()
+
+	reader := newRecordReader(nil, []arrow.Record{rec})
+	if reader.Schema() != schema {
+		t.Errorf("expected the schema of the first record, got %v", reader.Schema())
+	}
+
+	// Records stay allocated until the last reference is released
+	reader.Retain()
+	reader.Release()
+	if mem.CurrentAlloc() == 0 {
+		t.Fatal("records released while the reader is still referenced")
+	}
+
+	got, err := reader.Read()
+	if err != nil || got.NumRows() != 1 {
+		t.Fatalf("expected a record with 1 row, got %v, %v", got, err)
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	reader.Release()
+}
+
+func releaseTestRecords(recs []arrow.Record) {
+	for _, rec := range recs {
+		rec.Release()
+	}
+}
@@ -29,7 +29,7 @@ func authenticate(conn net.Conn, password string) error {
 		// No authentication required or error
 		if firstByte == '-' {
 			errMsg, _ := reader.ReadString('\n')
-			return fmt.Errorf("auth error: %s", strings.TrimSpace(errMsg))
+			return fmt.Errorf("%w: %s", ErrAuthFailed, strings.TrimSpace(errMsg))
 		}
 		// Put the byte back and continue without auth
 		return nil
@@ -66,7 +66,7 @@ func authenticate(conn net.Conn, password string) error {
 		return nil
 	case '-': // Error
 		errMsg, _ := reader.ReadString('\n')
-		return fmt.Errorf("authentication failed: %s", strings.TrimSpace(errMsg))
+		return fmt.Errorf("%w: %s", ErrAuthFailed, strings.TrimSpace(errMsg))
 	default:
 		return fmt.Errorf("unexpected auth response: %c", resultByte)
 	}
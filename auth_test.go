@@ -0,0 +1,96 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"testing"
+)
+
+// newAuthServer starts a fake server that sends an authentication challenge on
+// connect, answers the response with result, then answers queries with 1. An
+// empty result closes the connection instead.
+func newAuthServer(t *testing.T, result string) string {
+	return newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		conn.Write([]byte("+challenge\r\n"))
+		if _, err := readCommand(reader); err != nil {
+			return
+		}
+		if result == "" {
+			conn.Close()
+			return
+		}
+		conn.Write([]byte(result))
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write(arrowReply(t, "1", 1))
+		}
+	})
+}
+
+func TestAuthFailures(t *testing.T) {
+	testCases := []struct {
+		name     string
+		result   string
+		password string
+		authErr  bool
+		netErr   bool
+	}{
+		{name: "accepted", result: "+OK\r\n", password: "secret"},
+		{name: "rejected", result: "-invalid password\r\n", password: "wrong", authErr: true},
+		{name: "interrupted", result: "", password: "secret", netErr: true},
+		// The challenge is taken for the reply to the ping
+		{name: "no password", result: "+OK\r\n", authErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := newAuthServer(t, tc.result)
+			dsn := addr
+			if tc.password != "" {
+				dsn = "user:" + tc.password + "@" + addr
+			}
+			connector, err := NewConnector(dsn, nil)
+			if err != nil {
+				t.Fatalf("failed to create connector: %v", err)
+			}
+
+			err = connector.HealthCheck(context.Background())
+			switch {
+			case tc.authErr:
+				if !errors.Is(err, ErrAuthFailed) {
+					t.Errorf("expected ErrAuthFailed, got %v", err)
+				}
+			case tc.netErr:
+				if errors.Is(err, ErrAuthFailed) || !isNetworkError(err) {
+					t.Errorf("expected a network error, got %v", err)
+				}
+			case err != nil:
+				t.Errorf("HealthCheck failed: %v", err)
+			}
+
+			// Pinging through database/sql reports the same failures
+			db := sql.OpenDB(connector)
+			defer db.Close()
+			if pingErr := db.PingContext(context.Background()); errors.Is(pingErr, ErrAuthFailed) != tc.authErr {
+				t.Errorf("expected Ping to match ErrAuthFailed: %v, got %v", tc.authErr, pingErr)
+			}
+		})
+	}
+}
+
+func TestAuthRejectedMessage(t *testing.T) {
+	addr := newAuthServer(t, "-invalid password\r\n")
+	connector, err := NewConnector("user:wrong@"+addr, nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	_, err = connector.Connect(context.Background())
+	if err == nil || err.Error() != "luna: authentication failed: invalid password" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package luna
+
+import (
+	"time"
+
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// BatchStat describes a record batch of a result as it was received, before any
+// client-side filter, as returned by Rows.BatchStats.
+type BatchStat struct {
+	// Rows is the number of rows in the batch.
+	Rows int64
+	// Bytes is the size of the batch in the Arrow stream, including its
+	// metadata.
+	Bytes int64
+	// Decode is the time spent reading and decoding the batch, including waiting
+	// for its bytes to arrive.
+	Decode time.Duration
+}
+
+// BatchStats returns the stats of each record batch received for the current
+// result set, in order, e.g. to tune the server's batch size and the read buffer
+// size. All batches are received before the first row is returned, so the stats
+// are complete from the start. It's available through sql.Conn.Raw.
+func (r *Rows) BatchStats() []BatchStat {
+	return r.batches
+}
+
+func appendBatchStats(stats []BatchStat, batches []wire.BatchStat) []BatchStat {
+	for _, b := range batches {
+		stats = append(stats, BatchStat(b))
+	}
+	return stats
+}
@@ -0,0 +1,77 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"net"
+	"testing"
+)
+
+func TestRowsBatchStats(t *testing.T) {
+	replies := map[string][]byte{
+		"q:SELECT n FROM t": batchesReply(t, 1, 2, 3),
+		"q:SELECT n FROM u": arrowReply(t, "n", 1, 2, 3, 4),
+	}
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			for _, stmt := range statementCommands(cmd) {
+				conn.Write(replies[stmt])
+			}
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	dr, err := conn.QueryContext(context.Background(), "SELECT n FROM t; SELECT n FROM u", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := dr.(*Rows)
+	defer rows.Close()
+
+	testCases := []struct {
+		query   string
+		reply   []byte
+		batches []int64
+	}{
+		{"SELECT n FROM t", replies["q:SELECT n FROM t"], []int64{1, 1, 1}},
+		{"SELECT n FROM u", replies["q:SELECT n FROM u"], []int64{4}},
+	}
+
+	for i, tc := range testCases {
+		if i > 0 {
+			if err := rows.NextResultSet(); err != nil {
+				t.Fatalf("NextResultSet failed: %v", err)
+			}
+		}
+
+		stats := rows.BatchStats()
+		if len(stats) != len(tc.batches) {
+			t.Fatalf("%s: expected %d batches, got %+v", tc.query, len(tc.batches), stats)
+		}
+		var bytes int64
+		for j, s := range stats {
+			if s.Rows != tc.batches[j] || s.Bytes <= 0 || s.Decode < 0 {
+				t.Errorf("%s: batch %d: expected %d rows, got %+v", tc.query, j, tc.batches[j], s)
+			}
+			bytes += s.Bytes
+		}
+		// The schema and the end of the stream aren't part of any batch
+		if bytes >= int64(len(tc.reply)) {
+			t.Errorf("%s: expected fewer bytes than the reply's %d, got %d", tc.query, len(tc.reply), bytes)
+		}
+
+		// The stats don't change as rows are read
+		dest := make([]driver.Value, 1)
+		for rows.Next(dest) == nil {
+		}
+		if got := rows.BatchStats(); len(got) != len(stats) {
+			t.Errorf("%s: expected the stats to stay the same, got %+v", tc.query, got)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package luna
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Number of protocol events kept per connector for support bundles.
+const eventLogSize = 256
+
+// Longest query text kept in a protocol event, after redaction.
+const maxEventQueryLen = 256
+
+// protocolEvent records a connection attempt or a command round trip.
+type protocolEvent struct {
+	Time time.Time `json:"time"`
+	// Sequence number of the connection within its connector.
+	Conn int64 `json:"conn"`
+	// One of connect, query or exec.
+	Kind string `json:"kind"`
+	// Query text with string literals redacted.
+	Query    string        `json:"query,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	// Response bytes received, including the Arrow IPC stream.
+	Bytes int64  `json:"bytes_received,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// eventLog is a ring buffer of the most recent protocol events. A nil log
+// discards events.
+type eventLog struct {
+	mu     sync.Mutex
+	events []protocolEvent
+	next   int
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]protocolEvent, size)}
+}
+
+func (l *eventLog) add(e protocolEvent) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// snapshot returns the logged events, oldest first.
+func (l *eventLog) snapshot() []protocolEvent {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := make([]protocolEvent, 0, len(l.events))
+	if l.full {
+		events = append(events, l.events[l.next:]...)
+	}
+	return append(events, l.events[:l.next]...)
+}
+
+// eventQuery returns query as recorded in the event log: redacted like logged
+// queries, so that support bundles don't leak the data users query for, and
+// truncated.
+func eventQuery(query string) string {
+	redacted := redactQuery(query)
+	if len(redacted) > maxEventQueryLen {
+		redacted = redacted[:maxEventQueryLen] + "..."
+	}
+	return redacted
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// bundleConfig is the connector configuration as written to support bundles,
+// without secrets.
+type bundleConfig struct {
+	Addr           string     `json:"addr"`
+	User           string     `json:"user,omitempty"`
+	PasswordSet    bool       `json:"password_set"`
+	TLS            *bundleTLS `json:"tls,omitempty"`
+	ConnectTimeout string     `json:"connect_timeout"`
+	QueryTimeout   string     `json:"query_timeout"`
+	MaxInList      int        `json:"max_in_list"`
+	ReadBufferSize int        `json:"read_buffer_size"`
+	RateLimit      RateLimit  `json:"rate_limit"`
+}
+
+type bundleTLS struct {
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	CustomCA           bool   `json:"custom_ca"`
+	ClientCertificates int    `json:"client_certificates"`
+}
+
+// bundleServer describes the server as seen by a probe connection.
+type bundleServer struct {
+	Version string `json:"version,omitempty"`
+	// Time to connect and query the server version.
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type bundleRuntime struct {
+	Time       time.Time `json:"time"`
+	GoVersion  string    `json:"go_version"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	Goroutines int       `json:"goroutines"`
+}
+
+// WriteSupportBundle adds diagnostic files to zw, under the luna/ directory, to
+// attach to bug reports against the driver or the Luna server: the connector's
+// configuration without secrets, its recent protocol events with their timings
+// (string literals in queries are redacted), the server version, and runtime
+// information. The server is probed on a new connection bounded by ctx; if that
+// fails, the error is recorded in the bundle instead. The caller closes zw,
+// and may add files of its own.
+func (c *Connector) WriteSupportBundle(ctx context.Context, zw *zip.Writer) error {
+	now := c.clock.Now()
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"luna/config.json", c.bundleConfig()},
+		{"luna/events.json", c.events.snapshot()},
+		{"luna/server.json", c.probeServer(ctx)},
+		{"luna/runtime.json", bundleRuntime{
+			Time:       now,
+			GoVersion:  runtime.Version(),
+			OS:         runtime.GOOS,
+			Arch:       runtime.GOARCH,
+			Goroutines: runtime.NumGoroutine(),
+		}},
+	}
+
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Connector) bundleConfig() bundleConfig {
+	src := c.Config()
+	cfg := bundleConfig{
+		Addr:           src.Addr,
+		User:           src.User,
+		PasswordSet:    src.Password != "",
+		ConnectTimeout: src.ConnectTimeout.String(),
+		QueryTimeout:   src.QueryTimeout.String(),
+		MaxInList:      src.MaxInList,
+		ReadBufferSize: src.ReadBufferSize,
+		RateLimit:      src.RateLimit,
+	}
+	if tc := src.TLSConfig; tc != nil {
+		cfg.TLS = &bundleTLS{
+			ServerName:         tc.ServerName,
+			InsecureSkipVerify: tc.InsecureSkipVerify,
+			CustomCA:           tc.RootCAs != nil,
+			ClientCertificates: len(tc.Certificates),
+		}
+	}
+	return cfg
+}
+
+// probeServer connects to the server and queries its version.
+func (c *Connector) probeServer(ctx context.Context) bundleServer {
+	start := c.clock.Now()
+	version, err := c.serverVersion(ctx)
+	return bundleServer{Version: version, Duration: c.clock.Now().Sub(start), Error: errorString(err)}
+}
+
+func (c *Connector) serverVersion(ctx context.Context) (string, error) {
+	dc, err := c.Connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer dc.Close()
+
+	rows, err := dc.(*Conn).QueryContext(ctx, "SELECT version() AS version", nil)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return "", err
+	}
+	version, _ := dest[0].(string)
+	return version, nil
+}
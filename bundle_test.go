@@ -0,0 +1,180 @@
+package luna
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestEventLog(t *testing.T) {
+	l := newEventLog(3)
+	for i := int64(1); i <= 2; i++ {
+		l.add(protocolEvent{Conn: i})
+	}
+	if got := eventConns(l.snapshot()); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Errorf("expected events [1 2], got %v", got)
+	}
+
+	// Older events are overwritten, oldest first
+	for i := int64(3); i <= 5; i++ {
+		l.add(protocolEvent{Conn: i})
+	}
+	if got := eventConns(l.snapshot()); !reflect.DeepEqual(got, []int64{3, 4, 5}) {
+		t.Errorf("expected events [3 4 5], got %v", got)
+	}
+
+	var nilLog *eventLog
+	nilLog.add(protocolEvent{})
+	if got := nilLog.snapshot(); got != nil {
+		t.Errorf("expected no events from a nil log, got %v", got)
+	}
+}
+
+func eventConns(events []protocolEvent) []int64 {
+	var conns []int64
+	for _, e := range events {
+		conns = append(conns, e.Conn)
+	}
+	return conns
+}
+
+func TestEventQuery(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT 1", "SELECT ?"},
+		{"SELECT * FROM users WHERE email = 'bob@example.com'", "SELECT * FROM users WHERE email = ?"},
+		{`SELECT "name" FROM t1 WHERE x = 'it''s' AND y = 4111.5`, `SELECT "name" FROM t1 WHERE x = ? AND y = ?`},
+		{"SELECT 'unterminated", "SELECT ?"},
+		{strings.Repeat("x", 300), strings.Repeat("x", maxEventQueryLen) + "..."},
+	}
+
+	for _, tc := range testCases {
+		if got := eventQuery(tc.query); got != tc.expected {
+			t.Errorf("eventQuery(%q): expected %q, got %q", tc.query, tc.expected, got)
+		}
+	}
+}
+
+func TestWriteSupportBundle(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		// Password challenge
+		conn.Write([]byte("+challenge\r\n"))
+		if _, err := readCommand(reader); err != nil {
+			return
+		}
+		conn.Write([]byte("+OK\r\n"))
+
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			if cmd != "q:SELECT version() AS version" {
+				conn.Write([]byte("-Parser Error: no such table\r\n"))
+				continue
+			}
+
+			schema := arrow.NewSchema([]arrow.Field{{Name: "version", Type: arrow.BinaryTypes.String}}, nil)
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+			b.Field(0).(*array.StringBuilder).Append("v1.2.3")
+			rec := b.NewRecord()
+			writeArrowReply(conn, schema, rec)
+			rec.Release()
+			b.Release()
+		}
+	})
+
+	connector, err := NewConnector("etl:hunter2@"+addr+"?query_timeout=5s", nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	dc.(*Conn).QueryContext(context.Background(), "SELECT * FROM secrets WHERE token = 's3cr3t' OR pin = 90817", nil)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := connector.WriteSupportBundle(context.Background(), zw); err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+	zw.Close()
+
+	files := readZip(t, buf.Bytes())
+	for _, name := range []string{"luna/config.json", "luna/events.json", "luna/server.json", "luna/runtime.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("expected %s in bundle", name)
+		}
+	}
+	for name, data := range files {
+		if strings.Contains(data, "hunter2") || strings.Contains(data, "s3cr3t") || strings.Contains(data, "90817") {
+			t.Errorf("%s leaks a secret:\n%s", name, data)
+		}
+	}
+
+	var cfg bundleConfig
+	if err := json.Unmarshal([]byte(files["luna/config.json"]), &cfg); err != nil {
+		t.Fatalf("invalid config.json: %v", err)
+	}
+	if cfg.User != "etl" || !cfg.PasswordSet || cfg.QueryTimeout != "5s" {
+		t.Errorf("unexpected config snapshot %+v", cfg)
+	}
+
+	var events []protocolEvent
+	if err := json.Unmarshal([]byte(files["luna/events.json"]), &events); err != nil {
+		t.Fatalf("invalid events.json: %v", err)
+	}
+	if len(events) != 2 || events[0].Kind != "connect" || events[1].Kind != "query" {
+		t.Fatalf("expected a connect and a query event, got %+v", events)
+	}
+	if !strings.Contains(events[1].Error, "no such table") || events[1].Bytes == 0 {
+		t.Errorf("expected the query error and response size to be recorded, got %+v", events[1])
+	}
+
+	var server bundleServer
+	if err := json.Unmarshal([]byte(files["luna/server.json"]), &server); err != nil {
+		t.Fatalf("invalid server.json: %v", err)
+	}
+	if server.Version != "v1.2.3" || server.Error != "" {
+		t.Errorf("expected server version v1.2.3, got %+v", server)
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", f.Name, err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
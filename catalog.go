@@ -0,0 +1,127 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaInfo is a schema of an attached database, as listed by ListSchemas.
+type SchemaInfo struct {
+	Database string
+	Schema   string
+}
+
+// TableInfo is a table or view, as listed by ListTables.
+type TableInfo struct {
+	Database string
+	Schema   string
+	Table    string
+	// Type is "BASE TABLE", "VIEW" or "LOCAL TEMPORARY", as information_schema
+	// reports it.
+	Type string
+}
+
+// ColumnInfo is a column of a table or view, as returned by DescribeTable.
+type ColumnInfo struct {
+	Name string
+	// Type is the SQL type the server reports, e.g. "VARCHAR" or
+	// "DECIMAL(18,3)".
+	Type     string
+	Nullable bool
+	// Default is the default value expression, or empty if the column has
+	// none.
+	Default string
+}
+
+// ListSchemas returns the schemas of every attached database, the server's
+// internal ones excepted, ordered by database and name, e.g. for admin UIs.
+func ListSchemas(ctx context.Context, db *sql.DB) ([]SchemaInfo, error) {
+	rows, err := db.QueryContext(ctx, "SELECT database_name, schema_name FROM duckdb_schemas() WHERE NOT internal ORDER BY database_name, schema_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []SchemaInfo
+	for rows.Next() {
+		var s SchemaInfo
+		if err := rows.Scan(&s.Database, &s.Schema); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schemas, nil
+}
+
+// ListTables returns the tables and views of a schema, e.g. sales or
+// warehouse.sales, ordered by name. An unqualified schema is looked up in the
+// current database, and an empty one lists the tables of every schema.
+func ListTables(ctx context.Context, db *sql.DB, schema string) ([]TableInfo, error) {
+	query := "SELECT table_catalog, table_schema, table_name, table_type FROM information_schema.tables"
+	if schema != "" {
+		database, name := "current_database()", schema
+		if d, s, ok := strings.Cut(schema, "."); ok {
+			database, name = quoteSQLString(d), s
+		}
+		query += fmt.Sprintf(" WHERE table_catalog = %s AND table_schema = %s", database, quoteSQLString(name))
+	}
+	query += " ORDER BY table_catalog, table_schema, table_name"
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []TableInfo
+	for rows.Next() {
+		var t TableInfo
+		if err := rows.Scan(&t.Database, &t.Schema, &t.Table, &t.Type); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tables, nil
+}
+
+// DescribeTable returns the columns of a table or view, e.g. sales.orders, in
+// their order in the table. Unqualified names are looked up in the current
+// database and schema. It returns an error if the table doesn't exist.
+func DescribeTable(ctx context.Context, db *sql.DB, table string) ([]ColumnInfo, error) {
+	query := "SELECT column_name, data_type, is_nullable, COALESCE(column_default, '') FROM information_schema.columns WHERE " +
+		objectFilter(table, "table_catalog", "table_schema", "table_name") + " ORDER BY ordinal_position"
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var c ColumnInfo
+		var nullable string
+		if err := rows.Scan(&c.Name, &c.Type, &nullable, &c.Default); err != nil {
+			return nil, err
+		}
+		c.Nullable = nullable == "YES"
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if columns == nil {
+		return nil, fmt.Errorf("luna: table %s doesn't exist", table)
+	}
+	return columns, nil
+}
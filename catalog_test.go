@@ -0,0 +1,90 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+func TestCatalog(t *testing.T) {
+	columnNames := []string{"column_name", "data_type", "is_nullable", "column_default"}
+	fields := make([]arrow.Field, len(columnNames))
+	for i, name := range columnNames {
+		fields[i] = arrow.Field{Name: name, Type: arrow.BinaryTypes.String}
+	}
+	addr, commands := newReplyServer(t,
+		fakeReply{"duckdb_schemas", recordReply(t, []string{"database_name", "schema_name"},
+			[]any{"main", "main"},
+			[]any{"main", "sales"},
+		)},
+		fakeReply{"information_schema.tables", recordReply(t, []string{"table_catalog", "table_schema", "table_name", "table_type"},
+			[]any{"main", "sales", "orders", "BASE TABLE"},
+			[]any{"main", "sales", "recent_orders", "VIEW"},
+		)},
+		fakeReply{"table_name = 'missing'", schemaReply(t, arrow.NewSchema(fields, nil))},
+		fakeReply{"information_schema.columns", recordReply(t, columnNames,
+			[]any{"id", "BIGINT", "NO", ""},
+			[]any{"total", "DECIMAL(18,3)", "YES", "0"},
+		)},
+	)
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	schemas, err := ListSchemas(ctx, db)
+	if err != nil {
+		t.Fatalf("ListSchemas failed: %v", err)
+	}
+	expectedSchemas := []SchemaInfo{{Database: "main", Schema: "main"}, {Database: "main", Schema: "sales"}}
+	if !reflect.DeepEqual(schemas, expectedSchemas) {
+		t.Errorf("expected schemas %+v, got %+v", expectedSchemas, schemas)
+	}
+	<-commands
+
+	testCases := []struct {
+		schema   string
+		expected string
+	}{
+		{"", "information_schema.tables ORDER BY"},
+		{"sales", "WHERE table_catalog = current_database() AND table_schema = 'sales'"},
+		{"warehouse.it's", "WHERE table_catalog = 'warehouse' AND table_schema = 'it''s'"},
+	}
+	for _, tc := range testCases {
+		tables, err := ListTables(ctx, db, tc.schema)
+		if err != nil {
+			t.Fatalf("ListTables(%q) failed: %v", tc.schema, err)
+		}
+		if len(tables) != 2 || tables[1] != (TableInfo{Database: "main", Schema: "sales", Table: "recent_orders", Type: "VIEW"}) {
+			t.Errorf("unexpected tables for %q: %+v", tc.schema, tables)
+		}
+		if cmd := <-commands; !strings.Contains(cmd, tc.expected) {
+			t.Errorf("expected the query for %q to contain %q, got %q", tc.schema, tc.expected, cmd)
+		}
+	}
+
+	columns, err := DescribeTable(ctx, db, "sales.orders")
+	if err != nil {
+		t.Fatalf("DescribeTable failed: %v", err)
+	}
+	expectedColumns := []ColumnInfo{
+		{Name: "id", Type: "BIGINT"},
+		{Name: "total", Type: "DECIMAL(18,3)", Nullable: true, Default: "0"},
+	}
+	if !reflect.DeepEqual(columns, expectedColumns) {
+		t.Errorf("expected columns %+v, got %+v", expectedColumns, columns)
+	}
+	if cmd := <-commands; !strings.Contains(cmd, "table_schema = 'sales' AND table_name = 'orders' ORDER BY ordinal_position") {
+		t.Errorf("unexpected DescribeTable query %q", cmd)
+	}
+
+	if _, err := DescribeTable(ctx, db, "missing"); err == nil || !strings.Contains(err.Error(), "doesn't exist") {
+		t.Errorf("expected an error for a missing table, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package luna
+
+import (
+	"strings"
+)
+
+// StatementKind is the kind of an SQL statement, as reported by Kind.
+type StatementKind int
+
+const (
+	// KindUnknown is an empty statement or one starting with an unknown keyword.
+	KindUnknown StatementKind = iota
+	// KindSelect is a query that only reads data: SELECT, FROM, VALUES, TABLE, and
+	// WITH without a data-modifying statement.
+	KindSelect
+	// KindDML changes data: INSERT, UPDATE, DELETE, MERGE, TRUNCATE and COPY ...
+	// FROM, including WITH statements ending in one of them.
+	KindDML
+	// KindDDL changes the schema: CREATE, DROP, ALTER and COMMENT.
+	KindDDL
+	// KindTx controls transactions: BEGIN, START, COMMIT, END, ROLLBACK, ABORT,
+	// SAVEPOINT and RELEASE.
+	KindTx
+	// KindUtility is any other known statement, e.g. SET, PRAGMA, EXPLAIN, SHOW,
+	// DESCRIBE, ATTACH, or COPY ... TO.
+	KindUtility
+)
+
+func (k StatementKind) String() string {
+	switch k {
+	case KindSelect:
+		return "Select"
+	case KindDML:
+		return "DML"
+	case KindDDL:
+		return "DDL"
+	case KindTx:
+		return "Tx"
+	case KindUtility:
+		return "Utility"
+	default:
+		return "Unknown"
+	}
+}
+
+// Kinds of statements, by their leading keyword. COPY and WITH depend on the rest
+// of the statement.
+var statementKinds = map[string]StatementKind{
+	"SELECT":     KindSelect,
+	"FROM":       KindSelect,
+	"VALUES":     KindSelect,
+	"TABLE":      KindSelect,
+	"INSERT":     KindDML,
+	"UPDATE":     KindDML,
+	"DELETE":     KindDML,
+	"MERGE":      KindDML,
+	"TRUNCATE":   KindDML,
+	"CREATE":     KindDDL,
+	"DROP":       KindDDL,
+	"ALTER":      KindDDL,
+	"COMMENT":    KindDDL,
+	"BEGIN":      KindTx,
+	"START":      KindTx,
+	"COMMIT":     KindTx,
+	"END":        KindTx,
+	"ROLLBACK":   KindTx,
+	"ABORT":      KindTx,
+	"SAVEPOINT":  KindTx,
+	"RELEASE":    KindTx,
+	"SET":        KindUtility,
+	"RESET":      KindUtility,
+	"PRAGMA":     KindUtility,
+	"EXPLAIN":    KindUtility,
+	"SHOW":       KindUtility,
+	"DESCRIBE":   KindUtility,
+	"SUMMARIZE":  KindUtility,
+	"CALL":       KindUtility,
+	"USE":        KindUtility,
+	"ATTACH":     KindUtility,
+	"DETACH":     KindUtility,
+	"INSTALL":    KindUtility,
+	"LOAD":       KindUtility,
+	"EXPORT":     KindUtility,
+	"IMPORT":     KindUtility,
+	"CHECKPOINT": KindUtility,
+	"VACUUM":     KindUtility,
+	"ANALYZE":    KindUtility,
+}
+
+// Keywords followed by a table name. For those in tableFunctionWords, a name
+// followed by a parenthesis is a table function, e.g. FROM read_csv('f.csv'),
+// rather than a table with a column list, e.g. INSERT INTO t (a, b).
+var (
+	tableNameWords = map[string]bool{
+		"FROM":      true,
+		"JOIN":      true,
+		"USING":     true,
+		"INTO":      true,
+		"UPDATE":    true,
+		"TABLE":     true,
+		"VIEW":      true,
+		"TRUNCATE":  true,
+		"COPY":      true,
+		"DESCRIBE":  true,
+		"SUMMARIZE": true,
+	}
+	tableFunctionWords = map[string]bool{
+		"FROM":  true,
+		"JOIN":  true,
+		"USING": true,
+	}
+)
+
+// Keywords that can follow a table name keyword without being a table, e.g.
+// ON CONFLICT DO UPDATE SET.
+var notTableNames = map[string]bool{
+	"SELECT":  true,
+	"SET":     true,
+	"VALUES":  true,
+	"DEFAULT": true,
+	"LATERAL": true,
+	"TABLE":   true,
+	"WITH":    true,
+	"BY":      true,
+	"NAME":    true,
+}
+
+// Statement is the classification of an SQL statement, as returned by Classify.
+type Statement struct {
+	Kind StatementKind
+	// Tables are the names of the tables and views the statement refers to, in
+	// order of appearance and without duplicates, e.g. "sales.orders". Quoted
+	// identifiers are unquoted. Common table expressions are left out.
+	Tables []string
+}
+
+// Kind returns the kind of an SQL statement, for policy hooks and routers that
+// need to tell reads from writes without matching SQL with regular expressions.
+// It skips comments and quoted strings and identifiers. Statements it can't make
+// sense of are KindUnknown.
+func Kind(query string) StatementKind {
+	return statementKind(query, scanSQLWords(query))
+}
+
+// Classify returns the kind of an SQL statement and the tables it refers to.
+// Finding tables is best effort: only names directly after FROM, JOIN, INTO,
+// UPDATE, TABLE and similar keywords are reported, so in FROM a, b only a is, and
+// a column in e.g. EXTRACT(year FROM ts) is reported as a table.
+func Classify(query string) Statement {
+	words := scanSQLWords(query)
+	return Statement{
+		Kind:   statementKind(query, words),
+		Tables: referencedTables(query, words),
+	}
+}
+
+func statementKind(query string, words []sqlWord) StatementKind {
+	if len(words) == 0 {
+		return KindUnknown
+	}
+
+	switch words[0].text {
+	case "WITH":
+		// WITH ... INSERT, or a data-modifying CTE
+		for _, w := range words[1:] {
+			switch w.text {
+			case "INSERT", "UPDATE", "DELETE", "MERGE":
+				return KindDML
+			}
+		}
+		return KindSelect
+	case "COPY":
+		// COPY (SELECT ...) TO exports; COPY t FROM loads
+		if rest := strings.TrimLeft(query[words[0].end:], " \t\r\n"); strings.HasPrefix(rest, "(") {
+			return KindUtility
+		}
+		for _, w := range words[1:] {
+			switch w.text {
+			case "FROM":
+				return KindDML
+			case "TO":
+				return KindUtility
+			}
+		}
+		return KindUnknown
+	}
+	return statementKinds[words[0].text]
+}
+
+func referencedTables(query string, words []sqlWord) []string {
+	// Names declared as common table expressions, i.e. followed by AS (
+	ctes := make(map[string]bool)
+	for i := 0; i+1 < len(words); i++ {
+		if words[i+1].text == "AS" && strings.HasPrefix(strings.TrimLeft(query[words[i+1].end:], " \t\r\n"), "(") {
+			ctes[words[i].text] = true
+		}
+	}
+
+	var tables []string
+	seen := make(map[string]bool)
+	for i, w := range words {
+		if !tableNameWords[w.text] {
+			continue
+		}
+
+		// Skip IF [NOT] EXISTS
+		pos, j := w.end, i+1
+		if j < len(words) && words[j].text == "IF" {
+			for j < len(words) && words[j].text != "EXISTS" {
+				j++
+			}
+			if j == len(words) {
+				continue
+			}
+			pos = words[j].end
+		}
+
+		name, quoted, end := readSQLName(query, pos)
+		if name == "" || (!quoted && (notTableNames[strings.ToUpper(name)] || ctes[strings.ToUpper(name)])) {
+			continue
+		}
+		rest := strings.TrimLeft(query[end:], " \t\r\n")
+		if tableFunctionWords[w.text] && strings.HasPrefix(rest, "(") {
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+// readSQLName reads the possibly qualified and quoted name starting at
+// query[pos], after any spaces. It returns the name with its identifiers
+// unquoted, whether any of them was quoted, and the index following it; the name
+// is empty if there's none.
+func readSQLName(query string, pos int) (name string, quoted bool, end int) {
+	i := pos
+	for i < len(query) && isSQLSpace(query[i]) {
+		i++
+	}
+
+	var parts []string
+	for i < len(query) {
+		switch c := query[i]; {
+		case c == '"':
+			j := skipSQLQuoted(query, i)
+			if j-i < 2 || query[j-1] != '"' {
+				return "", false, pos
+			}
+			parts = append(parts, strings.ReplaceAll(query[i+1:j-1], `""`, `"`))
+			quoted = true
+			i = j
+		case isSQLWordStart(c):
+			j := i
+			for j < len(query) && (isSQLWordStart(query[j]) || (query[j] >= '0' && query[j] <= '9')) {
+				j++
+			}
+			parts = append(parts, query[i:j])
+			i = j
+		default:
+			return "", false, pos
+		}
+
+		if i >= len(query) || query[i] != '.' {
+			break
+		}
+		i++
+	}
+	return strings.Join(parts, "."), quoted, i
+}
@@ -0,0 +1,73 @@
+package luna
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKind(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected StatementKind
+	}{
+		{"SELECT 1", KindSelect},
+		{"  -- INSERT\n select * from t", KindSelect},
+		{"FROM t LIMIT 5", KindSelect},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", KindSelect},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", KindDML},
+		{"WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", KindDML},
+		{"SELECT 'DROP TABLE t'", KindSelect},
+		{"INSERT INTO t VALUES (1)", KindDML},
+		{"update t set a = 1", KindDML},
+		{"TRUNCATE t", KindDML},
+		{"COPY t FROM 'data.csv'", KindDML},
+		{"COPY t TO 'data.csv'", KindUtility},
+		{"COPY (SELECT * FROM t) TO 'out.parquet'", KindUtility},
+		{"CREATE TABLE t (a INT)", KindDDL},
+		{"DROP VIEW v", KindDDL},
+		{"BEGIN TRANSACTION", KindTx},
+		{"ROLLBACK", KindTx},
+		{"SET threads = 4", KindUtility},
+		{"EXPLAIN SELECT 1", KindUtility},
+		{"PRAGMA database_size", KindUtility},
+		{"", KindUnknown},
+		{"-- nothing", KindUnknown},
+		{"FROBNICATE t", KindUnknown},
+	}
+
+	for _, tc := range testCases {
+		if got := Kind(tc.query); got != tc.expected {
+			t.Errorf("Kind(%q): expected %v, got %v", tc.query, tc.expected, got)
+		}
+	}
+}
+
+func TestClassifyTables(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"SELECT * FROM orders o JOIN sales.customers c ON o.cid = c.id", []string{"orders", "sales.customers"}},
+		{`SELECT * FROM "My Table" JOIN "a""b".t USING (id)`, []string{"My Table", `a"b.t`}},
+		{"WITH recent AS (SELECT * FROM events) SELECT * FROM recent JOIN users ON true", []string{"events", "users"}},
+		{"INSERT INTO t (a, b) SELECT a, b FROM s", []string{"t", "s"}},
+		{"INSERT INTO t VALUES (1) ON CONFLICT DO UPDATE SET a = 2", []string{"t"}},
+		{"UPDATE t SET a = 1 FROM (SELECT 1) x", []string{"t"}},
+		{"DELETE FROM t WHERE id IN (SELECT id FROM t)", []string{"t"}},
+		{"MERGE INTO t USING s ON t.id = s.id WHEN MATCHED THEN DELETE", []string{"t", "s"}},
+		{"SELECT * FROM read_parquet('s3://b/f.parquet')", nil},
+		{"CREATE TABLE IF NOT EXISTS main.t (a INT)", []string{"main.t"}},
+		{"DROP TABLE IF EXISTS t", []string{"t"}},
+		{"TRUNCATE TABLE t", []string{"t"}},
+		{"CREATE VIEW v AS SELECT * FROM t", []string{"v", "t"}},
+		{"COPY t FROM 'data.csv'", []string{"t"}},
+		{"SELECT 'FROM x' AS s", nil},
+		{"SELECT 1", nil},
+	}
+
+	for _, tc := range testCases {
+		if got := Classify(tc.query).Tables; !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Classify(%q): expected tables %q, got %q", tc.query, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package luna
+
+import "time"
+
+// Clock is the time source of a connector's timeout logic: query and stall
+// timeouts, idle pings, rate limits and event times. WithClock replaces it, e.g.
+// to simulate stalls and expirations instantly in deterministic tests. Socket
+// deadlines and decode timings still use the system clock.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer cancels a pending Clock.AfterFunc call. Stop returns false if the call
+// already happened (or is happening).
+type Timer interface {
+	Stop() bool
+}
+
+// realClock is the clock backed by package time.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
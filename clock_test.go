@@ -0,0 +1,133 @@
+package luna
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for tests. Timers fire synchronously
+// from Advance once their time has come.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	when  time.Time
+	f     func()
+	done  bool // fired or stopped
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires the timers that became due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		switch {
+		case t.done:
+		case !t.when.After(c.now):
+			t.done = true
+			due = append(due, t)
+		default:
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// Pending returns the number of timers that haven't fired or been stopped.
+func (c *fakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if !t.done {
+			n++
+		}
+	}
+	return n
+}
+
+// waitForTimers waits until n timers are pending on clock, i.e. a goroutine
+// is blocked on it.
+func waitForTimers(t *testing.T, clock *fakeClock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Pending() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d pending timers", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.done {
+		return false
+	}
+	t.done = true
+	return true
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+
+	var fired []string
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "1s") })
+	stopped := clock.AfterFunc(2*time.Second, func() { fired = append(fired, "2s") })
+	clock.AfterFunc(3*time.Second, func() { fired = append(fired, "3s") })
+
+	clock.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 || fired[0] != "1s" {
+		t.Fatalf("expected only the 1s timer to fire, got %v", fired)
+	}
+
+	if !stopped.Stop() {
+		t.Error("expected Stop to succeed on a pending timer")
+	}
+	if stopped.Stop() {
+		t.Error("expected Stop to fail on a stopped timer")
+	}
+
+	clock.Advance(2 * time.Second)
+	if len(fired) != 2 || fired[1] != "3s" {
+		t.Fatalf("expected the 3s timer to fire, got %v", fired)
+	}
+	if clock.Pending() != 0 {
+		t.Errorf("expected no pending timers, got %d", clock.Pending())
+	}
+	if got := clock.Now().Sub(start); got != 3500*time.Millisecond {
+		t.Errorf("expected clock to advance by 3.5s, got %v", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Time limit for a password command, which may prompt for a passphrase or
+// touch, e.g. to unlock a keychain.
+const passwordCommandTimeout = time.Minute
+
+// resolvePassword returns the password a -password-source flag refers to:
+//
+//	env:NAME               the environment variable NAME
+//	cmd:COMMAND            the output of a shell command, e.g.
+//	                       "cmd:pass show luna/prod" or
+//	                       "cmd:aws secretsmanager get-secret-value --secret-id luna --query SecretString --output text"
+//	keychain:SERVICE[/ACCOUNT]  an entry of the OS keychain: the macOS login
+//	                       keychain, or the Secret Service (secret-tool) on Linux
+//
+// A trailing newline is removed from command output.
+func resolvePassword(ctx context.Context, source string) (string, error) {
+	kind, ref, ok := strings.Cut(source, ":")
+	if !ok || strings.TrimSpace(ref) == "" {
+		return "", fmt.Errorf("invalid password source %q: expected env:NAME, cmd:COMMAND or keychain:SERVICE[/ACCOUNT]", source)
+	}
+
+	switch kind {
+	case "env":
+		password, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("password source %s: environment variable not set", source)
+		}
+		return password, nil
+	case "cmd":
+		// Name the command by its program, since its arguments may be secret
+		return runPasswordCommand(ctx, strings.Fields(ref)[0], shellCommand(ref))
+	case "keychain":
+		args, err := keychainCommand(ref)
+		if err != nil {
+			return "", err
+		}
+		return runPasswordCommand(ctx, args[0], args)
+	}
+	return "", fmt.Errorf("invalid password source %q: unknown kind %q", source, kind)
+}
+
+// shellCommand returns the arguments that run command with the system shell.
+func shellCommand(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", command}
+	}
+	return []string{"/bin/sh", "-c", command}
+}
+
+// keychainCommand returns the arguments of the command that prints the keychain
+// entry of a service, and optionally an account, given as SERVICE[/ACCOUNT].
+func keychainCommand(ref string) ([]string, error) {
+	service, account, _ := strings.Cut(ref, "/")
+	switch runtime.GOOS {
+	case "darwin":
+		args := []string{"security", "find-generic-password", "-w", "-s", service}
+		if account != "" {
+			args = append(args, "-a", account)
+		}
+		return args, nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		args := []string{"secret-tool", "lookup", "service", service}
+		if account != "" {
+			args = append(args, "account", account)
+		}
+		return args, nil
+	}
+	return nil, fmt.Errorf("keychain password sources aren't supported on %s, use a cmd: source", runtime.GOOS)
+}
+
+// runPasswordCommand runs a command and returns its output without the trailing
+// newline. Its stdin and stderr are the terminal's, so that it can prompt. Errors
+// refer to the command as name.
+func runPasswordCommand(ctx context.Context, name string, args []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, passwordCommandTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// The output may hold part of the secret, so it's never included
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("password command %s failed with status %d", name, exitErr.ExitCode())
+		}
+		return "", fmt.Errorf("password command %s: %w", name, err)
+	}
+
+	password := strings.TrimSuffix(strings.TrimSuffix(out.String(), "\n"), "\r")
+	if password == "" {
+		return "", fmt.Errorf("password command %s printed nothing", name)
+	}
+	return password, nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	luna "github.com/flowerinthenight/luna-go"
+)
+
+type checkStatus string
+
+const (
+	statusOK   checkStatus = "ok"
+	statusWarn checkStatus = "warn"
+	statusFail checkStatus = "fail"
+	statusSkip checkStatus = "skip"
+)
+
+// Clock skew above this is reported as a warning.
+const maxClockSkew = time.Second
+
+// Certificates expiring sooner than this are reported as a warning.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// checkResult is a single line of the doctor report.
+type checkResult struct {
+	Name     string        `json:"name"`
+	Status   checkStatus   `json:"status"`
+	Detail   string        `json:"detail"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// doctor runs the connectivity checks in order, from DNS resolution up to an
+// actual query, so that the first failure points at the broken layer. Checks
+// after a failure are skipped, since they'd only fail for the same reason.
+type doctor struct {
+	dsn     string
+	timeout time.Duration
+
+	cfg       *luna.Config
+	connector *luna.Connector
+	conn      driver.Conn
+	report    []checkResult
+	failed    bool
+}
+
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	dsn := fs.String("dsn", "localhost:7688", "Luna DSN")
+	timeout := fs.Duration("timeout", 10*time.Second, "time limit for each check")
+	jsonOut := fs.Bool("json", false, "print the report as JSON")
+	bundle := fs.String("bundle", "", "also write a support bundle zip to this file")
+	passwordSource := fs.String("password-source", os.Getenv("LUNA_PASSWORD_SOURCE"), "where to read the password from, overriding the DSN's: env:NAME, cmd:COMMAND or keychain:SERVICE[/ACCOUNT]")
+	fs.Parse(args)
+
+	if *passwordSource != "" {
+		password, err := resolvePassword(context.Background(), *passwordSource)
+		if err == nil {
+			*dsn, err = shellDSN(*dsn, "", password, false, "", false)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lunacli: %v\n", err)
+			return 1
+		}
+	}
+
+	d := &doctor{dsn: *dsn, timeout: *timeout}
+	d.run()
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d.report)
+	} else {
+		d.print(os.Stdout)
+	}
+
+	if *bundle != "" {
+		if err := d.writeBundle(*bundle); err != nil {
+			fmt.Fprintf(os.Stderr, "lunacli: failed to write support bundle: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "support bundle written to %s\n", *bundle)
+	}
+
+	if d.failed {
+		return 1
+	}
+	return 0
+}
+
+// run executes all checks and fills in the report.
+func (d *doctor) run() {
+	defer func() {
+		if d.conn != nil {
+			d.conn.Close()
+		}
+	}()
+
+	d.check("dsn", d.checkDSN)
+	d.check("dns", d.checkDNS)
+	d.check("tcp", d.checkTCP)
+	d.check("tls", d.checkTLS)
+	d.check("auth", d.checkAuth)
+	d.check("handshake", func(context.Context) (checkStatus, string) {
+		return statusSkip, "the Luna protocol has no handshake, commands are sent right after connecting"
+	})
+	d.check("query", d.checkQuery)
+	d.check("clock", d.checkClock)
+}
+
+// check runs fn with a time limit and records its result.
+func (d *doctor) check(name string, fn func(ctx context.Context) (checkStatus, string)) {
+	if d.failed {
+		d.report = append(d.report, checkResult{Name: name, Status: statusSkip, Detail: "skipped after an earlier failure"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	start := time.Now()
+	status, detail := fn(ctx)
+	d.report = append(d.report, checkResult{Name: name, Status: status, Detail: detail, Duration: time.Since(start)})
+	if status == statusFail {
+		d.failed = true
+	}
+}
+
+func (d *doctor) checkDSN(context.Context) (checkStatus, string) {
+	cfg, err := luna.ParseDSN(d.dsn)
+	if err != nil {
+		return statusFail, err.Error()
+	}
+	d.cfg = cfg
+
+	d.connector, err = luna.NewConnectorWithOptions(d.dsn, luna.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		return statusFail, err.Error()
+	}
+
+	// The password is never printed
+	user := cfg.User
+	if user == "" {
+		user = "(none)"
+	}
+	return statusOK, fmt.Sprintf("addr=%s user=%s tls=%v password=%v", cfg.Addr, user, cfg.TLSConfig != nil, cfg.Password != "")
+}
+
+func (d *doctor) checkDNS(ctx context.Context) (checkStatus, string) {
+	host, _, err := net.SplitHostPort(d.cfg.Addr)
+	if err != nil {
+		return statusFail, fmt.Sprintf("invalid address %q: %v", d.cfg.Addr, err)
+	}
+	if net.ParseIP(host) != nil {
+		return statusSkip, "address is an IP literal"
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return statusFail, err.Error()
+	}
+	return statusOK, fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", "))
+}
+
+func (d *doctor) checkTCP(ctx context.Context) (checkStatus, string) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", d.cfg.Addr)
+	if err != nil {
+		return statusFail, err.Error()
+	}
+	defer conn.Close()
+
+	return statusOK, fmt.Sprintf("connected from %s to %s", conn.LocalAddr(), conn.RemoteAddr())
+}
+
+func (d *doctor) checkTLS(ctx context.Context) (checkStatus, string) {
+	if d.cfg.TLSConfig == nil {
+		return statusSkip, "TLS is disabled"
+	}
+
+	dialer := &tls.Dialer{Config: d.cfg.TLSConfig.Clone()}
+	conn, err := dialer.DialContext(ctx, "tcp", d.cfg.Addr)
+	if err != nil {
+		return statusFail, err.Error()
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	detail := fmt.Sprintf("%s, %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	if len(state.PeerCertificates) == 0 {
+		return statusOK, detail
+	}
+
+	cert := state.PeerCertificates[0]
+	detail += fmt.Sprintf(", certificate %q expires %s", cert.Subject.CommonName, cert.NotAfter.Format(time.DateOnly))
+	if time.Until(cert.NotAfter) < certExpiryWarning {
+		return statusWarn, detail
+	}
+	return statusOK, detail
+}
+
+func (d *doctor) checkAuth(ctx context.Context) (checkStatus, string) {
+	var err error
+	d.conn, err = d.connector.Connect(ctx)
+	if errors.Is(err, luna.ErrAuthFailed) {
+		return statusFail, "credentials rejected: " + err.Error()
+	}
+	if err != nil {
+		return statusFail, err.Error()
+	}
+
+	if d.cfg.Password == "" {
+		// A server that expects a password sends its challenge unasked
+		if err := d.conn.(driver.Pinger).Ping(ctx); err != nil {
+			return statusFail, err.Error()
+		}
+		return statusOK, "no password configured, connected without authentication"
+	}
+	return statusOK, "authenticated"
+}
+
+// checkQuery runs a trivial query and decodes its Arrow result.
+func (d *doctor) checkQuery(ctx context.Context) (checkStatus, string) {
+	value, schema, err := d.queryValue(ctx, "SELECT 1 AS one")
+	if err != nil {
+		return statusFail, err.Error()
+	}
+
+	if schema == nil || schema.NumFields() != 1 {
+		return statusFail, fmt.Sprintf("unexpected result schema %v", schema)
+	}
+	if fmt.Sprint(value) != "1" {
+		return statusFail, fmt.Sprintf("expected 1, got %v (%T)", value, value)
+	}
+	return statusOK, fmt.Sprintf("SELECT 1 decoded from Arrow as %s", schema.Field(0).Type)
+}
+
+// checkClock compares the server's clock with the local one.
+func (d *doctor) checkClock(ctx context.Context) (checkStatus, string) {
+	start := time.Now()
+	value, _, err := d.queryValue(ctx, "SELECT now() AS now")
+	if err != nil {
+		return statusWarn, fmt.Sprintf("can't read the server time: %v", err)
+	}
+	rtt := time.Since(start)
+
+	serverTime, ok := value.(time.Time)
+	if !ok {
+		return statusWarn, fmt.Sprintf("unexpected server time %v (%T)", value, value)
+	}
+
+	// The server read its clock somewhere during the round trip
+	skew := serverTime.Sub(start.Add(rtt / 2)).Round(time.Millisecond)
+	detail := fmt.Sprintf("server clock differs by %v (±%v)", skew, (rtt / 2).Round(time.Millisecond))
+	if skew.Abs() > maxClockSkew+rtt/2 {
+		return statusWarn, detail
+	}
+	return statusOK, detail
+}
+
+// queryValue runs query on the doctor's connection and returns the first value
+// of its first row, along with the Arrow schema of the result.
+func (d *doctor) queryValue(ctx context.Context, query string) (driver.Value, *arrow.Schema, error) {
+	dr, err := d.conn.(driver.QueryerContext).QueryContext(ctx, query, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer dr.Close()
+
+	rows, ok := dr.(*luna.Rows)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected driver rows %T", dr)
+	}
+	if len(rows.Columns()) == 0 {
+		return nil, nil, fmt.Errorf("query returned no columns")
+	}
+
+	dest := make([]driver.Value, len(rows.Columns()))
+	if err := rows.Next(dest); err != nil {
+		return nil, nil, fmt.Errorf("failed to read the result: %w", err)
+	}
+	return dest[0], rows.Schema(), nil
+}
+
+// print writes the report as a table, followed by a summary line.
+func (d *doctor) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tTIME\tDETAIL")
+
+	counts := make(map[checkStatus]int)
+	for _, r := range d.report {
+		counts[r.Status]++
+		elapsed := "-"
+		if r.Duration > 0 {
+			elapsed = r.Duration.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Name, strings.ToUpper(string(r.Status)), elapsed, r.Detail)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d checks: %d ok, %d warnings, %d failed, %d skipped\n",
+		len(d.report), counts[statusOK], counts[statusWarn], counts[statusFail], counts[statusSkip])
+}
+
+// writeBundle writes a support bundle with the doctor report and, if the DSN
+// could be parsed, the connector's diagnostics.
+func (d *doctor) writeBundle(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "doctor.json", Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(d.report); err != nil {
+		return err
+	}
+
+	if d.connector != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		defer cancel()
+		if err := d.connector.WriteSupportBundle(ctx, zw); err != nil {
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
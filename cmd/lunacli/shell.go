@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	luna "github.com/flowerinthenight/luna-go"
+	"golang.org/x/term"
+)
+
+// Number of statements kept in the history file.
+const historySize = 1000
+
+// outputModes are the formats results are printed in.
+var outputModes = []string{"table", "csv", "json"}
+
+// shell runs statements typed at a prompt, read from a script or given with -c,
+// and prints their results.
+type shell struct {
+	db *sql.DB
+	// Results go to out, which \o can redirect to a file; messages and errors
+	// always go to the terminal.
+	out     io.Writer
+	outFile *os.File
+	mode    string
+	timing  bool
+}
+
+func runShell(args []string) int {
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	dsn := fs.String("dsn", "localhost:7688", "Luna DSN")
+	user := fs.String("user", "", "user name, overriding the DSN's")
+	password := fs.String("password", "", "password, overriding the DSN's (default $LUNA_PASSWORD)")
+	passwordSource := fs.String("password-source", os.Getenv("LUNA_PASSWORD_SOURCE"), "where to read the password from: env:NAME, cmd:COMMAND or keychain:SERVICE[/ACCOUNT]")
+	useTLS := fs.Bool("tls", false, "connect with TLS")
+	tlsCA := fs.String("tls-ca", "", "PEM file with the CA certificates used to verify the server")
+	tlsInsecure := fs.Bool("tls-insecure-skip-verify", false, "skip server certificate verification")
+	command := fs.String("c", "", "run these statements and exit")
+	mode := fs.String("mode", "table", "output mode: table, csv or json")
+	timing := fs.Bool("timing", false, "print the time each statement takes")
+	historyFile := fs.String("history", defaultHistoryFile(), "file the statements typed at the prompt are saved to (empty to disable)")
+	verbose := fs.Bool("v", false, "log the driver's activity to stderr")
+	fs.Parse(args)
+
+	if !validMode(*mode) {
+		fmt.Fprintf(os.Stderr, "lunacli: unknown output mode %q, expected one of %s\n", *mode, strings.Join(outputModes, ", "))
+		return 2
+	}
+	if *password == "" && *passwordSource != "" {
+		resolved, err := resolvePassword(context.Background(), *passwordSource)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lunacli: %v\n", err)
+			return 1
+		}
+		*password = resolved
+	}
+	if *password == "" {
+		*password = os.Getenv("LUNA_PASSWORD")
+	}
+
+	full, err := shellDSN(*dsn, *user, *password, *useTLS, *tlsCA, *tlsInsecure)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunacli: %v\n", err)
+		return 2
+	}
+	var logs io.Writer = io.Discard
+	if *verbose {
+		logs = os.Stderr
+	}
+	connector, err := luna.NewConnectorWithOptions(full, luna.WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunacli: %v\n", err)
+		return 1
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	// A single connection, so that statements see each other's settings
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = db.PingContext(ctx)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunacli: can't connect to %s: %v\n", connector.Config().Addr, err)
+		return 1
+	}
+
+	s := &shell{db: db, out: os.Stdout, mode: *mode, timing: *timing}
+	defer s.setOutput("")
+
+	switch {
+	case *command != "":
+		return s.runScript(strings.NewReader(*command))
+	case !term.IsTerminal(int(os.Stdin.Fd())):
+		return s.runScript(os.Stdin)
+	}
+	return s.interactive(connector.Config().Addr, *historyFile)
+}
+
+// shellDSN applies the user, password and TLS flags to dsn.
+func shellDSN(dsn, user, password string, useTLS bool, tlsCA string, tlsInsecure bool) (string, error) {
+	if !strings.Contains(dsn, "://") {
+		dsn = "luna://" + dsn
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid DSN: %w", err)
+	}
+
+	if user != "" || password != "" {
+		name := user
+		if name == "" {
+			name = u.User.Username()
+		}
+		u.User = url.UserPassword(name, password)
+	}
+
+	q := u.Query()
+	if useTLS {
+		q.Set("tls", "true")
+	}
+	if tlsCA != "" {
+		q.Set("tls", "true")
+		q.Set("tls_ca", tlsCA)
+	}
+	if tlsInsecure {
+		q.Set("tls", "true")
+		q.Set("tls_insecure_skip_verify", "true")
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// runScript runs the statements read from r, and stops at the first failure.
+func (s *shell) runScript(r io.Reader) int {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunacli: %v\n", err)
+		return 1
+	}
+
+	stmts, rest := splitStatements(string(data))
+	// The last statement of a script doesn't need a semicolon
+	if strings.TrimSpace(rest) != "" {
+		stmts = append(stmts, strings.TrimSpace(rest))
+	}
+	for _, stmt := range stmts {
+		if err := s.run(stmt); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// interactive reads statements and \ commands at a prompt until \q, Ctrl-C or
+// Ctrl-D at an empty prompt.
+func (s *shell) interactive(addr, historyFile string) int {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunacli: %v\n", err)
+		return 1
+	}
+	defer term.Restore(fd, state)
+
+	hist := loadHistory(historyFile)
+	defer hist.close()
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "luna> ")
+	t.History = hist
+
+	// Statements run with the terminal restored, so that their output is
+	// printed as usual and Ctrl-C interrupts them
+	cooked := func(fn func()) {
+		term.Restore(fd, state)
+		fn()
+		term.MakeRaw(fd)
+	}
+
+	fmt.Fprintf(t, "Connected to %s. Type \\? for help.\n", addr)
+	var buf strings.Builder
+	for {
+		line, err := t.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return 0
+		}
+		if err != nil && !errors.Is(err, term.ErrPasteIndicator) {
+			fmt.Fprintf(os.Stderr, "lunacli: %v\r\n", err)
+			return 1
+		}
+
+		if buf.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), `\`) {
+			hist.add(line)
+			quit := false
+			cooked(func() { quit = s.meta(strings.TrimSpace(line)) })
+			if quit {
+				return 0
+			}
+			continue
+		}
+
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		stmts, rest := splitStatements(buf.String())
+		if len(stmts) > 0 {
+			hist.add(strings.TrimSpace(buf.String()[:len(buf.String())-len(rest)]))
+			cooked(func() {
+				for _, stmt := range stmts {
+					if err := s.run(stmt); err != nil {
+						fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+					}
+				}
+			})
+		}
+
+		buf.Reset()
+		if strings.TrimSpace(rest) != "" {
+			buf.WriteString(rest)
+			t.SetPrompt("   -> ")
+		} else {
+			t.SetPrompt("luna> ")
+		}
+	}
+}
+
+// meta runs a \ command, and reports whether the shell should exit.
+func (s *shell) meta(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case `\q`:
+		return true
+	case `\?`:
+		fmt.Println(`Statements end with a semicolon and may span several lines.
+
+  \timing [on|off]    print the time each statement takes
+  \o [file]           write results to file, or back to the terminal
+  \mode table|csv|json
+                      set the output format
+  \q                  quit (or Ctrl-D)`)
+	case `\timing`:
+		switch arg {
+		case "":
+			s.timing = !s.timing
+		case "on":
+			s.timing = true
+		case "off":
+			s.timing = false
+		default:
+			fmt.Fprintln(os.Stderr, `ERROR: \timing takes on or off`)
+			return false
+		}
+		fmt.Printf("Timing is %s.\n", map[bool]string{true: "on", false: "off"}[s.timing])
+	case `\o`:
+		if err := s.setOutput(arg); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		}
+	case `\mode`:
+		if !validMode(arg) {
+			fmt.Fprintf(os.Stderr, "ERROR: unknown output mode %q, expected one of %s\n", arg, strings.Join(outputModes, ", "))
+			return false
+		}
+		s.mode = arg
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: unknown command %s, type \\? for help\n", name)
+	}
+	return false
+}
+
+// setOutput sends results to the named file, or to stdout if name is empty.
+func (s *shell) setOutput(name string) error {
+	if s.outFile != nil {
+		s.outFile.Close()
+		s.outFile = nil
+	}
+	s.out = os.Stdout
+	if name == "" {
+		return nil
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	s.outFile, s.out = f, f
+	return nil
+}
+
+// run runs a statement and prints its result. Ctrl-C interrupts it.
+func (s *shell) run(stmt string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	start := time.Now()
+	var err error
+	if returnsRows(stmt) {
+		err = s.query(ctx, stmt)
+	} else {
+		err = s.exec(ctx, stmt)
+	}
+	if errors.Is(err, context.Canceled) {
+		err = errors.New("statement interrupted")
+	}
+	if err == nil && s.timing {
+		fmt.Printf("Time: %.3f ms\n", float64(time.Since(start).Microseconds())/1000)
+	}
+	return err
+}
+
+// returnsRows reports whether a statement is run as a query, for its result,
+// rather than executed.
+func returnsRows(stmt string) bool {
+	switch luna.Kind(stmt) {
+	case luna.KindDML:
+		return strings.Contains(strings.ToUpper(stmt), "RETURNING")
+	case luna.KindDDL, luna.KindTx:
+		return false
+	}
+	return true
+}
+
+func (s *shell) exec(ctx context.Context, stmt string) error {
+	res, err := s.db.ExecContext(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && luna.Kind(stmt) == luna.KindDML {
+		fmt.Printf("OK, %d rows affected\n", n)
+	} else {
+		fmt.Println("OK")
+	}
+	return nil
+}
+
+func (s *shell) query(ctx context.Context, stmt string) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reader, err := luna.QueryArrow(ctx, conn, stmt)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+
+	// Statements without a result set, e.g. SET
+	if len(reader.Schema().Fields()) == 0 {
+		fmt.Println("OK")
+		return nil
+	}
+
+	switch s.mode {
+	case "csv":
+		return luna.ExportRecords(ctx, s.out, reader, luna.ExportCSV)
+	case "json":
+		return luna.ExportRecords(ctx, s.out, reader, luna.ExportNDJSON)
+	}
+	return writeTable(s.out, reader)
+}
+
+// writeTable prints a result as an aligned table, followed by its row count.
+func writeTable(w io.Writer, reader *luna.RecordReader) error {
+	fields := reader.Schema().Fields()
+	header := make([]string, len(fields))
+	widths := make([]int, len(fields))
+	for i, f := range fields {
+		header[i] = f.Name
+		widths[i] = utf8.RuneCountInString(f.Name)
+	}
+
+	var rows [][]string
+	for reader.Next() {
+		rec := reader.Record()
+		for r := 0; r < int(rec.NumRows()); r++ {
+			row := make([]string, len(fields))
+			for c := range row {
+				col := rec.Column(c)
+				if col.IsNull(r) {
+					row[c] = "NULL"
+				} else {
+					row[c] = strings.ReplaceAll(col.ValueStr(r), "\n", `\n`)
+				}
+				widths[c] = max(widths[c], utf8.RuneCountInString(row[c]))
+			}
+			rows = append(rows, row)
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	writeRow := func(cells []string) {
+		for i, cell := range cells {
+			if i > 0 {
+				bw.WriteString(" | ")
+			} else {
+				bw.WriteString(" ")
+			}
+			bw.WriteString(cell)
+			if i < len(cells)-1 {
+				bw.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			}
+		}
+		bw.WriteString("\n")
+	}
+
+	writeRow(header)
+	for i, width := range widths {
+		if i > 0 {
+			bw.WriteString("+")
+		}
+		bw.WriteString(strings.Repeat("-", width+2))
+	}
+	bw.WriteString("\n")
+	for _, row := range rows {
+		writeRow(row)
+	}
+	if len(rows) == 1 {
+		bw.WriteString("(1 row)\n")
+	} else {
+		fmt.Fprintf(bw, "(%d rows)\n", len(rows))
+	}
+	return bw.Flush()
+}
+
+// splitStatements splits text into the statements ended by semicolons, and the
+// rest after the last one. Semicolons in quoted strings and identifiers and in
+// comments don't end statements.
+func splitStatements(text string) (stmts []string, rest string) {
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\'' || c == '"':
+			for i++; i < len(text) && text[i] != c; i++ {
+			}
+		case c == '-' && i+1 < len(text) && text[i+1] == '-':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == ';':
+			if stmt := strings.TrimSpace(text[start:i]); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			start = i + 1
+		}
+	}
+	return stmts, text[start:]
+}
+
+func validMode(mode string) bool {
+	return slices.Contains(outputModes, mode)
+}
+
+// history is the statement history of the prompt, kept in a file across
+// sessions. Statements are added whole, even if they span several lines.
+type history struct {
+	entries []string
+	file    *os.File
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".luna_history")
+}
+
+// loadHistory reads the history from name, and appends new statements to it.
+// Without a file, the history lasts for the session.
+func loadHistory(name string) *history {
+	h := &history{}
+	if name == "" {
+		return h
+	}
+
+	if data, err := os.ReadFile(name); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				h.entries = append(h.entries, line)
+			}
+		}
+		h.entries = h.entries[max(0, len(h.entries)-historySize):]
+	}
+	h.file, _ = os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	return h
+}
+
+// Add implements term.History. Lines are added by add once a statement is
+// complete instead.
+func (h *history) Add(string) {}
+
+func (h *history) Len() int { return len(h.entries) }
+
+func (h *history) At(i int) string { return h.entries[len(h.entries)-1-i] }
+
+func (h *history) add(entry string) {
+	entry = strings.ReplaceAll(strings.TrimSpace(entry), "\n", " ")
+	if entry == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if h.file != nil {
+		fmt.Fprintln(h.file, entry)
+	}
+}
+
+func (h *history) close() {
+	if h.file != nil {
+		h.file.Close()
+	}
+}
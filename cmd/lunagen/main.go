@@ -0,0 +1,372 @@
+// Command lunagen generates a typed Go struct and an Arrow-native scanner for the
+// result schema of a Luna query or table.
+//
+// The generated scanner reads values straight from the typed Arrow arrays of a
+// record batch, without going through driver.Value and database/sql's Scan, for
+// hot paths where reflection-based mapping is too slow.
+//
+// TIMESTAMP WITH TIME ZONE values are returned in the time zone of their
+// column, as the driver returns them without a timezone setting, and the zone
+// is read from each record batch, so that it follows the server's. TIMESTAMP
+// values have no zone and are returned as UTC times holding their wall clock,
+// like DATE values.
+//
+// Usage:
+//
+//	lunagen -dsn localhost:7688 -table users -type User -package model -o user_gen.go
+//	lunagen -dsn localhost:7688 -query "SELECT id, name FROM users" -type User
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	luna "github.com/flowerinthenight/luna-go"
+)
+
+func main() {
+	dsn := flag.String("dsn", "localhost:7688", "Luna DSN")
+	query := flag.String("query", "", "query whose result schema is used")
+	table := flag.String("table", "", "table whose schema is used (alternative to -query)")
+	typeName := flag.String("type", "", "name of the generated struct (required)")
+	pkg := flag.String("package", "main", "package name of the generated file")
+	out := flag.String("o", "", "output file (default stdout)")
+	flag.Parse()
+
+	if *typeName == "" || (*query == "") == (*table == "") {
+		fmt.Fprintln(os.Stderr, "lunagen: -type and exactly one of -query or -table are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	q := *query
+	if *table != "" {
+		q = "SELECT * FROM " + *table
+	}
+
+	schema, err := fetchSchema(*dsn, q)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunagen: %v\n", err)
+		os.Exit(1)
+	}
+
+	src, err := generate(*pkg, *typeName, q, schema)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunagen: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "lunagen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// fetchSchema runs query with a LIMIT 0 wrapper on a raw driver connection and
+// returns the Arrow schema of its result.
+func fetchSchema(dsn, query string) (*arrow.Schema, error) {
+	db, err := sql.Open("luna", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var schema *arrow.Schema
+	err = conn.Raw(func(dc any) error {
+		queryer, ok := dc.(driver.QueryerContext)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", dc)
+		}
+
+		rows, err := queryer.QueryContext(ctx, "SELECT * FROM ("+query+") LIMIT 0", nil)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		lr, ok := rows.(*luna.Rows)
+		if !ok {
+			return fmt.Errorf("unexpected driver rows %T", rows)
+		}
+
+		schema = lr.Schema()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if schema == nil {
+		return nil, fmt.Errorf("server returned no schema for query")
+	}
+
+	return schema, nil
+}
+
+// goColumn describes how a single Arrow field maps to the generated code.
+type goColumn struct {
+	field    string // Go struct field name
+	name     string // Arrow field name
+	goType   string // Go type of the struct field, without the nullable pointer
+	array    string // Arrow array type to assert the column to
+	value    string // expression converting col.Value(i) into goType, %s is the column variable
+	nullable bool
+	zone     bool // the value is converted to the column's time zone, in the %sLoc variable
+}
+
+// pointer reports whether the struct field is a pointer, to represent NULL.
+func (col goColumn) pointer() bool {
+	return col.nullable && !strings.HasPrefix(col.goType, "[]")
+}
+
+func columnFor(f arrow.Field) (goColumn, error) {
+	col := goColumn{name: f.Name, nullable: f.Nullable, value: "%s.Value(i)"}
+	switch dt := f.Type.(type) {
+	case *arrow.BooleanType:
+		col.goType, col.array = "bool", "Boolean"
+	case *arrow.Int8Type:
+		col.goType, col.array = "int8", "Int8"
+	case *arrow.Int16Type:
+		col.goType, col.array = "int16", "Int16"
+	case *arrow.Int32Type:
+		col.goType, col.array = "int32", "Int32"
+	case *arrow.Int64Type:
+		col.goType, col.array = "int64", "Int64"
+	case *arrow.Uint8Type:
+		col.goType, col.array = "uint8", "Uint8"
+	case *arrow.Uint16Type:
+		col.goType, col.array = "uint16", "Uint16"
+	case *arrow.Uint32Type:
+		col.goType, col.array = "uint32", "Uint32"
+	case *arrow.Uint64Type:
+		col.goType, col.array = "uint64", "Uint64"
+	case *arrow.Float32Type:
+		col.goType, col.array = "float32", "Float32"
+	case *arrow.Float64Type:
+		col.goType, col.array = "float64", "Float64"
+	case *arrow.StringType:
+		col.goType, col.array = "string", "String"
+	case *arrow.LargeStringType:
+		col.goType, col.array = "string", "LargeString"
+	case *arrow.StringViewType:
+		col.goType, col.array = "string", "StringView"
+	case *arrow.BinaryType:
+		// Copy, since the value aliases the Arrow buffer
+		col.goType, col.array = "[]byte", "Binary"
+		col.value = "bytes.Clone(%s.Value(i))"
+	case *arrow.LargeBinaryType:
+		col.goType, col.array = "[]byte", "LargeBinary"
+		col.value = "bytes.Clone(%s.Value(i))"
+	case *arrow.BinaryViewType:
+		col.goType, col.array = "[]byte", "BinaryView"
+		col.value = "bytes.Clone(%s.Value(i))"
+	case *arrow.Date32Type:
+		col.goType, col.array = "time.Time", "Date32"
+		col.value = "%s.Value(i).ToTime()"
+	case *arrow.Date64Type:
+		col.goType, col.array = "time.Time", "Date64"
+		col.value = "%s.Value(i).ToTime()"
+	case *arrow.TimestampType:
+		col.goType, col.array = "time.Time", "Timestamp"
+		col.value = fmt.Sprintf("%%s.Value(i).ToTime(arrow.%s)", timeUnitName(dt.Unit))
+		if dt.TimeZone != "" {
+			col.value += ".In(%sLoc)"
+			col.zone = true
+		}
+	case *arrow.Decimal128Type:
+		col.goType, col.array = "string", "Decimal128"
+		col.value = fmt.Sprintf("%%s.Value(i).ToString(%d)", dt.Scale)
+	case *arrow.Decimal256Type:
+		col.goType, col.array = "string", "Decimal256"
+		col.value = fmt.Sprintf("%%s.Value(i).ToString(%d)", dt.Scale)
+	default:
+		return col, fmt.Errorf("column %q: unsupported Arrow type %s", f.Name, f.Type)
+	}
+
+	return col, nil
+}
+
+func timeUnitName(unit arrow.TimeUnit) string {
+	switch unit {
+	case arrow.Second:
+		return "Second"
+	case arrow.Millisecond:
+		return "Millisecond"
+	case arrow.Microsecond:
+		return "Microsecond"
+	default:
+		return "Nanosecond"
+	}
+}
+
+// generate renders and formats the source file for schema.
+func generate(pkg, typeName, query string, schema *arrow.Schema) ([]byte, error) {
+	var cols []goColumn
+	used := map[string]int{}
+	for _, f := range schema.Fields() {
+		col, err := columnFor(f)
+		if err != nil {
+			return nil, err
+		}
+
+		col.field = exportedName(f.Name)
+		if n := used[col.field]; n > 0 {
+			col.field = fmt.Sprintf("%s%d", col.field, n+1)
+		}
+		used[col.field]++
+		cols = append(cols, col)
+	}
+
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("query result has no columns")
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by lunagen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "import (\n")
+	for _, imp := range imports(cols) {
+		if imp == "" {
+			fmt.Fprintf(&b, "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "\t%q\n", imp)
+	}
+	fmt.Fprintf(&b, ")\n\n")
+
+	fmt.Fprintf(&b, "// %s is a row of:\n//\n", typeName)
+	for _, line := range strings.Split(strings.TrimSpace(query), "\n") {
+		fmt.Fprintf(&b, "//\t%s\n", strings.TrimSpace(line))
+	}
+	fmt.Fprintf(&b, "type %s struct {\n", typeName)
+	for _, col := range cols {
+		typ := col.goType
+		if col.pointer() {
+			typ = "*" + typ
+		}
+		fmt.Fprintf(&b, "\t%s %s `luna:%q`\n", col.field, typ, col.name)
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "// Scan%s decodes every row of rec into a %s, reading the Arrow arrays directly.\n", typeName, typeName)
+	fmt.Fprintf(&b, "func Scan%s(rec arrow.Record) ([]%s, error) {\n", typeName, typeName)
+	fmt.Fprintf(&b, "\tif rec.NumCols() != %d {\n", len(cols))
+	fmt.Fprintf(&b, "\t\treturn nil, fmt.Errorf(\"expected %d columns, got %%d\", rec.NumCols())\n", len(cols))
+	fmt.Fprintf(&b, "\t}\n\n")
+	for i, col := range cols {
+		fmt.Fprintf(&b, "\tc%d, ok := rec.Column(%d).(*array.%s)\n", i, i, col.array)
+		fmt.Fprintf(&b, "\tif !ok {\n")
+		fmt.Fprintf(&b, "\t\treturn nil, fmt.Errorf(\"column %d (%s): expected *array.%s, got %%T\", rec.Column(%d))\n", i, col.name, col.array, i)
+		fmt.Fprintf(&b, "\t}\n")
+	}
+	for i, col := range cols {
+		if !col.zone {
+			continue
+		}
+		fmt.Fprintf(&b, "\tc%dLoc, err := c%d.DataType().(*arrow.TimestampType).GetZone()\n", i, i)
+		fmt.Fprintf(&b, "\tif err != nil {\n")
+		fmt.Fprintf(&b, "\t\treturn nil, fmt.Errorf(\"column %d (%s): %%w\", err)\n", i, col.name)
+		fmt.Fprintf(&b, "\t}\n")
+	}
+	fmt.Fprintf(&b, "\n\tout := make([]%s, rec.NumRows())\n", typeName)
+	fmt.Fprintf(&b, "\tfor i := range out {\n")
+	for i, col := range cols {
+		value := strings.ReplaceAll(col.value, "%s", fmt.Sprintf("c%d", i))
+		if col.pointer() {
+			fmt.Fprintf(&b, "\t\tif c%d.IsValid(i) {\n", i)
+			fmt.Fprintf(&b, "\t\t\tv := %s\n", value)
+			fmt.Fprintf(&b, "\t\t\tout[i].%s = &v\n", col.field)
+			fmt.Fprintf(&b, "\t\t}\n")
+		} else if col.nullable {
+			// Slices represent NULL as nil
+			fmt.Fprintf(&b, "\t\tif c%d.IsValid(i) {\n", i)
+			fmt.Fprintf(&b, "\t\t\tout[i].%s = %s\n", col.field, value)
+			fmt.Fprintf(&b, "\t\t}\n")
+		} else {
+			fmt.Fprintf(&b, "\t\tout[i].%s = %s\n", col.field, value)
+		}
+	}
+	fmt.Fprintf(&b, "\t}\n\n")
+	fmt.Fprintf(&b, "\treturn out, nil\n")
+	fmt.Fprintf(&b, "}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+
+	return src, nil
+}
+
+func imports(cols []goColumn) []string {
+	var needBytes, needTime bool
+	for _, col := range cols {
+		needBytes = needBytes || strings.HasPrefix(col.value, "bytes.")
+		needTime = needTime || col.goType == "time.Time"
+	}
+
+	var imps []string
+	if needBytes {
+		imps = append(imps, "bytes")
+	}
+	imps = append(imps, "fmt")
+	if needTime {
+		imps = append(imps, "time")
+	}
+
+	// An empty entry separates the standard library from third-party imports
+	return append(imps, "", "github.com/apache/arrow/go/v17/arrow", "github.com/apache/arrow/go/v17/arrow/array")
+}
+
+// Common initialisms kept upper-case in generated field names.
+var initialisms = map[string]bool{
+	"ID": true, "URL": true, "URI": true, "API": true, "IP": true,
+	"JSON": true, "SQL": true, "UUID": true, "HTTP": true,
+}
+
+// exportedName converts a column name such as "user_id" into an exported Go
+// identifier such as "UserID".
+func exportedName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var b strings.Builder
+	for _, part := range parts {
+		if upper := strings.ToUpper(part); initialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+
+	ident := b.String()
+	if ident == "" || !unicode.IsLetter([]rune(ident)[0]) {
+		ident = "Col" + ident
+	}
+
+	return ident
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the generated code")
+
+// TestGenerate compares the code generated for each schema against the golden
+// files in testdata. Run `go test ./cmd/lunagen -update` to accept changes.
+func TestGenerate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		typeName string
+		query    string
+		fields   []arrow.Field
+	}{
+		{
+			name:     "scalars",
+			typeName: "Order",
+			query:    "SELECT * FROM orders",
+			fields: []arrow.Field{
+				{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+				{Name: "user_id", Type: arrow.PrimitiveTypes.Int32},
+				{Name: "paid", Type: arrow.FixedWidthTypes.Boolean},
+				{Name: "quantity", Type: arrow.PrimitiveTypes.Uint16},
+				{Name: "weight", Type: arrow.PrimitiveTypes.Float32},
+				{Name: "score", Type: arrow.PrimitiveTypes.Float64},
+				{Name: "status", Type: arrow.BinaryTypes.String},
+				{Name: "amount", Type: &arrow.Decimal128Type{Precision: 18, Scale: 2}},
+			},
+		},
+		{
+			name:     "nullable",
+			typeName: "Document",
+			query:    "SELECT id, title, body, checksum, rank\nFROM documents\nWHERE deleted IS NULL",
+			fields: []arrow.Field{
+				{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+				{Name: "title", Type: arrow.BinaryTypes.String, Nullable: true},
+				{Name: "body", Type: arrow.BinaryTypes.LargeString, Nullable: true},
+				{Name: "checksum", Type: arrow.BinaryTypes.Binary, Nullable: true},
+				{Name: "rank", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+			},
+		},
+		{
+			name:     "names",
+			typeName: "Event",
+			query:    "SELECT * FROM events",
+			fields: []arrow.Field{
+				{Name: "event_uuid", Type: arrow.BinaryTypes.String},
+				{Name: "api-url", Type: arrow.BinaryTypes.String},
+				{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+				{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+				{Name: "2nd", Type: arrow.PrimitiveTypes.Int64},
+			},
+		},
+		{
+			name:     "times",
+			typeName: "Visit",
+			query:    "SELECT * FROM visits",
+			fields: []arrow.Field{
+				{Name: "day", Type: arrow.FixedWidthTypes.Date32},
+				{Name: "started", Type: &arrow.TimestampType{Unit: arrow.Microsecond}},
+				{Name: "logged", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}, Nullable: true},
+				{Name: "local", Type: &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "Europe/Paris"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := generate("model", tc.typeName, tc.query, arrow.NewSchema(tc.fields, nil))
+			if err != nil {
+				t.Fatalf("generate failed: %v", err)
+			}
+
+			golden := filepath.Join("testdata", tc.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("failed to write %s: %v", golden, err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", golden, err)
+			}
+			if string(got) != string(expected) {
+				t.Errorf("generated code differs from %s:\n%s", golden, got)
+				t.Log("if the change is intended, run: go test ./cmd/lunagen -update")
+			}
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fields   []arrow.Field
+		expected string
+	}{
+		{
+			name:     "no columns",
+			expected: "query result has no columns",
+		},
+		{
+			name:     "unsupported type",
+			fields:   []arrow.Field{{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)}},
+			expected: `column "tags": unsupported Arrow type list<item: utf8, nullable>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := generate("model", "Row", "SELECT 1", arrow.NewSchema(tc.fields, nil))
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
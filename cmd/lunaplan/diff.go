@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// thresholds control which changes between a baseline and a current plan are
+// reported.
+type thresholds struct {
+	// Timings are reported when they grow by more than this factor...
+	timeFactor float64
+	// ...and by more than this many seconds.
+	minTime float64
+	// Row counts and estimates are reported when they change by more than this
+	// factor, either way.
+	rowsFactor float64
+}
+
+// finding is a difference between the baseline and the current plan of a query.
+type finding struct {
+	query string
+	// Path of the operator in the plan, e.g. "HASH_JOIN > SEQ_SCAN", empty for the
+	// whole query.
+	path    string
+	message string
+	// True for changes that fail the check; other findings are informational.
+	regression bool
+}
+
+// diffPlanFiles compares the plans of each query in cur with those of the same
+// name in base.
+func diffPlanFiles(base, cur *planFile, th thresholds) []finding {
+	baseByName := make(map[string]*Plan, len(base.Plans))
+	for _, p := range base.Plans {
+		baseByName[p.Name] = p
+	}
+
+	var findings []finding
+	seen := make(map[string]bool, len(cur.Plans))
+	for _, p := range cur.Plans {
+		seen[p.Name] = true
+		b, ok := baseByName[p.Name]
+		if !ok {
+			findings = append(findings, finding{query: p.Name, message: "not in the baseline"})
+			continue
+		}
+		if b.Query != p.Query {
+			findings = append(findings, finding{query: p.Name, message: "query text changed since the baseline"})
+		}
+		findings = append(findings, diffPlans(b, p, th)...)
+	}
+
+	for _, p := range base.Plans {
+		if !seen[p.Name] {
+			findings = append(findings, finding{query: p.Name, message: "in the baseline but not in the queries file"})
+		}
+	}
+	return findings
+}
+
+// diffPlans compares two plans of a query.
+func diffPlans(base, cur *Plan, th thresholds) []finding {
+	var findings []finding
+	if th.slower(base.Latency, cur.Latency) {
+		findings = append(findings, finding{
+			query:      cur.Name,
+			message:    fmt.Sprintf("latency %s -> %s", seconds(base.Latency), seconds(cur.Latency)),
+			regression: true,
+		})
+	}
+	return diffOperators(findings, cur.Name, "", base.Root, cur.Root, th)
+}
+
+// diffOperators compares two operators in the same position of a plan, and their
+// children if the operators are the same.
+func diffOperators(findings []finding, query, parent string, base, cur *Operator, th thresholds) []finding {
+	if base == nil || cur == nil {
+		return findings
+	}
+
+	path := cur.Name
+	if parent != "" {
+		path = parent + " > " + cur.Name
+	}
+
+	if base.Name != cur.Name {
+		// The rest of the subtree isn't comparable anymore
+		return append(findings, finding{
+			query:      query,
+			path:       parent,
+			message:    fmt.Sprintf("operator %s replaced by %s", base.Name, cur.Name),
+			regression: true,
+		})
+	}
+
+	if th.rowsChanged(base.Rows, cur.Rows) {
+		findings = append(findings, finding{
+			query:      query,
+			path:       path,
+			message:    fmt.Sprintf("rows %d -> %d", base.Rows, cur.Rows),
+			regression: true,
+		})
+	}
+	if base.EstimatedRows >= 0 && cur.EstimatedRows >= 0 && th.rowsChanged(base.EstimatedRows, cur.EstimatedRows) {
+		findings = append(findings, finding{
+			query:      query,
+			path:       path,
+			message:    fmt.Sprintf("estimated rows %d -> %d", base.EstimatedRows, cur.EstimatedRows),
+			regression: true,
+		})
+	}
+	if th.slower(base.Time, cur.Time) {
+		findings = append(findings, finding{
+			query:      query,
+			path:       path,
+			message:    fmt.Sprintf("time %s -> %s", seconds(base.Time), seconds(cur.Time)),
+			regression: true,
+		})
+	}
+
+	if len(base.Children) != len(cur.Children) {
+		return append(findings, finding{
+			query:      query,
+			path:       path,
+			message:    fmt.Sprintf("%d inputs -> %d", len(base.Children), len(cur.Children)),
+			regression: true,
+		})
+	}
+	for i := range cur.Children {
+		findings = diffOperators(findings, query, path, base.Children[i], cur.Children[i], th)
+	}
+	return findings
+}
+
+// slower reports whether a timing grew past the thresholds.
+func (th thresholds) slower(base, cur float64) bool {
+	return cur-base > th.minTime && cur > base*th.timeFactor
+}
+
+// rowsChanged reports whether a row count changed by more than the rows factor.
+// Counts are offset by one, so that going from 0 to a few rows isn't reported.
+func (th thresholds) rowsChanged(base, cur int64) bool {
+	b, c := float64(base+1), float64(cur+1)
+	return c > b*th.rowsFactor || b > c*th.rowsFactor
+}
+
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond).String()
+}
+
+// printFindings writes a report of the findings, regressions first.
+func printFindings(w io.Writer, findings []finding) {
+	var regressions int
+	for _, f := range findings {
+		if f.regression {
+			regressions++
+		}
+	}
+
+	for _, regression := range []bool{true, false} {
+		for _, f := range findings {
+			if f.regression != regression {
+				continue
+			}
+
+			label := "NOTE"
+			if f.regression {
+				label = "REGRESSION"
+			}
+			where := f.query
+			if f.path != "" {
+				where += ": " + f.path
+			}
+			fmt.Fprintf(w, "%-10s  %s: %s\n", label, where, f.message)
+		}
+	}
+
+	switch regressions {
+	case 0:
+		fmt.Fprintln(w, "no regressions")
+	case 1:
+		fmt.Fprintln(w, "1 regression")
+	default:
+		fmt.Fprintf(w, "%d regressions\n", regressions)
+	}
+}
@@ -0,0 +1,234 @@
+// Command lunaplan catches query performance regressions by running EXPLAIN
+// ANALYZE for a set of queries and comparing the plans with a stored baseline:
+// operator changes, row estimates and counts that moved by more than a factor,
+// and timings that grew past a threshold.
+//
+// Usage:
+//
+//	lunaplan -dsn localhost:7688 -queries queries.sql -baseline plans.json -update
+//	lunaplan -dsn localhost:7688 -queries queries.sql -baseline plans.json
+//
+// The queries file holds SQL statements ending with a semicolon at the end of a
+// line. A "-- name: <name>" comment before a statement names it in reports;
+// unnamed statements are named after their position, e.g. "q2".
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/flowerinthenight/luna-go"
+)
+
+func main() {
+	dsn := flag.String("dsn", "localhost:7688", "Luna DSN")
+	queries := flag.String("queries", "", "file with the queries to explain (required)")
+	baseline := flag.String("baseline", "", "baseline plans file to compare with, or to write with -update (required)")
+	update := flag.Bool("update", false, "write the current plans to the baseline file instead of comparing")
+	out := flag.String("o", "", "also write the current plans to this file")
+	runs := flag.Int("runs", 3, "runs per query; the run with the median latency is kept")
+	timeFactor := flag.Float64("time-factor", 1.5, "report timings that grew by more than this factor")
+	minTime := flag.Duration("min-time", 10*time.Millisecond, "ignore timing changes smaller than this")
+	rowsFactor := flag.Float64("rows-factor", 10, "report row counts and estimates that changed by more than this factor")
+	timeout := flag.Duration("timeout", 5*time.Minute, "time limit of each query run")
+	flag.Parse()
+
+	if *queries == "" || *baseline == "" || *runs < 1 {
+		fmt.Fprintln(os.Stderr, "lunaplan: -queries and -baseline are required, and -runs must be positive")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	qs, err := readQueriesFile(*queries)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunaplan: %v\n", err)
+		os.Exit(1)
+	}
+
+	plans, err := explainAll(*dsn, qs, *runs, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunaplan: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *out != "" {
+		if err := writePlans(*out, plans); err != nil {
+			fmt.Fprintf(os.Stderr, "lunaplan: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *update {
+		if err := writePlans(*baseline, plans); err != nil {
+			fmt.Fprintf(os.Stderr, "lunaplan: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("wrote %d plans to %s\n", len(plans.Plans), *baseline)
+		return
+	}
+
+	base, err := readPlans(*baseline)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "lunaplan: no baseline at %s, record one with -update\n", *baseline)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunaplan: %v\n", err)
+		os.Exit(1)
+	}
+
+	th := thresholds{timeFactor: *timeFactor, minTime: minTime.Seconds(), rowsFactor: *rowsFactor}
+	findings := diffPlanFiles(base, plans, th)
+	printFindings(os.Stdout, findings)
+	for _, f := range findings {
+		if f.regression {
+			os.Exit(1)
+		}
+	}
+}
+
+// namedQuery is a query of the queries file.
+type namedQuery struct {
+	name string
+	sql  string
+}
+
+func readQueriesFile(path string) ([]namedQuery, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	qs, err := parseQueries(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(qs) == 0 {
+		return nil, fmt.Errorf("%s: no queries", path)
+	}
+	return qs, nil
+}
+
+// parseQueries splits r into statements ending with a semicolon at the end of a
+// line, named by the "-- name:" comment before them, if any.
+func parseQueries(r io.Reader) ([]namedQuery, error) {
+	var qs []namedQuery
+	var name string
+	var lines []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if n, ok := strings.CutPrefix(line, "-- name:"); ok {
+			name = strings.TrimSpace(n)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "--") {
+			continue
+		}
+
+		lines = append(lines, line)
+		if !strings.HasSuffix(line, ";") {
+			continue
+		}
+
+		if name == "" {
+			name = fmt.Sprintf("q%d", len(qs)+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate query name %q", name)
+		}
+		seen[name] = true
+
+		query := strings.TrimSuffix(strings.Join(lines, "\n"), ";")
+		qs = append(qs, namedQuery{name: name, sql: query})
+		name, lines = "", nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) > 0 {
+		return nil, fmt.Errorf("query %q doesn't end with a semicolon", strings.Join(lines, " "))
+	}
+	return qs, nil
+}
+
+// explainAll runs EXPLAIN ANALYZE runs times for each query, and keeps the plan
+// of the run with the median latency.
+func explainAll(dsn string, qs []namedQuery, runs int, timeout time.Duration) (*planFile, error) {
+	db, err := sql.Open("luna", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	out := &planFile{Created: time.Now().UTC()}
+	for _, q := range qs {
+		var samples []*Plan
+		for i := 0; i < runs; i++ {
+			plan, err := explain(db, q, timeout)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", q.name, err)
+			}
+			samples = append(samples, plan)
+		}
+
+		sort.Slice(samples, func(i, j int) bool { return samples[i].Latency < samples[j].Latency })
+		out.Plans = append(out.Plans, samples[len(samples)/2])
+	}
+	return out, nil
+}
+
+// explain runs EXPLAIN ANALYZE for a query and parses its JSON profile. The
+// result has an explain_key and an explain_value column, the latter holding the
+// profile.
+func explain(db *sql.DB, q namedQuery, timeout time.Duration) (*Plan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var key, value string
+	err := db.QueryRowContext(ctx, "EXPLAIN (ANALYZE, FORMAT JSON) "+q.sql).Scan(&key, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := parseProfile([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse the plan: %w", err)
+	}
+	plan.Name, plan.Query = q.name, q.sql
+	return plan, nil
+}
+
+func writePlans(path string, plans *planFile) error {
+	b, err := json.MarshalIndent(plans, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+func readPlans(path string) (*planFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans planFile
+	if err := json.Unmarshal(b, &plans); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &plans, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// planFile is the format of baseline files.
+type planFile struct {
+	Created time.Time `json:"created"`
+	Plans   []*Plan   `json:"plans"`
+}
+
+// Plan is the profile of a query run with EXPLAIN ANALYZE.
+type Plan struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	// Latency is the run time of the whole query, in seconds.
+	Latency float64   `json:"latency"`
+	Root    *Operator `json:"root"`
+}
+
+// Operator is a node of a query plan.
+type Operator struct {
+	Name string `json:"name"`
+	// Rows is the number of rows the operator produced.
+	Rows int64 `json:"rows"`
+	// EstimatedRows is the optimizer's estimate of Rows, or -1 if the server
+	// didn't report one.
+	EstimatedRows int64 `json:"estimated_rows"`
+	// Time is the time spent in the operator, in seconds.
+	Time     float64     `json:"time"`
+	Children []*Operator `json:"children,omitempty"`
+}
+
+// profileNode is a node of the JSON profile of EXPLAIN (ANALYZE, FORMAT JSON).
+// Older servers use the names without the operator_ prefix.
+type profileNode struct {
+	OperatorName        string          `json:"operator_name"`
+	Name                string          `json:"name"`
+	OperatorTiming      *float64        `json:"operator_timing"`
+	Timing              *float64        `json:"timing"`
+	OperatorCardinality *int64          `json:"operator_cardinality"`
+	Cardinality         *int64          `json:"cardinality"`
+	Latency             *float64        `json:"latency"`
+	ExtraInfo           json.RawMessage `json:"extra_info"`
+	Children            []profileNode   `json:"children"`
+}
+
+// Older servers report estimates in the extra_info text, e.g. "EC: 1000".
+var estimateText = regexp.MustCompile(`EC:\s*~?(\d+)`)
+
+// parseProfile converts the JSON profile of a query to a Plan. The top level
+// node describes the query; its single child is the root operator.
+func parseProfile(b []byte) (*Plan, error) {
+	var top profileNode
+	if err := json.Unmarshal(b, &top); err != nil {
+		return nil, err
+	}
+	if len(top.Children) != 1 {
+		return nil, fmt.Errorf("expected a single root operator, got %d", len(top.Children))
+	}
+
+	plan := &Plan{Root: convertNode(top.Children[0])}
+	switch {
+	case top.Latency != nil:
+		plan.Latency = *top.Latency
+	case top.OperatorTiming != nil:
+		plan.Latency = *top.OperatorTiming
+	case top.Timing != nil:
+		plan.Latency = *top.Timing
+	}
+	return plan, nil
+}
+
+func convertNode(n profileNode) *Operator {
+	op := &Operator{
+		Name:          strings.TrimSpace(n.OperatorName),
+		EstimatedRows: estimatedRows(n.ExtraInfo),
+	}
+	if op.Name == "" {
+		op.Name = strings.TrimSpace(n.Name)
+	}
+	if n.OperatorCardinality != nil {
+		op.Rows = *n.OperatorCardinality
+	} else if n.Cardinality != nil {
+		op.Rows = *n.Cardinality
+	}
+	if n.OperatorTiming != nil {
+		op.Time = *n.OperatorTiming
+	} else if n.Timing != nil {
+		op.Time = *n.Timing
+	}
+
+	for _, c := range n.Children {
+		op.Children = append(op.Children, convertNode(c))
+	}
+	return op
+}
+
+// estimatedRows extracts the optimizer's row estimate from the extra_info of a
+// profile node, which is an object with an "Estimated Cardinality" entry, or
+// text on older servers. It returns -1 if there's no estimate.
+func estimatedRows(extra json.RawMessage) int64 {
+	var info map[string]any
+	if err := json.Unmarshal(extra, &info); err == nil {
+		var s string
+		switch v := info["Estimated Cardinality"].(type) {
+		case string:
+			s = v
+		case float64:
+			return int64(v)
+		}
+		if n, err := strconv.ParseInt(strings.TrimPrefix(s, "~"), 10, 64); err == nil {
+			return n
+		}
+		return -1
+	}
+
+	var text string
+	if err := json.Unmarshal(extra, &text); err == nil {
+		if m := estimateText.FindStringSubmatch(text); m != nil {
+			if n, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+				return n
+			}
+		}
+	}
+	return -1
+}
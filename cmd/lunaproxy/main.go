@@ -0,0 +1,51 @@
+// Command lunaproxy captures the protocol traffic between clients and a Luna
+// server, and replays captured sessions as a mock server, to reproduce protocol
+// bugs reported by users without access to their server or data.
+//
+// Usage:
+//
+//	lunaproxy record -listen 127.0.0.1:7689 -upstream luna.example.com:7688 -o session.jsonl
+//	lunaproxy replay -listen 127.0.0.1:7689 -i session.jsonl
+//
+// Point the application at the -listen address while recording. A capture is a
+// JSON Lines file with a line per frame sent by a client and per chunk of bytes
+// sent by the server, tagged with the connection they belong to. Replaying
+// serves the captured connections in order, sending the server's bytes back as
+// long as clients send the captured commands.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commands maps subcommand names to their entry points, which receive the
+// remaining arguments and return the process exit code.
+var commands = map[string]func(args []string) int{
+	"record": runRecord,
+	"replay": runReplay,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "lunaproxy: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	os.Exit(run(os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: lunaproxy <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  record   forward connections to a server and capture their traffic to a file")
+	fmt.Fprintln(os.Stderr, "  replay   serve captured connections as a mock server")
+}
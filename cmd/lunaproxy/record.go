@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+)
+
+// runRecord forwards the connections accepted on -listen to -upstream until
+// interrupted, capturing their traffic.
+func runRecord(args []string) int {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:7689", "address to accept client connections on")
+	upstream := fs.String("upstream", "localhost:7688", "address of the Luna server")
+	out := fs.String("o", "session.jsonl", "capture file to write")
+	verbose := fs.Bool("v", false, "print each frame as it's captured")
+	fs.Parse(args)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunaproxy: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunaproxy: %v\n", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	r := &recorder{upstream: *upstream, capture: newCaptureWriter(f), verbose: *verbose}
+	fmt.Fprintf(os.Stderr, "lunaproxy: forwarding %s to %s, capturing to %s\n", ln.Addr(), *upstream, *out)
+	r.serve(ln)
+	fmt.Fprintf(os.Stderr, "lunaproxy: captured %d connections\n", r.seq.Load())
+	return 0
+}
+
+// recorder forwards client connections to the server, capturing their frames.
+type recorder struct {
+	upstream string
+	capture  *captureWriter
+	verbose  bool
+	seq      atomic.Int64
+}
+
+// serve handles the connections accepted on ln until it's closed, then waits for
+// them to end.
+func (r *recorder) serve(ln net.Listener) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	open := make(map[net.Conn]struct{})
+	for {
+		client, err := ln.Accept()
+		if err != nil {
+			break
+		}
+		mu.Lock()
+		open[client] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.handle(int(r.seq.Add(1)), client)
+			mu.Lock()
+			delete(open, client)
+			mu.Unlock()
+		}()
+	}
+
+	// Interrupted: end the connections still open
+	mu.Lock()
+	for conn := range open {
+		conn.Close()
+	}
+	mu.Unlock()
+	wg.Wait()
+}
+
+func (r *recorder) handle(id int, client net.Conn) {
+	defer client.Close()
+	server, err := net.Dial("tcp", r.upstream)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunaproxy: conn %d: %v\n", id, err)
+		return
+	}
+	defer server.Close()
+
+	// Closing both sides ends the other direction too
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.forwardClient(id, client, server)
+		server.Close()
+		client.Close()
+	}()
+	r.forwardServer(id, client, server)
+	server.Close()
+	client.Close()
+	<-done
+}
+
+// forwardClient copies the frames sent by the client to the server.
+func (r *recorder) forwardClient(id int, client, server net.Conn) {
+	reader := bufio.NewReader(client)
+	for {
+		payload, raw, err := readClientFrame(reader)
+		if len(raw) > 0 {
+			// Captured before it's forwarded, so that it comes before its reply
+			r.record(frame{Conn: id, Client: payload})
+			if _, werr := server.Write(raw); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				r.record(frame{Conn: id, EOF: "client"})
+			}
+			return
+		}
+	}
+}
+
+// forwardServer copies the bytes sent by the server to the client, in the
+// chunks they arrive in.
+func (r *recorder) forwardServer(id int, client, server net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := server.Read(buf)
+		if n > 0 {
+			r.record(frame{Conn: id, Server: append([]byte(nil), buf[:n]...)})
+			if _, werr := client.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				r.record(frame{Conn: id, EOF: "server"})
+			}
+			return
+		}
+	}
+}
+
+func (r *recorder) record(f frame) {
+	if err := r.capture.write(f); err != nil {
+		fmt.Fprintf(os.Stderr, "lunaproxy: failed to write capture: %v\n", err)
+	}
+	if r.verbose {
+		fmt.Fprintln(os.Stderr, f.describe())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// runReplay serves the connections of a capture to the clients connecting to
+// -listen, one captured connection per client connection, and exits once they
+// have all been replayed, with status 1 if a client strayed from the capture.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:7689", "address to accept client connections on")
+	in := fs.String("i", "session.jsonl", "capture file to replay")
+	verbose := fs.Bool("v", false, "print each frame as it's replayed")
+	fs.Parse(args)
+
+	conns, err := readCapture(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunaproxy: %v\n", err)
+		return 1
+	}
+	if len(conns) == 0 {
+		fmt.Fprintf(os.Stderr, "lunaproxy: %s has no connections\n", *in)
+		return 1
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunaproxy: %v\n", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "lunaproxy: replaying %d connections of %s on %s\n", len(conns), *in, ln.Addr())
+	r := &replayer{verbose: *verbose}
+	r.serve(ln, conns)
+	if n := r.mismatches.Load(); n > 0 {
+		fmt.Fprintf(os.Stderr, "lunaproxy: %d connections strayed from the capture\n", n)
+		return 1
+	}
+	return 0
+}
+
+// replayer plays the server's side of captured connections.
+type replayer struct {
+	verbose    bool
+	mismatches atomic.Int64
+}
+
+// serve replays a captured connection to each connection accepted on ln, in
+// order, until all have been replayed or ln is closed.
+func (r *replayer) serve(ln net.Listener, conns [][]frame) {
+	var wg sync.WaitGroup
+	for _, frames := range conns {
+		client, err := ln.Accept()
+		if err != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer client.Close()
+			if err := r.replay(client, frames); err != nil {
+				r.mismatches.Add(1)
+				fmt.Fprintf(os.Stderr, "lunaproxy: %v\n", err)
+				fmt.Fprintf(client, "-ERR lunaproxy: %s\r\n", strings.ReplaceAll(err.Error(), "\n", " "))
+			}
+		}()
+	}
+	wg.Wait()
+	ln.Close()
+}
+
+// replay sends the server's bytes of a captured connection to client, checking
+// that the client sends the captured commands in between. Other client frames,
+// e.g. authentication responses, which are salted, are read but not compared.
+func (r *replayer) replay(client net.Conn, frames []frame) error {
+	reader := bufio.NewReader(client)
+	for _, f := range frames {
+		if r.verbose {
+			fmt.Fprintln(os.Stderr, f.describe())
+		}
+
+		switch {
+		case f.Server != nil:
+			if _, err := client.Write(f.Server); err != nil {
+				return fmt.Errorf("conn %d: %w", f.Conn, err)
+			}
+		case f.EOF == "server":
+			return nil
+		case f.EOF == "client":
+			// Wait for the client to close its side
+			io.Copy(io.Discard, reader)
+			return nil
+		default:
+			got, _, err := readClientFrame(reader)
+			if err != nil {
+				return fmt.Errorf("conn %d: expected %q, got %v", f.Conn, f.Client, err)
+			}
+			if isCommand(f.Client) && got != f.Client {
+				return fmt.Errorf("conn %d: expected %q, got %q", f.Conn, f.Client, got)
+			}
+		}
+	}
+	return nil
+}
+
+// isCommand reports whether a client frame is a query or execute command.
+func isCommand(payload string) bool {
+	return strings.HasPrefix(payload, "q:") || strings.HasPrefix(payload, "x:")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// frame is a line of a capture: a frame a client sent, or a chunk of bytes the
+// server sent, on a connection.
+type frame struct {
+	// Conn numbers the connections of a capture from 1, in the order they were
+	// accepted.
+	Conn int `json:"conn"`
+	// Time is when the frame was read, in nanoseconds since the capture started.
+	Time time.Duration `json:"time"`
+	// Client is the payload of a RESP bulk frame sent by the client, e.g.
+	// "q:SELECT 1", or the raw bytes of anything else it sent, prefixed with
+	// "raw:". Empty for server frames.
+	Client string `json:"client,omitempty"`
+	// Server is a chunk of bytes sent by the server, as received.
+	Server []byte `json:"server,omitempty"`
+	// EOF marks the side of the connection that closed it, "client" or "server".
+	EOF string `json:"eof,omitempty"`
+}
+
+// captureWriter appends the frames of all connections to a capture file.
+type captureWriter struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+}
+
+func newCaptureWriter(w io.Writer) *captureWriter {
+	return &captureWriter{enc: json.NewEncoder(w), start: time.Now()}
+}
+
+func (c *captureWriter) write(f frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.Time = time.Since(c.start)
+	return c.enc.Encode(f)
+}
+
+// readClientFrame reads a frame sent by a client: a RESP bulk frame
+// ($<length>\r\n<data>\r\n), returned as its data, or whatever bytes are buffered
+// otherwise, returned with a "raw:" prefix. It also returns the frame's bytes as
+// sent.
+func readClientFrame(reader *bufio.Reader) (payload string, raw []byte, err error) {
+	b, err := reader.Peek(1)
+	if err != nil {
+		return "", nil, err
+	}
+	if b[0] != '$' {
+		raw, err := reader.Peek(reader.Buffered())
+		raw = append([]byte(nil), raw...)
+		reader.Discard(len(raw))
+		return "raw:" + string(raw), raw, err
+	}
+
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return "", []byte(header), err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+	if err != nil || length < 0 {
+		return "raw:" + header, []byte(header), nil
+	}
+	data := make([]byte, length+2)
+	n, err := io.ReadFull(reader, data)
+	raw = append([]byte(header), data[:n]...)
+	if err != nil {
+		return "", raw, err
+	}
+	return string(data[:length]), raw, nil
+}
+
+// readCapture reads the frames of a capture file, grouped by connection in the
+// order the connections were accepted.
+func readCapture(path string) ([][]frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var conns [][]frame
+	index := make(map[int]int)
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for line := 1; ; line++ {
+		var fr frame
+		if err := dec.Decode(&fr); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: frame %d: %w", path, line, err)
+		}
+
+		i, ok := index[fr.Conn]
+		if !ok {
+			i = len(conns)
+			index[fr.Conn] = i
+			conns = append(conns, nil)
+		}
+		conns[i] = append(conns[i], fr)
+	}
+	return conns, nil
+}
+
+// describe summarizes a frame for logs, truncating long commands.
+func (f frame) describe() string {
+	switch {
+	case f.EOF != "":
+		return fmt.Sprintf("conn %d: closed by %s", f.Conn, f.EOF)
+	case f.Server != nil:
+		return fmt.Sprintf("conn %d: server sent %d bytes", f.Conn, len(f.Server))
+	default:
+		cmd := f.Client
+		if len(cmd) > 120 {
+			cmd = cmd[:120] + "..."
+		}
+		return fmt.Sprintf("conn %d: client sent %q", f.Conn, cmd)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// chaosProxy forwards connections to the server. While chaos is enabled, it
+// kills connections and stalls responses partway through, at random.
+type chaosProxy struct {
+	ln       net.Listener
+	upstream string
+	// Probability that a chunk of a response kills its connection, or stalls it
+	// for stall, while chaos is enabled.
+	killRate  float64
+	stallRate float64
+	stall     time.Duration
+
+	chaos  atomic.Bool
+	kills  atomic.Int64
+	stalls atomic.Int64
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// startChaosProxy starts a proxy to upstream on a random local port, with chaos
+// disabled.
+func startChaosProxy(upstream string, killRate, stallRate float64, stall time.Duration) (*chaosProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &chaosProxy{
+		ln:        ln,
+		upstream:  upstream,
+		killRate:  killRate,
+		stallRate: stallRate,
+		stall:     stall,
+		conns:     make(map[net.Conn]struct{}),
+	}
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+func (p *chaosProxy) addr() string {
+	return p.ln.Addr().String()
+}
+
+func (p *chaosProxy) serve() {
+	defer p.wg.Done()
+	for {
+		client, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.handle(client)
+		}()
+	}
+}
+
+func (p *chaosProxy) handle(client net.Conn) {
+	server, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		client.Close()
+		return
+	}
+	p.track(client, true)
+	p.track(server, true)
+	defer p.track(client, false)
+	defer p.track(server, false)
+
+	// Closing both sides ends the other direction too
+	done := make(chan struct{})
+	go func() {
+		io.Copy(server, client)
+		server.Close()
+		client.Close()
+		close(done)
+	}()
+	p.forward(client, server)
+	server.Close()
+	client.Close()
+	<-done
+}
+
+// forward copies responses from server to client, injecting chaos.
+func (p *chaosProxy) forward(client, server net.Conn) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := server.Read(buf)
+		if n > 0 {
+			if p.chaos.Load() {
+				switch r := rand.Float64(); {
+				case r < p.killRate:
+					p.kills.Add(1)
+					return
+				case r < p.killRate+p.stallRate:
+					p.stalls.Add(1)
+					time.Sleep(p.stall)
+				}
+			}
+			if _, err := client.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *chaosProxy) track(conn net.Conn, open bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if open {
+		p.conns[conn] = struct{}{}
+	} else {
+		delete(p.conns, conn)
+	}
+}
+
+// close stops the proxy and closes its connections.
+func (p *chaosProxy) close() {
+	p.ln.Close()
+	p.mu.Lock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,296 @@
+// Command lunasoak is a long-running stress test of the driver's connection
+// handling, run before releases. Workers run queries, executions, Arrow queries
+// and cancelled queries through a pool that's closed and reopened every cycle,
+// connected through a proxy that alternates calm phases with chaos phases where
+// it kills connections and stalls responses partway. After each cycle, once the
+// pool is closed, it checks that goroutines, the heap and Arrow buffers went back
+// to their baseline, and exits with status 1 on the first leak.
+//
+// Usage:
+//
+//	lunasoak -duration 4h
+//	lunasoak -dsn localhost:7688 -query "SELECT * FROM range(100000)" -duration 30m
+//
+// Without -dsn, queries are answered by a built-in fake server.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/flowerinthenight/luna-go"
+)
+
+// options are the settings of a soak run.
+type options struct {
+	dsn            string
+	query          string
+	exec           string
+	duration       time.Duration
+	cycle          time.Duration
+	chaosPeriod    time.Duration
+	workers        int
+	maxConns       int
+	stallTimeout   time.Duration
+	maxHeapGrowth  uint64
+	goroutineSlack int
+	logger         *slog.Logger
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.dsn, "dsn", "", "Luna DSN to run against, through the chaos proxy (default: a built-in fake server)")
+	flag.StringVar(&opts.query, "query", "SELECT n FROM soak", "query run by the workers")
+	flag.StringVar(&opts.exec, "exec", "SELECT 1", "statement executed by the workers")
+	flag.DurationVar(&opts.duration, "duration", time.Hour, "how long to run")
+	flag.DurationVar(&opts.cycle, "cycle", 30*time.Second, "how long each pool is used before it's closed and reopened")
+	flag.DurationVar(&opts.chaosPeriod, "chaos-period", 10*time.Second, "length of the alternating calm and chaos phases")
+	flag.IntVar(&opts.workers, "workers", 16, "goroutines running statements")
+	flag.IntVar(&opts.maxConns, "max-conns", 8, "maximum open connections of each pool")
+	flag.DurationVar(&opts.stallTimeout, "stall-timeout", time.Second, "stall timeout of the connections; the proxy stalls for three times as long")
+	killRate := flag.Float64("kill-rate", 0.01, "probability that the proxy kills a connection at each response chunk during chaos")
+	stallRate := flag.Float64("stall-rate", 0.002, "probability that the proxy stalls a connection at each response chunk during chaos")
+	rows := flag.Int("rows", 10000, "rows of the fake server's query result")
+	maxHeapGrowth := flag.Int("max-heap-growth", 64, "heap growth in MiB since the first cycle that counts as a leak")
+	flag.IntVar(&opts.goroutineSlack, "goroutine-slack", 2, "goroutines over the baseline tolerated after a cycle")
+	verbose := flag.Bool("v", false, "log the driver's warnings")
+	flag.Parse()
+
+	if opts.workers < 1 || opts.maxConns < 1 || opts.cycle <= 0 || opts.duration <= 0 {
+		fmt.Fprintln(os.Stderr, "lunasoak: -workers, -max-conns, -cycle and -duration must be positive")
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.maxHeapGrowth = uint64(*maxHeapGrowth) << 20
+
+	level := slog.LevelError + 1
+	if *verbose {
+		level = slog.LevelWarn
+	}
+	opts.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	if opts.dsn == "" {
+		server, err := startFakeServer(*rows, 1024)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lunasoak: %v\n", err)
+			os.Exit(1)
+		}
+		defer server.close()
+		opts.dsn = server.addr()
+	}
+
+	cfg, err := luna.ParseDSN(opts.dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunasoak: %v\n", err)
+		os.Exit(1)
+	}
+	proxy, err := startChaosProxy(cfg.Addr, *killRate, *stallRate, 3*opts.stallTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lunasoak: %v\n", err)
+		os.Exit(1)
+	}
+	defer proxy.close()
+
+	if err := soak(opts, cfg, proxy); err != nil {
+		fmt.Fprintf(os.Stderr, "lunasoak: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("no leaks")
+}
+
+// counts are the outcomes of the statements run in a cycle.
+type counts struct {
+	statements atomic.Int64
+	failures   atomic.Int64
+}
+
+// soak runs cycles until the duration has elapsed, and returns an error for the
+// first leak found.
+func soak(opts options, cfg *luna.Config, proxy *chaosProxy) error {
+	// The DSN with its host replaced by the proxy; options keep the TLS settings
+	// of the original host
+	dsn := strings.Replace(opts.dsn, cfg.Addr, proxy.addr(), 1)
+	connOpts := []luna.Option{
+		luna.WithLogger(opts.logger),
+		luna.WithQueryTimeout(10 * opts.stallTimeout),
+		luna.WithStallTimeout(opts.stallTimeout),
+		luna.WithIdlePingInterval(opts.cycle / 4),
+		luna.WithDecodeRetry(true),
+	}
+	if cfg.TLSConfig != nil {
+		connOpts = append(connOpts, luna.WithTLSConfig(cfg.TLSConfig))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.duration)
+	defer cancel()
+	go toggleChaos(ctx, proxy, opts.chaosPeriod)
+
+	baseGoroutines := runtime.NumGoroutine()
+	var baseHeap uint64
+	for cycle := 1; ctx.Err() == nil; cycle++ {
+		mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+		connector, err := luna.NewConnectorWithOptions(dsn, append(connOpts, luna.WithAllocator(mem))...)
+		if err != nil {
+			return err
+		}
+
+		var c counts
+		kills, stalls := proxy.kills.Load(), proxy.stalls.Load()
+		runCycle(ctx, opts, sql.OpenDB(connector), &c)
+
+		// Leaks are measured once the pool is closed and its goroutines are gone
+		if n := mem.CurrentAlloc(); n != 0 {
+			return fmt.Errorf("cycle %d: %d bytes of Arrow buffers not released", cycle, n)
+		}
+		goroutines := settleGoroutines(baseGoroutines+opts.goroutineSlack, 10*time.Second)
+		if goroutines > baseGoroutines+opts.goroutineSlack {
+			return fmt.Errorf("cycle %d: %d goroutines left, %d at the start", cycle, goroutines, baseGoroutines)
+		}
+		heap := heapInUse()
+		if cycle == 1 {
+			baseHeap = heap
+		}
+		if heap > baseHeap+opts.maxHeapGrowth {
+			return fmt.Errorf("cycle %d: heap grew from %s to %s", cycle, mib(baseHeap), mib(heap))
+		}
+
+		fmt.Printf("cycle %d: %d statements, %d failed, %d kills, %d stalls, %d goroutines, heap %s\n",
+			cycle, c.statements.Load(), c.failures.Load(), proxy.kills.Load()-kills, proxy.stalls.Load()-stalls,
+			goroutines, mib(heap))
+	}
+	return nil
+}
+
+// runCycle runs the workers on db for a cycle, then closes db.
+func runCycle(ctx context.Context, opts options, db *sql.DB, c *counts) {
+	db.SetMaxOpenConns(opts.maxConns)
+	db.SetMaxIdleConns(opts.maxConns / 2)
+	db.SetConnMaxLifetime(opts.cycle / 3)
+
+	ctx, cancel := context.WithTimeout(ctx, opts.cycle)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for w := range opts.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; ctx.Err() == nil; i++ {
+				err := runStatement(ctx, opts, db, i)
+				c.statements.Add(1)
+				if err != nil && ctx.Err() == nil && !errors.Is(err, context.DeadlineExceeded) {
+					c.failures.Add(1)
+					opts.logger.Warn("statement failed", "error", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	db.Close()
+}
+
+// runStatement runs the i-th statement of a worker, rotating through the ways
+// results are read.
+func runStatement(ctx context.Context, opts options, db *sql.DB, i int) error {
+	switch i % 4 {
+	case 0:
+		return scanRows(ctx, db, opts.query)
+	case 1:
+		_, err := db.ExecContext(ctx, opts.exec)
+		return err
+	case 2:
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		reader, err := luna.QueryArrow(ctx, conn, opts.query)
+		if err != nil {
+			return err
+		}
+		defer reader.Release()
+		for reader.Next() {
+		}
+		return reader.Err()
+	default:
+		// Cancelled partway, or not at all
+		ctx, cancel := context.WithTimeout(ctx, rand.N(5*time.Millisecond))
+		defer cancel()
+		return scanRows(ctx, db, opts.query)
+	}
+}
+
+func scanRows(ctx context.Context, db *sql.DB, query string) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	dest := make([]any, len(cols))
+	for i := range dest {
+		dest[i] = new(any)
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// toggleChaos alternates calm and chaos phases of the proxy until ctx is done.
+func toggleChaos(ctx context.Context, proxy *chaosProxy, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			proxy.chaos.Store(false)
+			return
+		case <-ticker.C:
+			proxy.chaos.Store(!proxy.chaos.Load())
+		}
+	}
+}
+
+// settleGoroutines waits up to timeout for the number of goroutines to drop to
+// limit, e.g. for connections closed by the pool to finish, and returns it.
+func settleGoroutines(limit int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= limit || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapInuse
+}
+
+func mib(n uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// fakeServer is a minimal Luna server that answers every query with the same
+// Arrow result, and every execution with OK.
+type fakeServer struct {
+	ln    net.Listener
+	reply []byte
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// startFakeServer starts a fake server on a random local port, whose query
+// result has rows rows of a single n column, in batches of batchRows rows.
+func startFakeServer(rows, batchRows int) (*fakeServer, error) {
+	reply, err := encodeResult(rows, batchRows)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fakeServer{ln: ln, reply: reply, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *fakeServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		cmd, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		reply := []byte("+OK\r\n")
+		if strings.HasPrefix(cmd, "q:") {
+			reply = s.reply
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// close stops the server and closes its connections.
+func (s *fakeServer) close() {
+	s.ln.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// readCommand reads a single RESP bulk string command, e.g. "q:SELECT 1".
+func readCommand(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(line, "$") {
+		return "", fmt.Errorf("unexpected command frame: %q", line)
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, length+2)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", err
+	}
+
+	return string(data[:length]), nil
+}
+
+// encodeResult encodes the Arrow IPC stream of a result with rows rows of a
+// single n column, numbered from 0, in batches of batchRows rows.
+func encodeResult(rows, batchRows int) ([]byte, error) {
+	mem := memory.NewGoAllocator()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil)
+
+	var buf bytes.Buffer
+	writer := ipc.NewWriter(&buf, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+	b := array.NewRecordBuilder(mem, schema)
+	defer b.Release()
+	for start := 0; start < rows; start += batchRows {
+		for n := start; n < min(start+batchRows, rows); n++ {
+			b.Field(0).(*array.Int64Builder).Append(int64(n))
+		}
+		rec := b.NewRecord()
+		err := writer.Write(rec)
+		rec.Release()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
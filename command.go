@@ -0,0 +1,48 @@
+package luna
+
+import (
+	"context"
+
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// Command selects the protocol command a statement is sent with.
+type Command int
+
+const (
+	// CommandDefault sends statements run with QueryContext or QueryArrow as
+	// queries, and those run with ExecContext as executions.
+	CommandDefault Command = iota
+	// CommandQuery sends statements as queries (q:), whose reply is a result set.
+	CommandQuery
+	// CommandExecute sends statements as executions (x:), for DDL and DML.
+	CommandExecute
+)
+
+type commandKey struct{}
+
+// WithCommand returns a context that makes the statements run with it use cmd,
+// whichever method runs them. It's a way out for statements the server handles
+// better with the other command, e.g. vendor-specific syntax that returns rows
+// but must be executed:
+//
+//	rows, err := db.QueryContext(luna.WithCommand(ctx, luna.CommandExecute), "CALL refresh_stats()")
+//
+// An execution that replies with a plain OK yields rows without columns.
+func WithCommand(ctx context.Context, cmd Command) context.Context {
+	return context.WithValue(ctx, commandKey{}, cmd)
+}
+
+// commandPrefix returns the prefix of the command set in ctx with WithCommand,
+// or def if there's none.
+func commandPrefix(ctx context.Context, def string) string {
+	cmd, _ := ctx.Value(commandKey{}).(Command)
+	switch cmd {
+	case CommandQuery:
+		return wire.CmdQuery
+	case CommandExecute:
+		return wire.CmdExecute
+	default:
+		return def
+	}
+}
@@ -0,0 +1,84 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+func TestWithCommand(t *testing.T) {
+	commands := make(chan string, 1)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+
+			// Executions reply with OK, queries with a result set
+			if strings.HasPrefix(cmd, "x:") {
+				conn.Write([]byte("+OK\r\n"))
+				continue
+			}
+			rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	query := func(ctx context.Context) (columns int, err error) {
+		rows, err := db.QueryContext(ctx, "CALL p()")
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		cols, err := rows.Columns()
+		return len(cols), err
+	}
+	exec := func(ctx context.Context) (int, error) {
+		_, err := db.ExecContext(ctx, "CALL p()")
+		return 0, err
+	}
+
+	ctx := context.Background()
+	testCases := []struct {
+		name     string
+		ctx      context.Context
+		run      func(context.Context) (int, error)
+		expected string
+		columns  int
+	}{
+		{name: "query", ctx: ctx, run: query, expected: "q:CALL p()", columns: 1},
+		{name: "query as execute", ctx: WithCommand(ctx, CommandExecute), run: query, expected: "x:CALL p()"},
+		{name: "exec", ctx: ctx, run: exec, expected: "x:CALL p()"},
+		{name: "exec as query", ctx: WithCommand(ctx, CommandQuery), run: exec, expected: "q:CALL p()"},
+		{name: "default", ctx: WithCommand(ctx, CommandDefault), run: exec, expected: "x:CALL p()"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			columns, err := tc.run(tc.ctx)
+			if err != nil {
+				t.Fatalf("failed: %v", err)
+			}
+			if cmd := <-commands; cmd != tc.expected {
+				t.Errorf("expected command %q, got %q", tc.expected, cmd)
+			}
+			if columns != tc.columns {
+				t.Errorf("expected %d columns, got %d", tc.columns, columns)
+			}
+		})
+	}
+}
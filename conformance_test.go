@@ -0,0 +1,250 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// These tests check the driver against the way database/sql uses it: pooled
+// connections validated with driver.Validator and driver.SessionResetter after
+// errors, driver.ErrBadConn retries, and driver connections reached through
+// sql.Conn.Raw. Run them with -race.
+
+// newCountingFakeServer starts a fake server that replies to every query with a
+// single-row result, except that reply(n, cmd) may return a raw reply for the
+// n-th connection (from 1). It returns the address and the number of accepted
+// connections.
+func newCountingFakeServer(t *testing.T, reply func(n int64, cmd string) []byte) (string, *atomic.Int64) {
+	var accepted atomic.Int64
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		n := accepted.Add(1)
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			if raw := reply(n, cmd); raw != nil {
+				conn.Write(raw)
+				continue
+			}
+
+			rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+	return addr, &accepted
+}
+
+func TestPoolDiscardsConnAfterMalformedReply(t *testing.T) {
+	addr, accepted := newCountingFakeServer(t, func(n int64, cmd string) []byte {
+		if n == 1 {
+			return []byte("$5\r\nhello world\r\n")
+		}
+		return nil
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Query("SELECT n FROM t"); err == nil {
+		t.Fatal("expected an error for a malformed reply")
+	}
+
+	// IsValid reports the connection as broken, so the pool doesn't keep it
+	rows, err := db.Query("SELECT n FROM t")
+	if err != nil {
+		t.Fatalf("query on a new connection failed: %v", err)
+	}
+	rows.Close()
+
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+	if stats := db.Stats(); stats.OpenConnections != 1 {
+		t.Errorf("expected 1 open connection, got %d", stats.OpenConnections)
+	}
+}
+
+func TestPoolDiscardsConnAfterCancel(t *testing.T) {
+	// The first connection never replies
+	addr, accepted := newCountingFakeServer(t, func(n int64, cmd string) []byte {
+		if n == 1 {
+			return []byte{}
+		}
+		return nil
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := db.QueryContext(ctx, "SELECT n FROM t"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	rows, err := db.Query("SELECT n FROM t")
+	if err != nil {
+		t.Fatalf("query on a new connection failed: %v", err)
+	}
+	rows.Close()
+
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+}
+
+func TestBadConnRefusesCommands(t *testing.T) {
+	addr, _ := newCountingFakeServer(t, func(n int64, cmd string) []byte { return nil })
+	conn := connectFake(t, addr)
+
+	conn.mu.Lock()
+	conn.bad = true
+	conn.mu.Unlock()
+
+	// driver.ErrBadConn lets database/sql retry on another connection
+	checks := map[string]func() error{
+		"Prepare": func() error {
+			_, err := conn.Prepare("SELECT 1")
+			return err
+		},
+		"BeginTx": func() error {
+			_, err := conn.BeginTx(context.Background(), driver.TxOptions{})
+			return err
+		},
+		"ResetSession": func() error {
+			return conn.ResetSession(context.Background())
+		},
+		"Ping": func() error {
+			return conn.Ping(context.Background())
+		},
+	}
+	for name, check := range checks {
+		if err := check(); !errors.Is(err, driver.ErrBadConn) {
+			t.Errorf("%s: expected driver.ErrBadConn, got %v", name, err)
+		}
+	}
+	if conn.IsValid() {
+		t.Error("expected IsValid to report the connection as broken")
+	}
+}
+
+func TestCloseWaitsForCanceledQuery(t *testing.T) {
+	received := make(chan struct{}, 1)
+	addr, _ := newCountingFakeServer(t, func(n int64, cmd string) []byte {
+		received <- struct{}{}
+		return []byte{}
+	})
+	conn := connectFake(t, addr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queryErr := make(chan error, 1)
+	go func() {
+		_, err := conn.QueryContext(ctx, "SELECT n FROM t", nil)
+		queryErr <- err
+	}()
+	<-received
+
+	closeErr := make(chan error, 1)
+	go func() {
+		closeErr <- conn.Close()
+	}()
+
+	// Close waits for the query, which returns once its context is canceled
+	cancel()
+
+	select {
+	case err := <-queryErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("query didn't return after cancellation")
+	}
+	select {
+	case err := <-closeErr:
+		if err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close didn't return after the query")
+	}
+
+	if _, err := conn.QueryContext(context.Background(), "SELECT n FROM t", nil); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected driver.ErrBadConn after Close, got %v", err)
+	}
+}
+
+func TestRawConnsAlongsidePool(t *testing.T) {
+	addr, _ := newCountingFakeServer(t, func(n int64, cmd string) []byte { return nil })
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+
+			reader, err := QueryArrow(ctx, conn, "SELECT n FROM t")
+			if err != nil {
+				errs <- err
+				return
+			}
+			reader.Release()
+		}()
+		go func() {
+			defer wg.Done()
+			rows, err := db.QueryContext(ctx, "SELECT n FROM t")
+			if err != nil {
+				errs <- err
+				return
+			}
+			for rows.Next() {
+			}
+			if err := rows.Close(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -4,132 +4,739 @@ import (
 	"bufio"
 	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/flowerinthenight/luna-go/internal/wire"
 )
 
 type Conn struct {
+	// Serializes commands, so that concurrent callers can't interleave their frames.
+	// database/sql never uses a connection concurrently, but user code holding it
+	// through sql.Conn.Raw might.
+	mu sync.Mutex
 	// For test stubbing: if true, return temp table results
 	tempTableQuery bool
 	conn           net.Conn
 	reader         *bufio.Reader // Buffered reader for the connection
+	logger         *slog.Logger
+	mem            memory.Allocator // Allocator used to decode query results
+	// How the text of statements appears in log records.
+	logQueries QueryLogMode
 	// True, if the connection has been closed, else false.
 	closed bool
+	// True, if a command was interrupted and the protocol state is unknown.
+	// The connection can't be reused and reports driver.ErrBadConn.
+	bad bool
 	// True, if the connection has an open transaction.
 	tx bool
+	// How transactions are run.
+	txMode TxMode
+	// Dialect that statements are translated from.
+	dialect Dialect
+	// Listers of the files matching glob patterns, by URL scheme.
+	fileListers map[string]FileLister
+	// Statements of the open transaction in TxBatch mode, starting with its BEGIN
+	// statement.
+	batch []string
+	// Events of the statements buffered in batch, for the AfterQuery hooks called
+	// once Commit sends them.
+	batchEvents []QueryEvent
+	// IN lists longer than this are split into several queries (0 disables splitting).
+	maxInList int
+	// Whether queries ending with an ORDER BY are split too, and their parts merged.
+	inListOrder bool
+	// Queries whose result has more rows or bytes fail (0 means no limit).
+	maxResultRows  int
+	maxResultBytes int
+	// Rows and bytes received for the result of the current query, across the
+	// parts of a split query.
+	resultRows  int64
+	resultBytes int64
+	// How Rows converts values.
+	valueOptions
+	// If true, failures to decode the result of a read-only query are reported
+	// as driver.ErrBadConn, so that database/sql re-issues the query.
+	retryDecode bool
+	// If true, the trailers announced after Arrow streams are read.
+	resultTrailers bool
+	// If true, results with the server's input and error columns are returned
+	// as an *Error.
+	errorResults bool
+	// Default time limit for a single command round trip (0 means no limit).
+	queryTimeout time.Duration
+	// Time limit without receiving any bytes once a response has started (0
+	// means no limit).
+	stallTimeout time.Duration
+	// Time source for timeouts, replaced in tests.
+	clock Clock
+	// Connector-wide rate limiter, nil if there's no rate limit.
+	limiter *rateLimiter
+	// Counts the bytes received on the connection, for the rate limiter and event log.
+	counter *countingReader
+	// Connector-wide statistics of the tables referred to by statements, nil if
+	// disabled.
+	tables *tableStats
+	// Connector-wide expected result schemas, nil unless schema drift is checked.
+	schemas *schemaTracker
+	// Connector-wide log of recent protocol events, for support bundles.
+	events *eventLog
+	// Passes background failures to the connector's event handler.
+	emit func(Event)
+	// Returns the connector's current live settings, and the ones the connection
+	// last applied.
+	liveConfig func() *liveConfig
+	applied    *liveConfig
+	// Sequence number of the connection within its connector, in the event log.
+	id int64
+	// Idle connections are pinged this often (0 disables pinging).
+	idlePingInterval time.Duration
+	// Pending idle ping, nil if there's none.
+	idleTimer Timer
+	// Incremented whenever an idle ping is scheduled, so that a ping that fires
+	// while a command is running can tell it's stale.
+	idleGen int64
+	// Tables registered with RegisterTempTable, by upper-cased name.
+	tempTables map[string]tempTable
+	// Called with the warnings the server raises, nil to log them.
+	noticeHandler func(Notice)
+	// Statements that take at least this long are passed to onSlowQuery, or
+	// logged without it (0 disables the check).
+	slowQueryThreshold time.Duration
+	onSlowQuery        SlowQueryFunc
+	// Called around the statements the connection runs.
+	hooks []QueryHooks
+	// Metadata from the trailers of the results read by the last queryArrow.
+	stats ResultStats
+	// Stats of the record batches read by the last queryArrow.
+	batches []BatchStat
 }
 
 // It implements the driver.ExecerContext interface.
 func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
-	if c.closed {
-		return nil, driver.ErrBadConn
+	query, err := interpolateArgs(query, args)
+	if err != nil {
+		return nil, err
 	}
 
-	slog.Info("ExecContext called", "query", query)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// Send execute command
-	if err := sendCommand(c.conn, cmdExecute, query); err != nil {
-		return nil, fmt.Errorf("failed to send command: %w", err)
+	return c.hookedExec(ctx, query)
+}
+
+// hookedExec runs a statement through the query hooks, and returns its result.
+// The caller must hold c.mu.
+func (c *Conn) hookedExec(ctx context.Context, query string) (driver.Result, error) {
+	e, err := c.beforeQuery(ctx, "exec", query)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.exec(ctx, e.Query)
+	if _, ok := res.(batchedResult); ok {
+		// The statement runs on Commit, which calls the AfterQuery hooks
+		c.batchEvents = append(c.batchEvents, e)
+		return res, nil
+	}
+	var affected int64
+	if r, ok := res.(*result); ok {
+		affected = r.rowsAffected
+	}
+	c.afterQuery(ctx, e, affected, err)
+	return res, err
+}
+
+// exec runs a statement and returns its result. The caller must hold c.mu.
+func (c *Conn) exec(ctx context.Context, query string) (driver.Result, error) {
+	if c.closed || c.bad {
+		return nil, errBadConn
+	}
+
+	query = c.dialect.translate(query)
+	if c.tx && c.txMode == TxBatch {
+		c.batch = append(c.batch, query)
+		return batchedResult{}, nil
 	}
+	return c.sendExec(ctx, query)
+}
+
+// sendExec runs a statement as it is, without translating or buffering it, and
+// returns its result. The caller must hold c.mu.
+func (c *Conn) sendExec(ctx context.Context, query string) (driver.Result, error) {
+	c.logger.Debug("ExecContext called", c.queryAttr(query))
 
-	// Read response
-	respType, data, err := readResponse(c.reader)
+	cmd := commandPrefix(ctx, wire.CmdExecute)
+	var res *result
+	start, received := c.clock.Now(), c.counter.count()
+	err := c.roundTrip(ctx, "exec", query, func() error {
+		var err error
+		res, err = c.execute(ctx, cmd, query)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
 
-	// Handle errors
-	if respType == "error" {
-		return nil, fmt.Errorf("luna error: %s", string(data))
+	c.tables.record(query, res.rowsAffected, c.counter.count()-received)
+	c.statementDone(ctx, query, start, res.rowsAffected, res.stats, MemoryStats{})
+	return res, nil
+}
+
+// execute sends a command, an execute command unless overridden with
+// WithCommand, and consumes its response. The result has the number of rows
+// affected, if the server reported it, and the value returned by a RETURNING
+// clause.
+func (c *Conn) execute(ctx context.Context, cmd, query string) (*result, error) {
+	// Send execute command
+	if err := wire.SendCommand(c.conn, cmd, query); err != nil {
+		return nil, c.sendError(err)
+	}
+
+	return c.readExecResults(ctx, query)
+}
+
+// readExecResults reads the responses to an execution of query, one for each of
+// its statements up to the first that fails, and returns their combined result.
+func (c *Conn) readExecResults(ctx context.Context, query string) (*result, error) {
+	statements := splitSQLStatements(query)
+	if len(statements) <= 1 {
+		return c.readExecResult(ctx, query)
+	}
+
+	var res *result
+	for _, stmt := range statements {
+		next, err := c.readExecResult(ctx, stmt)
+		if err != nil {
+			return nil, err
+		}
+		res = res.followedBy(next)
+	}
+	return res, nil
+}
+
+// readExecResult reads the response to an execution of query, sent with execute
+// or in a pipeline.
+func (c *Conn) readExecResult(ctx context.Context, query string) (*result, error) {
+	respType, data, err := c.readReply(query)
+	if err != nil {
+		return nil, c.readError("failed to read response", err)
 	}
 
-	// Luna might return Arrow IPC data even for ExecContext
-	// We need to consume it but don't use it for DDL/DML
-	if respType == "arrow-stream" {
-		// Read and discard the Arrow data
-		_, err = parseArrowIPCFromReader(c.reader)
+	switch respType {
+	case wire.RespError:
+		return nil, c.replyError(query, data)
+	case wire.RespInt:
+		// DML may reply with the number of rows affected
+		n, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("luna: invalid integer reply %q", data)
+		}
+		return &result{rowsAffected: n, stats: noStats}, nil
+	case wire.RespArrowStream:
+		// DML may reply with a single-row Count batch, or the rows of a RETURNING
+		// clause; other Arrow data is consumed and dropped
+		schema, records, _, err := wire.ParseArrowIPCFromReader(c.reader, c.mem, c.checkpoint(ctx))
+		var serr *wire.StreamError
+		if errors.As(err, &serr) {
+			// The statement failed partway, and the error frame ended the stream
+			wire.ReleaseRecords(records)
+			return nil, c.replyError(query, []byte(serr.Msg))
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse Arrow IPC: %w", err)
+			return nil, c.readError("failed to parse Arrow IPC", err)
 		}
+		defer wire.ReleaseRecords(records)
+		t, err := c.readTrailer(query, schema)
+		if err != nil {
+			return nil, err
+		}
+		if c.errorResults {
+			if err := errorFromResult(query, schema, records); err != nil {
+				return nil, err
+			}
+		}
+		res := execResult(query, records)
+		res.stats = noStats
+		if t != nil {
+			res.stats = res.stats.add(t)
+		}
+		if t != nil && t.RowsAffected != nil {
+			// The server's count is authoritative
+			res.rowsAffected = *t.RowsAffected
+		}
+		return res, nil
 	}
 
-	// For DDL/DML, we typically don't get row counts from Luna
-	// Return a result with 0 rows affected
-	return &result{rowsAffected: 0}, nil
+	return &result{stats: noStats}, nil
 }
 
 // Implements the driver.QueryerContext interface.
 func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
-	if c.closed {
-		return nil, driver.ErrBadConn
+	query, err := interpolateArgs(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.beforeQuery(ctx, "query", query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.queryRows(ctx, e.Query)
+	if err != nil {
+		c.afterQuery(ctx, e, 0, err)
+		return nil, err
+	}
+	r := rows.(*Rows)
+	e.Memory = r.queryMem.stats()
+	c.afterQuery(ctx, e, r.numRows(), nil)
+	c.hookRowsClose(ctx, e, r)
+	return r, nil
+}
+
+// queryRows runs a query and returns its rows. The caller must hold c.mu.
+func (c *Conn) queryRows(ctx context.Context, query string) (driver.Rows, error) {
+	if c.closed || c.bad {
+		return nil, errBadConn
+	}
+
+	if c.tx && c.txMode == TxBatch {
+		return nil, ErrBatchedQuery
 	}
 
-	slog.Info("QueryContext called", "query", query)
+	query = c.dialect.translate(query)
+	c.logger.Debug("QueryContext called", c.queryAttr(query))
 
+	// Track the Arrow memory used by each result set, and by the query
+	queryMem := newTrackingAllocator(c.mem)
+	replies, err := c.queryStatements(ctx, query, queryMem)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]resultSet, 0, len(replies))
+	for i, r := range replies {
+		schema, records := r.schema, r.records
+		err := c.checkSchema(r.query, schema)
+		if err == nil && c.errorResults {
+			err = errorFromResult(r.query, schema, records)
+		}
+		if err == nil {
+			schema, records, err = applyClientSide(ctx, schema, records, r.mem)
+		} else {
+			wire.ReleaseRecords(records)
+		}
+		if err != nil {
+			releaseResultSets(sets)
+			releaseStatementResults(replies[i+1:])
+			return nil, err
+		}
+		c.statementDone(ctx, r.query, r.start, countRows(records), r.stats, r.mem.stats())
+		sets = append(sets, resultSet{schema: schema, records: records, mem: r.mem, stats: r.stats, batches: r.batches, err: r.err})
+	}
+
+	// Create Rows from Arrow records
+	rows := newRowsFromArrow(sets[0].schema, sets[0].records)
+	rows.mem = sets[0].mem
+	rows.queryMem = queryMem
+	rows.stats = sets[0].stats
+	rows.batches = sets[0].batches
+	rows.err = sets[0].err
+	rows.next = sets[1:]
+	rows.valueOptions = c.valueOptions
+	return rows, nil
+}
+
+// queryArrow runs a query and returns the Arrow schema and records of its result.
+// Oversized IN lists are split into several queries, whose results are concatenated,
+// or merged by their ORDER BY in ordered mode, whose trailers are added up in
+// c.stats, and whose batch stats are concatenated in c.batches.
+func (c *Conn) queryArrow(ctx context.Context, query string, mem memory.Allocator) (*arrow.Schema, []arrow.Record, error) {
+	c.stats = noStats
+	c.batches = nil
+	c.resultRows, c.resultBytes = 0, 0
+	queries, order := splitInList(query, c.maxInList, c.inListOrder)
+	if len(queries) <= 1 {
+		return c.queryRecords(ctx, c.withTempTables(query), mem)
+	}
+
+	var schema *arrow.Schema
+	var records []arrow.Record
+	for _, q := range queries {
+		s, recs, err := c.queryRecords(ctx, c.withTempTables(q), mem)
+		if schema == nil {
+			schema = s
+		}
+		records = append(records, recs...)
+		var partial *partialResultError
+		if errors.As(err, &partial) {
+			// The chunks after the failed one aren't run
+			return schema, records, err
+		}
+		if err != nil {
+			wire.ReleaseRecords(records)
+			return nil, nil, err
+		}
+	}
+
+	if order != nil {
+		merged, err := mergeInListChunks(ctx, schema, records, order, mem)
+		if err != nil {
+			return nil, nil, err
+		}
+		records = merged
+	}
+	return schema, records, nil
+}
+
+// queryRecords sends a query command and reads the resulting Arrow schema and records.
+// The round trip is aborted if ctx is done or the query timeout expires before it completes.
+// Record buffers are allocated from mem.
+func (c *Conn) queryRecords(ctx context.Context, query string, mem memory.Allocator) (*arrow.Schema, []arrow.Record, error) {
+	var schema *arrow.Schema
+	var records []arrow.Record
+	cmd := commandPrefix(ctx, wire.CmdQuery)
+	err := c.roundTrip(ctx, "query", query, func() error {
+		var err error
+		schema, records, err = c.query(ctx, cmd, query, mem)
+		return err
+	})
+	var partial *partialResultError
+	if errors.As(err, &partial) {
+		return schema, records, err
+	}
+	if err != nil {
+		wire.ReleaseRecords(records)
+		return nil, nil, err
+	}
+
+	return schema, records, nil
+}
+
+// query sends a command, a query command unless overridden with WithCommand,
+// and reads the resulting Arrow schema and records.
+func (c *Conn) query(ctx context.Context, cmd, query string, mem memory.Allocator) (*arrow.Schema, []arrow.Record, error) {
 	// Send query command
-	if err := sendCommand(c.conn, cmdQuery, query); err != nil {
-		return nil, fmt.Errorf("failed to send command: %w", err)
+	if err := wire.SendCommand(c.conn, cmd, query); err != nil {
+		return nil, nil, c.sendError(err)
 	}
 
-	// Read response
-	respType, data, err := readResponse(c.reader)
+	return c.readQueryResult(ctx, query, mem)
+}
+
+// readQueryResult reads the Arrow schema and records of the response to query,
+// sent with query or in a pipeline. A failure partway through the stream is
+// returned with the records read before it as a *partialResultError.
+func (c *Conn) readQueryResult(ctx context.Context, query string, mem memory.Allocator) (*arrow.Schema, []arrow.Record, error) {
+	respType, data, err := c.readReply(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, nil, c.readError("failed to read response", err)
 	}
 
 	// Handle errors
-	if respType == "error" {
-		return nil, fmt.Errorf("luna error: %s", string(data))
+	if respType == wire.RespError {
+		return nil, nil, c.replyError(query, data)
+	}
+
+	// Executions may reply with a plain OK, which has no result set
+	if respType == wire.RespOK {
+		return nil, nil, nil
 	}
 
 	// Handle Arrow IPC stream
+	var schema *arrow.Schema
 	var records []arrow.Record
-	if respType == "arrow-stream" {
+	if respType == wire.RespArrowStream {
 		// Read Arrow IPC directly from the buffered reader
-		records, err = parseArrowIPCFromReader(c.reader)
+		var batches []wire.BatchStat
+		schema, records, batches, err = wire.ParseArrowIPCFromReader(c.reader, mem, c.limitResult(c.checkpoint(ctx)))
+		c.batches = appendBatchStats(c.batches, batches)
+		var serr *wire.StreamError
+		if errors.As(err, &serr) {
+			// The query failed partway, and the error frame ended the stream
+			err = c.replyError(query, []byte(serr.Msg))
+			if len(records) == 0 {
+				return nil, nil, err
+			}
+			return schema, records, &partialResultError{err: err}
+		}
+		var lerr *resultLimitError
+		if errors.As(err, &lerr) {
+			// The rest of the stream was drained, but not the trailer after it
+			if _, terr := c.readTrailer(query, schema); terr != nil {
+				return nil, nil, terr
+			}
+			return nil, nil, err
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, c.readError("query interrupted", err)
+			}
+			return nil, nil, c.decodeError(query, err)
+		}
+		t, err := c.readTrailer(query, schema)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse Arrow IPC: %w", err)
+			wire.ReleaseRecords(records)
+			return nil, nil, err
+		}
+		if t != nil {
+			c.stats = c.stats.add(t)
 		}
 	} else {
 		// Parse Arrow IPC from buffered data (old path)
-		records, err = parseArrowIPC(data)
+		schema, records, err = wire.ParseArrowIPC(data, mem)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse Arrow IPC: %w", err)
+			return nil, nil, c.decodeError(query, err)
 		}
 	}
 
-	// Create Rows from Arrow records
-	return newRowsFromArrow(records), nil
+	return schema, records, nil
+}
+
+// roundTrip runs a single command/response exchange in fn, after waiting for the
+// rate limiter, and records it in the connector's event log. kind and query
+// describe the command for the log.
+func (c *Conn) roundTrip(ctx context.Context, kind, query string, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Waiting for the rate limiter doesn't count towards the query timeout
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	start, received := c.clock.Now(), c.counter.count()
+	err := c.exchange(ctx, fn)
+	n := c.counter.count() - received
+	if c.limiter != nil {
+		c.limiter.received(n)
+	}
+	c.events.add(protocolEvent{
+		Time:     start,
+		Conn:     c.id,
+		Kind:     kind,
+		Query:    eventQuery(query),
+		Duration: c.clock.Now().Sub(start),
+		Bytes:    n,
+		Error:    errorString(err),
+	})
+	c.armIdleProbe()
+
+	return err
+}
+
+// armIdleProbe schedules a ping for when the connection has been idle for the idle
+// ping interval, replacing any pending one. The caller must hold c.mu.
+func (c *Conn) armIdleProbe() {
+	if c.idlePingInterval <= 0 || c.closed || c.bad {
+		return
+	}
+
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	c.idleGen++
+	gen := c.idleGen
+	c.idleTimer = c.clock.AfterFunc(c.idlePingInterval, func() { c.idleProbe(gen) })
+}
+
+// idleProbe pings an idle connection, so that a half-open connection (e.g. dropped
+// by a NAT or load balancer) is detected and discarded by the pool before a query
+// needs it. Successful pings schedule the next probe. No probe runs in a
+// transaction: the connection isn't idle, and in TxBatch mode the ping would fail
+// with ErrBatchedQuery and discard the buffered statements. The end of the
+// transaction schedules the next probe.
+func (c *Conn) idleProbe(gen int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A command ran since the probe was scheduled, and scheduled a new one
+	if gen != c.idleGen || c.closed || c.bad || c.tx {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.idlePingInterval)
+	defer cancel()
+
+	rows, err := c.queryRows(ctx, "SELECT 1")
+	if err != nil {
+		c.logger.Warn("idle ping failed, discarding connection", "error", err)
+		c.bad = true
+		if c.emit != nil {
+			c.emit(Event{Kind: EventHeartbeatFailed, Conn: c.id, Err: err})
+		}
+		return
+	}
+	rows.Close()
+}
+
+// exchange runs fn bounded by ctx, the connection's query timeout and its stall
+// timeout. When any of them expires, the exchange is interrupted by expiring the
+// net.Conn deadline, so a hung server fails the command instead of blocking
+// forever. An interrupted connection is marked as bad since its protocol state is
+// unknown.
+func (c *Conn) exchange(ctx context.Context, fn func() error) error {
+	var timer Timer
+	if c.queryTimeout > 0 {
+		timer = c.clock.AfterFunc(c.queryTimeout, func() {
+			c.conn.SetDeadline(time.Now())
+		})
+	}
+	var stall *stallWatch
+	if c.stallTimeout > 0 {
+		stall = c.watchStall()
+	}
+
+	finish := c.watchCancel(ctx)
+	err := fn()
+	timedOut := timer != nil && !timer.Stop()
+	stalled := stall != nil && stall.stop()
+	cerr := finish()
+	if cerr == nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		// Stopped reading a result at a checkpoint between its batches
+		c.bad = true
+		cerr = ctx.Err()
+	}
+	if cerr != nil {
+		if errors.Is(cerr, context.DeadlineExceeded) {
+			return &timeoutError{err: cerr}
+		}
+		return cerr
+	}
+
+	if timedOut {
+		c.bad = true
+		if err != nil {
+			return fmt.Errorf("%w after %v: %w", ErrTimeout, c.queryTimeout, err)
+		}
+	}
+
+	if stalled {
+		c.bad = true
+		if err != nil {
+			return fmt.Errorf("%w: response stalled for %v: %w", ErrTimeout, c.stallTimeout, err)
+		}
+	}
+
+	if isNetworkError(err) && !errors.Is(err, ErrConnClosed) {
+		// The server may have executed the command, so the error doesn't match
+		// driver.ErrBadConn, only ErrConnClosed, and IsValid makes the pool discard the conn
+		c.bad = true
+		err = &connClosedError{err: err}
+	}
+
+	return err
+}
+
+// watchCancel interrupts the in-flight command if ctx is done before the returned
+// finish function is called, by expiring the connection's deadline so that any
+// blocked read or write fails immediately. finish stops watching and, if the
+// command was interrupted, marks the connection as bad and returns ctx.Err()
+// to report in place of the resulting I/O error.
+func (c *Conn) watchCancel(ctx context.Context) (finish func() error) {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+
+	done := make(chan struct{})
+	interrupted := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// The protocol state is unknown from here on
+			c.conn.SetDeadline(time.Now())
+			interrupted <- ctx.Err()
+		case <-done:
+			interrupted <- nil
+		}
+	}()
+
+	return func() error {
+		close(done)
+		if err := <-interrupted; err != nil {
+			c.bad = true
+			return err
+		}
+		return nil
+	}
 }
 
 // Ping implements the driver.Pinger interface.
-// It verifies the connection to Luna server is still alive.
+// It verifies the connection to Luna server is still alive, and that the server
+// doesn't expect a password that isn't configured: such a server sends its
+// authentication challenge, a plain OK reply, before the result of the query.
+// That is reported as ErrAuthFailed, so that readiness probes don't mistake
+// credential problems for network outages.
 func (c *Conn) Ping(ctx context.Context) error {
-	if c.closed {
-		return driver.ErrBadConn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.bad {
+		return errBadConn
 	}
 
 	// Execute a simple query to verify the connection
-	rows, err := c.QueryContext(ctx, "SELECT 1", nil)
+	schema, records, err := c.queryRecords(ctx, "SELECT 1", c.mem)
 	if err != nil {
+		if c.bad && ctx.Err() == nil {
+			// Pinging is idempotent, so database/sql may safely retry on a new connection
+			return fmt.Errorf("%w: %w", err, driver.ErrBadConn)
+		}
 		return err
 	}
+	wire.ReleaseRecords(records)
 
-	// Close the rows to release resources
-	if rows != nil {
-		rows.Close()
+	if schema == nil {
+		// The reply to the query is still to come, after the challenge
+		c.bad = true
+		return fmt.Errorf("%w: the server asked for a password, and none is configured", ErrAuthFailed)
 	}
+	return nil
+}
+
+// IsValid implements the driver.Validator interface.
+// Connections that were interrupted or hit a network error are not returned to the pool.
+func (c *Conn) IsValid() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.closed && !c.bad
+}
 
+// ResetSession implements the driver.SessionResetter interface.
+// It's called before a pooled connection is reused.
+func (c *Conn) ResetSession(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.bad {
+		return errBadConn
+	}
+	c.applyLiveConfig()
 	return nil
 }
 
 // Implements the driver.Conn interface.
+// Statements are prepared client-side, so only the connection state is checked.
 func (c *Conn) Prepare(query string) (driver.Stmt, error) {
-	if c.closed {
-		return nil, fmt.Errorf("luna: connection closed")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// database/sql retries on another connection
+	if c.closed || c.bad {
+		return nil, errBadConn
 	}
 	stmt := &Stmt{conn: c, query: query}
 	return stmt, nil
@@ -142,11 +749,21 @@ func (c *Conn) Begin() (driver.Tx, error) {
 
 // Implements the driver.ConnBeginTx interface.
 func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.tx {
 		return nil, fmt.Errorf("luna: there is already an open transaction")
 	}
 
-	if _, err := c.ExecContext(ctx, `BEGIN TRANSACTION`, nil); err != nil {
+	begin, err := beginStatement(opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.txMode == TxBatch {
+		// Statements are sent on Commit
+		c.batch = []string{begin}
+	} else if _, err := c.hookedExec(ctx, begin); err != nil {
 		return nil, err
 	}
 
@@ -155,12 +772,19 @@ func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, e
 }
 
 // Implements the driver.Conn interface.
+// It waits for an in-flight command to complete.
 func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.closed {
 		return fmt.Errorf("luna: connection already closed")
 	}
 
 	c.closed = true
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
 	if c.conn != nil {
 		return c.conn.Close()
 	}
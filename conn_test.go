@@ -0,0 +1,622 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// connectFake opens a driver connection to a fake server.
+func connectFake(t *testing.T, addr string) *Conn {
+	t.Helper()
+	connector, err := NewConnector(addr, nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { dc.Close() })
+
+	return dc.(*Conn)
+}
+
+func TestQueryContextFakeServer(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			if cmd != "q:SELECT 42 AS answer" {
+				conn.Write([]byte("-unexpected command\r\n"))
+				continue
+			}
+
+			rec := newTestRecord(t, arrow.Field{Name: "answer", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	rows, err := conn.QueryContext(context.Background(), "SELECT 42 AS answer", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	if cols := rows.Columns(); len(cols) != 1 || cols[0] != "answer" {
+		t.Errorf("unexpected columns: %v", cols)
+	}
+
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatalf("next failed: %v", err)
+	}
+	if dest[0] != int64(0) {
+		t.Errorf("expected 0, got %v", dest[0])
+	}
+	if err := rows.Next(dest); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestQueryContextCancelAbortsRead(t *testing.T) {
+	// The server accepts commands but never replies
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := conn.QueryContext(ctx, "SELECT 1", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("query took %v to return after cancellation", elapsed)
+	}
+
+	// The interrupted connection must not be reused
+	if _, err := conn.QueryContext(context.Background(), "SELECT 1", nil); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected driver.ErrBadConn after cancellation, got %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "SELECT 1", nil); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected driver.ErrBadConn after cancellation, got %v", err)
+	}
+}
+
+func TestQueryContextAlreadyCancelled(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE t (id INT)", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Nothing was sent, so the connection is still usable
+	if conn.bad {
+		t.Error("expected connection to remain usable")
+	}
+}
+
+func TestQueryTimeoutFromDSN(t *testing.T) {
+	// The server accepts commands but never replies
+	received := make(chan struct{}, 1)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			received <- struct{}{}
+		}
+	})
+
+	connector, err := NewConnector(addr+"?query_timeout=30s", nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	conn := dc.(*Conn)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.ExecContext(context.Background(), "CREATE TABLE t (id INT)", nil)
+		errc <- err
+	}()
+
+	// Simulate the stall instead of waiting for it
+	<-received
+	clock.Advance(29 * time.Second)
+	select {
+	case err := <-errc:
+		t.Fatalf("expected the command to still be waiting, got %v", err)
+	default:
+	}
+	clock.Advance(time.Second)
+
+	err = <-errc
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a deadline exceeded error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "query timed out after 30s") {
+		t.Errorf("expected a query timeout error, got %v", err)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+
+	if _, err := conn.ExecContext(context.Background(), "SELECT 1", nil); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected driver.ErrBadConn after timeout, got %v", err)
+	}
+}
+
+func TestQueryTimeoutStopped(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write([]byte("+OK\r\n"))
+		}
+	})
+
+	connector, err := NewConnector(addr+"?query_timeout=30s", nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	conn := dc.(*Conn)
+
+	if _, err := conn.ExecContext(context.Background(), "CREATE TABLE t (id INT)", nil); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+
+	// The timer is stopped once the command completes
+	if n := clock.Pending(); n != 0 {
+		t.Errorf("expected no pending timers, got %d", n)
+	}
+	clock.Advance(time.Minute)
+	if _, err := conn.ExecContext(context.Background(), "CREATE TABLE u (id INT)", nil); err != nil {
+		t.Errorf("expected the connection to remain usable, got %v", err)
+	}
+}
+
+func TestQueryMemoryStats(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+
+			rec := newTestRecord(t,
+				arrow.Field{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+				arrow.Field{Name: "name", Type: arrow.BinaryTypes.String},
+			)
+			writeArrowReply(conn, rec.Schema(), rec, rec)
+			rec.Release()
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	dr, err := conn.QueryContext(context.Background(), "SELECT id, name FROM t", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows := dr.(*Rows)
+
+	stats := rows.MemoryStats()
+	if stats.Allocated <= 0 || stats.InUse() <= 0 {
+		t.Errorf("expected memory in use before Close, got %+v", stats)
+	}
+
+	rows.Close()
+	if stats := rows.MemoryStats(); stats.InUse() != 0 {
+		t.Errorf("expected all memory released after Close, got %+v", stats)
+	}
+}
+
+func TestRowsCloseEarlyKeepsConnUsable(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec, rec, rec)
+			rec.Release()
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	// Stop after the first of three batches
+	rows, err := conn.QueryContext(context.Background(), "SELECT n FROM t", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		t.Fatalf("next failed: %v", err)
+	}
+	rows.Close()
+
+	// The next query must see its own response, not the rest of the previous one
+	rows, err = conn.QueryContext(context.Background(), "SELECT n FROM t", nil)
+	if err != nil {
+		t.Fatalf("second query failed: %v", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next(dest) == nil {
+		count++
+	}
+	if count != 3 {
+		t.Errorf("expected 3 rows, got %d", count)
+	}
+	if !conn.IsValid() {
+		t.Error("expected connection to stay valid")
+	}
+}
+
+func TestMalformedReplyDiscardsConn(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		if _, err := readCommand(reader); err != nil {
+			return
+		}
+		// The bulk string is longer than its declared length
+		conn.Write([]byte("$5\r\nhello world\r\n"))
+	})
+
+	conn := connectFake(t, addr)
+
+	if _, err := conn.QueryContext(context.Background(), "SELECT 1", nil); err == nil {
+		t.Fatal("expected an error for a malformed reply")
+	}
+	if conn.IsValid() {
+		t.Error("expected connection to be discarded, the rest of the reply is still buffered")
+	}
+}
+
+func TestConcurrentQueryContext(t *testing.T) {
+	// Each reply names its column after the query, so mixed up replies are detected
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			name := strings.TrimPrefix(cmd, "q:SELECT 0 AS ")
+			rec := newTestRecord(t, arrow.Field{Name: name, Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	// Interleaved frames may leave a query waiting for a reply that never comes
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				name := fmt.Sprintf("c%d_%d", g, i)
+				rows, err := conn.QueryContext(ctx, "SELECT 0 AS "+name, nil)
+				if err != nil {
+					errc <- err
+					return
+				}
+				cols := rows.Columns()
+				rows.Close()
+				if len(cols) != 1 || cols[0] != name {
+					errc <- fmt.Errorf("query for %s got columns %v", name, cols)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errc)
+
+	for err := range errc {
+		t.Error(err)
+	}
+	if !conn.IsValid() {
+		t.Error("expected connection to stay valid")
+	}
+}
+
+func TestIdlePing(t *testing.T) {
+	// The server answers two commands, then drops the connection silently
+	commands := make(chan string, 4)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for i := 0; i < 2; i++ {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	connector, err := NewConnector(addr+"?idle_ping_interval=30s", nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	conn := dc.(*Conn)
+
+	// Using the connection postpones the ping
+	clock.Advance(20 * time.Second)
+	rows, err := conn.QueryContext(context.Background(), "SELECT 0 AS n", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows.Close()
+	<-commands
+
+	clock.Advance(20 * time.Second)
+	select {
+	case cmd := <-commands:
+		t.Fatalf("expected no ping before the connection was idle for 30s, got %q", cmd)
+	default:
+	}
+
+	// Probes run synchronously from Advance
+	clock.Advance(10 * time.Second)
+	if cmd := <-commands; cmd != "q:SELECT 1" {
+		t.Errorf("expected a ping, got %q", cmd)
+	}
+	if !conn.IsValid() {
+		t.Fatal("expected connection to stay valid after a successful ping")
+	}
+
+	// The next ping finds the connection broken
+	clock.Advance(30 * time.Second)
+	if conn.IsValid() {
+		t.Error("expected connection to be discarded after a failed ping")
+	}
+	if n := clock.Pending(); n != 0 {
+		t.Errorf("expected no more pings on a broken connection, %d pending", n)
+	}
+}
+
+func TestIdlePingStoppedOnClose(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		readCommand(reader)
+	})
+
+	connector, err := NewConnectorWithOptions(addr, WithIdlePingInterval(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if n := clock.Pending(); n != 1 {
+		t.Fatalf("expected a pending ping, got %d", n)
+	}
+
+	dc.Close()
+	if n := clock.Pending(); n != 0 {
+		t.Errorf("expected the ping to be stopped on close, %d pending", n)
+	}
+}
+
+// failedStream encodes an Arrow stream cut short by an error frame: the stream
+// of reply without its end-of-stream marker, followed by msg.
+func failedStream(reply []byte, msg string) []byte {
+	stream := append([]byte{}, reply[:len(reply)-8]...)
+	return append(stream, "-"+msg+"\r\n"...)
+}
+
+func TestErrorFrameMidStream(t *testing.T) {
+	var schemaOnly bytes.Buffer
+	schema := arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	if err := writeArrowReply(&schemaOnly, schema); err != nil {
+		t.Fatalf("failed to encode reply: %v", err)
+	}
+
+	replies := map[string][]byte{
+		"q:SELECT n FROM t":     failedStream(arrowReply(t, "n", 1, 2, 3), "Invalid Input Error: division by zero"),
+		"q:SELECT n FROM empty": failedStream(schemaOnly.Bytes(), "Invalid Input Error: division by zero"),
+		"x:UPDATE t SET n = 0":  failedStream(arrowReply(t, "Count", 1), "Constraint Error: duplicate key"),
+		"q:SELECT 1":            arrowReply(t, "n", 1),
+	}
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			conn.Write(replies[cmd])
+		}
+	})
+
+	conn := connectFake(t, addr)
+	ctx := context.Background()
+
+	// The rows sent before the failure are delivered, then the error
+	rows, err := conn.QueryContext(ctx, "SELECT n FROM t", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	dest := make([]driver.Value, 1)
+	for i := int64(1); i <= 3; i++ {
+		if err := rows.Next(dest); err != nil {
+			t.Fatalf("row %d: Next failed: %v", i, err)
+		}
+		if dest[0] != i {
+			t.Errorf("row %d: expected %d, got %v", i, i, dest[0])
+		}
+	}
+	err = rows.Next(dest)
+	var lerr *Error
+	if !errors.As(err, &lerr) || lerr.Code != "Invalid Input" || lerr.Query != "SELECT n FROM t" {
+		t.Errorf("expected the server's error after the rows, got %v", err)
+	}
+	rows.Close()
+
+	// Without rows, the query fails right away
+	if _, err := conn.QueryContext(ctx, "SELECT n FROM empty", nil); !errors.As(err, &lerr) {
+		t.Errorf("expected a *luna.Error, got %v", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "UPDATE t SET n = 0", nil); !errors.As(err, &lerr) || lerr.Code != "Constraint" {
+		t.Errorf("expected a Constraint error, got %v", err)
+	}
+
+	// The error frame ended the stream, so the connection is still in sync
+	if !conn.IsValid() {
+		t.Fatal("expected connection to stay valid")
+	}
+	rows, err = conn.QueryContext(ctx, "SELECT 1", nil)
+	if err != nil {
+		t.Fatalf("query after the failure failed: %v", err)
+	}
+	defer rows.Close()
+	if err := rows.Next(dest); err != nil || dest[0] != int64(1) {
+		t.Errorf("expected 1, got %v, %v", dest[0], err)
+	}
+}
+
+func TestCompressedArrowReply(t *testing.T) {
+	testCases := []struct {
+		name  string
+		codec ipc.Option
+	}{
+		{"zstd", ipc.WithZstd()},
+		{"lz4", ipc.WithLZ4()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+				{Name: "s", Type: arrow.BinaryTypes.String},
+			}, nil))
+			defer b.Release()
+			for i := 0; i < 1000; i++ {
+				b.Field(0).(*array.StringBuilder).Append(fmt.Sprintf("value %d", i%10))
+			}
+			rec := b.NewRecord()
+			defer rec.Release()
+
+			// Arrow IPC buffer compression is signaled in each batch, and needs no
+			// negotiation to decode
+			var reply bytes.Buffer
+			writer := ipc.NewWriter(&reply, ipc.WithSchema(rec.Schema()), tc.codec)
+			if err := writer.Write(rec); err != nil {
+				t.Fatalf("failed to write record: %v", err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("failed to close writer: %v", err)
+			}
+
+			addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+				for {
+					if _, err := readCommand(reader); err != nil {
+						return
+					}
+					conn.Write(reply.Bytes())
+				}
+			})
+
+			conn := connectFake(t, addr)
+			rows, err := conn.QueryContext(context.Background(), "SELECT s FROM t", nil)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			defer rows.Close()
+
+			var got []driver.Value
+			dest := make([]driver.Value, 1)
+			for rows.Next(dest) == nil {
+				got = append(got, dest[0])
+			}
+			if len(got) != int(rec.NumRows()) {
+				t.Fatalf("expected %d rows, got %d", rec.NumRows(), len(got))
+			}
+			for i, v := range got {
+				if expected := rec.Column(0).ValueStr(i); v != expected {
+					t.Errorf("row %d: expected %q, got %v", i, expected, v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CopyFrom loads data read from r into table on conn, a connection from a
+// database/sql pool opened with the luna driver, e.g. a file that exists only on
+// the client rather than on the server's filesystem, where COPY FROM would look
+// for it. It returns the number of rows inserted.
+//
+// The only format is "csv": a header line naming the columns, followed by a line
+// per row. Parquet and the other formats COPY reads aren't supported, since the
+// server has no upload command to stream a file to: the CSV is parsed on the
+// client instead. Fields are sent as strings, which the server casts to the
+// column types, and empty fields are NULL. Rows are inserted with an Appender, a batch
+// at a time, so if loading fails partway, the batches before the failure stay
+// inserted; load into a staging table to make a failed load easy to discard.
+func CopyFrom(ctx context.Context, conn *sql.Conn, table, format string, r io.Reader) (int64, error) {
+	if !strings.EqualFold(format, "csv") {
+		return 0, fmt.Errorf("luna: unsupported format %q for CopyFrom: only csv can be uploaded", format)
+	}
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("luna: copying to %s: missing CSV header", table)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("luna: copying to %s: %w", table, err)
+	}
+
+	a, err := NewAppender(ctx, conn, table, header...)
+	if err != nil {
+		return 0, err
+	}
+
+	// Values are formatted as they're appended, so the record can be reused
+	cr.ReuseRecord = true
+	values := make([]any, len(header))
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return a.inserted, fmt.Errorf("luna: copying to %s: %w", table, err)
+		}
+
+		for i, field := range record {
+			if field == "" {
+				values[i] = nil
+			} else {
+				values[i] = field
+			}
+		}
+		if err := a.AppendRow(values...); err != nil {
+			return a.inserted, err
+		}
+	}
+
+	err = a.Close()
+	return a.inserted, err
+}
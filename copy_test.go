@@ -0,0 +1,72 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCopyFrom(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			conn.Write([]byte(":3\r\n"))
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	data := "id,name\n1,a\n2,\n3,\"x, y\"\n"
+	n, err := CopyFrom(ctx, conn, "t", "CSV", strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("CopyFrom failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 rows inserted, got %d", n)
+	}
+	expected := `x:INSERT INTO t ("id", "name") VALUES ('1', 'a'), ('2', NULL), ('3', 'x, y')`
+	if cmd := <-commands; cmd != expected {
+		t.Errorf("expected command %q, got %q", expected, cmd)
+	}
+
+	testCases := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{"unsupported format", "parquet", "PAR1"},
+		{"missing header", "csv", ""},
+		{"wrong number of fields", "csv", "id,name\n1\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if n, err := CopyFrom(ctx, conn, "t", tc.format, strings.NewReader(tc.data)); err == nil || n != 0 {
+				t.Errorf("expected an error and no rows, got %d, %v", n, err)
+			}
+		})
+	}
+	select {
+	case cmd := <-commands:
+		t.Errorf("expected nothing to be sent for failed copies, got %q", cmd)
+	default:
+	}
+}
@@ -0,0 +1,269 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrSchemaMismatch is returned when an existing table doesn't have the columns
+// a migration expects, e.g. a column of another type.
+var ErrSchemaMismatch = errors.New("luna: existing schema doesn't match")
+
+// ColumnDef is a column of a table created or extended by CreateTableIfNotExists
+// and EnsureColumns.
+type ColumnDef struct {
+	Name string
+	// Type is the SQL type of the column, e.g. "BIGINT" or "DECIMAL(18,2)".
+	// Common aliases, e.g. INT or TEXT, match the server's names.
+	Type string
+}
+
+// ExecIdempotent runs a DDL statement unless done reports that its end state is
+// already reached, then checks with done that it was. A statement that fails,
+// e.g. because the connection broke before the reply arrived although the
+// server applied it, is not an error if its end state is reached anyway. This
+// makes migration jobs safe to re-run against a server without transactional
+// DDL.
+func ExecIdempotent(ctx context.Context, db *sql.DB, query string, done func(ctx context.Context, db *sql.DB) (bool, error)) error {
+	ok, err := done(ctx, db)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	_, execErr := db.ExecContext(ctx, query)
+	ok, err = done(ctx, db)
+	switch {
+	case err != nil:
+		return errors.Join(execErr, err)
+	case ok:
+		return nil
+	case execErr != nil:
+		return execErr
+	default:
+		return fmt.Errorf("luna: %s didn't take effect", query)
+	}
+}
+
+// CreateTableIfNotExists creates a table with the given columns unless it exists,
+// with CREATE TABLE IF NOT EXISTS, and checks through information_schema that
+// the table has the columns, with matching types. An existing table may have
+// more columns. It returns an error matching ErrSchemaMismatch if the columns
+// don't match, e.g. for a table created by an older migration; see EnsureColumns.
+func CreateTableIfNotExists(ctx context.Context, db *sql.DB, table string, columns []ColumnDef) error {
+	name, err := quoteTableName(table)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("luna: table %s has no columns", table)
+	}
+
+	defs := make([]string, len(columns))
+	for i, col := range columns {
+		defs[i] = quoteSQLIdentifier(col.Name) + " " + col.Type
+	}
+	query := "CREATE TABLE IF NOT EXISTS " + name + " (" + strings.Join(defs, ", ") + ")"
+
+	var missing []ColumnDef
+	err = ExecIdempotent(ctx, db, query, func(ctx context.Context, db *sql.DB) (bool, error) {
+		existing, err := tableColumns(ctx, db, table)
+		if err != nil || existing == nil {
+			return false, err
+		}
+		missing, err = missingColumns(table, existing, columns)
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: table %s has no column %s", ErrSchemaMismatch, table, missing[0].Name)
+	}
+	return nil
+}
+
+// EnsureColumns adds the columns a table lacks, one ALTER TABLE ... ADD COLUMN IF
+// NOT EXISTS statement each, and checks that it ends up with all of them. It
+// returns an error matching ErrSchemaMismatch if a column exists with another
+// type, without changing anything, and an error if the table doesn't exist.
+func EnsureColumns(ctx context.Context, db *sql.DB, table string, columns []ColumnDef) error {
+	name, err := quoteTableName(table)
+	if err != nil {
+		return err
+	}
+
+	existing, err := tableColumns(ctx, db, table)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return fmt.Errorf("luna: table %s doesn't exist", table)
+	}
+	missing, err := missingColumns(table, existing, columns)
+	if err != nil {
+		return err
+	}
+
+	for _, col := range missing {
+		query := "ALTER TABLE " + name + " ADD COLUMN IF NOT EXISTS " + quoteSQLIdentifier(col.Name) + " " + col.Type
+		err := ExecIdempotent(ctx, db, query, func(ctx context.Context, db *sql.DB) (bool, error) {
+			existing, err := tableColumns(ctx, db, table)
+			if err != nil {
+				return false, err
+			}
+			still, err := missingColumns(table, existing, []ColumnDef{col})
+			return len(still) == 0, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropCatalogs are the kinds of objects DropIfExists drops, with the catalog
+// function and columns that list them.
+var dropCatalogs = map[string]struct {
+	from, catalog, schema, name string
+}{
+	"TABLE":    {"information_schema.tables WHERE table_type = 'BASE TABLE' AND", "table_catalog", "table_schema", "table_name"},
+	"VIEW":     {"information_schema.tables WHERE table_type = 'VIEW' AND", "table_catalog", "table_schema", "table_name"},
+	"SEQUENCE": {"duckdb_sequences() WHERE", "database_name", "schema_name", "sequence_name"},
+	"INDEX":    {"duckdb_indexes() WHERE", "database_name", "schema_name", "index_name"},
+}
+
+// DropIfExists drops a table, view, sequence or index, as given by kind, with
+// DROP ... IF EXISTS, and checks through the server's catalog that it's gone.
+func DropIfExists(ctx context.Context, db *sql.DB, kind, name string) error {
+	kind = strings.ToUpper(kind)
+	c, ok := dropCatalogs[kind]
+	if !ok {
+		return fmt.Errorf("luna: DropIfExists can't drop a %s", kind)
+	}
+	quoted, err := quoteTableName(name)
+	if err != nil {
+		return err
+	}
+
+	where := objectFilter(name, c.catalog, c.schema, c.name)
+	query := "DROP " + kind + " IF EXISTS " + quoted
+	return ExecIdempotent(ctx, db, query, func(ctx context.Context, db *sql.DB) (bool, error) {
+		var n int64
+		if err := db.QueryRowContext(ctx, "SELECT count(*) FROM "+c.from+" "+where).Scan(&n); err != nil {
+			return false, err
+		}
+		return n == 0, nil
+	})
+}
+
+// objectFilter returns the SQL condition selecting the catalog rows of an object
+// named name, e.g. sales.orders, whose catalog, schema and name are in the given
+// columns. Unqualified names are looked up in the current database and schema.
+func objectFilter(name, catalogCol, schemaCol, nameCol string) string {
+	parts := strings.Split(name, ".")
+	catalog, schema := "current_database()", "current_schema()"
+	switch len(parts) {
+	case 3:
+		catalog, schema = quoteSQLString(parts[0]), quoteSQLString(parts[1])
+	case 2:
+		schema = quoteSQLString(parts[0])
+	}
+	return fmt.Sprintf("%s = %s AND %s = %s AND %s = %s",
+		catalogCol, catalog, schemaCol, schema, nameCol, quoteSQLString(parts[len(parts)-1]))
+}
+
+// tableColumns returns the types of the columns of table by name, from
+// information_schema.columns, or nil if the table doesn't exist.
+func tableColumns(ctx context.Context, db *sql.DB, table string) (map[string]string, error) {
+	query := "SELECT column_name, data_type FROM information_schema.columns WHERE " +
+		objectFilter(table, "table_catalog", "table_schema", "table_name")
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns map[string]string
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		if columns == nil {
+			columns = make(map[string]string)
+		}
+		columns[name] = typ
+	}
+	return columns, rows.Err()
+}
+
+// missingColumns returns the columns that aren't among the existing ones, and
+// an error matching ErrSchemaMismatch for the first one that is, but with
+// another type.
+func missingColumns(table string, existing map[string]string, columns []ColumnDef) ([]ColumnDef, error) {
+	var missing []ColumnDef
+	for _, col := range columns {
+		typ, ok := existing[col.Name]
+		if !ok {
+			missing = append(missing, col)
+			continue
+		}
+		if normalizeSQLType(typ) != normalizeSQLType(col.Type) {
+			return nil, fmt.Errorf("%w: column %s of table %s is %s, not %s", ErrSchemaMismatch, col.Name, table, typ, col.Type)
+		}
+	}
+	return missing, nil
+}
+
+// sqlTypeAliases maps type aliases to the names information_schema reports.
+var sqlTypeAliases = map[string]string{
+	"INT":       "INTEGER",
+	"INT4":      "INTEGER",
+	"SIGNED":    "INTEGER",
+	"INT8":      "BIGINT",
+	"LONG":      "BIGINT",
+	"INT2":      "SMALLINT",
+	"SHORT":     "SMALLINT",
+	"INT1":      "TINYINT",
+	"BOOL":      "BOOLEAN",
+	"LOGICAL":   "BOOLEAN",
+	"FLOAT4":    "FLOAT",
+	"REAL":      "FLOAT",
+	"FLOAT8":    "DOUBLE",
+	"TEXT":      "VARCHAR",
+	"STRING":    "VARCHAR",
+	"CHAR":      "VARCHAR",
+	"BPCHAR":    "VARCHAR",
+	"BYTEA":     "BLOB",
+	"BINARY":    "BLOB",
+	"VARBINARY": "BLOB",
+	"DATETIME":  "TIMESTAMP",
+	"NUMERIC":   "DECIMAL",
+	// Without spaces, see normalizeSQLType
+	"TIMESTAMPTZ": "TIMESTAMPWITHTIMEZONE",
+}
+
+// normalizeSQLType returns a canonical form of an SQL type, to compare a type
+// written in a migration with the one the server reports: upper-cased, without
+// spaces, aliases resolved, VARCHAR lengths dropped, since they aren't
+// enforced, and the default DECIMAL width made explicit.
+func normalizeSQLType(typ string) string {
+	typ = strings.ToUpper(strings.Join(strings.Fields(typ), ""))
+	base, args, _ := strings.Cut(typ, "(")
+	if alias, ok := sqlTypeAliases[base]; ok {
+		base = alias
+	}
+	if base == "DECIMAL" && args == "" {
+		return "DECIMAL(18,3)"
+	}
+	if base == "VARCHAR" || args == "" {
+		return base
+	}
+	return base + "(" + args
+}
@@ -0,0 +1,281 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestNormalizeSQLType(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected string
+	}{
+		{"BIGINT", "BIGINT"},
+		{"int8", "BIGINT"},
+		{"INT", "INTEGER"},
+		{"text", "VARCHAR"},
+		{"VARCHAR(20)", "VARCHAR"},
+		{"decimal(18, 2)", "DECIMAL(18,2)"},
+		{"NUMERIC", "DECIMAL(18,3)"},
+		{"timestamptz", "TIMESTAMPWITHTIMEZONE"},
+		{"TIMESTAMP WITH TIME ZONE", "TIMESTAMPWITHTIMEZONE"},
+		{"INTEGER[]", "INTEGER[]"},
+	}
+
+	for _, tc := range testCases {
+		if got := normalizeSQLType(tc.in); got != tc.expected {
+			t.Errorf("normalizeSQLType(%q) = %q, expected %q", tc.in, got, tc.expected)
+		}
+	}
+}
+
+// catalogServer is a fake server holding the columns of a single table, which
+// answers information_schema.columns queries and applies CREATE TABLE and ALTER
+// TABLE ADD COLUMN statements.
+type catalogServer struct {
+	mu       sync.Mutex
+	columns  map[string]string
+	commands []string
+	// Close the connection instead of replying to the next statement, after
+	// applying it
+	dropReply bool
+}
+
+var (
+	createColumnRe = regexp.MustCompile(`"(\w+)" (\w+)`)
+	addColumnRe    = regexp.MustCompile(`ADD COLUMN IF NOT EXISTS "(\w+)" (\w+)`)
+)
+
+func (s *catalogServer) start(t *testing.T) string {
+	return newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.commands = append(s.commands, cmd)
+			switch {
+			case strings.Contains(cmd, "information_schema.columns"):
+				reply := columnsReply(t, s.columns)
+				s.mu.Unlock()
+				conn.Write(reply)
+				continue
+			case strings.HasPrefix(cmd, "x:CREATE TABLE IF NOT EXISTS"):
+				if s.columns == nil {
+					s.columns = make(map[string]string)
+					for _, m := range createColumnRe.FindAllStringSubmatch(cmd, -1) {
+						s.columns[m[1]] = m[2]
+					}
+				}
+			case strings.HasPrefix(cmd, "x:ALTER TABLE"):
+				m := addColumnRe.FindStringSubmatch(cmd)
+				s.columns[m[1]] = m[2]
+			}
+			drop := s.dropReply
+			s.dropReply = false
+			s.mu.Unlock()
+
+			if drop {
+				conn.Close()
+				return
+			}
+			conn.Write([]byte("+OK\r\n"))
+		}
+	})
+}
+
+// columnsReply encodes the column_name and data_type rows of columns.
+func columnsReply(t *testing.T, columns map[string]string) []byte {
+	t.Helper()
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+		{Name: "column_name", Type: arrow.BinaryTypes.String},
+		{Name: "data_type", Type: arrow.BinaryTypes.String},
+	}, nil))
+	defer b.Release()
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.Field(0).(*array.StringBuilder).Append(name)
+		b.Field(1).(*array.StringBuilder).Append(columns[name])
+	}
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf strings.Builder
+	if err := writeArrowReply(&buf, rec.Schema(), rec); err != nil {
+		t.Fatalf("failed to encode reply: %v", err)
+	}
+	return []byte(buf.String())
+}
+
+// executed returns the statements executed on the server, in order.
+func (s *catalogServer) executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stmts []string
+	for _, cmd := range s.commands {
+		if strings.HasPrefix(cmd, "x:") {
+			stmts = append(stmts, strings.TrimPrefix(cmd, "x:"))
+		}
+	}
+	return stmts
+}
+
+func openCatalogServer(t *testing.T, s *catalogServer) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("luna", s.start(t))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestCreateTableIfNotExists(t *testing.T) {
+	s := &catalogServer{}
+	db := openCatalogServer(t, s)
+	columns := []ColumnDef{{Name: "id", Type: "INT8"}, {Name: "name", Type: "TEXT"}}
+
+	// The second run finds the table and sends nothing
+	for run := 0; run < 2; run++ {
+		if err := CreateTableIfNotExists(context.Background(), db, "sales.people", columns); err != nil {
+			t.Fatalf("run %d: CreateTableIfNotExists failed: %v", run, err)
+		}
+	}
+	expected := []string{`CREATE TABLE IF NOT EXISTS "sales"."people" ("id" INT8, "name" TEXT)`}
+	if got := s.executed(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected statements %q, got %q", expected, got)
+	}
+
+	// An older table with another type isn't changed
+	s.mu.Lock()
+	s.columns = map[string]string{"id": "INTEGER"}
+	s.mu.Unlock()
+	err := CreateTableIfNotExists(context.Background(), db, "sales.people", columns)
+	if !errors.Is(err, ErrSchemaMismatch) || !strings.Contains(err.Error(), "column id of table sales.people is INTEGER") {
+		t.Errorf("expected ErrSchemaMismatch, got %v", err)
+	}
+	if got := s.executed(); len(got) != 1 {
+		t.Errorf("expected no more statements, got %q", got)
+	}
+}
+
+func TestExecIdempotentLostReply(t *testing.T) {
+	s := &catalogServer{dropReply: true}
+	db := openCatalogServer(t, s)
+
+	// The connection breaks after the server applied the statement
+	columns := []ColumnDef{{Name: "id", Type: "BIGINT"}}
+	if err := CreateTableIfNotExists(context.Background(), db, "people", columns); err != nil {
+		t.Errorf("expected the applied statement to succeed, got %v", err)
+	}
+
+	// A statement that doesn't take effect fails
+	err := ExecIdempotent(context.Background(), db, "SELECT 1", func(ctx context.Context, db *sql.DB) (bool, error) {
+		return false, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "didn't take effect") {
+		t.Errorf("expected an error, got %v", err)
+	}
+}
+
+func TestEnsureColumns(t *testing.T) {
+	s := &catalogServer{columns: map[string]string{"id": "BIGINT"}}
+	db := openCatalogServer(t, s)
+
+	columns := []ColumnDef{{Name: "id", Type: "BIGINT"}, {Name: "note", Type: "TEXT"}, {Name: "amount", Type: "DOUBLE"}}
+	for run := 0; run < 2; run++ {
+		if err := EnsureColumns(context.Background(), db, "people", columns); err != nil {
+			t.Fatalf("run %d: EnsureColumns failed: %v", run, err)
+		}
+	}
+	expected := []string{
+		`ALTER TABLE "people" ADD COLUMN IF NOT EXISTS "note" TEXT`,
+		`ALTER TABLE "people" ADD COLUMN IF NOT EXISTS "amount" DOUBLE`,
+	}
+	if got := s.executed(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected statements %q, got %q", expected, got)
+	}
+
+	err := EnsureColumns(context.Background(), db, "people", []ColumnDef{{Name: "amount", Type: "BIGINT"}, {Name: "new", Type: "DATE"}})
+	if !errors.Is(err, ErrSchemaMismatch) || len(s.executed()) != 2 {
+		t.Errorf("expected ErrSchemaMismatch without statements, got %v, %q", err, s.executed())
+	}
+
+	s.mu.Lock()
+	s.columns = nil
+	s.mu.Unlock()
+	if err := EnsureColumns(context.Background(), db, "people", columns); err == nil || !strings.Contains(err.Error(), "doesn't exist") {
+		t.Errorf("expected an error for a missing table, got %v", err)
+	}
+}
+
+func TestDropIfExists(t *testing.T) {
+	var mu sync.Mutex
+	var commands []string
+	exists := true
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			commands = append(commands, cmd)
+			if strings.HasPrefix(cmd, "x:DROP") {
+				exists = false
+				mu.Unlock()
+				conn.Write([]byte("+OK\r\n"))
+				continue
+			}
+			n := int64(0)
+			if exists {
+				n = 1
+			}
+			mu.Unlock()
+			conn.Write(arrowReply(t, "count", n))
+		}
+	})
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	for run := 0; run < 2; run++ {
+		if err := DropIfExists(context.Background(), db, "view", "sales.daily"); err != nil {
+			t.Fatalf("run %d: DropIfExists failed: %v", run, err)
+		}
+	}
+	expected := []string{
+		"q:SELECT count(*) FROM information_schema.tables WHERE table_type = 'VIEW' AND table_catalog = current_database() AND table_schema = 'sales' AND table_name = 'daily'",
+		`x:DROP VIEW IF EXISTS "sales"."daily"`,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(commands) != 4 || commands[0] != expected[0] || commands[1] != expected[1] {
+		t.Errorf("expected commands starting with %q, got %q", expected, commands)
+	}
+
+	if err := DropIfExists(context.Background(), db, "database", "main"); err == nil {
+		t.Error("expected an error for an unsupported kind")
+	}
+}
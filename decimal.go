@@ -0,0 +1,253 @@
+package luna
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// DecimalMode selects how Rows returns the values of DECIMAL columns.
+type DecimalMode int
+
+const (
+	// DecimalAsString returns each decimal as its exact text, e.g. "123.45", which
+	// scans into a string, a float64, or a github.com/shopspring/decimal.Decimal.
+	// This is the default.
+	DecimalAsString DecimalMode = iota
+	// DecimalAsRat returns each decimal as a *big.Rat. Scan it into a **big.Rat or
+	// an *any.
+	DecimalAsRat
+	// DecimalAsDecimal returns each decimal as a Decimal, which scans into a
+	// Decimal or a float64.
+	DecimalAsDecimal
+)
+
+// decimalModes maps the values of the decimal_mode DSN parameter to decimal modes.
+var decimalModes = map[string]DecimalMode{
+	"string":  DecimalAsString,
+	"rat":     DecimalAsRat,
+	"decimal": DecimalAsDecimal,
+}
+
+var (
+	scanTypeRat     = reflect.TypeOf((*big.Rat)(nil))
+	scanTypeDecimal = reflect.TypeOf(Decimal{})
+)
+
+func parseDecimalModeParam(v string, dst *DecimalMode) error {
+	mode, ok := decimalModes[v]
+	if !ok {
+		return fmt.Errorf("must be string, rat or decimal")
+	}
+	*dst = mode
+	return nil
+}
+
+// Decimal is an exact decimal number, Coefficient × 10^Exp, e.g.
+// Decimal{Coefficient: big.NewInt(12345), Exp: -2} for 123.45. A nil
+// Coefficient is zero.
+//
+// Its fields are the arguments of github.com/shopspring/decimal's NewFromBigInt,
+// so converting doesn't go through text:
+//
+//	d := decimal.NewFromBigInt(v.Coefficient, v.Exp)
+//
+// Decimal implements driver.Valuer and sql.Scanner, so values read from a query
+// can be passed back as query arguments.
+type Decimal struct {
+	Coefficient *big.Int
+	Exp         int32
+}
+
+// ParseDecimal parses a decimal number such as "-123.45" or "1.5e-3".
+func ParseDecimal(s string) (Decimal, error) {
+	mantissa, exp := s, int64(0)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("luna: invalid decimal %q", s)
+		}
+		mantissa, exp = s[:i], e
+	}
+
+	digits := strings.TrimLeft(mantissa, "+-")
+	if len(mantissa)-len(digits) > 1 {
+		return Decimal{}, fmt.Errorf("luna: invalid decimal %q", s)
+	}
+	if intPart, frac, ok := strings.Cut(digits, "."); ok {
+		digits = intPart + frac
+		exp -= int64(len(frac))
+	}
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("luna: invalid decimal %q", s)
+	}
+	if exp < -1<<31 || exp > 1<<31-1 {
+		return Decimal{}, fmt.Errorf("luna: decimal %q out of range", s)
+	}
+
+	coef, _ := new(big.Int).SetString(digits, 10)
+	if strings.HasPrefix(mantissa, "-") {
+		coef.Neg(coef)
+	}
+	return Decimal{Coefficient: coef, Exp: int32(exp)}, nil
+}
+
+// String returns the decimal in plain notation, e.g. "123.45", with as many
+// fractional digits as -Exp.
+func (d Decimal) String() string {
+	if d.Coefficient == nil {
+		d.Coefficient = new(big.Int)
+	}
+	if d.Exp >= 0 {
+		return d.Coefficient.String() + strings.Repeat("0", int(d.Exp))
+	}
+
+	digits := new(big.Int).Abs(d.Coefficient).String()
+	scale := int(-d.Exp)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	s := digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	if d.Coefficient.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// Rat returns the decimal as a big.Rat.
+func (d Decimal) Rat() *big.Rat {
+	r := new(big.Rat)
+	if d.Coefficient == nil {
+		return r
+	}
+	r.SetInt(d.Coefficient)
+
+	exp := int64(d.Exp)
+	if exp < 0 {
+		exp = -exp
+	}
+	pow := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(exp), nil))
+	if d.Exp < 0 {
+		return r.Quo(r, pow)
+	}
+	return r.Mul(r, pow)
+}
+
+// Value implements the driver.Valuer interface. Decimals are passed as their
+// exact text.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Scan implements the sql.Scanner interface. It accepts decimals in any decimal
+// mode, as well as integers, floats and text.
+func (d *Decimal) Scan(src any) error {
+	switch v := src.(type) {
+	case Decimal:
+		*d = v
+	case *big.Rat:
+		dec, ok := decimalFromRat(v)
+		if !ok {
+			return fmt.Errorf("luna: %s isn't a finite decimal", v)
+		}
+		*d = dec
+	case string:
+		return d.parse(v)
+	case []byte:
+		return d.parse(string(v))
+	case int64:
+		*d = Decimal{Coefficient: big.NewInt(v)}
+	case float64:
+		return d.parse(strconv.FormatFloat(v, 'g', -1, 64))
+	case nil:
+		return fmt.Errorf("luna: can't scan NULL into a Decimal")
+	default:
+		return fmt.Errorf("luna: can't scan %T into a Decimal", src)
+	}
+	return nil
+}
+
+func (d *Decimal) parse(s string) error {
+	dec, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = dec
+	return nil
+}
+
+// decimalFromRat converts r to a decimal, if its denominator only has the prime
+// factors 2 and 5.
+func decimalFromRat(r *big.Rat) (Decimal, bool) {
+	num, den := new(big.Int).Set(r.Num()), new(big.Int).Set(r.Denom())
+	ten, two, five := big.NewInt(10), big.NewInt(2), big.NewInt(5)
+
+	var exp int32
+	rem := new(big.Int)
+	for den.Cmp(big.NewInt(1)) != 0 {
+		switch {
+		case rem.Mod(den, ten).Sign() == 0:
+			den.Quo(den, ten)
+		case rem.Mod(den, two).Sign() == 0:
+			den.Quo(den, two)
+			num.Mul(num, five)
+		case rem.Mod(den, five).Sign() == 0:
+			den.Quo(den, five)
+			num.Mul(num, two)
+		default:
+			return Decimal{}, false
+		}
+		exp--
+	}
+	return Decimal{Coefficient: num, Exp: exp}, true
+}
+
+// decimalScanType returns the Go type of the values of a decimal column in the
+// given mode, or nil if dt isn't a decimal type.
+func decimalScanType(dt arrow.DataType, mode DecimalMode) reflect.Type {
+	switch dt.ID() {
+	case arrow.DECIMAL128, arrow.DECIMAL256:
+	default:
+		return nil
+	}
+
+	switch mode {
+	case DecimalAsRat:
+		return scanTypeRat
+	case DecimalAsDecimal:
+		return scanTypeDecimal
+	default:
+		return scanTypeString
+	}
+}
+
+// decimalValue extracts the decimal at rowIdx of a Decimal128 or Decimal256
+// array, in the given mode.
+func decimalValue(arr arrow.Array, rowIdx int, mode DecimalMode) any {
+	var coef *big.Int
+	var scale int32
+	switch arr := arr.(type) {
+	case *array.Decimal128:
+		coef = arr.Value(rowIdx).BigInt()
+		scale = arr.DataType().(*arrow.Decimal128Type).Scale
+	case *array.Decimal256:
+		coef = arr.Value(rowIdx).BigInt()
+		scale = arr.DataType().(*arrow.Decimal256Type).Scale
+	}
+
+	d := Decimal{Coefficient: coef, Exp: -scale}
+	switch mode {
+	case DecimalAsRat:
+		return d.Rat()
+	case DecimalAsDecimal:
+		return d
+	default:
+		return d.String()
+	}
+}
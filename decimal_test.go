@@ -0,0 +1,284 @@
+package luna
+
+import (
+	"bufio"
+	"database/sql"
+	"database/sql/driver"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/decimal128"
+	"github.com/apache/arrow/go/v17/arrow/decimal256"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestParseDecimal(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected string
+		err      bool
+	}{
+		{in: "123.45", expected: "123.45"},
+		{in: "-0.05", expected: "-0.05"},
+		{in: "+7", expected: "7"},
+		{in: "1.50", expected: "1.50"},
+		{in: ".5", expected: "0.5"},
+		{in: "1.5e-3", expected: "0.0015"},
+		{in: "25E2", expected: "2500"},
+		{in: "", err: true},
+		{in: "-", err: true},
+		{in: "1.2.3", err: true},
+		{in: "--1", err: true},
+		{in: "1e", err: true},
+		{in: "0x10", err: true},
+		{in: "NaN", err: true},
+	}
+
+	for _, tc := range testCases {
+		d, err := ParseDecimal(tc.in)
+		if tc.err {
+			if err == nil {
+				t.Errorf("ParseDecimal(%q): expected an error, got %v", tc.in, d)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDecimal(%q) failed: %v", tc.in, err)
+			continue
+		}
+		if got := d.String(); got != tc.expected {
+			t.Errorf("ParseDecimal(%q): expected %s, got %s", tc.in, tc.expected, got)
+		}
+	}
+}
+
+func TestDecimalRat(t *testing.T) {
+	testCases := []struct {
+		d        Decimal
+		expected string
+	}{
+		{Decimal{Coefficient: big.NewInt(12345), Exp: -2}, "2469/20"},
+		{Decimal{Coefficient: big.NewInt(-3), Exp: 2}, "-300/1"},
+		{Decimal{}, "0/1"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.d.Rat().String(); got != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.d, tc.expected, got)
+		}
+	}
+}
+
+func TestDecimalScan(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src      any
+		expected string
+		err      bool
+	}{
+		{name: "decimal", src: Decimal{Coefficient: big.NewInt(105), Exp: -1}, expected: "10.5"},
+		{name: "rat", src: big.NewRat(1, 8), expected: "0.125"},
+		{name: "negative rat", src: big.NewRat(-3, 20), expected: "-0.15"},
+		{name: "integer rat", src: big.NewRat(42, 1), expected: "42"},
+		{name: "string", src: "99.90", expected: "99.90"},
+		{name: "bytes", src: []byte("-1.5"), expected: "-1.5"},
+		{name: "int64", src: int64(-12), expected: "-12"},
+		{name: "float64", src: 0.25, expected: "0.25"},
+		{name: "repeating rat", src: big.NewRat(1, 3), err: true},
+		{name: "null", src: nil, err: true},
+		{name: "bool", src: true, err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var d Decimal
+			err := d.Scan(tc.src)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error, got %v", d)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if got := d.String(); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDecimalValuer(t *testing.T) {
+	d, err := ParseDecimal("-1234567890123456789012345678.90")
+	if err != nil {
+		t.Fatalf("ParseDecimal failed: %v", err)
+	}
+
+	// database/sql accepts Decimal arguments, and reads them back unchanged
+	var arg driver.Valuer = d
+	v, err := arg.Value()
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	if !driver.IsValue(v) {
+		t.Fatalf("expected a valid driver.Value, got %T", v)
+	}
+
+	var back Decimal
+	if err := back.Scan(v); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !reflect.DeepEqual(back, d) {
+		t.Errorf("expected %v, got %v", d, back)
+	}
+}
+
+func newDecimalRecord(t *testing.T) arrow.Record {
+	t.Helper()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "price", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}, Nullable: true},
+		{Name: "big", Type: &arrow.Decimal256Type{Precision: 40, Scale: 3}},
+	}, nil)
+
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+	b.Field(0).(*array.Decimal128Builder).AppendValues([]decimal128.Num{decimal128.FromI64(12345), decimal128.FromI64(-5), {}}, []bool{true, true, false})
+	b.Field(1).(*array.Decimal256Builder).AppendValues([]decimal256.Num{decimal256.FromI64(1500), decimal256.FromI64(7), decimal256.FromI64(0)}, nil)
+	return b.NewRecord()
+}
+
+func TestRowsDecimalColumns(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     DecimalMode
+		scanType reflect.Type
+		expected [][]driver.Value
+	}{
+		{
+			name:     "string",
+			mode:     DecimalAsString,
+			scanType: scanTypeString,
+			expected: [][]driver.Value{
+				{"123.45", "1.500"},
+				{"-0.05", "0.007"},
+				{nil, "0.000"},
+			},
+		},
+		{
+			name:     "rat",
+			mode:     DecimalAsRat,
+			scanType: scanTypeRat,
+			expected: [][]driver.Value{
+				{big.NewRat(12345, 100), big.NewRat(3, 2)},
+				{big.NewRat(-1, 20), big.NewRat(7, 1000)},
+				{nil, new(big.Rat)},
+			},
+		},
+		{
+			name:     "decimal",
+			mode:     DecimalAsDecimal,
+			scanType: scanTypeDecimal,
+			expected: [][]driver.Value{
+				{Decimal{Coefficient: big.NewInt(12345), Exp: -2}, Decimal{Coefficient: big.NewInt(1500), Exp: -3}},
+				{Decimal{Coefficient: big.NewInt(-5), Exp: -2}, Decimal{Coefficient: big.NewInt(7), Exp: -3}},
+				{nil, Decimal{Coefficient: big.NewInt(0), Exp: -3}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := newDecimalRecord(t)
+			rows := newRowsFromArrow(rec.Schema(), []arrow.Record{rec})
+			rows.decimalMode = tc.mode
+			defer rows.Close()
+
+			for i := range rows.Columns() {
+				if got := rows.ColumnTypeScanType(i); got != tc.scanType {
+					t.Errorf("column %d: expected scan type %v, got %v", i, tc.scanType, got)
+				}
+			}
+
+			for i, expected := range tc.expected {
+				dest := make([]driver.Value, len(expected))
+				if err := rows.Next(dest); err != nil {
+					t.Fatalf("row %d: Next failed: %v", i, err)
+				}
+				for j := range expected {
+					if !decimalValuesEqual(dest[j], expected[j]) {
+						t.Errorf("row %d, column %d: expected %v, got %v", i, j, expected[j], dest[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+// decimalValuesEqual compares values returned for decimal columns, with big.Rat
+// values compared by value.
+func decimalValuesEqual(got, expected driver.Value) bool {
+	if r, ok := expected.(*big.Rat); ok {
+		g, ok := got.(*big.Rat)
+		return ok && g.Cmp(r) == 0
+	}
+	if d, ok := expected.(Decimal); ok {
+		g, ok := got.(Decimal)
+		return ok && g.Exp == d.Exp && g.Coefficient.Cmp(d.Coefficient) == 0
+	}
+	return reflect.DeepEqual(got, expected)
+}
+
+func TestScanDecimals(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			rec := newDecimalRecord(t)
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	var (
+		s string
+		f float64
+		d Decimal
+		r *big.Rat
+	)
+	testCases := []struct {
+		mode     string
+		dest     any
+		expected func() string
+	}{
+		{"string", &s, func() string { return s }},
+		{"string", &f, func() string { return big.NewFloat(f).Text('f', 2) }},
+		{"string", &d, func() string { return d.String() }},
+		{"decimal", &f, func() string { return big.NewFloat(f).Text('f', 2) }},
+		{"decimal", &d, func() string { return d.String() }},
+		{"rat", &r, func() string { return r.FloatString(2) }},
+		{"rat", &d, func() string { return d.String() }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			db, err := sql.Open("luna", addr+"?decimal_mode="+tc.mode)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+
+			if err := db.QueryRow("SELECT price, big FROM t").Scan(tc.dest, new(any)); err != nil {
+				t.Fatalf("scan into %T failed: %v", tc.dest, err)
+			}
+			if got := tc.expected(); got != "123.45" {
+				t.Errorf("scan into %T: expected 123.45, got %s", tc.dest, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package luna
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect selects the SQL dialect that statements are written in. Statements in
+// another dialect than the server's are translated before they're sent.
+type Dialect int
+
+const (
+	// DialectLuna sends statements unchanged. This is the default.
+	DialectLuna Dialect = iota
+	// DialectPostgres translates the Postgres syntax the server doesn't accept,
+	// to ease moving code written for Postgres: the JSONB type becomes JSON,
+	// ::regclass casts are dropped (e.g. in nextval('seq'::regclass)), and
+	// clock_timestamp() and statement_timestamp() become now(), the start time
+	// of the statement's transaction. ILIKE, :: casts and now() need no
+	// translation.
+	DialectPostgres
+)
+
+// dialects maps the values of the dialect DSN parameter to dialects.
+var dialects = map[string]Dialect{
+	"luna":     DialectLuna,
+	"postgres": DialectPostgres,
+}
+
+func parseDialectParam(v string, dst *Dialect) error {
+	dialect, ok := dialects[v]
+	if !ok {
+		return fmt.Errorf("must be luna or postgres")
+	}
+	*dst = dialect
+	return nil
+}
+
+// Postgres functions translated by DialectPostgres, with their replacements.
+var postgresFunctions = map[string]string{
+	"CLOCK_TIMESTAMP":     "now",
+	"STATEMENT_TIMESTAMP": "now",
+}
+
+// translate rewrites query from the dialect to the server's. Quoted strings and
+// identifiers and comments are left alone.
+func (d Dialect) translate(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, w := range scanSQLWords(query) {
+		// Qualified names, e.g. a column named jsonb, aren't translated
+		if w.start > 0 && query[w.start-1] == '.' {
+			continue
+		}
+
+		switch {
+		case w.text == "JSONB":
+			b.WriteString(query[last:w.start])
+			b.WriteString("JSON")
+			last = w.end
+		case w.text == "REGCLASS":
+			cast := strings.TrimRight(query[:w.start], " \t\r\n")
+			if !strings.HasSuffix(cast, "::") || len(cast)-2 < last {
+				continue
+			}
+			b.WriteString(query[last : len(cast)-2])
+			last = w.end
+		case postgresFunctions[w.text] != "":
+			if !strings.HasPrefix(strings.TrimLeft(query[w.end:], " \t\r\n"), "(") {
+				continue
+			}
+			b.WriteString(query[last:w.start])
+			b.WriteString(postgresFunctions[w.text])
+			last = w.end
+		}
+	}
+	if last == 0 {
+		return query
+	}
+
+	b.WriteString(query[last:])
+	return b.String()
+}
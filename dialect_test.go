@@ -0,0 +1,63 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+)
+
+func TestDialectTranslate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		dialect  Dialect
+		query    string
+		expected string
+	}{
+		{"luna unchanged", DialectLuna, "SELECT '{}'::jsonb", "SELECT '{}'::jsonb"},
+		{"jsonb cast", DialectPostgres, "SELECT '{}'::jsonb AS j", "SELECT '{}'::JSON AS j"},
+		{"jsonb column type", DialectPostgres, "CREATE TABLE t (doc JSONB NOT NULL)", "CREATE TABLE t (doc JSON NOT NULL)"},
+		{"regclass cast", DialectPostgres, "SELECT nextval('seq'::regclass)", "SELECT nextval('seq')"},
+		{"regclass with spaces", DialectPostgres, "SELECT nextval('seq' :: regclass)", "SELECT nextval('seq' )"},
+		{"clock timestamp", DialectPostgres, "SELECT clock_timestamp(), statement_timestamp ()", "SELECT now(), now ()"},
+		{"native syntax", DialectPostgres, "SELECT now()::date FROM t WHERE name ILIKE 'a%'", "SELECT now()::date FROM t WHERE name ILIKE 'a%'"},
+		{"quoted", DialectPostgres, `SELECT 'x::jsonb', "jsonb" FROM t`, `SELECT 'x::jsonb', "jsonb" FROM t`},
+		{"qualified", DialectPostgres, "SELECT t.jsonb FROM t", "SELECT t.jsonb FROM t"},
+		{"comment", DialectPostgres, "SELECT 1 -- jsonb\n", "SELECT 1 -- jsonb\n"},
+		{"column named like a function", DialectPostgres, "SELECT clock_timestamp FROM t", "SELECT clock_timestamp FROM t"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.dialect.translate(tc.query); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDialectFromDSN(t *testing.T) {
+	commands := make(chan string, 1)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			conn.Write([]byte("+OK\r\n"))
+		}
+	})
+
+	conn := connectFake(t, addr+"?dialect=postgres")
+	if _, err := conn.ExecContext(context.Background(), "ALTER TABLE t ADD COLUMN doc jsonb", nil); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if cmd := <-commands; cmd != "x:ALTER TABLE t ADD COLUMN doc JSON" {
+		t.Errorf("expected the translated statement, got %q", cmd)
+	}
+
+	if _, err := NewConnector(addr+"?dialect=mysql", nil); err == nil {
+		t.Error("expected an error for an unknown dialect")
+	}
+}
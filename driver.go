@@ -3,22 +3,72 @@ package luna
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
 	"fmt"
-	"log/slog"
+	"io"
 	"net"
-	"net/url"
-	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
 )
 
 func init() {
 	sql.Register("luna", Driver{})
 }
 
+// Compile-time checks that the exported types keep implementing the
+// database/sql/driver interfaces they advertise.
+var (
+	_ driver.Driver        = Driver{}
+	_ driver.DriverContext = Driver{}
+
+	_ driver.Connector = (*Connector)(nil)
+	_ io.Closer        = (*Connector)(nil)
+
+	_ driver.Conn            = (*Conn)(nil)
+	_ driver.ConnBeginTx     = (*Conn)(nil)
+	_ driver.ExecerContext   = (*Conn)(nil)
+	_ driver.QueryerContext  = (*Conn)(nil)
+	_ driver.Pinger          = (*Conn)(nil)
+	_ driver.Validator       = (*Conn)(nil)
+	_ driver.SessionResetter = (*Conn)(nil)
+
+	_ driver.Stmt             = (*Stmt)(nil)
+	_ driver.StmtExecContext  = (*Stmt)(nil)
+	_ driver.StmtQueryContext = (*Stmt)(nil)
+
+	_ driver.Rows                         = (*Rows)(nil)
+	_ driver.RowsColumnTypeScanType       = (*Rows)(nil)
+	_ driver.RowsColumnTypeNullable       = (*Rows)(nil)
+	_ driver.RowsColumnTypePrecisionScale = (*Rows)(nil)
+	_ driver.RowsNextResultSet            = (*Rows)(nil)
+
+	_ driver.Tx     = (*tx)(nil)
+	_ driver.Result = (*result)(nil)
+)
+
+// Driver is the luna driver registered with database/sql. The connectors it
+// opens for sql.Open start from the defaults set with Configure.
 type Driver struct{}
 
+// defaultConfig holds the defaults set with Configure, nil if there are none.
+var defaultConfig atomic.Pointer[Config]
+
+// Configure sets defaults for the connectors the registered driver opens, e.g.
+// for sql.Open("luna", dsn), so that code that can't build a connector, such as
+// a framework that only takes a DSN, still gets a logger, limits or TLS settings.
+// Settings in the DSN take precedence over the defaults; Addr is ignored, and
+// nil or zero fields keep the driver's defaults. Each sql.DB takes a snapshot of
+// the defaults when it's opened, so later calls don't change the sql.DBs opened
+// before. Connectors created with NewConnector and NewConnectorWithOptions don't
+// use the defaults. Configure(Config{}) clears them. It's safe for concurrent use.
+func Configure(cfg Config) {
+	cfg = cfg.clone()
+	defaultConfig.Store(&cfg)
+}
+
 // Implements the driver.Driver interface.
 func (d Driver) Open(dsn string) (driver.Conn, error) {
 	c, err := d.OpenConnector(dsn)
@@ -31,67 +81,228 @@ func (d Driver) Open(dsn string) (driver.Conn, error) {
 
 // Implements the driver.DriverContext interface.
 func (Driver) OpenConnector(dsn string) (driver.Connector, error) {
-	return NewConnector(dsn, func(execerContext driver.ExecerContext) error {
-		return nil
-	})
+	cfg, err := parseDSN(dsn, defaultConfig.Load())
+	if err != nil {
+		return nil, err
+	}
+	return newConnector(cfg), nil
 }
 
 type Connector struct {
-	u *url.URL
+	cfg Config
 	// Callback to perform additional initialization steps.
 	connInitFn func(execer driver.ExecerContext) error
 	// True, if the connector has been closed, else false.
 	closed bool
+	// Time source for timeouts, replaced with WithClock.
+	clock Clock
+	// Guards the fields of cfg that UpdateConfig changes.
+	cfgMu sync.Mutex
+	// Settings that UpdateConfig can change, including the rate limiter shared
+	// by the connections, created on the first Connect.
+	live atomic.Pointer[liveConfig]
+	// Log of recent protocol events of all connections, for support bundles.
+	events *eventLog
+	// Statistics of the tables referred to by the connections' statements.
+	tables *tableStats
+	// Expected result schemas of the queries run so far, nil unless schema drift
+	// is checked.
+	schemas *schemaTracker
+	// Number of connections opened so far, used to tell them apart in the event log.
+	connSeq atomic.Int64
+	// True if the last connection attempt failed to reach the server.
+	hostMu   sync.Mutex
+	hostDown bool
 }
 
 // Implements the driver.Connector interface.
 func (*Connector) Driver() driver.Driver { return Driver{} }
 
+// Config returns a copy of the connector's configuration.
+func (c *Connector) Config() Config {
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	return c.cfg
+}
+
 // Implements the driver.Connector interface.
 func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
-	slog.Info("connecting", "host", c.u.Host)
-	var nc net.Conn
-	var err error
-	deadline, ok := ctx.Deadline()
-	if ok {
-		nc, err = net.DialTimeout("tcp", c.u.Host, time.Until(deadline))
-		if err != nil {
-			return nil, err
+	id := c.connSeq.Add(1)
+	start := c.clock.Now()
+	conn, err := c.connect(ctx, id)
+	c.events.add(protocolEvent{
+		Time:     start,
+		Conn:     id,
+		Kind:     "connect",
+		Duration: c.clock.Now().Sub(start),
+		Error:    errorString(err),
+	})
+	c.hostReached(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// HealthCheck opens a new connection, authenticates and pings the server, then
+// closes the connection, e.g. for readiness probes that shouldn't use or wait for
+// the connections of a pool. Credential problems are reported as errors matching
+// ErrAuthFailed, and network failures as a *net.OpError when dialing or as
+// ErrConnClosed afterwards, so that probes can tell them apart.
+func (c *Connector) HealthCheck(ctx context.Context) error {
+	dc, err := c.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+
+	return dc.(*Conn).Ping(ctx)
+}
+
+// dial opens a connection to the server, with the TLS handshake done if enabled.
+func (c *Connector) dial(ctx context.Context) (net.Conn, error) {
+	if c.cfg.DialFunc == nil {
+		netDialer := &net.Dialer{
+			Timeout:        c.cfg.ConnectTimeout,
+			KeepAlive:      c.cfg.KeepAlive,
+			ControlContext: c.socketControl(),
+		}
+		var nc net.Conn
+		var err error
+		if c.cfg.TLSConfig != nil {
+			// The handshake is done as part of dialing, bounded by ctx
+			dialer := &tls.Dialer{NetDialer: netDialer, Config: c.cfg.TLSConfig}
+			nc, err = dialer.DialContext(ctx, "tcp", c.cfg.Addr)
+		} else {
+			nc, err = netDialer.DialContext(ctx, "tcp", c.cfg.Addr)
 		}
-	} else {
-		nc, err = net.Dial("tcp", c.u.Host)
 		if err != nil {
 			return nil, err
 		}
+		if err := c.tuneSocket(nc, true); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("luna: failed to set socket options: %w", err)
+		}
+		return nc, nil
+	}
+
+	if c.cfg.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.ConnectTimeout)
+		defer cancel()
+	}
+	nc, err := c.cfg.DialFunc(ctx, "tcp", c.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.tuneSocket(nc, false); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("luna: failed to set socket options: %w", err)
+	}
+	if c.cfg.TLSConfig == nil {
+		return nc, nil
+	}
+
+	// Like tls.Dialer, verify the host of the address unless a name is set
+	config := c.cfg.TLSConfig
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(c.cfg.Addr)
+	}
+	tc := tls.Client(nc, config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// connect dials the server and sets up a new connection.
+func (c *Connector) connect(ctx context.Context, id int64) (*Conn, error) {
+	c.liveConfig().logger.Debug("connecting", "host", c.cfg.Addr, "tls", c.cfg.TLSConfig != nil)
+	nc, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := &countingReader{r: nc}
+	reader := bufio.NewReader(counter)
+	if c.cfg.ReadBufferSize > 0 {
+		reader = bufio.NewReaderSize(counter, c.cfg.ReadBufferSize)
 	}
 
 	conn := &Conn{
-		conn:   nc,
-		reader: bufio.NewReader(nc),
+		conn:       nc,
+		reader:     reader,
+		mem:        c.cfg.Allocator,
+		clock:      c.clock,
+		counter:    counter,
+		events:     c.events,
+		emit:       c.emit,
+		liveConfig: c.liveConfig,
+		id:         id,
+
+		noticeHandler:    c.cfg.NoticeHandler,
+		resultTrailers:   c.cfg.ResultTrailers,
+		hooks:            c.cfg.QueryHooks,
+		idlePingInterval: c.cfg.IdlePingInterval,
+		errorResults:     c.cfg.ErrorResults,
+		maxResultRows:    c.cfg.MaxResultRows,
+		maxResultBytes:   c.cfg.MaxResultBytes,
+		logQueries:       c.cfg.LogQueries,
+		txMode:           c.cfg.TxMode,
+		dialect:          c.cfg.Dialect,
+		fileListers:      c.cfg.FileListers,
+		valueOptions: valueOptions{
+			nestedMode:  c.cfg.NestedMode,
+			decimalMode: c.cfg.DecimalMode,
+			uuidMode:    c.cfg.UUIDMode,
+			utf8Mode:    c.cfg.UTF8Mode,
+			location:    c.cfg.TimeZone,
+		},
 	}
 
+	// The logger, timeouts, rate limiter and slow query settings can change with
+	// UpdateConfig
+	conn.applyLiveConfig()
+	if c.cfg.TableStats {
+		conn.tables = c.tables
+	}
+	conn.schemas = c.schemas
+
 	// Perform authentication if password is provided
 	// Note: Luna server doesn't send anything on connection
 	// It only sends auth challenge if server has password configured
-	password := ""
-	if c.u.User != nil {
-		password, _ = c.u.User.Password()
-	}
-	if password != "" {
-		if err := authenticate(nc, password); err != nil {
+	if c.cfg.Password != "" {
+		if err := authenticate(nc, c.cfg.Password); err != nil {
 			nc.Close()
-			return nil, fmt.Errorf("authentication failed: %w", err)
+			if errors.Is(err, ErrAuthFailed) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("luna: authentication exchange interrupted: %w", err)
 		}
 	}
 	// If no password, Luna just waits for commands - no handshake needed
 
+	if c.cfg.TimeZone != nil {
+		if _, err := conn.ExecContext(ctx, setTimeZoneStatement(c.cfg.TimeZone), nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("luna: can't set the time zone: %w", err)
+		}
+	}
+
 	if c.connInitFn != nil {
 		if err := c.connInitFn(conn); err != nil {
-			nc.Close()
+			conn.Close()
 			return nil, err
 		}
 	}
 
+	conn.mu.Lock()
+	conn.armIdleProbe()
+	conn.mu.Unlock()
+
 	return conn, nil
 }
 
@@ -107,19 +318,26 @@ func (c *Connector) Close() error {
 // The user must close the Connector, if it is not passed to the sql.OpenDB function.
 // Otherwise, sql.DB closes the Connector when calling sql.DB.Close().
 func NewConnector(dsn string, connInitFn func(execer driver.ExecerContext) error) (*Connector, error) {
-	// Ensure DSN has a scheme
-	fdsn := dsn
-	if !strings.Contains(fdsn, "://") {
-		fdsn = "luna://" + fdsn
-	}
+	return NewConnectorWithOptions(dsn, WithConnInitFn(connInitFn))
+}
 
-	parsedDSN, err := url.Parse(fdsn)
+// NewConnectorWithOptions creates a Connector from a DSN and options, which take
+// precedence over the equivalent DSN settings. As with NewConnector, the user must
+// close the Connector if it is not passed to the sql.OpenDB function.
+func NewConnectorWithOptions(dsn string, opts ...Option) (*Connector, error) {
+	cfg, err := ParseDSN(dsn)
 	if err != nil {
 		return nil, err
 	}
+	return newConnector(cfg, opts...), nil
+}
 
-	return &Connector{
-		u:          parsedDSN,
-		connInitFn: connInitFn,
-	}, nil
+// newConnector creates a Connector from a parsed configuration and options.
+func newConnector(cfg *Config, opts ...Option) *Connector {
+	c := &Connector{cfg: *cfg, clock: realClock{}, events: newEventLog(eventLogSize), tables: newTableStats()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.schemas = newSchemaTracker(c.cfg.SchemaDrift)
+	return c
 }
@@ -0,0 +1,340 @@
+package luna
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// ErrServerMaintenance is reported when the server rejects a command because it's
+// draining connections or in maintenance mode. The command wasn't executed, so it
+// can safely be retried on another connection. Errors matching it also match
+// driver.ErrBadConn, so database/sql discards the connection and retries.
+var ErrServerMaintenance = errors.New("luna: server is in maintenance mode")
+
+// Error reply prefixes the server uses while draining or in maintenance mode.
+var maintenancePrefixes = []string{"DRAINING", "MAINTENANCE"}
+
+type maintenanceError struct {
+	msg string
+}
+
+func (e *maintenanceError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrServerMaintenance, e.msg)
+}
+
+func (e *maintenanceError) Is(target error) bool {
+	return target == ErrServerMaintenance || target == driver.ErrBadConn
+}
+
+// isMaintenanceReply reports whether an error reply signals server maintenance.
+func isMaintenanceReply(msg string) bool {
+	word, _, _ := strings.Cut(strings.TrimSpace(msg), " ")
+	for _, prefix := range maintenancePrefixes {
+		if strings.EqualFold(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrServerThrottled is reported when the server rejects a command because the
+// client is sending too many requests. The command wasn't executed. The error is
+// a *ThrottleError, whose RetryAfter field carries the server's hint of how long
+// to back off, if any.
+var ErrServerThrottled = errors.New("luna: server is throttling requests")
+
+// Error reply prefixes the server uses when throttling a client.
+var throttlePrefixes = []string{"THROTTLED", "BUSY"}
+
+// ThrottleError is returned when the server rejects a command because of rate
+// limiting. It matches ErrServerThrottled, but not driver.ErrBadConn: retrying
+// right away on another connection would only add to the load.
+type ThrottleError struct {
+	// Msg is the error reply from the server.
+	Msg string
+	// RetryAfter is how long the server asked the client to wait before retrying,
+	// or zero if the reply had no retry-after hint.
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrServerThrottled, e.Msg)
+}
+
+func (e *ThrottleError) Is(target error) bool {
+	return target == ErrServerThrottled
+}
+
+// isThrottleReply reports whether an error reply signals throttling.
+func isThrottleReply(msg string) bool {
+	word, _, _ := strings.Cut(strings.TrimSpace(msg), " ")
+	for _, prefix := range throttlePrefixes {
+		if strings.EqualFold(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter extracts the retry-after hint from a throttling reply, e.g.
+// "THROTTLED too many queries, retry-after=1.5s". The value is either a Go
+// duration or a number of seconds. It returns zero if there's no valid hint.
+func parseRetryAfter(msg string) time.Duration {
+	i := strings.Index(strings.ToLower(msg), "retry-after")
+	if i < 0 {
+		return 0
+	}
+
+	value := strings.TrimLeft(msg[i+len("retry-after"):], " =:")
+	value, _, _ = strings.Cut(value, " ")
+	value = strings.TrimRight(value, ",;.")
+
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return 0
+}
+
+// replyError converts an error reply from the server to query into a Go error.
+func (c *Conn) replyError(query string, data []byte) error {
+	msg := string(data)
+	if isMaintenanceReply(msg) {
+		// The server is going away, don't reuse the connection
+		c.bad = true
+		return &maintenanceError{msg: msg}
+	}
+
+	if isThrottleReply(msg) {
+		return &ThrottleError{Msg: msg, RetryAfter: parseRetryAfter(msg)}
+	}
+
+	err := parseServerError(msg)
+	err.Query = query
+	return err
+}
+
+var (
+	// ErrSyntax matches server errors about SQL that can't be parsed.
+	ErrSyntax = errors.New("luna: syntax error")
+	// ErrPermission matches server errors about statements the user isn't allowed
+	// to run.
+	ErrPermission = errors.New("luna: permission denied")
+	// ErrTimeout matches queries that ran past the query timeout or the deadline
+	// of their context.
+	ErrTimeout = errors.New("luna: query timed out")
+	// ErrConnClosed matches failures caused by a closed or broken connection.
+	ErrConnClosed = errors.New("luna: connection closed")
+	// ErrAuthFailed matches failures to authenticate: the server rejected the
+	// credentials, or asked for a password when none is configured. Network
+	// failures during the exchange don't match it.
+	ErrAuthFailed = errors.New("luna: authentication failed")
+)
+
+// Error is an error reported by the server for a statement. Match its class
+// with errors.Is and ErrSyntax or ErrPermission, or get its details with
+// errors.As:
+//
+//	var lerr *luna.Error
+//	if errors.As(err, &lerr) && lerr.Code == "Catalog" {
+//		log.Printf("%s (%s)", lerr.Message, lerr.Hint)
+//	}
+type Error struct {
+	// Code is the class of the error, from the "<Code> Error:" prefix of the
+	// server's message, e.g. "Parser", "Binder" or "Catalog". It's empty if the
+	// message has no such prefix.
+	Code string
+	// Message is the server's message, without the class prefix.
+	Message string
+	// Query is the statement that failed. It's left out of Error(), so that
+	// logging the error doesn't log the data in the statement.
+	Query string
+	// Hint is the server's suggestion to fix the statement, e.g. `Did you mean
+	// "users"?`, if the message has one.
+	Hint string
+	// Err is the underlying cause, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return "luna error: " + e.Message
+	}
+	return "luna error: " + e.Code + " Error: " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrSyntax:
+		return e.Code == "Parser" || e.Code == "Syntax"
+	case ErrPermission:
+		return e.Code == "Permission"
+	default:
+		return false
+	}
+}
+
+// Marker of the server's hints in error messages.
+var hintPrefixes = []string{"Did you mean", "HINT:"}
+
+// parseServerError splits a server error message such as `Catalog Error: Table
+// with name usr does not exist! Did you mean "users"?` into an Error.
+func parseServerError(msg string) *Error {
+	msg = strings.TrimSpace(msg)
+	e := &Error{Message: msg}
+	if prefix, rest, ok := strings.Cut(msg, ": "); ok {
+		if code, ok := strings.CutSuffix(prefix, " Error"); ok && code != "" && !strings.ContainsAny(code, ":\n") {
+			e.Code, e.Message = code, rest
+		}
+	}
+
+	for _, prefix := range hintPrefixes {
+		i := strings.Index(e.Message, prefix)
+		if i < 0 {
+			continue
+		}
+		hint := e.Message[i:]
+		if j := strings.IndexAny(hint, "\n"); j >= 0 {
+			hint = hint[:j]
+		}
+		if prefix == "Did you mean" {
+			if j := strings.Index(hint, "?"); j >= 0 {
+				hint = hint[:j+1]
+			}
+		}
+		e.Hint = strings.TrimSpace(strings.TrimPrefix(hint, "HINT:"))
+		break
+	}
+	return e
+}
+
+// isErrorResult reports whether a result has the schema the server uses to
+// report a failed statement as rows: an "input" column with the statement and an
+// "error" column with the message.
+func isErrorResult(schema *arrow.Schema) bool {
+	return schema.NumFields() == 2 && schema.Field(0).Name == "input" && schema.Field(1).Name == "error"
+}
+
+// resultError converts a row of an error result into an Error.
+func resultError(input, msg string) *Error {
+	err := parseServerError(msg)
+	err.Query = input
+	return err
+}
+
+// errorFromResult returns the error reported by the result of query, if it's an
+// error result with at least one row, or nil.
+func errorFromResult(query string, schema *arrow.Schema, records []arrow.Record) error {
+	if schema == nil || !isErrorResult(schema) {
+		return nil
+	}
+
+	for _, rec := range records {
+		if rec.NumRows() == 0 {
+			continue
+		}
+		input, msg := rec.Column(0), rec.Column(1)
+		if msg.IsNull(0) {
+			return nil
+		}
+		err := resultError(input.ValueStr(0), msg.ValueStr(0))
+		if input.IsNull(0) || err.Query == "" {
+			err.Query = query
+		}
+		return err
+	}
+	return nil
+}
+
+// partialResultError is returned along with the records a query sent before it
+// failed partway, so that callers can deliver them before err.
+type partialResultError struct {
+	err error
+}
+
+func (e *partialResultError) Error() string { return e.err.Error() }
+
+func (e *partialResultError) Unwrap() error { return e.err }
+
+// connClosedError marks a failure caused by a closed or broken connection, so
+// that it matches ErrConnClosed, keeping the message of its cause.
+type connClosedError struct {
+	err error
+}
+
+func (e *connClosedError) Error() string { return e.err.Error() }
+
+func (e *connClosedError) Unwrap() error { return e.err }
+
+func (e *connClosedError) Is(target error) bool { return target == ErrConnClosed }
+
+// errBadConn is returned by operations on a closed or broken connection. It
+// matches driver.ErrBadConn, so that database/sql retries on another connection.
+var errBadConn error = &connClosedError{err: driver.ErrBadConn}
+
+// timeoutError marks a failure caused by a context deadline, so that it matches
+// ErrTimeout, keeping the message of its cause.
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string { return e.err.Error() }
+
+func (e *timeoutError) Unwrap() error { return e.err }
+
+func (e *timeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// readError converts a failure to read or parse a response into a Go error. The
+// rest of the response may still be in flight or buffered, so the connection's
+// position in the stream is unknown: it's marked bad rather than risk handing
+// the leftover bytes to the next command as its response.
+func (c *Conn) readError(msg string, err error) error {
+	c.bad = true
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// isNetworkError reports whether err means the connection to the server is broken.
+func isNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch {
+	case errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE):
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// sendError converts a failure to send a command into a Go error. The server
+// didn't receive the whole command, so network failures are reported as
+// driver.ErrBadConn, letting database/sql retry on a new connection.
+func (c *Conn) sendError(err error) error {
+	if isNetworkError(err) {
+		c.bad = true
+		return &connClosedError{err: fmt.Errorf("failed to send command: %w: %w", err, driver.ErrBadConn)}
+	}
+	return fmt.Errorf("failed to send command: %w", err)
+}
@@ -0,0 +1,433 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestMaintenanceReply(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		if _, err := readCommand(reader); err != nil {
+			return
+		}
+		conn.Write([]byte("-DRAINING server is shutting down\r\n"))
+	})
+
+	conn := connectFake(t, addr)
+
+	_, err := conn.ExecContext(context.Background(), "CREATE TABLE t (id INT)", nil)
+	if !errors.Is(err, ErrServerMaintenance) {
+		t.Fatalf("expected ErrServerMaintenance, got %v", err)
+	}
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected maintenance errors to match driver.ErrBadConn")
+	}
+	if !conn.bad {
+		t.Error("expected connection to be marked bad")
+	}
+}
+
+func TestMaintenanceReplyRetriedByPool(t *testing.T) {
+	// The first connection is drained, later ones are served normally
+	var accepted atomic.Int32
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		draining := accepted.Add(1) == 1
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			if draining {
+				conn.Write([]byte("-MAINTENANCE try again later\r\n"))
+				continue
+			}
+
+			rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	var n int64
+	if err := db.QueryRow("SELECT 0 AS n").Scan(&n); err != nil {
+		t.Fatalf("expected query to be retried on a new connection, got %v", err)
+	}
+	if accepted.Load() < 2 {
+		t.Errorf("expected a second connection, got %d", accepted.Load())
+	}
+}
+
+func TestIsMaintenanceReply(t *testing.T) {
+	testCases := []struct {
+		msg      string
+		expected bool
+	}{
+		{"DRAINING server is shutting down", true},
+		{"maintenance", true},
+		{"ERR syntax error", false},
+		{"Parser Error: MAINTENANCE is not a table", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		if got := isMaintenanceReply(tc.msg); got != tc.expected {
+			t.Errorf("isMaintenanceReply(%q): expected %v, got %v", tc.msg, tc.expected, got)
+		}
+	}
+}
+
+func TestThrottleReply(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write([]byte("-THROTTLED too many queries, retry-after=250ms\r\n"))
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	_, err := conn.QueryContext(context.Background(), "SELECT 1", nil)
+	if !errors.Is(err, ErrServerThrottled) {
+		t.Fatalf("expected ErrServerThrottled, got %v", err)
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		t.Error("expected throttling errors not to match driver.ErrBadConn")
+	}
+
+	var throttleErr *ThrottleError
+	if !errors.As(err, &throttleErr) {
+		t.Fatalf("expected a *ThrottleError, got %T", err)
+	}
+	if throttleErr.RetryAfter != 250*time.Millisecond {
+		t.Errorf("expected RetryAfter 250ms, got %v", throttleErr.RetryAfter)
+	}
+	if !conn.IsValid() {
+		t.Error("expected connection to stay valid")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	testCases := []struct {
+		msg      string
+		expected time.Duration
+	}{
+		{"THROTTLED retry-after=1.5s", 1500 * time.Millisecond},
+		{"THROTTLED too many queries, retry-after: 2s, please slow down", 2 * time.Second},
+		{"BUSY Retry-After 3", 3 * time.Second},
+		{"THROTTLED retry-after=2.", 2 * time.Second},
+		{"THROTTLED retry-after=-1s", 0},
+		{"THROTTLED retry-after=soon", 0},
+		{"THROTTLED too many queries", 0},
+	}
+
+	for _, tc := range testCases {
+		if got := parseRetryAfter(tc.msg); got != tc.expected {
+			t.Errorf("parseRetryAfter(%q): expected %v, got %v", tc.msg, tc.expected, got)
+		}
+	}
+}
+
+func TestSendErrorIsBadConn(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		readCommand(reader)
+	})
+
+	conn := connectFake(t, addr)
+
+	// Break the connection underneath the driver
+	conn.conn.Close()
+
+	_, err := conn.ExecContext(context.Background(), "CREATE TABLE t (id INT)", nil)
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("expected driver.ErrBadConn, got %v", err)
+	}
+	if !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected the cause to be kept, got %v", err)
+	}
+	if !errors.Is(err, ErrConnClosed) {
+		t.Errorf("expected ErrConnClosed, got %v", err)
+	}
+	if conn.IsValid() {
+		t.Error("expected connection to be invalid")
+	}
+	if err := conn.ResetSession(context.Background()); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("expected ResetSession to return driver.ErrBadConn, got %v", err)
+	}
+	if _, err := conn.QueryContext(context.Background(), "SELECT 1", nil); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("expected ErrConnClosed on a broken connection, got %v", err)
+	}
+}
+
+func TestReadErrorDiscardsConn(t *testing.T) {
+	// The first connection dies while a command is in flight
+	var accepted atomic.Int32
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		dying := accepted.Add(1) == 1
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			if dying {
+				return
+			}
+			conn.Write([]byte("+OK\r\n"))
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// The server may have executed the command, so it must not be retried
+	_, err = db.Exec("INSERT INTO t VALUES (1)")
+	if err == nil || errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("expected a network error other than driver.ErrBadConn, got %v", err)
+	}
+
+	// The broken connection is discarded and the pool re-dials
+	if _, err := db.Exec("INSERT INTO t VALUES (2)"); err != nil {
+		t.Fatalf("expected exec to succeed on a new connection, got %v", err)
+	}
+	if accepted.Load() != 2 {
+		t.Errorf("expected 2 connections, got %d", accepted.Load())
+	}
+}
+
+func TestIsNetworkError(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{io.EOF, true},
+		{fmt.Errorf("failed to read response: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{syscall.EPIPE, true},
+		{errors.New("luna error: syntax error"), false},
+	}
+
+	for _, tc := range testCases {
+		if got := isNetworkError(tc.err); got != tc.expected {
+			t.Errorf("isNetworkError(%v): expected %v, got %v", tc.err, tc.expected, got)
+		}
+	}
+}
+
+func TestParseServerError(t *testing.T) {
+	testCases := []struct {
+		msg      string
+		expected Error
+	}{
+		{
+			msg:      `Parser Error: syntax error at or near "SELEC"`,
+			expected: Error{Code: "Parser", Message: `syntax error at or near "SELEC"`},
+		},
+		{
+			msg: `Catalog Error: Table with name usr does not exist!` + "\n" + `Did you mean "users"?` + "\n\nLINE 1: SELECT * FROM usr",
+			expected: Error{
+				Code:    "Catalog",
+				Message: `Table with name usr does not exist!` + "\n" + `Did you mean "users"?` + "\n\nLINE 1: SELECT * FROM usr",
+				Hint:    `Did you mean "users"?`,
+			},
+		},
+		{
+			msg:      "Binder Error: Referenced column \"x\" not found. HINT: check the column names",
+			expected: Error{Code: "Binder", Message: "Referenced column \"x\" not found. HINT: check the column names", Hint: "check the column names"},
+		},
+		{
+			msg:      "something went wrong: disk full",
+			expected: Error{Message: "something went wrong: disk full"},
+		},
+		{
+			msg:      "  out of memory\r\n",
+			expected: Error{Message: "out of memory"},
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := parseServerError(tc.msg); *got != tc.expected {
+			t.Errorf("parseServerError(%q): expected %+v, got %+v", tc.msg, tc.expected, *got)
+		}
+	}
+}
+
+func TestServerErrorReply(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			switch cmd {
+			case "q:SELEC 1":
+				conn.Write([]byte("-Parser Error: syntax error at or near \"SELEC\"\r\n"))
+			case "x:DROP TABLE t":
+				conn.Write([]byte("-Permission Error: cannot drop t\r\n"))
+			default:
+				conn.Write([]byte("-Catalog Error: Table with name t does not exist!\r\n"))
+			}
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	_, err := conn.QueryContext(context.Background(), "SELEC 1", nil)
+	var lerr *Error
+	if !errors.As(err, &lerr) {
+		t.Fatalf("expected a *luna.Error, got %T: %v", err, err)
+	}
+	if lerr.Code != "Parser" || lerr.Query != "SELEC 1" {
+		t.Errorf("expected a Parser error for SELEC 1, got %+v", *lerr)
+	}
+	if got := err.Error(); got != `luna error: Parser Error: syntax error at or near "SELEC"` {
+		t.Errorf("unexpected message: %s", got)
+	}
+	if !errors.Is(err, ErrSyntax) || errors.Is(err, ErrPermission) {
+		t.Errorf("expected ErrSyntax only, got %v", err)
+	}
+
+	_, err = conn.ExecContext(context.Background(), "DROP TABLE t", nil)
+	if !errors.Is(err, ErrPermission) || errors.Is(err, ErrSyntax) {
+		t.Errorf("expected ErrPermission only, got %v", err)
+	}
+
+	_, err = conn.QueryContext(context.Background(), "SELECT * FROM t", nil)
+	if !errors.As(err, &lerr) || lerr.Code != "Catalog" {
+		t.Fatalf("expected a Catalog error, got %v", err)
+	}
+	if errors.Is(err, ErrSyntax) || errors.Is(err, ErrPermission) || errors.Is(err, ErrConnClosed) {
+		t.Errorf("expected an error of no class, got %v", err)
+	}
+
+	// Statement errors leave the connection usable
+	if !conn.IsValid() {
+		t.Error("expected connection to stay valid")
+	}
+}
+
+func TestErrorResult(t *testing.T) {
+	testCases := []struct {
+		fields   []string
+		expected bool
+	}{
+		{[]string{"input", "error"}, true},
+		{[]string{"input", "message"}, false},
+		{[]string{"error", "input"}, false},
+		{[]string{"input", "error", "extra"}, false},
+	}
+
+	for _, tc := range testCases {
+		fields := make([]arrow.Field, len(tc.fields))
+		for i, name := range tc.fields {
+			fields[i] = arrow.Field{Name: name, Type: arrow.BinaryTypes.String}
+		}
+		if got := isErrorResult(arrow.NewSchema(fields, nil)); got != tc.expected {
+			t.Errorf("isErrorResult(%v): expected %v, got %v", tc.fields, tc.expected, got)
+		}
+	}
+
+	err := resultError("SELECT * FROM tmp", "Catalog Error: Table with name tmp does not exist!")
+	if err.Code != "Catalog" || err.Query != "SELECT * FROM tmp" {
+		t.Errorf("unexpected error: %+v", *err)
+	}
+}
+
+// errorResultReply encodes an error result with the input and error columns.
+func errorResultReply(t *testing.T, input, msg string) []byte {
+	t.Helper()
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+		{Name: "input", Type: arrow.BinaryTypes.String},
+		{Name: "error", Type: arrow.BinaryTypes.String},
+	}, nil))
+	defer b.Release()
+	b.Field(0).(*array.StringBuilder).Append(input)
+	b.Field(1).(*array.StringBuilder).Append(msg)
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	if err := writeArrowReply(&buf, rec.Schema(), rec); err != nil {
+		t.Fatalf("failed to encode reply: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestErrorResults(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			conn.Write(errorResultReply(t, cmd[2:], "Catalog Error: Table with name tmp does not exist!"))
+		}
+	})
+
+	testCases := []struct {
+		name    string
+		convert bool
+	}{
+		{"converted", true},
+		{"as rows", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			connector, err := NewConnectorWithOptions(addr, WithErrorResults(tc.convert))
+			if err != nil {
+				t.Fatalf("failed to create connector: %v", err)
+			}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+
+			var input, msg string
+			err = db.QueryRow("SELECT * FROM tmp").Scan(&input, &msg)
+			_, execErr := db.Exec("INSERT INTO tmp VALUES (1)")
+
+			if !tc.convert {
+				if err != nil || execErr != nil {
+					t.Fatalf("expected the error result as rows, got %v and %v", err, execErr)
+				}
+				if input != "SELECT * FROM tmp" {
+					t.Errorf("expected the input column, got %q", input)
+				}
+				return
+			}
+
+			for query, err := range map[string]error{"SELECT * FROM tmp": err, "INSERT INTO tmp VALUES (1)": execErr} {
+				var lerr *Error
+				if !errors.As(err, &lerr) {
+					t.Fatalf("%s: expected a *luna.Error, got %v", query, err)
+				}
+				if lerr.Code != "Catalog" || lerr.Query != query {
+					t.Errorf("%s: unexpected error %+v", query, *lerr)
+				}
+			}
+		})
+	}
+}
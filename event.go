@@ -0,0 +1,88 @@
+package luna
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// EventHeartbeatFailed is an idle connection that failed its ping (see
+	// WithIdlePingInterval) and was discarded.
+	EventHeartbeatFailed EventKind = iota + 1
+	// EventHostDown is a failure to reach the server while opening a connection,
+	// after the previous connection attempt reached it. Further failures aren't
+	// reported until the server is back.
+	EventHostDown
+	// EventHostRecovered is a connection that reached the server after
+	// EventHostDown.
+	EventHostRecovered
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventHeartbeatFailed:
+		return "HeartbeatFailed"
+	case EventHostDown:
+		return "HostDown"
+	case EventHostRecovered:
+		return "HostRecovered"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event is a failure, or a recovery, that the driver noticed outside of a call
+// that could return it, as passed to the handler set with WithEventHandler.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Addr is the address of the server.
+	Addr string
+	// Conn is the sequence number of the connection within its connector, as in
+	// support bundles, or 0 for events that aren't about a connection.
+	Conn int64
+	// Err is the error behind a failure, nil for recoveries.
+	Err error
+}
+
+// emit passes an event to the event handler, if there's one. Events are logged
+// either way by the components raising them.
+func (c *Connector) emit(e Event) {
+	if c.cfg.EventHandler == nil {
+		return
+	}
+	e.Time = c.clock.Now()
+	e.Addr = c.cfg.Addr
+	c.cfg.EventHandler(e)
+}
+
+// hostReached records the outcome of a connection attempt, and emits
+// EventHostDown or EventHostRecovered when the server became unreachable or
+// reachable again. Failures after the server was reached, e.g. a wrong
+// password, count as reaching it; cancelled attempts say nothing either way.
+func (c *Connector) hostReached(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	var opErr *net.OpError
+	down := errors.As(err, &opErr) && opErr.Op == "dial"
+
+	c.hostMu.Lock()
+	changed := c.hostDown != down
+	c.hostDown = down
+	c.hostMu.Unlock()
+
+	switch {
+	case changed && down:
+		c.liveConfig().logger.Warn("server unreachable", "host", c.cfg.Addr, "error", err)
+		c.emit(Event{Kind: EventHostDown, Err: err})
+	case changed:
+		c.liveConfig().logger.Info("server reachable again", "host", c.cfg.Addr)
+		c.emit(Event{Kind: EventHostRecovered})
+	}
+}
@@ -0,0 +1,107 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostEvents(t *testing.T) {
+	// Reserve a port with nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var mu sync.Mutex
+	var events []Event
+	connector, err := NewConnectorWithOptions(addr, WithEventHandler(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+
+	kinds := func() []EventKind {
+		mu.Lock()
+		defer mu.Unlock()
+		var kinds []EventKind
+		for _, e := range events {
+			kinds = append(kinds, e.Kind)
+			if e.Addr != addr {
+				t.Errorf("%v: expected address %s, got %s", e.Kind, addr, e.Addr)
+			}
+		}
+		return kinds
+	}
+
+	// Repeated failures are reported once
+	for range 2 {
+		if _, err := connector.Connect(context.Background()); err == nil {
+			t.Fatal("expected connecting to fail")
+		}
+	}
+	if got := kinds(); !reflect.DeepEqual(got, []EventKind{EventHostDown}) {
+		t.Fatalf("expected a HostDown event, got %v", got)
+	}
+	if events[0].Err == nil {
+		t.Error("expected the HostDown event to have the dial error")
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("can't listen on %s again: %v", addr, err)
+	}
+	defer ln.Close()
+
+	for range 2 {
+		dc, err := connector.Connect(context.Background())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		dc.Close()
+	}
+	if got := kinds(); !reflect.DeepEqual(got, []EventKind{EventHostDown, EventHostRecovered}) {
+		t.Errorf("expected HostDown then HostRecovered events, got %v", got)
+	}
+}
+
+func TestHeartbeatFailedEvent(t *testing.T) {
+	// The server drops connections without answering
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		readCommand(reader)
+	})
+
+	var events []Event
+	connector, err := NewConnectorWithOptions(addr, WithIdlePingInterval(time.Minute), WithEventHandler(func(e Event) {
+		events = append(events, e)
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+
+	// Probes run synchronously from Advance
+	clock.Advance(time.Minute)
+	if len(events) != 1 || events[0].Kind != EventHeartbeatFailed || events[0].Conn != 1 || events[0].Err == nil {
+		t.Fatalf("expected a HeartbeatFailed event for connection 1, got %+v", events)
+	}
+	if !events[0].Time.Equal(clock.Now()) {
+		t.Errorf("expected the event at %v, got %v", clock.Now(), events[0].Time)
+	}
+}
@@ -0,0 +1,100 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/csv"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// ExportFormat selects the file format ExportRecords writes: CSV or NDJSON.
+// There's no Parquet format.
+type ExportFormat int
+
+const (
+	// ExportCSV writes a header line with the column names, then a line per
+	// row, with NULL as an empty field.
+	ExportCSV ExportFormat = iota
+	// ExportNDJSON writes one JSON object per row and line, encoded like
+	// WriteJSON's.
+	ExportNDJSON
+)
+
+// ExportRecords writes the records of reader, e.g. a result returned by
+// QueryArrow, to w in the given format, encoding the Arrow columns directly
+// rather than converting each value to a driver.Value, for extract jobs:
+//
+//	reader, err := luna.QueryArrow(ctx, conn, "SELECT * FROM events")
+//	...
+//	defer reader.Release()
+//	err = luna.ExportRecords(ctx, f, reader, luna.ExportCSV)
+//
+// Writing stops when ctx is done. Parquet isn't supported, to keep the driver's
+// dependencies small; write the reader with pqarrow instead.
+func ExportRecords(ctx context.Context, w io.Writer, reader array.RecordReader, format ExportFormat) error {
+	switch format {
+	case ExportCSV:
+		return exportCSV(ctx, w, reader)
+	case ExportNDJSON:
+		return encodeJSON(ctx, &flushWriter{w: w}, reader, NDJSON)
+	}
+	return fmt.Errorf("luna: unknown export format %d", format)
+}
+
+func exportCSV(ctx context.Context, w io.Writer, reader array.RecordReader) error {
+	var cw *csv.Writer
+	err := catchCSVPanic(func() error {
+		cw = csv.NewWriter(w, reader.Schema(), csv.WithHeader(true), csv.WithNullWriter(""))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	written := false
+	for reader.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := catchCSVPanic(func() error { return cw.Write(reader.Record()) }); err != nil {
+			return err
+		}
+		written = true
+	}
+	if err := reader.Err(); err != nil {
+		return err
+	}
+
+	// The header is written with the first record, so an empty result gets an
+	// empty record
+	if !written {
+		b := array.NewRecordBuilder(memory.DefaultAllocator, reader.Schema())
+		rec := b.NewRecord()
+		b.Release()
+		err := catchCSVPanic(func() error { return cw.Write(rec) })
+		rec.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return cw.Flush()
+}
+
+// catchCSVPanic calls fn, a call to the CSV writer, which panics on column
+// types CSV has no text form for, e.g. structs and maps, and returns the panic
+// as an error.
+func catchCSVPanic(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("luna: can't export records as CSV: %v", r)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		return fmt.Errorf("luna: can't export records as CSV: %w", err)
+	}
+	return nil
+}
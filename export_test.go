@@ -0,0 +1,78 @@
+package luna
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestExportRecords(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	newReader := func(batches ...[]int64) *RecordReader {
+		var records []arrow.Record
+		for _, ids := range batches {
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+			for _, id := range ids {
+				b.Field(0).(*array.Int64Builder).Append(id)
+				if id%2 == 0 {
+					b.Field(1).AppendNull()
+				} else {
+					b.Field(1).(*array.StringBuilder).Append("a, b")
+				}
+			}
+			records = append(records, b.NewRecord())
+			b.Release()
+		}
+		return newRecordReader(schema, records)
+	}
+
+	testCases := []struct {
+		name     string
+		format   ExportFormat
+		batches  [][]int64
+		expected string
+	}{
+		{"csv", ExportCSV, [][]int64{{1, 2}, {3}}, "id,name\n1,\"a, b\"\n2,\n3,\"a, b\"\n"},
+		{"empty csv", ExportCSV, nil, "id,name\n"},
+		{"ndjson", ExportNDJSON, [][]int64{{1}, {2}}, "{\"id\":1,\"name\":\"a, b\"}\n{\"id\":2,\"name\":null}\n"},
+		{"empty ndjson", ExportNDJSON, nil, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := newReader(tc.batches...)
+			defer reader.Release()
+
+			var buf bytes.Buffer
+			if err := ExportRecords(context.Background(), &buf, reader, tc.format); err != nil {
+				t.Fatalf("ExportRecords failed: %v", err)
+			}
+			if buf.String() != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, buf.String())
+			}
+		})
+	}
+
+	reader := newReader([]int64{1})
+	defer reader.Release()
+	if err := ExportRecords(context.Background(), &bytes.Buffer{}, reader, ExportFormat(9)); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestExportRecordsUnsupportedCSV(t *testing.T) {
+	rec := newTestRecord(t, arrow.Field{Name: "s", Type: arrow.StructOf(arrow.Field{Name: "x", Type: arrow.PrimitiveTypes.Int64})})
+	reader := newRecordReader(rec.Schema(), []arrow.Record{rec})
+	defer reader.Release()
+
+	if err := ExportRecords(context.Background(), &bytes.Buffer{}, reader, ExportCSV); err == nil {
+		t.Error("expected an error for a struct column")
+	}
+}
@@ -0,0 +1,219 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// newFakeServer starts a minimal Luna server on a random local port and returns
+// its address. Every accepted connection is passed to handle, which typically
+// loops over readCommand and writes replies. The server stops when the test ends.
+func newFakeServer(t *testing.T, handle func(conn net.Conn, reader *bufio.Reader)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	return serveFake(t, ln, handle)
+}
+
+// newFakeTLSServer is like newFakeServer, but the server only accepts TLS connections.
+func newFakeTLSServer(t *testing.T, config *tls.Config, handle func(conn net.Conn, reader *bufio.Reader)) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	return serveFake(t, ln, handle)
+}
+
+// serveFake serves connections accepted by ln until the test ends.
+func serveFake(t *testing.T, ln net.Listener, handle func(conn net.Conn, reader *bufio.Reader)) string {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				handle(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// readCommand reads a single RESP bulk string command, e.g. "q:SELECT 1".
+func readCommand(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(line, "$") {
+		return "", fmt.Errorf("unexpected command frame: %q", line)
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, length+2)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", err
+	}
+
+	return string(data[:length]), nil
+}
+
+// statementCommands returns the commands of each statement of cmd, which the
+// server replies to in turn, up to the first that fails.
+func statementCommands(cmd string) []string {
+	prefix, query, ok := strings.Cut(cmd, ":")
+	statements := splitSQLStatements(query)
+	if !ok || len(statements) <= 1 {
+		return []string{cmd}
+	}
+
+	cmds := make([]string, len(statements))
+	for i, stmt := range statements {
+		cmds[i] = prefix + ":" + stmt
+	}
+	return cmds
+}
+
+// writeArrowReply writes records as an Arrow IPC stream, the way Luna replies to queries.
+func writeArrowReply(w io.Writer, schema *arrow.Schema, records ...arrow.Record) error {
+	writer := ipc.NewWriter(w, ipc.WithSchema(schema), ipc.WithAllocator(memory.NewGoAllocator()))
+	for _, rec := range records {
+		if err := writer.Write(rec); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// newValuesRecord builds a record of string and int64 columns from rows of
+// values.
+func newValuesRecord(t *testing.T, names []string, rows ...[]any) arrow.Record {
+	t.Helper()
+	fields := make([]arrow.Field, len(names))
+	for i, name := range names {
+		fields[i] = arrow.Field{Name: name, Type: arrow.BinaryTypes.String}
+		if _, ok := rows[0][i].(int64); ok {
+			fields[i].Type = arrow.PrimitiveTypes.Int64
+		}
+	}
+
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema(fields, nil))
+	defer b.Release()
+	for _, row := range rows {
+		for i, v := range row {
+			switch v := v.(type) {
+			case string:
+				b.Field(i).(*array.StringBuilder).Append(v)
+			case int64:
+				b.Field(i).(*array.Int64Builder).Append(v)
+			}
+		}
+	}
+	return b.NewRecord()
+}
+
+// fakeReply is an answer of a server started with newReplyServer.
+type fakeReply struct {
+	// match is a substring of the commands answered with reply.
+	match string
+	reply []byte
+}
+
+// newReplyServer starts a fake server that answers each statement of a command
+// with the reply of the first of replies whose match the statement contains, or
+// an error if none does. The commands are sent to the returned channel, which holds up to 100.
+func newReplyServer(t *testing.T, replies ...fakeReply) (addr string, commands chan string) {
+	commands = make(chan string, 100)
+	addr = newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+
+			for _, stmt := range statementCommands(cmd) {
+				reply := []byte("-ERR unexpected command\r\n")
+				for _, r := range replies {
+					if strings.Contains(stmt, r.match) {
+						reply = r.reply
+						break
+					}
+				}
+				conn.Write(reply)
+				if strings.HasPrefix(string(reply), "-") {
+					break
+				}
+			}
+		}
+	})
+	return addr, commands
+}
+
+// recordReply encodes the Arrow reply holding the rows of string and int64
+// values of columns names, as built by newValuesRecord.
+func recordReply(t *testing.T, names []string, rows ...[]any) []byte {
+	t.Helper()
+	rec := newValuesRecord(t, names, rows...)
+	defer rec.Release()
+	return schemaReply(t, rec.Schema(), rec)
+}
+
+// schemaReply encodes the Arrow reply holding records of schema, without rows
+// if there are no records.
+func schemaReply(t *testing.T, schema *arrow.Schema, records ...arrow.Record) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeArrowReply(&buf, schema, records...); err != nil {
+		t.Fatalf("failed to encode reply: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,215 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/compute"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/apache/arrow/go/v17/arrow/scalar"
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// FilterOp is the comparison of a client-side Filter.
+type FilterOp string
+
+const (
+	FilterEq FilterOp = "="
+	FilterNe FilterOp = "!="
+	FilterLt FilterOp = "<"
+	FilterLe FilterOp = "<="
+	FilterGt FilterOp = ">"
+	FilterGe FilterOp = ">="
+)
+
+// Arrow compute functions implementing the filter comparisons.
+var filterFunctions = map[FilterOp]string{
+	FilterEq: "equal",
+	FilterNe: "not_equal",
+	FilterLt: "less",
+	FilterLe: "less_equal",
+	FilterGt: "greater",
+	FilterGe: "greater_equal",
+}
+
+// Filter compares a result column with a constant, e.g.
+// Filter{Column: "status", Op: FilterEq, Value: "active"}. The value may be a
+// bool, an integer, a float or a string, and is converted to the column type as
+// needed. Rows where the column is NULL never match.
+type Filter struct {
+	Column string
+	Op     FilterOp
+	Value  any
+}
+
+type clientFiltersKey struct{}
+
+type clientProjectionKey struct{}
+
+// WithClientFilter returns a context that makes the queries run with it drop the
+// rows of their result that don't match all filters, before rows are converted
+// for database/sql, e.g. when the SQL is generated by a third party and can't be
+// changed. Filters are evaluated with Arrow compute kernels on whole record
+// batches. They apply to QueryContext and QueryArrow, not to statements run with
+// ExecContext. Referring to a column that isn't in the result is an error.
+func WithClientFilter(ctx context.Context, filters ...Filter) context.Context {
+	return context.WithValue(ctx, clientFiltersKey{}, filters)
+}
+
+// WithClientProjection returns a context that makes the queries run with it keep
+// only the given columns of their result, in that order. Filters set with
+// WithClientFilter may use columns that aren't kept.
+func WithClientProjection(ctx context.Context, columns ...string) context.Context {
+	return context.WithValue(ctx, clientProjectionKey{}, columns)
+}
+
+// applyClientSide filters and projects a query result according to the options
+// set in ctx. It takes ownership of records, and returns the records to use in
+// their place.
+func applyClientSide(ctx context.Context, schema *arrow.Schema, records []arrow.Record, mem memory.Allocator) (*arrow.Schema, []arrow.Record, error) {
+	filters, _ := ctx.Value(clientFiltersKey{}).([]Filter)
+	columns, _ := ctx.Value(clientProjectionKey{}).([]string)
+	if len(filters) == 0 && len(columns) == 0 {
+		return schema, records, nil
+	}
+
+	if schema == nil && len(records) > 0 {
+		schema = records[0].Schema()
+	}
+	if schema == nil {
+		// No result set to filter
+		return schema, records, nil
+	}
+
+	outSchema, indices, err := projectSchema(schema, columns)
+	if err != nil {
+		wire.ReleaseRecords(records)
+		return nil, nil, err
+	}
+
+	ctx = compute.WithAllocator(ctx, mem)
+	out := make([]arrow.Record, 0, len(records))
+	for i, rec := range records {
+		filtered, err := filterRecord(ctx, rec, filters)
+		if err == nil && indices != nil {
+			projected := array.NewRecord(outSchema, projectColumns(filtered, indices), filtered.NumRows())
+			filtered.Release()
+			filtered = projected
+		}
+		if err != nil {
+			wire.ReleaseRecords(out)
+			wire.ReleaseRecords(records[i:])
+			return nil, nil, err
+		}
+
+		rec.Release()
+		out = append(out, filtered)
+	}
+
+	return outSchema, out, nil
+}
+
+// projectSchema returns the schema made of the given columns of schema, and
+// their indices. With no columns, it returns schema and nil indices.
+func projectSchema(schema *arrow.Schema, columns []string) (*arrow.Schema, []int, error) {
+	if len(columns) == 0 {
+		return schema, nil, nil
+	}
+
+	fields := make([]arrow.Field, len(columns))
+	indices := make([]int, len(columns))
+	for i, name := range columns {
+		idx := schema.FieldIndices(name)
+		if len(idx) == 0 {
+			return nil, nil, fmt.Errorf("luna: client projection of unknown column %q", name)
+		}
+		fields[i], indices[i] = schema.Field(idx[0]), idx[0]
+	}
+
+	md := schema.Metadata()
+	return arrow.NewSchema(fields, &md), indices, nil
+}
+
+func projectColumns(rec arrow.Record, indices []int) []arrow.Array {
+	cols := make([]arrow.Array, len(indices))
+	for i, idx := range indices {
+		cols[i] = rec.Column(idx)
+	}
+	return cols
+}
+
+// filterRecord returns the rows of rec that match all filters, as a new reference.
+func filterRecord(ctx context.Context, rec arrow.Record, filters []Filter) (arrow.Record, error) {
+	if len(filters) == 0 {
+		rec.Retain()
+		return rec, nil
+	}
+
+	var mask compute.Datum
+	for _, f := range filters {
+		matches, err := filterMask(ctx, rec, f)
+		if err != nil {
+			if mask != nil {
+				mask.Release()
+			}
+			return nil, err
+		}
+		if mask == nil {
+			mask = matches
+			continue
+		}
+
+		combined, err := compute.CallFunction(ctx, "and_kleene", nil, mask, matches)
+		mask.Release()
+		matches.Release()
+		if err != nil {
+			return nil, err
+		}
+		mask = combined
+	}
+	defer mask.Release()
+
+	selection := mask.(*compute.ArrayDatum).MakeArray()
+	defer selection.Release()
+
+	return compute.FilterRecordBatch(ctx, rec, selection, compute.DefaultFilterOptions())
+}
+
+// filterMask evaluates a single filter on rec, as a boolean array datum.
+func filterMask(ctx context.Context, rec arrow.Record, f Filter) (compute.Datum, error) {
+	fn, ok := filterFunctions[f.Op]
+	if !ok {
+		return nil, fmt.Errorf("luna: unknown client filter operator %q", f.Op)
+	}
+
+	idx := rec.Schema().FieldIndices(f.Column)
+	if len(idx) == 0 {
+		return nil, fmt.Errorf("luna: client filter on unknown column %q", f.Column)
+	}
+
+	value, err := filterScalar(f.Value)
+	if err != nil {
+		return nil, fmt.Errorf("luna: client filter on column %q: %w", f.Column, err)
+	}
+
+	col := compute.NewDatum(rec.Column(idx[0]))
+	defer col.Release()
+
+	mask, err := compute.CallFunction(ctx, fn, nil, col, compute.NewDatum(value))
+	if err != nil {
+		return nil, fmt.Errorf("luna: client filter on column %q: %w", f.Column, err)
+	}
+	return mask, nil
+}
+
+// filterScalar converts a filter value to an Arrow scalar.
+func filterScalar(v any) (scalar.Scalar, error) {
+	switch v := v.(type) {
+	case bool, int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint, float32, float64, string:
+		return scalar.MakeScalar(v), nil
+	default:
+		return nil, fmt.Errorf("unsupported filter value %v (%T)", v, v)
+	}
+}
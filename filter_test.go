@@ -0,0 +1,212 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestClientFilterAndProjection(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "status", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64},
+	}, nil)
+
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+
+			// Two batches: (1, active, 0.5) (2, closed, 1.5) | (3, NULL, 2.5) (4, active, 3.5)
+			var recs []arrow.Record
+			for batch := 0; batch < 2; batch++ {
+				b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+				b.Field(0).(*array.Int32Builder).AppendValues([]int32{int32(batch*2 + 1), int32(batch*2 + 2)}, nil)
+				if batch == 0 {
+					b.Field(1).(*array.StringBuilder).AppendValues([]string{"active", "closed"}, nil)
+				} else {
+					b.Field(1).(*array.StringBuilder).AppendValues([]string{"", "active"}, []bool{false, true})
+				}
+				b.Field(2).(*array.Float64Builder).AppendValues([]float64{float64(batch*2) + 0.5, float64(batch*2) + 1.5}, nil)
+				recs = append(recs, b.NewRecord())
+				b.Release()
+			}
+			writeArrowReply(conn, schema, recs...)
+			releaseTestRecords(recs)
+		}
+	})
+
+	testCases := []struct {
+		name     string
+		filters  []Filter
+		columns  []string
+		expected [][]driver.Value
+		err      string
+	}{
+		{
+			name:    "string equality skips NULL",
+			filters: []Filter{{Column: "status", Op: FilterNe, Value: "closed"}},
+			expected: [][]driver.Value{
+				{int32(1), "active", 0.5},
+				{int32(4), "active", 3.5},
+			},
+		},
+		{
+			name:    "numeric comparison with a wider value",
+			filters: []Filter{{Column: "id", Op: FilterGe, Value: int64(2)}, {Column: "score", Op: FilterLt, Value: 3.0}},
+			expected: [][]driver.Value{
+				{int32(2), "closed", 1.5},
+				{int32(3), nil, 2.5},
+			},
+		},
+		{
+			name:    "projection",
+			columns: []string{"score", "id"},
+			expected: [][]driver.Value{
+				{0.5, int32(1)},
+				{1.5, int32(2)},
+				{2.5, int32(3)},
+				{3.5, int32(4)},
+			},
+		},
+		{
+			name:    "filter on a column that isn't projected",
+			filters: []Filter{{Column: "status", Op: FilterEq, Value: "active"}},
+			columns: []string{"id"},
+			expected: [][]driver.Value{
+				{int32(1)},
+				{int32(4)},
+			},
+		},
+		{
+			name:    "unknown filter column",
+			filters: []Filter{{Column: "missing", Op: FilterEq, Value: 1}},
+			err:     `client filter on unknown column "missing"`,
+		},
+		{
+			name:    "unknown projected column",
+			columns: []string{"missing"},
+			err:     `client projection of unknown column "missing"`,
+		},
+		{
+			name:    "unknown operator",
+			filters: []Filter{{Column: "id", Op: "LIKE", Value: 1}},
+			err:     `unknown client filter operator "LIKE"`,
+		},
+		{
+			name:    "unsupported value",
+			filters: []Filter{{Column: "id", Op: FilterEq, Value: []int{1}}},
+			err:     "unsupported filter value",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+			defer mem.AssertSize(t, 0)
+
+			connector, err := NewConnectorWithOptions(addr, WithAllocator(mem))
+			if err != nil {
+				t.Fatalf("failed to create connector: %v", err)
+			}
+			dc, err := connector.Connect(context.Background())
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer dc.Close()
+
+			ctx := context.Background()
+			if tc.filters != nil {
+				ctx = WithClientFilter(ctx, tc.filters...)
+			}
+			if tc.columns != nil {
+				ctx = WithClientProjection(ctx, tc.columns...)
+			}
+
+			rows, err := dc.(*Conn).QueryContext(ctx, "SELECT * FROM t", nil)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			defer rows.Close()
+
+			if len(rows.Columns()) != len(tc.expected[0]) {
+				t.Errorf("expected %d columns, got %v", len(tc.expected[0]), rows.Columns())
+			}
+
+			var got [][]driver.Value
+			for {
+				dest := make([]driver.Value, len(rows.Columns()))
+				if err := rows.Next(dest); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("Next failed: %v", err)
+				}
+				got = append(got, dest)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestQueryArrowClientFilter(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get conn: %v", err)
+	}
+	defer conn.Close()
+
+	reader, err := QueryArrow(WithClientFilter(ctx, Filter{Column: "n", Op: FilterGt, Value: 1000}), conn, "SELECT n FROM t")
+	if err != nil {
+		t.Fatalf("QueryArrow failed: %v", err)
+	}
+	defer reader.Release()
+
+	if n := reader.Schema().NumFields(); n != 1 {
+		t.Errorf("expected the schema to be kept, got %d fields", n)
+	}
+	var rows int64
+	for reader.Next() {
+		rows += reader.Record().NumRows()
+	}
+	if rows != 0 {
+		t.Errorf("expected no rows, got %d", rows)
+	}
+}
@@ -0,0 +1,253 @@
+package luna
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ForEachRow runs a query and calls fn with each row of its result, in order,
+// without the conversions and allocations of database/sql's Scan. cols are the
+// names of the result's columns and vals the row's values, of the types
+// Rows.Next returns; both are reused between calls, so fn must copy them to keep
+// them, and the bytes of []byte values too. An error returned by fn stops
+// reading the rows and is returned. The rows of each result set of a
+// multi-statement query are passed in turn, with the columns of their own
+// result set.
+func (c *Conn) ForEachRow(ctx context.Context, query string, fn func(cols []string, vals []driver.Value) error) error {
+	var cols []string
+	return c.eachRow(ctx, query, func(set []string) error {
+		cols = set
+		return nil
+	}, func(vals []driver.Value) error {
+		return fn(cols, vals)
+	})
+}
+
+// eachRow runs a query, and calls set with the columns of each of its result
+// sets, then row with each row of that result set.
+func (c *Conn) eachRow(ctx context.Context, query string, set func(cols []string) error, row func(vals []driver.Value) error) error {
+	rows, err := c.QueryContext(ctx, query, nil)
+	if err != nil {
+		return err
+	}
+	r := rows.(*Rows)
+	defer r.Close()
+
+	for {
+		cols := r.Columns()
+		if err := set(cols); err != nil {
+			return err
+		}
+		vals := make([]driver.Value, len(cols))
+		for {
+			err := r.Next(vals)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := row(vals); err != nil {
+				return err
+			}
+		}
+
+		if err := r.NextResultSet(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// ForEachRow runs a query on a connection from a database/sql pool opened with
+// the luna driver, and calls fn with each row of its result. See
+// Conn.ForEachRow.
+func ForEachRow(ctx context.Context, conn *sql.Conn, query string, fn func(cols []string, vals []driver.Value) error) error {
+	return conn.Raw(func(dc any) error {
+		c, ok := dc.(*Conn)
+		if !ok {
+			return fmt.Errorf("luna: ForEachRow needs a luna connection, got %T", dc)
+		}
+		return c.ForEachRow(ctx, query, fn)
+	})
+}
+
+// Query runs a query on a connection from a database/sql pool opened with the
+// luna driver, and returns its rows as structs of type T, without going through
+// Scan. Columns are matched, ignoring case, to the exported fields named after
+// their `luna:"name"` tag or else the field name, as for RegisterTempTable;
+// fields tagged `luna:"-"` are skipped. Fields without a column are left zero,
+// and a column without a field is an error.
+//
+// Values are assigned to fields of their type or of an interface type they
+// implement. Integers and floats are converted to other numeric types they fit
+// in, and strings and []byte to each other. NULLs leave fields zero, or nil for
+// pointers, whose other values are assigned to the value they point to. Fields
+// that implement sql.Scanner, e.g. sql.NullString, are scanned with the value
+// instead. The fields of each struct type are looked up once.
+func Query[T any](ctx context.Context, conn *sql.Conn, query string) ([]T, error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("luna: Query needs a struct type, got %s", t)
+	}
+	fields := structFields(t)
+
+	var out []T
+	err := conn.Raw(func(dc any) error {
+		c, ok := dc.(*Conn)
+		if !ok {
+			return fmt.Errorf("luna: Query needs a luna connection, got %T", dc)
+		}
+
+		var cols []string
+		var plan []int
+		return c.eachRow(ctx, query, func(set []string) error {
+			cols, plan = set, make([]int, len(set))
+			for i, col := range set {
+				field, ok := fields[strings.ToLower(col)]
+				if !ok {
+					return fmt.Errorf("luna: column %s has no field in %s", col, t)
+				}
+				plan[i] = field
+			}
+			return nil
+		}, func(vals []driver.Value) error {
+			var zero T
+			out = append(out, zero)
+			v := reflect.ValueOf(&out[len(out)-1]).Elem()
+			for i, field := range plan {
+				if err := assignValue(v.Field(field), vals[i]); err != nil {
+					return fmt.Errorf("luna: column %s: %w", cols[i], err)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// structFieldsCache maps struct types to their fields by column name, as
+// returned by structFields.
+var structFieldsCache sync.Map
+
+// structFields returns the indexes of the fields of the struct type t that Query
+// assigns, by lower-cased column name.
+func structFields(t reflect.Type) map[string]int {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.(map[string]int)
+	}
+
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("luna")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name := f.Name
+		if tag != "" {
+			name = tag
+		}
+		fields[strings.ToLower(name)] = i
+	}
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+var scannerType = reflect.TypeFor[sql.Scanner]()
+
+// assignValue assigns a value returned by Rows.Next to a struct field, as
+// described in Query.
+func assignValue(dst reflect.Value, val driver.Value) error {
+	if reflect.PointerTo(dst.Type()).Implements(scannerType) {
+		return dst.Addr().Interface().(sql.Scanner).Scan(val)
+	}
+	if val == nil {
+		dst.SetZero()
+		return nil
+	}
+	if dst.Kind() == reflect.Pointer {
+		p := reflect.New(dst.Type().Elem())
+		if err := assignValue(p.Elem(), val); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	}
+
+	// Binary values point into the result's buffers, which are released with it
+	if b, ok := val.([]byte); ok {
+		val = bytes.Clone(b)
+	}
+	src := reflect.ValueOf(val)
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+
+	switch dk, sk := dst.Kind(), src.Kind(); {
+	case isIntKind(dk) && isIntKind(sk):
+		if n := src.Int(); !dst.OverflowInt(n) {
+			dst.SetInt(n)
+			return nil
+		}
+	case isIntKind(dk) && isUintKind(sk):
+		if n := src.Uint(); n <= math.MaxInt64 && !dst.OverflowInt(int64(n)) {
+			dst.SetInt(int64(n))
+			return nil
+		}
+	case isUintKind(dk) && isUintKind(sk):
+		if n := src.Uint(); !dst.OverflowUint(n) {
+			dst.SetUint(n)
+			return nil
+		}
+	case isUintKind(dk) && isIntKind(sk):
+		if n := src.Int(); n >= 0 && !dst.OverflowUint(uint64(n)) {
+			dst.SetUint(uint64(n))
+			return nil
+		}
+	case isFloatKind(dk) && isIntKind(sk):
+		dst.SetFloat(float64(src.Int()))
+		return nil
+	case isFloatKind(dk) && isUintKind(sk):
+		dst.SetFloat(float64(src.Uint()))
+		return nil
+	case isFloatKind(dk) && isFloatKind(sk):
+		if f := src.Float(); !dst.OverflowFloat(f) {
+			dst.SetFloat(f)
+			return nil
+		}
+	case dk == reflect.String && (sk == reflect.String || src.Type() == scanTypeBytes):
+		dst.SetString(src.Convert(scanTypeString).String())
+		return nil
+	case dk == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8 && (sk == reflect.String || src.Type() == scanTypeBytes):
+		dst.SetBytes(src.Convert(scanTypeBytes).Bytes())
+		return nil
+	}
+	return fmt.Errorf("can't assign %T %v to a field of type %s", val, val, dst.Type())
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUintKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
@@ -0,0 +1,137 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newUsersServer(t *testing.T) string {
+	addr, _ := newReplyServer(t,
+		fakeReply{"SELECT id, name, note FROM users", recordReply(t, []string{"id", "name", "note"},
+			[]any{int64(1), "ada", "admin"},
+			[]any{int64(300), "grace", ""},
+		)},
+		fakeReply{"SELECT id, email FROM users", recordReply(t, []string{"id", "email"}, []any{int64(1), "ada@example.com"})},
+	)
+	return addr
+}
+
+func TestForEachRow(t *testing.T) {
+	db, err := sql.Open("luna", newUsersServer(t))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	var rows []string
+	err = ForEachRow(ctx, conn, "SELECT id, name, note FROM users", func(cols []string, vals []driver.Value) error {
+		rows = append(rows, fmt.Sprintf("%s=%v %s=%v", cols[0], vals[0], cols[1], vals[1]))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachRow failed: %v", err)
+	}
+	expected := []string{"id=1 name=ada", "id=300 name=grace"}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected rows %q, got %q", expected, rows)
+	}
+
+	// An error from fn stops reading the rows
+	errStop := errors.New("stop")
+	calls := 0
+	err = ForEachRow(ctx, conn, "SELECT id, name, note FROM users", func(cols []string, vals []driver.Value) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("expected fn's error after one call, got %v after %d", err, calls)
+	}
+}
+
+func TestQueryStructs(t *testing.T) {
+	db, err := sql.Open("luna", newUsersServer(t))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	type user struct {
+		ID      int32 `luna:"id"`
+		Name    *string
+		Note    sql.NullString
+		Label   []byte `luna:"-"`
+		Missing string
+	}
+	users, err := Query[user](ctx, conn, "SELECT id, name, note FROM users")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	ada, grace := "ada", "grace"
+	expected := []user{
+		{ID: 1, Name: &ada, Note: sql.NullString{String: "admin", Valid: true}},
+		{ID: 300, Name: &grace, Note: sql.NullString{Valid: true}},
+	}
+	if !reflect.DeepEqual(users, expected) {
+		t.Errorf("expected %+v, got %+v", expected, users)
+	}
+
+	type narrowUser struct {
+		ID         int8
+		Name, Note string
+	}
+	testCases := []struct {
+		name     string
+		query    func() error
+		expected string
+	}{
+		{
+			name: "overflow",
+			query: func() error {
+				_, err := Query[narrowUser](ctx, conn, "SELECT id, name, note FROM users")
+				return err
+			},
+			expected: "column id: can't assign int64 300 to a field of type int8",
+		},
+		{
+			name: "column without field",
+			query: func() error {
+				_, err := Query[struct{ ID int64 }](ctx, conn, "SELECT id, email FROM users")
+				return err
+			},
+			expected: "column email has no field",
+		},
+		{
+			name: "not a struct",
+			query: func() error {
+				_, err := Query[int64](ctx, conn, "SELECT id, email FROM users")
+				return err
+			},
+			expected: "needs a struct type",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.query(); err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected an error with %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrNoFiles is returned by ExpandGlob and CheckGlobs for patterns that match no
+// files.
+var ErrNoFiles = errors.New("luna: no files match")
+
+// FileLister lists the files matching a glob pattern, e.g. with a cloud storage
+// SDK, for ExpandGlob and CheckGlobs. Patterns use the server's syntax, e.g.
+// "s3://bucket/prefix/*.parquet".
+type FileLister func(ctx context.Context, pattern string) ([]string, error)
+
+// fileLister returns the lister registered for the scheme of pattern, e.g. "s3",
+// or nil to list files on the server.
+func (c *Conn) fileLister(pattern string) FileLister {
+	scheme, _, ok := strings.Cut(pattern, "://")
+	if !ok {
+		return nil
+	}
+	return c.fileListers[strings.ToLower(scheme)]
+}
+
+// ExpandGlob returns the files matching a glob pattern, as read_parquet and the
+// other read functions would see them. Files are listed with the FileLister
+// registered for the pattern's scheme with WithFileLister, and otherwise by the
+// server's glob function, which uses the server's credentials. Patterns that
+// match no files are reported with ErrNoFiles.
+func ExpandGlob(ctx context.Context, db *sql.DB, pattern string) ([]string, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var list FileLister
+	err = conn.Raw(func(dc any) error {
+		c, ok := dc.(*Conn)
+		if !ok {
+			return fmt.Errorf("luna: ExpandGlob needs a luna connection, got %T", dc)
+		}
+		list = c.fileLister(pattern)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	if list != nil {
+		files, err = list(ctx, pattern)
+	} else {
+		files, err = serverGlob(ctx, conn, pattern)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("luna: can't list files matching %q: %w", pattern, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrNoFiles, pattern)
+	}
+	return files, nil
+}
+
+func serverGlob(ctx context.Context, conn *sql.Conn, pattern string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT file FROM glob("+quoteSQLString(pattern)+")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// CheckGlobs checks that the glob patterns a query reads files from match at
+// least one file each, to fail early with ErrNoFiles rather than with a server
+// error once the query has run for a while. The patterns are the string literals
+// with *, ? or [ in the arguments of the read_* and parquet_scan functions, and
+// right after FROM or JOIN. Each is listed with ExpandGlob.
+func CheckGlobs(ctx context.Context, db *sql.DB, query string) error {
+	for _, pattern := range queryGlobs(query) {
+		if _, err := ExpandGlob(ctx, db, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryGlobs returns the glob patterns that query reads files from.
+func queryGlobs(query string) []string {
+	var globs []string
+	add := func(start, end int) {
+		s := sqlStringValue(query[start:end])
+		if strings.ContainsAny(s, "*?[") && !slices.Contains(globs, s) {
+			globs = append(globs, s)
+		}
+	}
+
+	words := scanSQLWords(query)
+	for _, w := range words {
+		next := skipSQLSpace(query, w.end)
+		switch {
+		case (w.text == "FROM" || w.text == "JOIN") && next < len(query) && query[next] == '\'':
+			add(next, skipSQLQuoted(query, next))
+		case (strings.HasPrefix(w.text, "READ_") || w.text == "PARQUET_SCAN") && next < len(query) && query[next] == '(':
+			// The literals of the arguments, including lists of patterns
+			depth := 0
+			for i := next; i < len(query); {
+				switch query[i] {
+				case '\'':
+					end := skipSQLQuoted(query, i)
+					add(i, end)
+					i = end
+					continue
+				case '"':
+					i = skipSQLQuoted(query, i)
+					continue
+				case '(':
+					depth++
+				case ')':
+					depth--
+				}
+				i++
+				if depth == 0 {
+					break
+				}
+			}
+		}
+	}
+	return globs
+}
+
+// sqlStringValue returns the value of a single-quoted string literal.
+func sqlStringValue(lit string) string {
+	lit = strings.TrimPrefix(lit, "'")
+	lit = strings.TrimSuffix(lit, "'")
+	return strings.ReplaceAll(lit, "''", "'")
+}
+
+func quoteSQLString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func skipSQLSpace(query string, i int) int {
+	for i < len(query) && isSQLSpace(query[i]) {
+		i++
+	}
+	return i
+}
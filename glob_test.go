@@ -0,0 +1,121 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestQueryGlobs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"read_parquet", "SELECT * FROM read_parquet('s3://b/data/*.parquet')", []string{"s3://b/data/*.parquet"}},
+		{"list and options", "SELECT * FROM read_csv(['gs://b/a/*.csv', 'gs://b/b.csv'], header = true, types = {'x': 'INT'})", []string{"gs://b/a/*.csv"}},
+		{"from literal", "SELECT COUNT(*) FROM 'data/2024-??.parquet' JOIN 'dim/[ab].csv' USING (id)", []string{"data/2024-??.parquet", "dim/[ab].csv"}},
+		{"quoted quote", "SELECT * FROM read_json('s3://b/o''neil/*.json')", []string{"s3://b/o'neil/*.json"}},
+		{"duplicates", "SELECT * FROM parquet_scan('a/*.parquet') UNION ALL SELECT * FROM read_parquet('a/*.parquet')", []string{"a/*.parquet"}},
+		{"no glob", "SELECT * FROM read_parquet('s3://b/data.parquet')", nil},
+		{"other literals", "SELECT * FROM t WHERE name LIKE 'a*' AND note = 'what?'", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := queryGlobs(tc.query); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+// fileReply encodes a result with a file column of the given files.
+func fileReply(t *testing.T, files ...string) []byte {
+	t.Helper()
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+		{Name: "file", Type: arrow.BinaryTypes.String},
+	}, nil))
+	defer b.Release()
+	b.Field(0).(*array.StringBuilder).AppendValues(files, nil)
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	if err := writeArrowReply(&buf, rec.Schema(), rec); err != nil {
+		t.Fatalf("failed to encode reply: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExpandGlob(t *testing.T) {
+	replies := map[string][]byte{
+		"q:SELECT file FROM glob('s3://b/*.parquet')": fileReply(t, "s3://b/1.parquet", "s3://b/2.parquet"),
+		"q:SELECT file FROM glob('s3://b/*.csv')":     fileReply(t),
+	}
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			conn.Write(replies[cmd])
+		}
+	})
+
+	var listed []string
+	connector, err := NewConnectorWithOptions(addr, WithFileLister("GS", func(ctx context.Context, pattern string) ([]string, error) {
+		listed = append(listed, pattern)
+		if strings.HasSuffix(pattern, ".json") {
+			return nil, errors.New("access denied")
+		}
+		return []string{"gs://b/x.parquet"}, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx := context.Background()
+	files, err := ExpandGlob(ctx, db, "s3://b/*.parquet")
+	if err != nil {
+		t.Fatalf("ExpandGlob failed: %v", err)
+	}
+	if !reflect.DeepEqual(files, []string{"s3://b/1.parquet", "s3://b/2.parquet"}) {
+		t.Errorf("unexpected files %q", files)
+	}
+
+	if _, err := ExpandGlob(ctx, db, "s3://b/*.csv"); !errors.Is(err, ErrNoFiles) || !strings.Contains(err.Error(), "s3://b/*.csv") {
+		t.Errorf("expected ErrNoFiles with the pattern, got %v", err)
+	}
+
+	// Registered schemes are listed client-side
+	if files, err := ExpandGlob(ctx, db, "gs://b/*.parquet"); err != nil || len(files) != 1 {
+		t.Errorf("expected the lister's file, got %q, %v", files, err)
+	}
+	if _, err := ExpandGlob(ctx, db, "gs://b/*.json"); err == nil || errors.Is(err, ErrNoFiles) {
+		t.Errorf("expected the lister's error, got %v", err)
+	}
+	if !reflect.DeepEqual(listed, []string{"gs://b/*.parquet", "gs://b/*.json"}) {
+		t.Errorf("unexpected listed patterns %q", listed)
+	}
+
+	err = CheckGlobs(ctx, db, "SELECT * FROM read_parquet('s3://b/*.parquet') JOIN read_csv('s3://b/*.csv') USING (id)")
+	if !errors.Is(err, ErrNoFiles) {
+		t.Errorf("expected ErrNoFiles, got %v", err)
+	}
+	if err := CheckGlobs(ctx, db, "SELECT * FROM 'gs://b/*.parquet'"); err != nil {
+		t.Errorf("CheckGlobs failed: %v", err)
+	}
+}
@@ -5,9 +5,11 @@ go 1.25.1
 require (
 	github.com/apache/arrow/go/v17 v17.0.0
 	golang.org/x/crypto v0.43.0
+	golang.org/x/term v0.36.0
 )
 
 require (
+	github.com/JohnCGriffin/overflow v0.0.0-20211019200055-46fa312c352c // indirect
 	github.com/goccy/go-json v0.10.3 // indirect
 	github.com/google/flatbuffers v24.3.25+incompatible // indirect
 	github.com/klauspost/compress v1.17.9 // indirect
@@ -0,0 +1,117 @@
+package luna
+
+import (
+	"context"
+	"time"
+)
+
+// QueryHooks are called around the statements a connection runs, e.g. to audit
+// them, rewrite them to inject a tenant filter, or block them, without wrapping
+// every call site. Nil hooks are skipped. The hooks set with several
+// WithQueryHooks options are called in order, each BeforeQuery getting the
+// statement returned by the one before.
+//
+// Hooks see the statements of Exec, Query, QueryArrow and RunPipeline calls,
+// including the transaction and session statements the driver sends through
+// Exec, before dialect translation and IN list splitting. A statement that
+// database/sql retries on another connection passes through them again. Hooks
+// are called while the connection is busy, so they must not use it.
+//
+// In a transaction in TxBatch mode, BeforeQuery is called as a statement is
+// buffered, and AfterQuery once Commit has sent the batch, with the batch's
+// error and Rows 0, since the server doesn't report them per statement.
+// Statements dropped by Rollback get no AfterQuery.
+type QueryHooks struct {
+	// BeforeQuery is called before a statement is sent, and returns the
+	// statement to send instead, or the same one. An error fails the statement
+	// without sending it, and AfterQuery isn't called then.
+	BeforeQuery func(ctx context.Context, e QueryEvent) (string, error)
+	// AfterQuery is called once the reply to a statement has been read, or the
+	// statement failed, with Duration, Rows and Err set.
+	AfterQuery func(ctx context.Context, e QueryEvent)
+	// AfterRowsClose is called when the rows of a Query are closed, with
+	// Duration since the statement was sent, and Rows the number of rows read.
+	AfterRowsClose func(ctx context.Context, e QueryEvent)
+}
+
+// QueryEvent describes a statement passed to QueryHooks.
+type QueryEvent struct {
+	// Query is the statement, as rewritten by the BeforeQuery hooks once they
+	// ran.
+	Query string
+	// Kind is "exec", "query", "arrow" or "pipeline", for the call that runs
+	// the statement.
+	Kind string
+	// Conn identifies the connection, as in the support bundle's events.
+	Conn int64
+	// Start is when the statement was passed to the hooks.
+	Start time.Time
+	// Duration is how long the statement took, set for the After hooks.
+	Duration time.Duration
+	// Rows is the number of rows returned or affected, set for the After
+	// hooks. For AfterRowsClose, it's the number of rows read before closing.
+	Rows int64
+	// Err is the error the statement failed with, set for the After hooks.
+	Err error
+	// Memory is the Arrow memory allocated to decode the result of a query or
+	// QueryArrow call, across its result sets, set for the After hooks; it's
+	// zero for other statements. For AfterRowsClose, it includes the memory
+	// released by closing the rows.
+	Memory MemoryStats
+}
+
+// beforeQuery passes query through the BeforeQuery hooks, and returns the event
+// for the After hooks, whose Query is the statement to send. The caller must
+// hold c.mu.
+func (c *Conn) beforeQuery(ctx context.Context, kind, query string) (QueryEvent, error) {
+	e := QueryEvent{Query: query, Kind: kind, Conn: c.id}
+	if len(c.hooks) == 0 {
+		return e, nil
+	}
+
+	e.Start = c.clock.Now()
+	for _, h := range c.hooks {
+		if h.BeforeQuery == nil {
+			continue
+		}
+		query, err := h.BeforeQuery(ctx, e)
+		if err != nil {
+			return e, err
+		}
+		e.Query = query
+	}
+	return e, nil
+}
+
+// afterQuery passes the outcome of a statement to the AfterQuery hooks. The
+// caller must hold c.mu.
+func (c *Conn) afterQuery(ctx context.Context, e QueryEvent, rows int64, err error) {
+	if len(c.hooks) == 0 {
+		return
+	}
+
+	e.Duration, e.Rows, e.Err = c.clock.Now().Sub(e.Start), rows, err
+	for _, h := range c.hooks {
+		if h.AfterQuery != nil {
+			h.AfterQuery(ctx, e)
+		}
+	}
+}
+
+// hookRowsClose arranges for the AfterRowsClose hooks to be called when rows
+// are closed.
+func (c *Conn) hookRowsClose(ctx context.Context, e QueryEvent, rows *Rows) {
+	if len(c.hooks) == 0 {
+		return
+	}
+
+	hooks, clock := c.hooks, c.clock
+	rows.onClose = func(read int64) {
+		e.Duration, e.Rows, e.Memory = clock.Now().Sub(e.Start), read, rows.queryMem.stats()
+		for _, h := range hooks {
+			if h.AfterRowsClose != nil {
+				h.AfterRowsClose(ctx, e)
+			}
+		}
+	}
+}
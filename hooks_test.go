@@ -0,0 +1,244 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestQueryHooks(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			received = append(received, cmd)
+			mu.Unlock()
+			if strings.HasPrefix(cmd, "x:") {
+				conn.Write([]byte(":3\r\n"))
+				continue
+			}
+			conn.Write(arrowReply(t, "n", 1, 2, 3))
+		}
+	})
+
+	var events []string
+	errBlocked := errors.New("blocked")
+	tenant := QueryHooks{
+		BeforeQuery: func(ctx context.Context, e QueryEvent) (string, error) {
+			if strings.HasPrefix(e.Query, "DROP") {
+				return "", errBlocked
+			}
+			return strings.ReplaceAll(e.Query, "orders", "tenant_7.orders"), nil
+		},
+	}
+	audit := QueryHooks{
+		BeforeQuery: func(ctx context.Context, e QueryEvent) (string, error) {
+			events = append(events, "before "+e.Kind+" "+e.Query)
+			return e.Query, nil
+		},
+		AfterQuery: func(ctx context.Context, e QueryEvent) {
+			events = append(events, fmt.Sprintf("after %s %s rows=%d err=%v", e.Kind, e.Query, e.Rows, e.Err))
+			// Results are decoded with tracked memory, executions have none
+			if decoded := e.Kind == "query" || e.Kind == "arrow"; decoded != (e.Memory.Allocated > 0) {
+				t.Errorf("%s %s: unexpected memory stats %+v", e.Kind, e.Query, e.Memory)
+			}
+		},
+		AfterRowsClose: func(ctx context.Context, e QueryEvent) {
+			events = append(events, fmt.Sprintf("close %s read=%d", e.Query, e.Rows))
+			if e.Memory.Allocated == 0 || e.Memory.InUse() != 0 {
+				t.Errorf("expected the memory of closed rows to be released, got %+v", e.Memory)
+			}
+		},
+	}
+	connector, err := NewConnectorWithOptions(addr, WithQueryHooks(tenant), WithQueryHooks(audit))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	conn := dc.(*Conn)
+	ctx := context.Background()
+
+	rows, err := conn.QueryContext(ctx, "SELECT n FROM orders", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	dest := make([]driver.Value, 1)
+	rows.Next(dest)
+	rows.Close()
+
+	if _, err := conn.ExecContext(ctx, "DELETE FROM orders", nil); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "DROP TABLE orders", nil); !errors.Is(err, errBlocked) {
+		t.Errorf("expected the blocked statement to fail, got %v", err)
+	}
+	reader, err := conn.QueryArrow(ctx, "SELECT n FROM orders")
+	if err != nil {
+		t.Fatalf("QueryArrow failed: %v", err)
+	}
+	reader.Release()
+	var p Pipeline
+	p.Exec("UPDATE orders SET n = 1")
+	results, err := conn.RunPipeline(ctx, &p)
+	if err != nil {
+		t.Fatalf("pipeline failed: %v", err)
+	}
+	releasePipelineResults(results)
+
+	expectedEvents := []string{
+		"before query SELECT n FROM tenant_7.orders",
+		"after query SELECT n FROM tenant_7.orders rows=3 err=<nil>",
+		"close SELECT n FROM tenant_7.orders read=1",
+		"before exec DELETE FROM tenant_7.orders",
+		"after exec DELETE FROM tenant_7.orders rows=3 err=<nil>",
+		"before arrow SELECT n FROM tenant_7.orders",
+		"after arrow SELECT n FROM tenant_7.orders rows=3 err=<nil>",
+		"before pipeline UPDATE tenant_7.orders SET n = 1",
+		"after pipeline UPDATE tenant_7.orders SET n = 1 rows=3 err=<nil>",
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("expected events:\n%s\ngot:\n%s", strings.Join(expectedEvents, "\n"), strings.Join(events, "\n"))
+	}
+	expectedCommands := []string{
+		"q:SELECT n FROM tenant_7.orders",
+		"x:DELETE FROM tenant_7.orders",
+		"q:SELECT n FROM tenant_7.orders",
+		"x:UPDATE tenant_7.orders SET n = 1",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(received, expectedCommands) {
+		t.Errorf("expected commands %q, got %q", expectedCommands, received)
+	}
+}
+
+func TestQueryHooksError(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write([]byte("-Catalog Error: Table with name missing does not exist!\r\n"))
+		}
+	})
+
+	var failed error
+	connector, err := NewConnectorWithOptions(addr, WithQueryHooks(QueryHooks{
+		AfterQuery: func(ctx context.Context, e QueryEvent) { failed = e.Err },
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	_, err = db.Query("SELECT * FROM missing")
+	var lerr *Error
+	if !errors.As(err, &lerr) || !errors.Is(failed, err) {
+		t.Errorf("expected AfterQuery to get the query's error %v, got %v", err, failed)
+	}
+}
+
+func TestQueryHooksBatchTransaction(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			for _, stmt := range statementCommands(cmd) {
+				if strings.Contains(stmt, "DELETE") {
+					conn.Write([]byte("-Constraint Error: Violates foreign key constraint\r\n"))
+					break
+				}
+				conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	})
+
+	var events []string
+	connector, err := NewConnectorWithOptions(addr, WithTxMode(TxBatch), WithQueryHooks(QueryHooks{
+		BeforeQuery: func(ctx context.Context, e QueryEvent) (string, error) {
+			events = append(events, "before "+e.Kind+" "+e.Query)
+			return strings.ReplaceAll(e.Query, "orders", "tenant_7.orders"), nil
+		},
+		AfterQuery: func(ctx context.Context, e QueryEvent) {
+			events = append(events, fmt.Sprintf("after %s %s failed=%v", e.Kind, e.Query, e.Err != nil))
+		},
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	conn := dc.(*Conn)
+	ctx := context.Background()
+
+	commit := func(statements ...string) error {
+		tx, err := conn.BeginTx(ctx, driver.TxOptions{})
+		if err != nil {
+			t.Fatalf("BeginTx failed: %v", err)
+		}
+		if _, err := conn.ExecContext(ctx, statements[0], nil); err != nil {
+			t.Fatalf("exec failed: %v", err)
+		}
+		if _, err := conn.ExecBatchContext(ctx, statements[1:]); err != nil {
+			t.Fatalf("ExecBatchContext failed: %v", err)
+		}
+		events = append(events, "commit")
+		return tx.Commit()
+	}
+
+	// AfterQuery waits for the batch to run, which skips the hooks
+	if err := commit("INSERT INTO orders VALUES (1)", "UPDATE orders SET n = 2"); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	expected := "x:BEGIN TRANSACTION\n;\nINSERT INTO tenant_7.orders VALUES (1)\n;\nUPDATE tenant_7.orders SET n = 2\n;\nCOMMIT"
+	if cmd := <-commands; cmd != expected {
+		t.Errorf("expected %q, got %q", expected, cmd)
+	}
+
+	// Every statement of a failed batch gets its error
+	if err := commit("INSERT INTO orders VALUES (3)", "DELETE FROM orders"); err == nil {
+		t.Fatal("expected Commit to fail")
+	}
+	<-commands
+
+	expectedEvents := []string{
+		"before exec INSERT INTO orders VALUES (1)",
+		"before pipeline UPDATE orders SET n = 2",
+		"commit",
+		"after exec INSERT INTO tenant_7.orders VALUES (1) failed=false",
+		"after pipeline UPDATE tenant_7.orders SET n = 2 failed=false",
+		"before exec INSERT INTO orders VALUES (3)",
+		"before pipeline DELETE FROM orders",
+		"commit",
+		"after exec INSERT INTO tenant_7.orders VALUES (3) failed=true",
+		"after pipeline DELETE FROM tenant_7.orders failed=true",
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("expected events:\n%s\ngot:\n%s", strings.Join(expectedEvents, "\n"), strings.Join(events, "\n"))
+	}
+}
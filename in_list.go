@@ -0,0 +1,699 @@
+package luna
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/compute"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// Words that make it unsafe to split a query by its IN list, since the results of
+// the individual chunks can't simply be concatenated (aggregates, ordering, limits,
+// sampling, set operations, or disjunctions that would duplicate rows). Function
+// calls in the select list are refused separately, since any of them may be an
+// aggregate. ORDER BY is allowed in ordered mode, where the chunks are merged.
+var inListUnsafeWords = map[string]bool{
+	"GROUP":       true,
+	"ORDER":       true,
+	"LIMIT":       true,
+	"OFFSET":      true,
+	"FETCH":       true,
+	"SAMPLE":      true,
+	"TABLESAMPLE": true,
+	"DISTINCT":    true,
+	"HAVING":      true,
+	"UNION":       true,
+	"INTERSECT":   true,
+	"EXCEPT":      true,
+	"OR":          true,
+	"OVER":        true,
+	"QUALIFY":     true,
+	"COUNT":       true,
+	"SUM":         true,
+	"AVG":         true,
+	"MIN":         true,
+	"MAX":         true,
+}
+
+// Words that can't appear in the operand of a split IN list, since they may
+// negate the IN or make it part of a larger expression.
+var inListOperandWords = map[string]bool{
+	"NOT":     true,
+	"AND":     true,
+	"CASE":    true,
+	"WHEN":    true,
+	"IS":      true,
+	"BETWEEN": true,
+	"LIKE":    true,
+	"ILIKE":   true,
+	"GLOB":    true,
+	"SIMILAR": true,
+	"EXISTS":  true,
+	"ANY":     true,
+	"ALL":     true,
+	"SOME":    true,
+}
+
+type sqlWord struct {
+	text  string // upper-cased
+	start int
+	end   int
+	depth int // parenthesis nesting depth
+}
+
+// inListOrderKey is a column of the ORDER BY clause of a split query, by name or
+// by 1-based position in the select list.
+type inListOrderKey struct {
+	column     string
+	quoted     bool
+	position   int
+	desc       bool
+	nullsFirst bool
+}
+
+// splitInList splits a SELECT query containing a single literal IN (...) list longer
+// than maxLen into several queries, each with at most maxLen items in the list.
+// The queries are meant to be executed in order and their results concatenated,
+// or merged by the returned ORDER BY keys if there are any.
+//
+// Splitting is conservative: it returns nil (meaning "run the query as is") unless
+// the query is a plain SELECT with exactly one oversized IN list, no function
+// call in its select list and none of the constructs in inListUnsafeWords, and
+// the list is a conjunct of the top-level WHERE clause, i.e. its IN isn't
+// negated, compared, nested in parentheses or CASE, or part of a JOIN's ON. In
+// ordered mode, a final ORDER BY of result columns is allowed too. Duplicate
+// items are dropped, so that a row can't match in two chunks.
+func splitInList(query string, maxLen int, ordered bool) ([]string, []inListOrderKey) {
+	if maxLen <= 0 {
+		return nil, nil
+	}
+
+	words := scanSQLWords(query)
+	if len(words) == 0 || words[0].text != "SELECT" || selectListHasCall(query, words) {
+		return nil, nil
+	}
+
+	where, orderBy := -1, -1
+	for i, w := range words {
+		if ordered && w.text == "ORDER" && w.depth == 0 && orderBy < 0 && i+1 < len(words) && words[i+1].text == "BY" {
+			orderBy = i
+			continue
+		}
+		if inListUnsafeWords[w.text] {
+			return nil, nil
+		}
+		if w.text == "WHERE" && w.depth == 0 && where < 0 {
+			where = i
+		}
+	}
+
+	whereEnd := len(query)
+	var order []inListOrderKey
+	if orderBy >= 0 {
+		whereEnd = words[orderBy].start
+		var ok bool
+		if order, ok = parseInListOrderBy(query[words[orderBy+1].end:]); !ok {
+			return nil, nil
+		}
+	}
+
+	listOpen, listClose := -1, -1
+	var items []string
+	for i, w := range words {
+		if w.text != "IN" {
+			continue
+		}
+
+		// Find the opening parenthesis right after IN
+		p := w.end
+		for p < len(query) && isSQLSpace(query[p]) {
+			p++
+		}
+		if p >= len(query) || query[p] != '(' {
+			continue
+		}
+
+		end, list := splitSQLList(query, p)
+		if end < 0 {
+			continue
+		}
+		list = dedupeSQLList(list)
+		if len(list) <= maxLen {
+			continue
+		}
+
+		// Only a single oversized list is supported, in a conjunct of the WHERE
+		// clause
+		if listOpen >= 0 || !isWhereConjunct(query, words, where, i, end, whereEnd) {
+			return nil, nil
+		}
+
+		listOpen, listClose, items = p, end, list
+	}
+
+	if listOpen < 0 {
+		return nil, nil
+	}
+
+	var queries []string
+	for i := 0; i < len(items); i += maxLen {
+		j := min(i+maxLen, len(items))
+		queries = append(queries, query[:listOpen+1]+strings.Join(items[i:j], ", ")+query[listClose:])
+	}
+
+	return queries, order
+}
+
+// isWhereConjunct reports whether the IN at words[in], whose list ends at
+// query[listClose], is a conjunct of its own of the top-level WHERE clause at
+// words[where], which ends at query[whereEnd]: an operand without negation or
+// comparison, then IN and its list, between AND, WHERE and the clause's end.
+func isWhereConjunct(query string, words []sqlWord, where, in, listClose, whereEnd int) bool {
+	if where < 0 || in < where || words[in].depth != 0 || listClose >= whereEnd {
+		return false
+	}
+
+	// The AND of a BETWEEN doesn't start a conjunct, so the BETWEEN ends up in
+	// the operand and is refused
+	start, between := words[where].end, false
+	for _, w := range words[where+1 : in] {
+		switch {
+		case w.depth != 0:
+		case w.text == "BETWEEN":
+			between = true
+		case w.text == "AND" && between:
+			between = false
+		case w.text == "AND":
+			start = w.end
+		}
+	}
+	end := whereEnd
+	for _, w := range words[in+1:] {
+		if w.start >= whereEnd {
+			break
+		}
+		if w.start > listClose && w.depth == 0 && w.text == "AND" {
+			end = w.start
+			break
+		}
+	}
+
+	for _, w := range words[where+1 : in] {
+		if w.start >= start && inListOperandWords[w.text] {
+			return false
+		}
+	}
+	operand := query[start:words[in].start]
+	return !isSQLBlank(operand) && !hasSQLComparison(operand) && isSQLBlank(query[listClose+1:end])
+}
+
+// parseInListOrderBy parses the items of an ORDER BY clause, after BY, that can
+// be used to merge the chunks of a split query: result columns or positions,
+// with an optional direction and NULLS FIRST or LAST.
+func parseInListOrderBy(clause string) ([]inListOrderKey, bool) {
+	clause = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(clause), ";"))
+	if clause == "" || strings.ContainsAny(clause, "()'-/") {
+		return nil, false
+	}
+
+	var keys []inListOrderKey
+	for _, item := range strings.Split(clause, ",") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			return nil, false
+		}
+
+		var key inListOrderKey
+		name := fields[0]
+		if n, err := strconv.Atoi(name); err == nil {
+			if n < 1 {
+				return nil, false
+			}
+			key.position = n
+		} else {
+			// Qualified names refer to the result column of the same name
+			if i := strings.LastIndexByte(name, '.'); i >= 0 {
+				name = name[i+1:]
+			}
+			if len(name) > 2 && name[0] == '"' && name[len(name)-1] == '"' {
+				name, key.quoted = name[1:len(name)-1], true
+			}
+			if name == "" || (key.quoted && strings.Contains(name, `"`)) || (!key.quoted && !isSQLIdentifier(name)) {
+				return nil, false
+			}
+			key.column = name
+		}
+
+		rest := strings.ToUpper(strings.Join(fields[1:], " "))
+		if strings.HasPrefix(rest, "ASC") || strings.HasPrefix(rest, "DESC") {
+			key.desc = strings.HasPrefix(rest, "DESC")
+			rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(rest, "ASC"), "DESC"))
+		}
+		switch rest {
+		case "":
+		case "NULLS FIRST":
+			key.nullsFirst = true
+		case "NULLS LAST":
+		default:
+			return nil, false
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, true
+}
+
+// hasSQLComparison reports whether s has a comparison operator outside quoted
+// strings and identifiers.
+func hasSQLComparison(s string) bool {
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '\'', '"':
+			i = skipSQLQuoted(s, i)
+			continue
+		case '=', '<', '>', '!':
+			return true
+		}
+		i++
+	}
+	return false
+}
+
+// isSQLBlank reports whether s has nothing but spaces and comments.
+func isSQLBlank(s string) bool {
+	for i := 0; i < len(s); {
+		switch {
+		case isSQLSpace(s[i]):
+			i++
+		case strings.HasPrefix(s[i:], "--"):
+			i = skipSQLLineComment(s, i)
+		case strings.HasPrefix(s[i:], "/*"):
+			i = skipSQLBlockComment(s, i)
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// selectListHasCall reports whether the select list of query, whose words are
+// words, has a function call, i.e. a word followed by an opening parenthesis.
+func selectListHasCall(query string, words []sqlWord) bool {
+	for _, w := range words[1:] {
+		if w.text == "FROM" {
+			return false
+		}
+		p := w.end
+		for p < len(query) && isSQLSpace(query[p]) {
+			p++
+		}
+		if p < len(query) && query[p] == '(' {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupeSQLList returns the items of a list without the repeated ones, in the
+// order they first appear.
+func dedupeSQLList(items []string) []string {
+	seen := make(map[string]bool, len(items))
+	unique := items[:0]
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			unique = append(unique, item)
+		}
+	}
+	return unique
+}
+
+// scanSQLWords returns the keywords and identifiers of query, with their
+// parenthesis depth, skipping quoted strings, quoted identifiers, and comments.
+func scanSQLWords(query string) []sqlWord {
+	var words []sqlWord
+	depth := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			i = skipSQLQuoted(query, i)
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			i = skipSQLLineComment(query, i)
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			i = skipSQLBlockComment(query, i)
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case isSQLWordStart(c):
+			start := i
+			for i < len(query) && (isSQLWordStart(query[i]) || (query[i] >= '0' && query[i] <= '9')) {
+				i++
+			}
+			words = append(words, sqlWord{text: strings.ToUpper(query[start:i]), start: start, end: i, depth: depth})
+		case c >= '0' && c <= '9':
+			// Skip numeric literals so that e.g. 1e10 isn't read as a word
+			for i < len(query) && (isSQLWordStart(query[i]) || (query[i] >= '0' && query[i] <= '9') || query[i] == '.') {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+
+	return words
+}
+
+// splitSQLList splits the parenthesized, comma-separated list starting at query[open]
+// into its trimmed items. It returns the index of the closing parenthesis, or -1 if
+// the list is unterminated or contains a subquery or a comment.
+func splitSQLList(query string, open int) (int, []string) {
+	var items []string
+	depth := 0
+	start := open + 1
+	for i := open; i < len(query); {
+		switch query[i] {
+		case '\'', '"':
+			i = skipSQLQuoted(query, i)
+			continue
+		case '-', '/':
+			// A comment may hide a comma or parenthesis
+			if strings.HasPrefix(query[i:], "--") || strings.HasPrefix(query[i:], "/*") {
+				return -1, nil
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(query[start:i]))
+				for _, item := range items {
+					if item == "" || strings.HasPrefix(strings.ToUpper(item), "SELECT") {
+						return -1, nil
+					}
+				}
+				return i, items
+			}
+		case ',':
+			if depth == 1 {
+				items = append(items, strings.TrimSpace(query[start:i]))
+				start = i + 1
+			}
+		}
+		i++
+	}
+
+	return -1, nil
+}
+
+// skipSQLQuoted returns the index right after the quoted string or identifier
+// starting at query[i]. Doubled quotes are treated as escapes.
+func skipSQLQuoted(query string, i int) int {
+	quote := query[i]
+	i++
+	for i < len(query) {
+		if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return i
+}
+
+// isSQLDollarQuote reports whether query[i] starts the opening tag of a dollar
+// quoted string, $$ or $tag$, where tag is an identifier. A $ inside a word,
+// e.g. the identifier a$b, doesn't.
+func isSQLDollarQuote(query string, i int) bool {
+	if i > 0 && isSQLWordChar(query[i-1]) {
+		return false
+	}
+	j := i + 1
+	if j < len(query) && isSQLWordStart(query[j]) {
+		for j < len(query) && isSQLWordChar(query[j]) {
+			j++
+		}
+	}
+	return j < len(query) && query[j] == '$'
+}
+
+// skipSQLDollarQuoted returns the index right after the dollar quoted string
+// starting at query[i], or the end of query if it's unterminated.
+func skipSQLDollarQuoted(query string, i int) int {
+	tag := query[i : strings.IndexByte(query[i+1:], '$')+i+2]
+	if end := strings.Index(query[i+len(tag):], tag); end >= 0 {
+		return i + len(tag) + end + len(tag)
+	}
+	return len(query)
+}
+
+// skipSQLLineComment returns the index of the end of the line of the comment
+// starting at query[i].
+func skipSQLLineComment(query string, i int) int {
+	for i < len(query) && query[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+// skipSQLBlockComment returns the index right after the /* */ comment starting
+// at query[i], or the end of query if it's unterminated.
+func skipSQLBlockComment(query string, i int) int {
+	if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+		return i + 2 + end + 2
+	}
+	return len(query)
+}
+
+func isSQLWordStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// mergeInListChunks merges the records of the chunks of a query split in
+// ordered mode, each sorted by keys, into a single record sorted by keys.
+// Strings are compared by their bytes, and NULLs come last unless the key says
+// otherwise, as on the server by default. records are released.
+func mergeInListChunks(ctx context.Context, schema *arrow.Schema, records []arrow.Record, keys []inListOrderKey, mem memory.Allocator) ([]arrow.Record, error) {
+	defer wire.ReleaseRecords(records)
+	if schema == nil || len(records) == 0 {
+		return nil, nil
+	}
+
+	cols := make([]arrow.Array, schema.NumFields())
+	defer func() {
+		for _, col := range cols {
+			if col != nil {
+				col.Release()
+			}
+		}
+	}()
+	for i := range cols {
+		chunks := make([]arrow.Array, len(records))
+		for j, rec := range records {
+			chunks[j] = rec.Column(i)
+		}
+		col, err := array.Concatenate(chunks, mem)
+		if err != nil {
+			return nil, fmt.Errorf("luna: failed to merge the chunks of a split IN list: %w", err)
+		}
+		cols[i] = col
+	}
+
+	type orderColumn struct {
+		key     inListOrderKey
+		col     arrow.Array
+		compare func(i, j int) int
+	}
+	order := make([]orderColumn, len(keys))
+	for k, key := range keys {
+		idx, err := key.columnIndex(schema)
+		if err != nil {
+			return nil, err
+		}
+		compare, err := arrowCompare(cols[idx])
+		if err != nil {
+			return nil, fmt.Errorf("luna: can't merge the chunks of a split IN list by column %s: %w", schema.Field(idx).Name, err)
+		}
+		order[k] = orderColumn{key: key, col: cols[idx], compare: compare}
+	}
+
+	n := cols[0].Len()
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	// Each chunk is sorted already, and a stable sort keeps ties in chunk order
+	sort.SliceStable(perm, func(a, b int) bool {
+		i, j := perm[a], perm[b]
+		for _, o := range order {
+			inull, jnull := o.col.IsNull(i), o.col.IsNull(j)
+			switch {
+			case inull && jnull:
+				continue
+			case inull || jnull:
+				return inull == o.key.nullsFirst
+			}
+			c := o.compare(i, j)
+			if o.key.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	b := array.NewInt64Builder(mem)
+	defer b.Release()
+	for _, i := range perm {
+		b.Append(int64(i))
+	}
+	indices := b.NewArray()
+	defer indices.Release()
+
+	ctx = compute.WithAllocator(ctx, mem)
+	sorted := make([]arrow.Array, len(cols))
+	for i, col := range cols {
+		taken, err := compute.TakeArray(ctx, col, indices)
+		if err != nil {
+			for _, s := range sorted[:i] {
+				s.Release()
+			}
+			return nil, fmt.Errorf("luna: failed to merge the chunks of a split IN list: %w", err)
+		}
+		sorted[i] = taken
+	}
+	rec := array.NewRecord(schema, sorted, int64(n))
+	for _, s := range sorted {
+		s.Release()
+	}
+
+	return []arrow.Record{rec}, nil
+}
+
+// columnIndex returns the index of the result column of an ORDER BY key.
+func (key inListOrderKey) columnIndex(schema *arrow.Schema) (int, error) {
+	if key.position > 0 {
+		if key.position > schema.NumFields() {
+			return 0, fmt.Errorf("luna: ORDER BY position %d is past the %d result columns", key.position, schema.NumFields())
+		}
+		return key.position - 1, nil
+	}
+
+	idx := -1
+	for i, f := range schema.Fields() {
+		if f.Name == key.column || (!key.quoted && strings.EqualFold(f.Name, key.column)) {
+			if idx >= 0 {
+				return 0, fmt.Errorf("luna: ORDER BY column %s is ambiguous in the result", key.column)
+			}
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return 0, fmt.Errorf("luna: ORDER BY column %s isn't in the result, which the chunks of a split IN list are merged by; select it or disable in_list_order", key.column)
+	}
+	return idx, nil
+}
+
+// arrowCompare returns a function comparing the non-NULL values at two indexes
+// of arr. NaN is greater than the other floats, as on the server.
+func arrowCompare(arr arrow.Array) (func(i, j int) int, error) {
+	switch a := arr.(type) {
+	case *array.Boolean:
+		return func(i, j int) int {
+			vi, vj := a.Value(i), a.Value(j)
+			switch {
+			case vi == vj:
+				return 0
+			case vj:
+				return -1
+			default:
+				return 1
+			}
+		}, nil
+	case *array.Int8:
+		return orderedCompare(a.Value), nil
+	case *array.Int16:
+		return orderedCompare(a.Value), nil
+	case *array.Int32:
+		return orderedCompare(a.Value), nil
+	case *array.Int64:
+		return orderedCompare(a.Value), nil
+	case *array.Uint8:
+		return orderedCompare(a.Value), nil
+	case *array.Uint16:
+		return orderedCompare(a.Value), nil
+	case *array.Uint32:
+		return orderedCompare(a.Value), nil
+	case *array.Uint64:
+		return orderedCompare(a.Value), nil
+	case *array.Float32:
+		return floatCompare(a.Value), nil
+	case *array.Float64:
+		return floatCompare(a.Value), nil
+	case *array.String:
+		return orderedCompare(a.Value), nil
+	case *array.LargeString:
+		return orderedCompare(a.Value), nil
+	case *array.Binary:
+		return func(i, j int) int { return bytes.Compare(a.Value(i), a.Value(j)) }, nil
+	case *array.LargeBinary:
+		return func(i, j int) int { return bytes.Compare(a.Value(i), a.Value(j)) }, nil
+	case *array.Date32:
+		return orderedCompare(a.Value), nil
+	case *array.Date64:
+		return orderedCompare(a.Value), nil
+	case *array.Timestamp:
+		return orderedCompare(a.Value), nil
+	case *array.Time32:
+		return orderedCompare(a.Value), nil
+	case *array.Time64:
+		return orderedCompare(a.Value), nil
+	case *array.Duration:
+		return orderedCompare(a.Value), nil
+	case *array.Decimal128:
+		return func(i, j int) int { return a.Value(i).Cmp(a.Value(j)) }, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", arr.DataType())
+}
+
+func orderedCompare[T cmp.Ordered](value func(int) T) func(i, j int) int {
+	return func(i, j int) int {
+		return cmp.Compare(value(i), value(j))
+	}
+}
+
+func floatCompare[T float32 | float64](value func(int) T) func(i, j int) int {
+	return func(i, j int) int {
+		vi, vj := value(i), value(j)
+		switch inan, jnan := vi != vi, vj != vj; {
+		case inan && jnan:
+			return 0
+		case inan:
+			return 1
+		case jnan:
+			return -1
+		}
+		return cmp.Compare(vi, vj)
+	}
+}
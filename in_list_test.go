@@ -0,0 +1,259 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+func TestSplitInList(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		maxLen   int
+		expected []string
+	}{
+		{
+			"split",
+			"SELECT * FROM t WHERE id IN (1, 2, 3, 4, 5)",
+			2,
+			[]string{
+				"SELECT * FROM t WHERE id IN (1, 2)",
+				"SELECT * FROM t WHERE id IN (3, 4)",
+				"SELECT * FROM t WHERE id IN (5)",
+			},
+		},
+		{
+			"quoted items",
+			"select name from t where name in ('a,b', 'it''s', 'c') and x = 1",
+			2,
+			[]string{
+				"select name from t where name in ('a,b', 'it''s') and x = 1",
+				"select name from t where name in ('c') and x = 1",
+			},
+		},
+		{"disabled", "SELECT * FROM t WHERE id IN (1, 2, 3)", 0, nil},
+		{"short list", "SELECT * FROM t WHERE id IN (1, 2)", 2, nil},
+		{"not in", "SELECT * FROM t WHERE id NOT IN (1, 2, 3)", 2, nil},
+		{"disjunction", "SELECT * FROM t WHERE id IN (1, 2, 3) OR x = 1", 2, nil},
+		{
+			"duplicates",
+			"SELECT * FROM t WHERE id IN (1, 2, 1, 3, 2)",
+			2,
+			[]string{
+				"SELECT * FROM t WHERE id IN (1, 2)",
+				"SELECT * FROM t WHERE id IN (3)",
+			},
+		},
+		{"duplicates within limit", "SELECT * FROM t WHERE id IN (1, 2, 1, 2)", 2, nil},
+		{
+			"function outside select list",
+			"SELECT id FROM t WHERE lower(name) IN ('a', 'b', 'c')",
+			2,
+			[]string{
+				"SELECT id FROM t WHERE lower(name) IN ('a', 'b')",
+				"SELECT id FROM t WHERE lower(name) IN ('c')",
+			},
+		},
+		{"aggregate", "SELECT COUNT(*) FROM t WHERE id IN (1, 2, 3)", 2, nil},
+		{"list aggregate", "SELECT list(id) FROM t WHERE id IN (1, 2, 3)", 2, nil},
+		{"string_agg", "SELECT string_agg(name, ',') FROM t WHERE id IN (1, 2, 3)", 2, nil},
+		{"median", "SELECT median (x) AS m FROM t WHERE id IN (1, 2, 3)", 2, nil},
+		{"group by", "SELECT id FROM t WHERE id IN (1, 2, 3) GROUP BY id", 2, nil},
+		{"fetch", "SELECT * FROM t WHERE id IN (1, 2, 3) FETCH FIRST 2 ROWS ONLY", 2, nil},
+		{"sample", "SELECT * FROM t WHERE id IN (1, 2, 3) USING SAMPLE 10%", 2, nil},
+		{"tablesample", "SELECT * FROM t TABLESAMPLE 10% WHERE id IN (1, 2, 3)", 2, nil},
+		{"order by", "SELECT * FROM t WHERE id IN (1, 2, 3) ORDER BY id", 2, nil},
+		{"subquery", "SELECT * FROM t WHERE id IN (SELECT id FROM u)", 0, nil},
+		{"not a select", "DELETE FROM t WHERE id IN (1, 2, 3)", 2, nil},
+		{"two lists", "SELECT * FROM t WHERE a IN (1, 2, 3) AND b IN (1, 2, 3)", 2, nil},
+		{
+			"other conjuncts",
+			"SELECT * FROM t WHERE a = 1 AND id IN (1, 2, 3) AND b BETWEEN 1 AND 2",
+			2,
+			[]string{
+				"SELECT * FROM t WHERE a = 1 AND id IN (1, 2) AND b BETWEEN 1 AND 2",
+				"SELECT * FROM t WHERE a = 1 AND id IN (3) AND b BETWEEN 1 AND 2",
+			},
+		},
+		{
+			"block comments",
+			"SELECT * FROM t /* no OR here */ WHERE id IN (1, 2, 3)",
+			2,
+			[]string{
+				"SELECT * FROM t /* no OR here */ WHERE id IN (1, 2)",
+				"SELECT * FROM t /* no OR here */ WHERE id IN (3)",
+			},
+		},
+		{"negated in parentheses", "SELECT * FROM t WHERE NOT (id IN (1, 2, 3))", 2, nil},
+		{"negated operand", "SELECT * FROM t WHERE NOT id IN (1, 2, 3)", 2, nil},
+		{"compared", "SELECT * FROM t WHERE (id IN (1, 2, 3)) = false", 2, nil},
+		{"compared after", "SELECT * FROM t WHERE id IN (1, 2, 3) = false", 2, nil},
+		{"is true", "SELECT * FROM t WHERE id IN (1, 2, 3) IS TRUE", 2, nil},
+		{"compared before", "SELECT * FROM t WHERE false = id IN (1, 2, 3)", 2, nil},
+		{"parenthesized", "SELECT * FROM t WHERE (a = 1 AND id IN (1, 2, 3))", 2, nil},
+		{"case", "SELECT * FROM t WHERE CASE WHEN id IN (1, 2, 3) THEN a ELSE b END", 2, nil},
+		{"between bound", "SELECT * FROM t WHERE a BETWEEN 1 AND id IN (1, 2, 3)", 2, nil},
+		{"join on", "SELECT * FROM t LEFT JOIN u ON t.id = u.id AND u.kind IN (1, 2, 3)", 2, nil},
+		{"join on with where", "SELECT * FROM t LEFT JOIN u ON t.id = u.id AND u.kind IN (1, 2, 3) WHERE t.a = 1", 2, nil},
+		{"select list", "SELECT id IN (1, 2, 3) AS hit FROM t", 2, nil},
+		{"derived table", "SELECT * FROM (SELECT * FROM t WHERE id IN (1, 2, 3)) AS s WHERE s.a = 1", 2, nil},
+		{"commented disjunction", "SELECT * FROM t /* it's */ WHERE id IN (1, 2, 3) OR x = 1", 2, nil},
+		{"comment in list", "SELECT * FROM t WHERE id IN (1, /* , */ 2, 3)", 2, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, order := splitInList(tc.query, tc.maxLen, false)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+			if order != nil {
+				t.Errorf("expected no ORDER BY keys, got %+v", order)
+			}
+		})
+	}
+}
+
+func TestSplitInListOrdered(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+		order    []inListOrderKey
+	}{
+		{
+			name:  "order by",
+			query: "SELECT id, name FROM t WHERE id IN (1, 2, 3) ORDER BY t.name DESC NULLS FIRST, 1",
+			expected: []string{
+				"SELECT id, name FROM t WHERE id IN (1, 2) ORDER BY t.name DESC NULLS FIRST, 1",
+				"SELECT id, name FROM t WHERE id IN (3) ORDER BY t.name DESC NULLS FIRST, 1",
+			},
+			order: []inListOrderKey{{column: "name", desc: true, nullsFirst: true}, {position: 1}},
+		},
+		{
+			name:  "quoted column",
+			query: `SELECT * FROM t WHERE id IN (1, 2, 3) ORDER BY "Name" ASC`,
+			expected: []string{
+				`SELECT * FROM t WHERE id IN (1, 2) ORDER BY "Name" ASC`,
+				`SELECT * FROM t WHERE id IN (3) ORDER BY "Name" ASC`,
+			},
+			order: []inListOrderKey{{column: "Name", quoted: true}},
+		},
+		{
+			name:     "without order by",
+			query:    "SELECT * FROM t WHERE id IN (1, 2, 3)",
+			expected: []string{"SELECT * FROM t WHERE id IN (1, 2)", "SELECT * FROM t WHERE id IN (3)"},
+		},
+		{name: "expression", query: "SELECT * FROM t WHERE id IN (1, 2, 3) ORDER BY lower(name)"},
+		{name: "arithmetic", query: "SELECT * FROM t WHERE id IN (1, 2, 3) ORDER BY a + b"},
+		{name: "limit", query: "SELECT * FROM t WHERE id IN (1, 2, 3) ORDER BY id LIMIT 10"},
+		{name: "collation", query: "SELECT * FROM t WHERE id IN (1, 2, 3) ORDER BY name COLLATE nocase"},
+		{name: "window", query: "SELECT * FROM t WHERE id IN (1, 2, 3) ORDER BY id, a OVER (ORDER BY b)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, order := splitInList(tc.query, 2, true)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+			if !reflect.DeepEqual(order, tc.order) {
+				t.Errorf("expected ORDER BY keys %+v, got %+v", tc.order, order)
+			}
+		})
+	}
+}
+
+func TestInListOrder(t *testing.T) {
+	addr, commands := newReplyServer(t,
+		fakeReply{"IN (1, 2)", recordReply(t, []string{"id", "name"}, []any{int64(1), "a"}, []any{int64(2), "c"})},
+		fakeReply{"IN (3, 4)", recordReply(t, []string{"id", "name"}, []any{int64(4), "b"}, []any{int64(3), "d"})},
+	)
+
+	db, err := sql.Open("luna", addr+"?max_in_list=2&in_list_order=true")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, name FROM t WHERE id IN (1, 2, 3, 4) ORDER BY name")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows failed: %v", err)
+	}
+	if expected := []int64{1, 4, 2, 3}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected ids %v in name order, got %v", expected, ids)
+	}
+	for _, expected := range []string{
+		"q:SELECT id, name FROM t WHERE id IN (1, 2) ORDER BY name",
+		"q:SELECT id, name FROM t WHERE id IN (3, 4) ORDER BY name",
+	} {
+		if cmd := <-commands; cmd != expected {
+			t.Errorf("expected %q, got %q", expected, cmd)
+		}
+	}
+
+	// The parts can't be merged by a column that isn't in the result
+	_, err = db.Query("SELECT id, name FROM t WHERE id IN (1, 2, 3, 4) ORDER BY created")
+	if err == nil || !strings.Contains(err.Error(), "ORDER BY column created isn't in the result") {
+		t.Errorf("expected an error for the missing ORDER BY column, got %v", err)
+	}
+}
+
+func TestMergeInListChunks(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	chunk := func(ids []int64, scores []float64, valid []bool) arrow.Record {
+		b := array.NewRecordBuilder(mem, schema)
+		defer b.Release()
+		b.Field(0).(*array.Float64Builder).AppendValues(scores, valid)
+		b.Field(1).(*array.Int64Builder).AppendValues(ids, nil)
+		return b.NewRecord()
+	}
+
+	// Each chunk is sorted by score DESC NULLS FIRST, then id, as the server does
+	records := []arrow.Record{
+		chunk([]int64{1, 2, 3}, []float64{0, math.NaN(), 2}, []bool{false, true, true}),
+		chunk([]int64{4, 5, 6, 7}, []float64{0, 3, 2, 1}, []bool{false, true, true, true}),
+	}
+	keys := []inListOrderKey{{column: "SCORE", desc: true, nullsFirst: true}, {position: 2}}
+	merged, err := mergeInListChunks(context.Background(), schema, records, keys, mem)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	defer wire.ReleaseRecords(merged)
+
+	if len(merged) != 1 {
+		t.Fatalf("expected a single record, got %d", len(merged))
+	}
+	ids := merged[0].Column(1).(*array.Int64).Int64Values()
+	if expected := []int64{1, 4, 2, 5, 3, 6, 7}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected ids %v, got %v", expected, ids)
+	}
+}
@@ -0,0 +1,442 @@
+// Package wire implements the Luna client protocol: RESP framed commands and
+// replies, with query results streamed as Arrow IPC.
+package wire
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// Protocol constants
+const (
+	CmdQuery   = "q:" // Query command (SELECT)
+	CmdExecute = "x:" // Execute command (DDL/DML)
+)
+
+// Response types returned by ReadResponse
+const (
+	RespArrowStream = "arrow-stream" // Arrow IPC stream follows, read it with ParseArrowIPCFromReader
+	RespBulk        = "bulk"         // Bulk string
+	RespNull        = "null"         // Null bulk string
+	RespOK          = "ok"           // Simple string
+	RespError       = "error"        // Error message
+	RespInt         = "int"          // Integer
+	RespTrailer     = "trailer"      // Trailer of a previous Arrow stream, read it with ParseTrailer
+)
+
+// TrailerMarker starts the trailer frame the server may send after the end of an
+// Arrow stream: |<length>\r\n<JSON>\r\n. Provisional: the server doesn't
+// define trailers yet, so the driver only reads them when enabled.
+const TrailerMarker = '|'
+
+// TrailerMetadataKey is the key of the schema metadata by which the server
+// announces a trailer: a value of "true" means that a trailer frame follows the
+// end of the Arrow stream.
+const TrailerMetadataKey = "luna.trailer"
+
+// Trailer is the metadata the server may send after the end of an Arrow stream.
+// Fields the server didn't send are nil.
+type Trailer struct {
+	// Number of rows changed by the statement.
+	RowsAffected *int64 `json:"rows_affected"`
+	// Number of rows in the result.
+	Rows *int64 `json:"rows"`
+	// Warnings raised by the statement.
+	Warnings []string `json:"warnings"`
+	// Other statistics, e.g. "bytes_scanned", by name.
+	Stats map[string]int64 `json:"stats"`
+}
+
+// SendCommand sends a command to Luna using RESP bulk string format
+// Format: $<length>\r\n<data>\r\n
+func SendCommand(w io.Writer, cmd string, sql string) error {
+	message := cmd + sql
+	resp := fmt.Sprintf("$%d\r\n%s\r\n", len(message), message)
+
+	_, err := w.Write([]byte(resp))
+	return err
+}
+
+// ReadResponse reads and parses the response from Luna
+// Returns the response type and data
+// Uses the provided buffered reader to maintain read position across calls
+func ReadResponse(reader *bufio.Reader) (string, []byte, error) {
+	// Read the first byte to determine response type
+	firstByte, err := reader.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch firstByte {
+	case 0xFF: // Arrow IPC continuation marker (0xFFFFFFFF)
+		// Luna sends Arrow IPC format directly
+		// Read the remaining 3 bytes of the continuation marker
+		marker := make([]byte, 3)
+		_, err := io.ReadFull(reader, marker)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read continuation marker: %w", err)
+		}
+
+		// Verify it's all 0xFF
+		if marker[0] != 0xFF || marker[1] != 0xFF || marker[2] != 0xFF {
+			return "", nil, fmt.Errorf("invalid continuation marker: %X %X %X", marker[0], marker[1], marker[2])
+		}
+
+		// For Arrow IPC, we return a special marker
+		// The actual parsing will be done by passing the reader to ParseArrowIPCFromReader
+		// Store the continuation marker to prepend it later
+		return RespArrowStream, []byte{0xFF, 0xFF, 0xFF, 0xFF}, nil
+
+	case '$': // Bulk string (RESP format - error messages might use this)
+		data, err := readBulk(reader)
+		if err != nil {
+			return "", nil, err
+		}
+		if data == nil {
+			return RespNull, nil, nil
+		}
+		return RespBulk, data, nil
+
+	case TrailerMarker: // Trailer of an Arrow stream, sent after the stream was read
+		data, err := readBulk(reader)
+		if err != nil {
+			return "", nil, err
+		}
+		return RespTrailer, data, nil
+
+	case '+': // Simple string (OK response)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		return RespOK, []byte(strings.TrimSpace(line)), nil
+
+	case '-': // Error
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		return RespError, []byte(strings.TrimSpace(line)), nil
+
+	case ':': // Integer
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		return RespInt, []byte(strings.TrimSpace(line)), nil
+
+	default:
+		return "", nil, fmt.Errorf("unknown response type: %c", firstByte)
+	}
+}
+
+// readBulk reads the <length>\r\n<data>\r\n body of a bulk frame, after its
+// type byte. It returns nil data for a length of -1.
+func readBulk(reader *bufio.Reader) ([]byte, error) {
+	// Read length
+	lengthStr, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	lengthStr = strings.TrimSpace(lengthStr)
+	length, err := strconv.Atoi(lengthStr)
+	if err != nil || length < -1 {
+		return nil, fmt.Errorf("invalid length: %s", lengthStr)
+	}
+
+	if length == -1 {
+		return nil, nil
+	}
+
+	// Read data
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read trailing \r\n
+	var crlf [2]byte
+	if _, err := io.ReadFull(reader, crlf[:]); err != nil {
+		return nil, err
+	}
+	if crlf != [2]byte{'\r', '\n'} {
+		return nil, fmt.Errorf("invalid bulk string terminator: %q", crlf[:])
+	}
+
+	return data, nil
+}
+
+// HasTrailer reports whether the schema of an Arrow stream announces a trailer
+// after the stream.
+func HasTrailer(schema *arrow.Schema) bool {
+	if schema == nil {
+		return false
+	}
+	v, ok := schema.Metadata().GetValue(TrailerMetadataKey)
+	return ok && v == "true"
+}
+
+// ReadTrailer reads the trailer following an Arrow stream whose schema announced
+// one, waiting for it to arrive.
+func ReadTrailer(reader *bufio.Reader) (*Trailer, error) {
+	respType, data, err := ReadResponse(reader)
+	if err != nil {
+		return nil, err
+	}
+	if respType != RespTrailer {
+		return nil, fmt.Errorf("expected a trailer, got %s", respType)
+	}
+	return ParseTrailer(data)
+}
+
+// ParseTrailer parses the JSON data of a trailer frame.
+func ParseTrailer(data []byte) (*Trailer, error) {
+	var t Trailer
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid trailer: %w", err)
+	}
+	return &t, nil
+}
+
+// ParseArrowIPC parses Arrow IPC format data and returns the schema and records.
+// Record buffers are allocated from mem.
+func ParseArrowIPC(data []byte, mem memory.Allocator) (*arrow.Schema, []arrow.Record, error) {
+	if len(data) == 0 {
+		return nil, nil, nil
+	}
+
+	reader, err := ipc.NewReader(
+		&bytesReader{data: data},
+		ipc.WithAllocator(mem),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create IPC reader: %w", err)
+	}
+	defer reader.Release()
+
+	var records []arrow.Record
+	for reader.Next() {
+		rec := reader.Record()
+		rec.Retain() // Keep the record alive
+		records = append(records, rec)
+	}
+
+	if err := reader.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading IPC records: %w", err)
+	}
+
+	return reader.Schema(), records, nil
+}
+
+// bytesReader wraps a byte slice to implement io.Reader
+type bytesReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bytesReader) Read(p []byte) (n int, err error) {
+	if r.pos >= len(r.data) {
+		return 0, io.EOF
+	}
+	n = copy(p, r.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// StreamError is returned by ParseArrowIPCFromReader when an error frame arrives
+// in place of the next Arrow message, e.g. because the query failed after some
+// record batches were sent. The error frame ends the stream, so the reader is
+// positioned at the next reply.
+type StreamError struct {
+	// Message of the error frame.
+	Msg string
+}
+
+func (e *StreamError) Error() string {
+	return "error frame in Arrow stream: " + e.Msg
+}
+
+// Progress describes how much of an Arrow stream has been read.
+type Progress struct {
+	// Record batches read so far.
+	Batches int
+	// Bytes of the stream read so far.
+	Bytes int64
+	// Rows of the record batches read so far.
+	Rows int64
+}
+
+// BatchStat describes a record batch of an Arrow stream, as read by
+// ParseArrowIPCFromReader.
+type BatchStat struct {
+	// Rows in the batch.
+	Rows int64
+	// Bytes of the stream read for the batch.
+	Bytes int64
+	// Time spent reading and decoding the batch, including waiting for its bytes.
+	Decode time.Duration
+}
+
+// Checkpoint is called between the messages of an Arrow stream with the progress
+// so far. Returning an error stops reading the stream, leaving the rest of it
+// unread, unless it's a *DrainError.
+type Checkpoint func(Progress) error
+
+// DrainError is returned by a Checkpoint to stop decoding an Arrow stream, but
+// read and discard the rest of it, so that the reader is left past its end.
+// ParseArrowIPCFromReader then returns Err.
+type DrainError struct {
+	Err error
+}
+
+func (e *DrainError) Error() string { return e.Err.Error() }
+
+func (e *DrainError) Unwrap() error { return e.Err }
+
+// streamCounter counts the bytes of a stream read through it.
+type streamCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *streamCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamMessageReader is an ipc.MessageReader that checks for an error frame
+// and calls the checkpoint before each message following the schema.
+type streamMessageReader struct {
+	ipc.MessageReader
+	reader     *bufio.Reader
+	counter    *streamCounter
+	checkpoint Checkpoint
+	batches    int
+	rows       int64
+	// True once the first message, which starts with the continuation marker
+	// already consumed from reader, has been read.
+	started bool
+}
+
+func (r *streamMessageReader) Message() (*ipc.Message, error) {
+	if r.started {
+		if r.checkpoint != nil {
+			if err := r.checkpoint(Progress{Batches: r.batches, Bytes: r.counter.n, Rows: r.rows}); err != nil {
+				return nil, err
+			}
+		}
+		if b, err := r.reader.Peek(1); err == nil && b[0] == '-' {
+			line, err := r.reader.ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			return nil, &StreamError{Msg: strings.TrimSpace(line[1:])}
+		}
+	}
+	r.started = true
+	msg, err := r.MessageReader.Message()
+	if err == nil && msg.Type() == ipc.MessageRecordBatch {
+		r.batches++
+	}
+	return msg, err
+}
+
+// drain reads and discards the messages left in the stream, up to its end or an
+// error frame.
+func (r *streamMessageReader) drain() error {
+	r.checkpoint = nil
+	for {
+		msg, err := r.Message()
+		var serr *StreamError
+		if err == io.EOF || errors.As(err, &serr) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		msg.Release()
+	}
+}
+
+// ParseArrowIPCFromReader reads Arrow IPC data directly from a buffered reader
+// and returns the stream's schema and records. The schema is available even if
+// the stream has no record batches. Record buffers are allocated from mem.
+//
+// If an error frame ends the stream early, the schema and the records read
+// before it are returned along with a *StreamError; otherwise the records are
+// only returned without an error. If checkpoint isn't nil, it's called between
+// messages, and an error it returns is returned, wrapped, or unwrapped for a
+// *DrainError. The stats of the records read are returned with them.
+func ParseArrowIPCFromReader(reader *bufio.Reader, mem memory.Allocator, checkpoint Checkpoint) (*arrow.Schema, []arrow.Record, []BatchStat, error) {
+	// The reader is positioned right after the continuation marker
+	// We need to prepend the marker for the Arrow IPC reader
+
+	// Use io.MultiReader to prepend the continuation marker
+	continuationReader := &bytesReader{data: []byte{0xFF, 0xFF, 0xFF, 0xFF}}
+	counter := &streamCounter{r: io.MultiReader(continuationReader, reader)}
+
+	// Use Arrow IPC library to read directly from the stream
+	msgReader := &streamMessageReader{
+		MessageReader: ipc.NewMessageReader(counter, ipc.WithAllocator(mem)),
+		reader:        reader,
+		counter:       counter,
+		checkpoint:    checkpoint,
+	}
+	ipcReader, err := ipc.NewReaderFromMessageReader(msgReader, ipc.WithAllocator(mem))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create IPC reader: %w", err)
+	}
+	defer ipcReader.Release()
+
+	// Read all records from the stream
+	var records []arrow.Record
+	var stats []BatchStat
+	for {
+		start, read := time.Now(), counter.n
+		if !ipcReader.Next() {
+			break
+		}
+		rec := ipcReader.Record()
+		rec.Retain() // Keep the record alive after reader is released
+		records = append(records, rec)
+		stats = append(stats, BatchStat{Rows: rec.NumRows(), Bytes: counter.n - read, Decode: time.Since(start)})
+		msgReader.rows += rec.NumRows()
+	}
+
+	if err := ipcReader.Err(); err != nil {
+		var serr *StreamError
+		if errors.As(err, &serr) {
+			return ipcReader.Schema(), records, stats, serr
+		}
+		ReleaseRecords(records)
+		var derr *DrainError
+		if errors.As(err, &derr) {
+			if err := msgReader.drain(); err != nil {
+				return nil, nil, nil, fmt.Errorf("error draining IPC records: %w", err)
+			}
+			return ipcReader.Schema(), nil, nil, derr.Err
+		}
+		return nil, nil, nil, fmt.Errorf("error reading IPC records: %w", err)
+	}
+
+	return ipcReader.Schema(), records, stats, nil
+}
+
+// ReleaseRecords releases all records, e.g. when a partially read result is dropped.
+func ReleaseRecords(records []arrow.Record) {
+	for _, rec := range records {
+		rec.Release()
+	}
+}
@@ -0,0 +1,9 @@
+package luna
+
+// Interval is the value of an INTERVAL column. Months and days are kept apart
+// from the rest, since their length in time depends on the date they're added to.
+type Interval struct {
+	Months      int32
+	Days        int32
+	Nanoseconds int64
+}
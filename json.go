@@ -0,0 +1,170 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// JSONFormat selects how WriteJSON writes rows.
+type JSONFormat int
+
+const (
+	// JSONArray writes a single JSON array of row objects.
+	JSONArray JSONFormat = iota
+	// NDJSON writes one row object per line (newline-delimited JSON).
+	NDJSON
+)
+
+// Size of the buffer WriteJSON encodes rows into. A full buffer is written and,
+// for HTTP responses, flushed to the client.
+const jsonBufferSize = 32 << 10
+
+// contentType returns the HTTP Content-Type of the format.
+func (f JSONFormat) contentType() string {
+	if f == NDJSON {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// WriteJSON runs a query on conn and streams its rows to w as JSON objects keyed
+// by column name, in the given format, e.g. to serve a query from a web handler:
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		conn, err := db.Conn(r.Context())
+//		...
+//		defer conn.Close()
+//		if err := luna.WriteJSON(r.Context(), w, conn, "SELECT * FROM events", luna.NDJSON); err != nil {
+//			log.Print(err)
+//		}
+//	}
+//
+// Rows are encoded from the Arrow result, without database/sql scanning, into a
+// buffer that is written to w whenever it fills up. If w is an http.ResponseWriter,
+// the Content-Type header is set unless it already is, and each write is flushed
+// to the client, so a slow client holds back encoding instead of growing a buffer.
+// Encoding stops when ctx is done, e.g. when the client disconnects. Once rows
+// have been written, an error can't change the response status anymore: a JSON
+// array is left unterminated, so that clients can tell the output is incomplete.
+//
+// Values are encoded by encoding/json: times as RFC 3339 strings, binary values as
+// base64 strings, decimals as strings, lists as arrays and structs as objects.
+func WriteJSON(ctx context.Context, w io.Writer, conn *sql.Conn, query string, format JSONFormat) error {
+	if format != JSONArray && format != NDJSON {
+		return fmt.Errorf("luna: unknown JSON format %d", format)
+	}
+
+	reader, err := QueryArrow(ctx, conn, query)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+
+	if rw, ok := w.(http.ResponseWriter); ok && rw.Header().Get("Content-Type") == "" {
+		rw.Header().Set("Content-Type", format.contentType())
+	}
+
+	out := &flushWriter{w: w}
+	out.flusher, _ = w.(http.Flusher)
+	return encodeJSON(ctx, out, reader, format)
+}
+
+// encodeJSON writes the rows of reader to out in the given format, through a
+// buffer that is written to out whenever it fills up.
+func encodeJSON(ctx context.Context, out *flushWriter, reader array.RecordReader, format JSONFormat) error {
+	// Column names are encoded once
+	fields := reader.Schema().Fields()
+	keys := make([][]byte, len(fields))
+	for i, f := range fields {
+		key, err := json.Marshal(f.Name)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+
+	bw := bufio.NewWriterSize(out, jsonBufferSize)
+
+	if format == JSONArray {
+		bw.WriteByte('[')
+	}
+
+	var buf []byte
+	first := true
+	for reader.Next() {
+		rec := reader.Record()
+		for row := 0; row < int(rec.NumRows()); row++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if format == JSONArray && !first {
+				bw.WriteByte(',')
+			}
+			first = false
+
+			bw.WriteByte('{')
+			for col := 0; col < int(rec.NumCols()); col++ {
+				if col > 0 {
+					bw.WriteByte(',')
+				}
+				bw.Write(keys[col])
+				bw.WriteByte(':')
+
+				var err error
+				if buf, err = appendJSONValue(buf[:0], rec.Column(col), row); err != nil {
+					return fmt.Errorf("luna: can't encode column %s as JSON: %w", fields[col].Name, err)
+				}
+				bw.Write(buf)
+			}
+			bw.WriteByte('}')
+			if format == NDJSON {
+				bw.WriteByte('\n')
+			}
+
+			// Write errors are sticky, so checking once per row is enough
+			if out.err != nil {
+				return out.err
+			}
+		}
+	}
+
+	if err := reader.Err(); err != nil {
+		return err
+	}
+	if format == JSONArray {
+		bw.WriteByte(']')
+	}
+	return bw.Flush()
+}
+
+// flushWriter flushes an HTTP response after each write, and remembers the
+// first write error.
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	err     error
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	if fw.err != nil {
+		return 0, fw.err
+	}
+
+	n, err := fw.w.Write(p)
+	if err != nil {
+		fw.err = err
+		return n, err
+	}
+	if fw.flusher != nil {
+		fw.flusher.Flush()
+	}
+	return n, nil
+}
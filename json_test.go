@@ -0,0 +1,181 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestWriteJSON(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			if cmd != "q:SELECT id, name FROM users" {
+				conn.Write([]byte("$-1\r\n"))
+				continue
+			}
+
+			schema := arrow.NewSchema([]arrow.Field{
+				{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+				{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+			}, nil)
+			var recs []arrow.Record
+			for batch := 0; batch < 2; batch++ {
+				b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+				b.Field(0).(*array.Int64Builder).Append(int64(batch + 1))
+				if batch == 0 {
+					b.Field(1).(*array.StringBuilder).Append(`a "quoted" name`)
+				} else {
+					b.Field(1).AppendNull()
+				}
+				recs = append(recs, b.NewRecord())
+				b.Release()
+			}
+			writeArrowReply(conn, schema, recs...)
+			releaseTestRecords(recs)
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	testCases := []struct {
+		name        string
+		format      JSONFormat
+		query       string
+		contentType string
+		expected    string
+	}{
+		{
+			name:        "array",
+			format:      JSONArray,
+			query:       "SELECT id, name FROM users",
+			contentType: "application/json",
+			expected:    `[{"id":1,"name":"a \"quoted\" name"},{"id":2,"name":null}]`,
+		},
+		{
+			name:        "ndjson",
+			format:      NDJSON,
+			query:       "SELECT id, name FROM users",
+			contentType: "application/x-ndjson",
+			expected:    "{\"id\":1,\"name\":\"a \\\"quoted\\\" name\"}\n{\"id\":2,\"name\":null}\n",
+		},
+		{
+			name:        "empty array",
+			format:      JSONArray,
+			query:       "SELECT NULL",
+			contentType: "application/json",
+			expected:    "[]",
+		},
+		{
+			name:        "empty ndjson",
+			format:      NDJSON,
+			query:       "SELECT NULL",
+			contentType: "application/x-ndjson",
+			expected:    "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := db.Conn(context.Background())
+			if err != nil {
+				t.Fatalf("failed to get connection: %v", err)
+			}
+			defer conn.Close()
+
+			rec := httptest.NewRecorder()
+			if err := WriteJSON(context.Background(), rec, conn, tc.query, tc.format); err != nil {
+				t.Fatalf("WriteJSON failed: %v", err)
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != tc.contentType {
+				t.Errorf("expected content type %s, got %s", tc.contentType, got)
+			}
+			if got := rec.Body.String(); got != tc.expected {
+				t.Errorf("expected body %q, got %q", tc.expected, got)
+			}
+			if tc.expected != "" && !rec.Flushed {
+				t.Error("expected the response to be flushed")
+			}
+		})
+	}
+}
+
+func TestWriteJSONCanceled(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+
+			// Enough rows to fill the write buffer several times
+			schema := arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil)
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+			for i := 0; i < 20000; i++ {
+				b.Field(0).(*array.Int64Builder).Append(int64(i))
+			}
+			rec := b.NewRecord()
+			b.Release()
+			writeArrowReply(conn, schema, rec)
+			rec.Release()
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	// Cancel once the first chunk reaches the writer, like a client disconnecting
+	// mid-response
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancelingWriter{cancel: cancel}
+
+	err = WriteJSON(ctx, w, conn, "SELECT n FROM t", JSONArray)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	// The array is left unterminated
+	body := w.String()
+	if !strings.HasPrefix(body, `[{"n":0},`) || strings.HasSuffix(body, "]") {
+		t.Errorf("expected a truncated array, got %d bytes ending in %q", len(body), body[max(0, len(body)-20):])
+	}
+	if len(body) > jsonBufferSize {
+		t.Errorf("expected at most one buffer to be written, got %d bytes", len(body))
+	}
+}
+
+type cancelingWriter struct {
+	strings.Builder
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.Builder.Write(p)
+}
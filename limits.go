@@ -0,0 +1,50 @@
+package luna
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// ErrResultTooLarge is returned for queries whose result has more rows or bytes
+// than the max_result_rows or max_result_bytes limit. The rest of the result is
+// read and discarded, so the connection stays usable.
+var ErrResultTooLarge = errors.New("luna: result too large")
+
+// resultLimitError describes the limit a result exceeded.
+type resultLimitError struct {
+	param string
+	unit  string
+	limit int
+}
+
+func (e *resultLimitError) Error() string {
+	return fmt.Sprintf("%v: more than %d %s, the %s limit", ErrResultTooLarge, e.limit, e.unit, e.param)
+}
+
+func (e *resultLimitError) Is(target error) bool { return target == ErrResultTooLarge }
+
+// limitResult returns checkpoint extended to stop decoding the result of a query
+// once it has more rows or bytes than allowed, counting the parts of a split
+// query received before it. The rest of the result is drained.
+func (c *Conn) limitResult(checkpoint wire.Checkpoint) wire.Checkpoint {
+	if c.maxResultRows <= 0 && c.maxResultBytes <= 0 {
+		return checkpoint
+	}
+
+	rows, bytes := c.resultRows, c.resultBytes
+	return func(p wire.Progress) error {
+		c.resultRows, c.resultBytes = rows+p.Rows, bytes+p.Bytes
+		if c.maxResultRows > 0 && c.resultRows > int64(c.maxResultRows) {
+			return &wire.DrainError{Err: &resultLimitError{param: "max_result_rows", unit: "rows", limit: c.maxResultRows}}
+		}
+		if c.maxResultBytes > 0 && c.resultBytes > int64(c.maxResultBytes) {
+			return &wire.DrainError{Err: &resultLimitError{param: "max_result_bytes", unit: "bytes", limit: c.maxResultBytes}}
+		}
+		if checkpoint != nil {
+			return checkpoint(p)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMaxResultSize(t *testing.T) {
+	// Five batches of two rows, followed by a trailer
+	var big bytes.Buffer
+	rec := newValuesRecord(t, []string{"n"}, []any{int64(1)}, []any{int64(2)})
+	defer rec.Release()
+	writeArrowReply(&big, announceTrailer(rec.Schema()), rec, rec, rec, rec, rec)
+	big.Write(trailerFrame(`{"rows":10}`))
+
+	var conns atomic.Int32
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		conns.Add(1)
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(cmd, "q:SELECT n FROM big"):
+				conn.Write(big.Bytes())
+			default:
+				conn.Write(arrowReply(t, "n", 1, 2))
+			}
+		}
+	})
+
+	testCases := []struct {
+		name     string
+		params   string
+		query    string
+		expected string
+	}{
+		{
+			name:   "within limits",
+			params: "max_result_rows=10",
+			query:  "SELECT n FROM big",
+		},
+		{
+			name:     "rows",
+			params:   "max_result_rows=5",
+			query:    "SELECT n FROM big",
+			expected: "more than 5 rows, the max_result_rows limit",
+		},
+		{
+			name:     "bytes",
+			params:   "max_result_bytes=" + strconv.Itoa(big.Len()/2),
+			query:    "SELECT n FROM big",
+			expected: "bytes, the max_result_bytes limit",
+		},
+		{
+			// The parts of a split query count together
+			name:     "split query",
+			params:   "max_result_rows=3&max_in_list=2",
+			query:    "SELECT n FROM t WHERE n IN (1, 2, 3, 4)",
+			expected: "more than 3 rows",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := sql.Open("luna", addr+"?result_trailers=true&"+tc.params)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			conns.Store(0)
+			rows, err := db.Query(tc.query)
+			if tc.expected == "" {
+				if err != nil {
+					t.Fatalf("query failed: %v", err)
+				}
+				rows.Close()
+				return
+			}
+			if !errors.Is(err, ErrResultTooLarge) || !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("expected an error matching ErrResultTooLarge with %q, got %v", tc.expected, err)
+			}
+
+			// The result was drained, so the connection is still usable
+			var n int64
+			if err := db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+				t.Fatalf("expected the next query to succeed, got %d %v", n, err)
+			}
+			if got := conns.Load(); got != 1 {
+				t.Errorf("expected the connection to be reused, got %d connections", got)
+			}
+		})
+	}
+}
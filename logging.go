@@ -0,0 +1,152 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+)
+
+// LogLevelOff is a log level above all others, which disables logging when used
+// as Config.LogLevel.
+const LogLevelOff = slog.Level(math.MaxInt)
+
+// QueryLogMode selects how the text of statements appears in the driver's logs.
+type QueryLogMode int
+
+const (
+	// QueryLogRedacted logs statements with their string and number literals
+	// replaced by ?, so that logs don't hold the data in them. This is the
+	// default.
+	QueryLogRedacted QueryLogMode = iota
+	// QueryLogFull logs statements as sent.
+	QueryLogFull
+	// QueryLogOff leaves statements out of log records.
+	QueryLogOff
+)
+
+// queryLogModes maps the values of the log_queries DSN parameter to modes.
+var queryLogModes = map[string]QueryLogMode{
+	"redacted": QueryLogRedacted,
+	"full":     QueryLogFull,
+	"off":      QueryLogOff,
+}
+
+func parseQueryLogParam(v string, dst *QueryLogMode) error {
+	mode, ok := queryLogModes[v]
+	if !ok {
+		return fmt.Errorf("must be redacted, full or off")
+	}
+	*dst = mode
+	return nil
+}
+
+// logLevels maps the values of the log_level DSN parameter to levels.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+	"off":   LogLevelOff,
+}
+
+func parseLogLevelParam(v string, dst *slog.Leveler) error {
+	level, ok := logLevels[strings.ToLower(v)]
+	if !ok {
+		return fmt.Errorf("must be debug, info, warn, error or off")
+	}
+	*dst = level
+	return nil
+}
+
+// levelHandler drops the records below a minimum level before they reach the
+// handler it wraps.
+type levelHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// leveledLogger returns logger with records below level dropped, or a logger
+// that discards everything if logger is nil. A nil level leaves filtering to
+// the logger's handler.
+func leveledLogger(logger *slog.Logger, level slog.Leveler) *slog.Logger {
+	switch {
+	case logger == nil:
+		return slog.New(slog.DiscardHandler)
+	case level == nil:
+		return logger
+	case level.Level() == LogLevelOff:
+		return slog.New(slog.DiscardHandler)
+	}
+	return slog.New(&levelHandler{Handler: logger.Handler(), level: level})
+}
+
+// queryAttr returns the log attribute of a statement, as selected by the
+// connection's query log mode. An empty attribute is ignored by handlers.
+func (c *Conn) queryAttr(query string) slog.Attr {
+	switch c.logQueries {
+	case QueryLogFull:
+		return slog.String("query", query)
+	case QueryLogOff:
+		return slog.Attr{}
+	}
+	return slog.String("query", redactQuery(query))
+}
+
+// redactQuery replaces the string and number literals of query with ?, keeping
+// identifiers, including quoted ones, and keywords.
+func redactQuery(query string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'':
+			i = skipSQLQuoted(query, i)
+			b.WriteByte('?')
+			continue
+		case c == '"':
+			end := skipSQLQuoted(query, i)
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		case isSQLWordStart(c):
+			// Keep digits inside identifiers, e.g. t1
+			start := i
+			for i < len(query) && isSQLWordChar(query[i]) {
+				i++
+			}
+			b.WriteString(query[start:i])
+			continue
+		case isSQLDigit(c) || c == '.' && i+1 < len(query) && isSQLDigit(query[i+1]):
+			for i < len(query) && (isSQLWordChar(query[i]) || query[i] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+func isSQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSQLWordChar(c byte) bool {
+	return isSQLWordStart(c) || isSQLDigit(c)
+}
@@ -0,0 +1,102 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRedactQuery(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT 1", "SELECT ?"},
+		{"SELECT * FROM t1 WHERE name = 'it''s' AND v > 1.5e3", "SELECT * FROM t1 WHERE name = ? AND v > ?"},
+		{`SELECT "col 2" FROM "t" LIMIT .5`, `SELECT "col 2" FROM "t" LIMIT ?`},
+		{"INSERT INTO t VALUES ($1, 'x')", "INSERT INTO t VALUES ($?, ?)"},
+	}
+
+	for _, tc := range testCases {
+		if got := redactQuery(tc.query); got != tc.expected {
+			t.Errorf("redactQuery(%q) = %q, expected %q", tc.query, got, tc.expected)
+		}
+	}
+}
+
+func TestQueryLogging(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write(arrowReply(t, "1", 1))
+		}
+	})
+
+	const query = "SELECT 1 FROM users WHERE email = 'a@example.com'"
+	testCases := []struct {
+		name     string
+		dsn      string
+		opts     []Option
+		expected string
+		absent   string
+	}{
+		{name: "info level", dsn: addr, opts: []Option{WithLogLevel(slog.LevelInfo)}, absent: "QueryContext called"},
+		{name: "redacted", dsn: addr + "?log_level=debug", expected: `query="SELECT ? FROM users WHERE email = ?"`, absent: "example.com"},
+		{name: "full", dsn: addr + "?log_level=debug&log_queries=full", expected: "a@example.com"},
+		{name: "off", dsn: addr + "?log_level=debug&log_queries=off", expected: "QueryContext called", absent: "query="},
+		{name: "logging off", dsn: addr + "?log_level=off", absent: "connecting"},
+		{name: "option", dsn: addr, opts: []Option{WithLogLevel(slog.LevelDebug), WithQueryLogging(QueryLogFull)}, expected: "a@example.com"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			// The handler's own level lets everything through
+			opts := append([]Option{WithLogHandler(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug - 4}))}, tc.opts...)
+			connector, err := NewConnectorWithOptions(tc.dsn, opts...)
+			if err != nil {
+				t.Fatalf("failed to create connector: %v", err)
+			}
+			dc, err := connector.Connect(context.Background())
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer dc.Close()
+			rows, err := dc.(*Conn).QueryContext(context.Background(), query, nil)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			rows.Close()
+
+			if tc.expected != "" && !strings.Contains(logs.String(), tc.expected) {
+				t.Errorf("expected logs to contain %q, got %q", tc.expected, logs.String())
+			}
+			if tc.absent != "" && strings.Contains(logs.String(), tc.absent) {
+				t.Errorf("expected logs not to contain %q, got %q", tc.absent, logs.String())
+			}
+		})
+	}
+}
+
+func TestLogHandlerNil(t *testing.T) {
+	connector, err := NewConnectorWithOptions("localhost:7688", WithLogHandler(nil))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	if logger := connector.liveConfig().logger; logger == nil || logger.Enabled(context.Background(), slog.LevelError) {
+		t.Errorf("expected a logger that discards everything, got %v", logger)
+	}
+
+	if _, err := ParseDSN("localhost:7688?log_queries=some"); err == nil {
+		t.Error("expected an error for an invalid log_queries value")
+	}
+	if _, err := ParseDSN("localhost:7688?log_level=trace"); err == nil {
+		t.Error("expected an error for an invalid log_level value")
+	}
+}
@@ -0,0 +1,153 @@
+package luna
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// MemoryStats reports the Arrow memory used to decode a query result.
+type MemoryStats struct {
+	// Total bytes allocated while decoding the result.
+	Allocated int64
+	// Total bytes released so far. Record buffers are released when Rows is closed.
+	Released int64
+}
+
+// InUse returns the number of bytes still held by the result.
+func (s MemoryStats) InUse() int64 {
+	return s.Allocated - s.Released
+}
+
+// trackingAllocator wraps a memory.Allocator and counts the bytes allocated and
+// released through it, so memory usage can be attributed to a single query.
+type trackingAllocator struct {
+	mem       memory.Allocator
+	allocated atomic.Int64
+	released  atomic.Int64
+}
+
+func newTrackingAllocator(mem memory.Allocator) *trackingAllocator {
+	return &trackingAllocator{mem: mem}
+}
+
+// Implements the memory.Allocator interface.
+func (a *trackingAllocator) Allocate(size int) []byte {
+	a.allocated.Add(int64(size))
+	return a.mem.Allocate(size)
+}
+
+// Implements the memory.Allocator interface.
+func (a *trackingAllocator) Reallocate(size int, b []byte) []byte {
+	a.released.Add(int64(len(b)))
+	a.allocated.Add(int64(size))
+	return a.mem.Reallocate(size, b)
+}
+
+// Implements the memory.Allocator interface.
+func (a *trackingAllocator) Free(b []byte) {
+	a.released.Add(int64(len(b)))
+	a.mem.Free(b)
+}
+
+// stats returns a snapshot of the allocator's counters.
+func (a *trackingAllocator) stats() MemoryStats {
+	return MemoryStats{
+		Allocated: a.allocated.Load(),
+		Released:  a.released.Load(),
+	}
+}
+
+// Buffers handed out by PoolAllocator are aligned like those of
+// memory.GoAllocator, and pooled in power-of-two size classes from 64 bytes to
+// 1 GiB. Larger buffers aren't pooled.
+const (
+	poolAlignment = 64
+	poolMinClass  = 6
+	poolMaxClass  = 30
+)
+
+// PoolAllocator is a memory.Allocator that keeps the buffers of released query
+// results and reuses them for later results, instead of leaving them to the
+// garbage collector. Arrow allocates the bodies of the IPC messages it reads
+// from the connection with it too, so in high-QPS services most of the memory
+// needed to read and decode a result comes from buffers of earlier results.
+//
+// Share a single PoolAllocator between connectors with WithAllocator, or set
+// buffer_pool=true in the DSN. Pooled buffers are still reclaimed by the garbage
+// collector when they stay unused, like those of a sync.Pool. Buffers are zeroed
+// before they are reused. It's safe for concurrent use.
+type PoolAllocator struct {
+	pools [poolMaxClass - poolMinClass + 1]sync.Pool
+}
+
+// NewPoolAllocator returns a new, empty PoolAllocator.
+func NewPoolAllocator() *PoolAllocator {
+	return &PoolAllocator{}
+}
+
+// poolClass returns the size class of a buffer of the given size, i.e. the
+// exponent of the smallest power of two that holds it, or -1 if it's too large
+// to be pooled.
+func poolClass(size int) int {
+	class := max(bits.Len(uint(size-1)), poolMinClass)
+	if class > poolMaxClass {
+		return -1
+	}
+	return class
+}
+
+// Implements the memory.Allocator interface.
+func (a *PoolAllocator) Allocate(size int) []byte {
+	class := poolClass(size)
+	if class < 0 {
+		return allocateAligned(size, size)
+	}
+	if p, ok := a.pools[class-poolMinClass].Get().(*[]byte); ok {
+		b := (*p)[:size]
+		clear(b)
+		return b
+	}
+	return allocateAligned(size, 1<<class)
+}
+
+// Implements the memory.Allocator interface.
+func (a *PoolAllocator) Reallocate(size int, b []byte) []byte {
+	if size <= cap(b) {
+		if size > len(b) {
+			clear(b[len(b):size])
+		}
+		return b[:size]
+	}
+	nb := a.Allocate(size)
+	copy(nb, b)
+	a.Free(b)
+	return nb
+}
+
+// Implements the memory.Allocator interface. Buffers that weren't allocated by
+// a PoolAllocator are left to the garbage collector.
+func (a *PoolAllocator) Free(b []byte) {
+	c := cap(b)
+	if c == 0 || c&(c-1) != 0 {
+		return
+	}
+	class := poolClass(c)
+	if class < 0 || 1<<class != c || uintptr(unsafe.Pointer(unsafe.SliceData(b)))%poolAlignment != 0 {
+		return
+	}
+	b = b[:0]
+	a.pools[class-poolMinClass].Put(&b)
+}
+
+// allocateAligned returns a zeroed buffer of the given size and capacity,
+// starting at an address that is a multiple of poolAlignment.
+func allocateAligned(size, capacity int) []byte {
+	buf := make([]byte, capacity+poolAlignment)
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(buf)))
+	off := int((poolAlignment - addr%poolAlignment) % poolAlignment)
+	return buf[off : off+size : off+capacity]
+}
@@ -0,0 +1,137 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestPoolAllocator(t *testing.T) {
+	mem := NewPoolAllocator()
+
+	testCases := []struct {
+		size     int
+		capacity int
+	}{
+		{size: 0, capacity: 0},
+		{size: 1, capacity: 64},
+		{size: 64, capacity: 64},
+		{size: 65, capacity: 128},
+		{size: 5000, capacity: 8192},
+	}
+
+	for _, tc := range testCases {
+		// Dirty a buffer of the same class, so that reusing it must zero it
+		dirty := mem.Allocate(tc.size)
+		for i := range dirty {
+			dirty[i] = 0xFF
+		}
+		mem.Free(dirty)
+
+		b := mem.Allocate(tc.size)
+		if len(b) != tc.size || cap(b) != tc.capacity {
+			t.Errorf("Allocate(%d): expected len %d and cap %d, got %d and %d", tc.size, tc.size, tc.capacity, len(b), cap(b))
+		}
+		if tc.size > 0 && uintptr(unsafe.Pointer(&b[0]))%64 != 0 {
+			t.Errorf("Allocate(%d): buffer isn't 64-byte aligned", tc.size)
+		}
+		for i, c := range b {
+			if c != 0 {
+				t.Errorf("Allocate(%d): byte %d isn't zeroed", tc.size, i)
+				break
+			}
+		}
+		mem.Free(b)
+	}
+}
+
+func TestPoolAllocatorReallocate(t *testing.T) {
+	mem := NewPoolAllocator()
+
+	b := mem.Allocate(10)
+	copy(b, "0123456789")
+
+	// Shrinking and growing within the capacity keeps the buffer, with the grown
+	// bytes zeroed
+	b = mem.Reallocate(4, b)
+	b = mem.Reallocate(8, b)
+	if string(b) != "0123\x00\x00\x00\x00" {
+		t.Errorf("expected the first bytes kept and the rest zeroed, got %q", b)
+	}
+
+	b = mem.Reallocate(100, b)
+	if len(b) != 100 || cap(b) != 128 || string(b[:4]) != "0123" {
+		t.Errorf("expected a 128-byte buffer starting with 0123, got len %d, cap %d, %q", len(b), cap(b), b[:4])
+	}
+	mem.Free(b)
+
+	// Buffers from elsewhere are left alone
+	mem.Free(make([]byte, 100))
+	mem.Free(memory.NewGoAllocator().Allocate(64)[1:])
+}
+
+func TestPoolAllocatorQueries(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for n := int64(0); ; n++ {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+				{Name: "n", Type: arrow.PrimitiveTypes.Int64},
+				{Name: "s", Type: arrow.BinaryTypes.String},
+			}, nil))
+			for i := int64(0); i < 1000; i++ {
+				b.Field(0).(*array.Int64Builder).Append(n*1000 + i)
+				b.Field(1).(*array.StringBuilder).Append("row")
+			}
+			rec := b.NewRecord()
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+			b.Release()
+		}
+	})
+
+	mem := memory.NewCheckedAllocator(NewPoolAllocator())
+	connector, err := NewConnectorWithOptions(addr, WithAllocator(mem))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	// Each result reuses the buffers of the previous one, which must not leak
+	// into it
+	for q := int64(0); q < 5; q++ {
+		rows, err := db.QueryContext(context.Background(), "SELECT n, s FROM t")
+		if err != nil {
+			t.Fatalf("query %d failed: %v", q, err)
+		}
+		var i int64
+		for ; rows.Next(); i++ {
+			var n int64
+			var s string
+			if err := rows.Scan(&n, &s); err != nil {
+				t.Fatalf("query %d: scan failed: %v", q, err)
+			}
+			if n != q*1000+i || s != "row" {
+				t.Fatalf("query %d, row %d: got %d, %q", q, i, n, s)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatalf("query %d: close failed: %v", q, err)
+		}
+		if i != 1000 {
+			t.Errorf("query %d: expected 1000 rows, got %d", q, i)
+		}
+	}
+
+	mem.AssertSize(t, 0)
+}
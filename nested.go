@@ -0,0 +1,241 @@
+package luna
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+// NestedMode selects how Rows returns the values of nested columns: lists, such
+// as the results of DuckDB's list() aggregate, structs and maps.
+type NestedMode int
+
+const (
+	// NestedAsJSON returns each nested value as JSON in a string, e.g. `[1,2,null]`
+	// or `{"a":1,"b":"x"}`, which scans into a string, []byte or json.RawMessage.
+	// Struct fields and map entries keep their order, and map keys that aren't
+	// strings are written as the text of their JSON encoding. This is the default.
+	NestedAsJSON NestedMode = iota
+	// NestedAsGo returns each list as a []any, each struct as a map[string]any, and
+	// each map as a []MapEntry in the map's order, holding their elements converted
+	// the same way as scalar columns, nested values included. Scan them into an *any.
+	NestedAsGo
+)
+
+// MapEntry is a key/value pair of a map value returned in NestedAsGo mode. Maps
+// are returned as slices of entries, since their keys may be of any type,
+// including lists.
+type MapEntry struct {
+	// Key is never nil, since Arrow doesn't allow NULL keys.
+	Key any
+	// Value is nil if the value is NULL.
+	Value any
+}
+
+// nestedModes maps the values of the nested_mode DSN parameter to nested modes.
+var nestedModes = map[string]NestedMode{
+	"json": NestedAsJSON,
+	"go":   NestedAsGo,
+}
+
+var (
+	scanTypeSlice      = reflect.TypeOf([]any(nil))
+	scanTypeMap        = reflect.TypeOf(map[string]any(nil))
+	scanTypeMapEntries = reflect.TypeOf([]MapEntry(nil))
+)
+
+func parseNestedModeParam(v string, dst *NestedMode) error {
+	mode, ok := nestedModes[v]
+	if !ok {
+		return fmt.Errorf("must be json or go")
+	}
+	*dst = mode
+	return nil
+}
+
+// nestedScanType returns the Go type of the values of a nested column in the
+// given mode, or nil if dt isn't a nested type.
+func nestedScanType(dt arrow.DataType, mode NestedMode) reflect.Type {
+	switch dt.ID() {
+	case arrow.LIST, arrow.LARGE_LIST, arrow.FIXED_SIZE_LIST:
+		if mode == NestedAsGo {
+			return scanTypeSlice
+		}
+		return scanTypeString
+	case arrow.STRUCT:
+		if mode == NestedAsGo {
+			return scanTypeMap
+		}
+		return scanTypeString
+	case arrow.MAP:
+		if mode == NestedAsGo {
+			return scanTypeMapEntries
+		}
+		return scanTypeString
+	default:
+		return nil
+	}
+}
+
+// nestedValue extracts the nested value at rowIdx of a list, struct or map array,
+// in the mode of opts. The values nested in Go values are converted according to opts.
+func nestedValue(arr arrow.Array, rowIdx int, opts valueOptions) (any, error) {
+	if opts.nestedMode == NestedAsGo {
+		return goValue(arr, rowIdx, opts)
+	}
+
+	b, err := appendJSONValue(nil, arr, rowIdx)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// goValue extracts the value at rowIdx, with lists as []any, structs as
+// map[string]any and maps as []MapEntry.
+func goValue(arr arrow.Array, rowIdx int, opts valueOptions) (any, error) {
+	if arr.IsNull(rowIdx) {
+		return nil, nil
+	}
+
+	switch arr := arr.(type) {
+	case *array.List, *array.LargeList, *array.FixedSizeList:
+		list := arr.(array.ListLike)
+		values := list.ListValues()
+		start, end := list.ValueOffsets(rowIdx)
+		elems := make([]any, 0, end-start)
+		for i := start; i < end; i++ {
+			v, err := goValue(values, int(i), opts)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+		return elems, nil
+	case *array.Struct:
+		fields := arr.DataType().(*arrow.StructType).Fields()
+		m := make(map[string]any, len(fields))
+		for i, f := range fields {
+			v, err := goValue(arr.Field(i), rowIdx, opts)
+			if err != nil {
+				return nil, err
+			}
+			m[f.Name] = v
+		}
+		return m, nil
+	case *array.Map:
+		keys, items := arr.Keys(), arr.Items()
+		start, end := arr.ValueOffsets(rowIdx)
+		entries := make([]MapEntry, 0, end-start)
+		for i := int(start); i < int(end); i++ {
+			k, err := goValue(keys, i, opts)
+			if err != nil {
+				return nil, err
+			}
+			v, err := goValue(items, i, opts)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, MapEntry{Key: k, Value: v})
+		}
+		return entries, nil
+	case *array.Dictionary:
+		return goValue(arr.Dictionary(), arr.GetValueIndex(rowIdx), opts)
+	default:
+		return getValueFromColumn(arr, rowIdx, opts)
+	}
+}
+
+// appendJSONKey appends the map key at i to b as a JSON object key. Keys that
+// aren't strings are written as the text of their JSON encoding, e.g. "1". Arrow
+// doesn't allow NULL keys, so keys are never NULL.
+func appendJSONKey(b []byte, keys arrow.Array, i int) ([]byte, error) {
+	key, err := appendJSONValue(nil, keys, i)
+	if err != nil {
+		return nil, err
+	}
+	if key[0] == '"' {
+		return append(b, key...), nil
+	}
+
+	quoted, err := json.Marshal(string(key))
+	if err != nil {
+		return nil, err
+	}
+	return append(b, quoted...), nil
+}
+
+// appendJSONValue appends the value at rowIdx to b as JSON. Struct fields are
+// written in schema order, and maps as objects in entry order.
+func appendJSONValue(b []byte, arr arrow.Array, rowIdx int) ([]byte, error) {
+	if arr.IsNull(rowIdx) {
+		return append(b, "null"...), nil
+	}
+
+	var err error
+	switch arr := arr.(type) {
+	case *array.List, *array.LargeList, *array.FixedSizeList:
+		list := arr.(array.ListLike)
+		values := list.ListValues()
+		start, end := list.ValueOffsets(rowIdx)
+		b = append(b, '[')
+		for i := start; i < end; i++ {
+			if i > start {
+				b = append(b, ',')
+			}
+			if b, err = appendJSONValue(b, values, int(i)); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, ']'), nil
+	case *array.Struct:
+		fields := arr.DataType().(*arrow.StructType).Fields()
+		b = append(b, '{')
+		for i, f := range fields {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			key, err := json.Marshal(f.Name)
+			if err != nil {
+				return nil, err
+			}
+			b = append(append(b, key...), ':')
+			if b, err = appendJSONValue(b, arr.Field(i), rowIdx); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	case *array.Map:
+		keys, items := arr.Keys(), arr.Items()
+		start, end := arr.ValueOffsets(rowIdx)
+		b = append(b, '{')
+		for i := int(start); i < int(end); i++ {
+			if i > int(start) {
+				b = append(b, ',')
+			}
+			if b, err = appendJSONKey(b, keys, i); err != nil {
+				return nil, err
+			}
+			b = append(b, ':')
+			if b, err = appendJSONValue(b, items, i); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	case *array.Dictionary:
+		return appendJSONValue(b, arr.Dictionary(), arr.GetValueIndex(rowIdx))
+	default:
+		v, err := getValueFromColumn(arr, rowIdx, valueOptions{})
+		if err != nil {
+			return nil, err
+		}
+		enc, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("can't encode %v as JSON: %w", v, err)
+		}
+		return append(b, enc...), nil
+	}
+}
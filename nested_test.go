@@ -0,0 +1,311 @@
+package luna
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// newListRecord builds a record with three rows of list columns:
+//
+//	ints      [1, null, 3]   null      []
+//	strings   ["a"]          ["b", ""] null
+//	pairs     [0.5, 1.5]     [2, 3]    [4, 5]
+//	nested    [[1], [2, 3]]  []        [[]]
+func newListRecord(t *testing.T) arrow.Record {
+	t.Helper()
+	mem := memory.NewGoAllocator()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "ints", Type: arrow.ListOf(arrow.PrimitiveTypes.Int64), Nullable: true},
+		{Name: "strings", Type: arrow.LargeListOf(arrow.BinaryTypes.String), Nullable: true},
+		{Name: "pairs", Type: arrow.FixedSizeListOf(2, arrow.PrimitiveTypes.Float64)},
+		{Name: "nested", Type: arrow.ListOf(arrow.ListOf(arrow.PrimitiveTypes.Int32))},
+	}, nil)
+	b := array.NewRecordBuilder(mem, schema)
+	defer b.Release()
+
+	ints := b.Field(0).(*array.ListBuilder)
+	intValues := ints.ValueBuilder().(*array.Int64Builder)
+	ints.Append(true)
+	intValues.AppendValues([]int64{1, 0, 3}, []bool{true, false, true})
+	ints.AppendNull()
+	ints.Append(true)
+
+	strs := b.Field(1).(*array.LargeListBuilder)
+	strValues := strs.ValueBuilder().(*array.StringBuilder)
+	strs.Append(true)
+	strValues.Append("a")
+	strs.Append(true)
+	strValues.AppendValues([]string{"b", ""}, nil)
+	strs.AppendNull()
+
+	pairs := b.Field(2).(*array.FixedSizeListBuilder)
+	pairValues := pairs.ValueBuilder().(*array.Float64Builder)
+	for i := 0; i < 3; i++ {
+		pairs.Append(true)
+	}
+	pairValues.AppendValues([]float64{0.5, 1.5, 2, 3, 4, 5}, nil)
+
+	nested := b.Field(3).(*array.ListBuilder)
+	inner := nested.ValueBuilder().(*array.ListBuilder)
+	innerValues := inner.ValueBuilder().(*array.Int32Builder)
+	nested.Append(true)
+	inner.Append(true)
+	innerValues.Append(1)
+	inner.Append(true)
+	innerValues.AppendValues([]int32{2, 3}, nil)
+	nested.Append(true)
+	nested.Append(true)
+	inner.Append(true)
+
+	return b.NewRecord()
+}
+
+func TestRowsListColumns(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     NestedMode
+		scanType reflect.Type
+		expected [][]driver.Value
+	}{
+		{
+			name:     "json",
+			mode:     NestedAsJSON,
+			scanType: scanTypeString,
+			expected: [][]driver.Value{
+				{"[1,null,3]", `["a"]`, "[0.5,1.5]", "[[1],[2,3]]"},
+				{nil, `["b",""]`, "[2,3]", "[]"},
+				{"[]", nil, "[4,5]", "[[]]"},
+			},
+		},
+		{
+			name:     "slice",
+			mode:     NestedAsGo,
+			scanType: scanTypeSlice,
+			expected: [][]driver.Value{
+				{[]any{int64(1), nil, int64(3)}, []any{"a"}, []any{0.5, 1.5}, []any{[]any{int32(1)}, []any{int32(2), int32(3)}}},
+				{nil, []any{"b", ""}, []any{2.0, 3.0}, []any{}},
+				{[]any{}, nil, []any{4.0, 5.0}, []any{[]any{}}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := newListRecord(t)
+			rows := newRowsFromArrow(rec.Schema(), []arrow.Record{rec})
+			rows.nestedMode = tc.mode
+			defer rows.Close()
+
+			for i := range rows.Columns() {
+				if got := rows.ColumnTypeScanType(i); got != tc.scanType {
+					t.Errorf("column %d: expected scan type %v, got %v", i, tc.scanType, got)
+				}
+			}
+
+			for i, expected := range tc.expected {
+				dest := make([]driver.Value, len(rows.Columns()))
+				if err := rows.Next(dest); err != nil {
+					t.Fatalf("row %d: Next failed: %v", i, err)
+				}
+				if !reflect.DeepEqual(dest, expected) {
+					t.Errorf("row %d: expected %#v, got %#v", i, expected, dest)
+				}
+			}
+		})
+	}
+}
+
+// newStructRecord builds a record with three rows of struct columns, with
+// fields out of alphabetical order:
+//
+//	point   {b: 1, a: "x"}  null          {b: 2, a: null}
+//	items   [{id: 1}]       [{id: null}]  []
+func newStructRecord(t *testing.T) arrow.Record {
+	t.Helper()
+	mem := memory.NewGoAllocator()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "point", Type: arrow.StructOf(
+			arrow.Field{Name: "b", Type: arrow.PrimitiveTypes.Int64},
+			arrow.Field{Name: "a", Type: arrow.BinaryTypes.String, Nullable: true},
+		), Nullable: true},
+		{Name: "items", Type: arrow.ListOf(arrow.StructOf(
+			arrow.Field{Name: "id", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+		))},
+	}, nil)
+	b := array.NewRecordBuilder(mem, schema)
+	defer b.Release()
+
+	point := b.Field(0).(*array.StructBuilder)
+	pointB := point.FieldBuilder(0).(*array.Int64Builder)
+	pointA := point.FieldBuilder(1).(*array.StringBuilder)
+	point.Append(true)
+	pointB.Append(1)
+	pointA.Append("x")
+	point.AppendNull()
+	point.Append(true)
+	pointB.Append(2)
+	pointA.AppendNull()
+
+	items := b.Field(1).(*array.ListBuilder)
+	item := items.ValueBuilder().(*array.StructBuilder)
+	itemID := item.FieldBuilder(0).(*array.Int32Builder)
+	items.Append(true)
+	item.Append(true)
+	itemID.Append(1)
+	items.Append(true)
+	item.Append(true)
+	itemID.AppendNull()
+	items.Append(true)
+
+	return b.NewRecord()
+}
+
+func TestRowsStructColumns(t *testing.T) {
+	testCases := []struct {
+		name      string
+		mode      NestedMode
+		scanTypes []reflect.Type
+		expected  [][]driver.Value
+	}{
+		{
+			name:      "json",
+			mode:      NestedAsJSON,
+			scanTypes: []reflect.Type{scanTypeString, scanTypeString},
+			expected: [][]driver.Value{
+				{`{"b":1,"a":"x"}`, `[{"id":1}]`},
+				{nil, `[{"id":null}]`},
+				{`{"b":2,"a":null}`, "[]"},
+			},
+		},
+		{
+			name:      "go",
+			mode:      NestedAsGo,
+			scanTypes: []reflect.Type{scanTypeMap, scanTypeSlice},
+			expected: [][]driver.Value{
+				{map[string]any{"b": int64(1), "a": "x"}, []any{map[string]any{"id": int32(1)}}},
+				{nil, []any{map[string]any{"id": nil}}},
+				{map[string]any{"b": int64(2), "a": nil}, []any{}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := newStructRecord(t)
+			rows := newRowsFromArrow(rec.Schema(), []arrow.Record{rec})
+			rows.nestedMode = tc.mode
+			defer rows.Close()
+
+			for i, expected := range tc.scanTypes {
+				if got := rows.ColumnTypeScanType(i); got != expected {
+					t.Errorf("column %d: expected scan type %v, got %v", i, expected, got)
+				}
+			}
+
+			for i, expected := range tc.expected {
+				dest := make([]driver.Value, len(rows.Columns()))
+				if err := rows.Next(dest); err != nil {
+					t.Fatalf("row %d: Next failed: %v", i, err)
+				}
+				if !reflect.DeepEqual(dest, expected) {
+					t.Errorf("row %d: expected %#v, got %#v", i, expected, dest)
+				}
+			}
+		})
+	}
+}
+
+// newMapRecord builds a record with three rows of map columns:
+//
+//	scores   {"b": 1, "a": null}  null  {}
+//	names    {2: "x", 1: "y"}     {}    {}
+func newMapRecord(t *testing.T) arrow.Record {
+	t.Helper()
+	mem := memory.NewGoAllocator()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "scores", Type: arrow.MapOf(arrow.BinaryTypes.String, arrow.PrimitiveTypes.Int64), Nullable: true},
+		{Name: "names", Type: arrow.MapOf(arrow.PrimitiveTypes.Int32, arrow.BinaryTypes.String)},
+	}, nil)
+	b := array.NewRecordBuilder(mem, schema)
+	defer b.Release()
+
+	scores := b.Field(0).(*array.MapBuilder)
+	scoreKeys := scores.KeyBuilder().(*array.StringBuilder)
+	scoreItems := scores.ItemBuilder().(*array.Int64Builder)
+	scores.Append(true)
+	scoreKeys.AppendValues([]string{"b", "a"}, nil)
+	scoreItems.AppendValues([]int64{1, 0}, []bool{true, false})
+	scores.AppendNull()
+	scores.Append(true)
+
+	names := b.Field(1).(*array.MapBuilder)
+	nameKeys := names.KeyBuilder().(*array.Int32Builder)
+	nameItems := names.ItemBuilder().(*array.StringBuilder)
+	names.Append(true)
+	nameKeys.AppendValues([]int32{2, 1}, nil)
+	nameItems.AppendValues([]string{"x", "y"}, nil)
+	names.Append(true)
+	names.Append(true)
+
+	return b.NewRecord()
+}
+
+func TestRowsMapColumns(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     NestedMode
+		scanType reflect.Type
+		expected [][]driver.Value
+	}{
+		{
+			name:     "json",
+			mode:     NestedAsJSON,
+			scanType: scanTypeString,
+			expected: [][]driver.Value{
+				{`{"b":1,"a":null}`, `{"2":"x","1":"y"}`},
+				{nil, "{}"},
+				{"{}", "{}"},
+			},
+		},
+		{
+			name:     "go",
+			mode:     NestedAsGo,
+			scanType: scanTypeMapEntries,
+			expected: [][]driver.Value{
+				{[]MapEntry{{"b", int64(1)}, {"a", nil}}, []MapEntry{{int32(2), "x"}, {int32(1), "y"}}},
+				{nil, []MapEntry{}},
+				{[]MapEntry{}, []MapEntry{}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := newMapRecord(t)
+			rows := newRowsFromArrow(rec.Schema(), []arrow.Record{rec})
+			rows.nestedMode = tc.mode
+			defer rows.Close()
+
+			for i := range rows.Columns() {
+				if got := rows.ColumnTypeScanType(i); got != tc.scanType {
+					t.Errorf("column %d: expected scan type %v, got %v", i, tc.scanType, got)
+				}
+			}
+
+			for i, expected := range tc.expected {
+				dest := make([]driver.Value, len(rows.Columns()))
+				if err := rows.Next(dest); err != nil {
+					t.Fatalf("row %d: Next failed: %v", i, err)
+				}
+				if !reflect.DeepEqual(dest, expected) {
+					t.Errorf("row %d: expected %#v, got %#v", i, expected, dest)
+				}
+			}
+		})
+	}
+}
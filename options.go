@@ -0,0 +1,351 @@
+package luna
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// Option configures a Connector. Options take precedence over the equivalent DSN settings.
+type Option func(*Connector)
+
+// WithConnInitFn sets a callback to perform additional initialization steps on every new connection.
+func WithConnInitFn(fn func(execer driver.ExecerContext) error) Option {
+	return func(c *Connector) {
+		c.connInitFn = fn
+	}
+}
+
+// WithDialTimeout limits the time spent establishing a connection, including the TLS handshake,
+// same as the connect_timeout DSN parameter. A context deadline passed to Connect still applies
+// when it's earlier.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Connector) {
+		c.cfg.ConnectTimeout = timeout
+	}
+}
+
+// WithDialFunc opens connections with dial instead of a net.Dialer, e.g. over an
+// in-memory network in simulations, or through a proxy. The dial timeout applies
+// to the context passed to dial; keep-alive settings are up to dial.
+func WithDialFunc(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(c *Connector) {
+		c.cfg.DialFunc = dial
+	}
+}
+
+// WithClock replaces the time source of the connector's timeouts, idle pings,
+// rate limits and event times, e.g. with a manually advanced clock for
+// deterministic tests.
+func WithClock(clock Clock) Option {
+	return func(c *Connector) {
+		c.clock = clock
+	}
+}
+
+// WithQueryTimeout sets the default time limit for a single query or command,
+// same as the query_timeout DSN parameter.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(c *Connector) {
+		c.cfg.QueryTimeout = timeout
+	}
+}
+
+// WithStallTimeout sets the time limit without receiving any bytes once a
+// response has started, same as the stall_timeout DSN parameter.
+func WithStallTimeout(timeout time.Duration) Option {
+	return func(c *Connector) {
+		c.cfg.StallTimeout = timeout
+	}
+}
+
+// WithMaxResultSize sets the most rows, and bytes of the Arrow stream, that the
+// result of a query may have, same as the max_result_rows and max_result_bytes
+// DSN parameters (0 means no limit). Larger results fail with
+// ErrResultTooLarge.
+func WithMaxResultSize(rows, bytes int) Option {
+	return func(c *Connector) {
+		c.cfg.MaxResultRows = rows
+		c.cfg.MaxResultBytes = bytes
+	}
+}
+
+// WithKeepAlive sets the interval between TCP keep-alive probes, same as the keepalive
+// DSN parameter.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(c *Connector) {
+		c.cfg.KeepAlive = interval
+	}
+}
+
+// WithSocketBuffers sets the sizes in bytes of the kernel receive and send
+// buffers of each connection, SO_RCVBUF and SO_SNDBUF, same as the
+// socket_read_buffer and socket_write_buffer DSN parameters (0 keeps the OS
+// default).
+func WithSocketBuffers(read, write int) Option {
+	return func(c *Connector) {
+		c.cfg.SocketReadBuffer = read
+		c.cfg.SocketWriteBuffer = write
+	}
+}
+
+// WithTCPNoDelay sets whether connections send small writes right away, same as
+// the tcp_nodelay DSN parameter (default true).
+func WithTCPNoDelay(enabled bool) Option {
+	return func(c *Connector) {
+		c.cfg.DisableNoDelay = !enabled
+	}
+}
+
+// WithTCPQuickAck sets whether connections enable TCP_QUICKACK when connecting,
+// same as the tcp_quickack DSN parameter (default false). It's ignored on
+// platforms other than Linux.
+func WithTCPQuickAck(enabled bool) Option {
+	return func(c *Connector) {
+		c.cfg.QuickAck = enabled
+	}
+}
+
+// WithIdlePingInterval pings idle connections at the given interval, same as the
+// idle_ping_interval DSN parameter. Connections that fail the ping are discarded
+// by the pool, instead of failing the next query.
+func WithIdlePingInterval(interval time.Duration) Option {
+	return func(c *Connector) {
+		c.cfg.IdlePingInterval = interval
+	}
+}
+
+// WithTLSConfig enables TLS with the given configuration, replacing any TLS settings from the DSN.
+// A nil config disables TLS.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Connector) {
+		c.cfg.TLSConfig = config
+	}
+}
+
+// WithLogger sets the logger used by the connector and its connections (default slog.Default()).
+// A nil logger disables logging.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Connector) {
+		c.cfg.Logger = logger
+	}
+}
+
+// WithLogHandler sets the handler of the logger used by the connector and its
+// connections. A nil handler disables logging.
+func WithLogHandler(h slog.Handler) Option {
+	return func(c *Connector) {
+		c.cfg.Logger = nil
+		if h != nil {
+			c.cfg.Logger = slog.New(h)
+		}
+	}
+}
+
+// WithLogLevel drops log records below level, whatever the handler's own level.
+// LogLevelOff disables logging, and a *slog.LevelVar changes the level at run time.
+func WithLogLevel(level slog.Leveler) Option {
+	return func(c *Connector) {
+		c.cfg.LogLevel = level
+	}
+}
+
+// WithQueryLogging sets how the text of statements appears in log records
+// (default QueryLogRedacted).
+func WithQueryLogging(mode QueryLogMode) Option {
+	return func(c *Connector) {
+		c.cfg.LogQueries = mode
+	}
+}
+
+// WithNoticeHandler sets a function called with each warning the server raises
+// for a statement. Without one, warnings are logged. The handler is called while
+// the connection is busy, so it must not use the connection.
+func WithNoticeHandler(fn func(Notice)) Option {
+	return func(c *Connector) {
+		c.cfg.NoticeHandler = fn
+	}
+}
+
+// WithSlowQuery sets a function called with each statement that takes at least
+// threshold, including the wait for the server and the decoding of its result,
+// e.g. to find expensive statements without wrapping every call site. A nil fn
+// logs slow statements as warnings instead.
+func WithSlowQuery(threshold time.Duration, fn SlowQueryFunc) Option {
+	return func(c *Connector) {
+		c.cfg.SlowQueryThreshold = threshold
+		c.cfg.OnSlowQuery = fn
+	}
+}
+
+// WithQueryHooks adds hooks called around the statements connections run, after
+// the ones added by earlier options. See QueryHooks.
+func WithQueryHooks(hooks QueryHooks) Option {
+	return func(c *Connector) {
+		c.cfg.QueryHooks = append(c.cfg.QueryHooks, hooks)
+	}
+}
+
+// WithEventHandler sets a function called with the failures and recoveries the
+// driver notices in the background, e.g. a failed idle ping or an unreachable
+// server, which are otherwise only logged. It's called from the goroutine that
+// noticed the event, e.g. a timer's or the one opening a connection, so it
+// should return quickly.
+func WithEventHandler(fn func(Event)) Option {
+	return func(c *Connector) {
+		c.cfg.EventHandler = fn
+	}
+}
+
+// WithAllocator sets the Arrow memory allocator used to decode query results
+// (default memory.NewGoAllocator()).
+func WithAllocator(mem memory.Allocator) Option {
+	return func(c *Connector) {
+		c.cfg.Allocator = mem
+	}
+}
+
+// WithNestedMode sets how Rows returns the values of list columns, same as the
+// nested_mode DSN parameter (default NestedAsJSON).
+func WithNestedMode(mode NestedMode) Option {
+	return func(c *Connector) {
+		c.cfg.NestedMode = mode
+	}
+}
+
+// WithDecimalMode sets how Rows returns the values of decimal columns, same as
+// the decimal_mode DSN parameter (default DecimalAsString).
+func WithDecimalMode(mode DecimalMode) Option {
+	return func(c *Connector) {
+		c.cfg.DecimalMode = mode
+	}
+}
+
+// WithUTF8Mode sets how Rows handles string values that aren't valid UTF-8, same
+// as the utf8_mode DSN parameter (default UTF8Unchecked).
+func WithUTF8Mode(mode UTF8Mode) Option {
+	return func(c *Connector) {
+		c.cfg.UTF8Mode = mode
+	}
+}
+
+// WithUUIDMode sets how Rows returns the values of UUID columns, same as the
+// uuid_mode DSN parameter (default UUIDAsString).
+func WithUUIDMode(mode UUIDMode) Option {
+	return func(c *Connector) {
+		c.cfg.UUIDMode = mode
+	}
+}
+
+// WithTimeZone sets the session time zone of new connections, same as the
+// timezone DSN parameter. The location must have an IANA name, e.g. one loaded
+// with time.LoadLocation("Europe/Paris"), rather than time.Local.
+func WithTimeZone(loc *time.Location) Option {
+	return func(c *Connector) {
+		c.cfg.TimeZone = loc
+	}
+}
+
+// WithErrorResults sets whether results with the server's input and error
+// columns, which report a statement that failed, are returned as an *Error
+// instead of as rows, same as the error_results DSN parameter (default false).
+func WithErrorResults(convert bool) Option {
+	return func(c *Connector) {
+		c.cfg.ErrorResults = convert
+	}
+}
+
+// WithTxMode sets how transactions are run, same as the tx_mode DSN parameter
+// (default TxServer).
+func WithTxMode(mode TxMode) Option {
+	return func(c *Connector) {
+		c.cfg.TxMode = mode
+	}
+}
+
+// WithDialect sets the SQL dialect that statements are written in, same as the
+// dialect DSN parameter (default DialectLuna).
+func WithDialect(dialect Dialect) Option {
+	return func(c *Connector) {
+		c.cfg.Dialect = dialect
+	}
+}
+
+// WithFileLister lists the files matching glob patterns with the given scheme,
+// e.g. "s3" or "gs", with list rather than the server's glob function, for
+// ExpandGlob and CheckGlobs.
+func WithFileLister(scheme string, list FileLister) Option {
+	return func(c *Connector) {
+		if c.cfg.FileListers == nil {
+			c.cfg.FileListers = make(map[string]FileLister)
+		}
+		c.cfg.FileListers[strings.ToLower(scheme)] = list
+	}
+}
+
+// WithTableStats sets whether the connector aggregates the statements, rows and
+// bytes of each table referred to by queries, reported by Connector.TableStats,
+// same as the table_stats DSN parameter (default false).
+func WithTableStats(enabled bool) Option {
+	return func(c *Connector) {
+		c.cfg.TableStats = enabled
+	}
+}
+
+// WithSchemaDrift sets what happens when the result of a query has another
+// schema than the last time it ran through the connector, same as the
+// schema_drift DSN parameter (default SchemaDriftOff).
+func WithSchemaDrift(mode SchemaDriftMode) Option {
+	return func(c *Connector) {
+		c.cfg.SchemaDrift = mode
+	}
+}
+
+// WithDecodeRetry sets whether failures to decode the result of a read-only
+// query are retried on another connection, same as the retry_decode DSN
+// parameter (default false).
+func WithDecodeRetry(retry bool) Option {
+	return func(c *Connector) {
+		c.cfg.RetryDecode = retry
+	}
+}
+
+// WithResultTrailers sets whether the trailers the server announces after an
+// Arrow stream are read, same as the result_trailers DSN parameter (default
+// false). The trailer format is provisional, since the server doesn't define
+// one yet, and may change.
+func WithResultTrailers(enabled bool) Option {
+	return func(c *Connector) {
+		c.cfg.ResultTrailers = enabled
+	}
+}
+
+// WithReadBufferSize sets the size of the buffered reader of each connection.
+// Larger buffers reduce the number of reads for big Arrow results.
+func WithReadBufferSize(size int) Option {
+	return func(c *Connector) {
+		c.cfg.ReadBufferSize = size
+	}
+}
+
+// WithCredentials sets the user name and password used to authenticate,
+// replacing any user info from the DSN.
+func WithCredentials(username, password string) Option {
+	return func(c *Connector) {
+		c.cfg.User, c.cfg.Password = username, password
+	}
+}
+
+// WithRateLimit limits the commands sent and bytes received by all connections of the
+// connector, same as the rate_limit_qps and rate_limit_bytes DSN parameters.
+func WithRateLimit(limit RateLimit) Option {
+	return func(c *Connector) {
+		c.cfg.RateLimit = limit
+	}
+}
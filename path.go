@@ -0,0 +1,113 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrUnportablePath is returned by NormalizeFilePath for paths the server can't
+// be expected to read as meant, e.g. a Windows path given to a Linux server.
+var ErrUnportablePath = errors.New("luna: path can't be read by the server")
+
+// NormalizeFilePath converts a path passed to read_parquet, read_csv and the other
+// table functions to a form a server running on serverOS reads as meant.
+// serverOS is a GOOS value, as returned by ServerOS, or empty if unknown:
+//
+//   - URLs of other schemes than file, e.g. s3:// or https://, are returned
+//     unchanged.
+//   - file:// URLs are converted to paths, with escapes decoded.
+//   - Windows paths, with a drive letter or a UNC host, get forward slashes,
+//     which Windows accepts, so that glob patterns and SQL literals don't
+//     depend on backslashes. They're reported for servers on other systems.
+//   - Relative paths and ~ are reported, since the server resolves them against
+//     its own working and home directories, not the client's.
+//
+// Errors match ErrUnportablePath. Glob characters are kept.
+func NormalizeFilePath(p, serverOS string) (string, error) {
+	if scheme, _, ok := strings.Cut(p, "://"); ok && !strings.EqualFold(scheme, "file") && !strings.ContainsAny(scheme, `/\`) {
+		return p, nil
+	}
+
+	local := p
+	if len(p) > 7 && strings.EqualFold(p[:7], "file://") {
+		// Not parsed with url.Parse, which would take the ? of a glob for a query
+		host, rest, _ := strings.Cut(p[7:], "/")
+		decoded, err := url.PathUnescape("/" + rest)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid file URL %q: %v", ErrUnportablePath, p, err)
+		}
+		switch {
+		case host != "" && !strings.EqualFold(host, "localhost"):
+			// file://server/share/f is a UNC path
+			local = "//" + host + decoded
+		case isDrivePath(decoded[1:]):
+			local = decoded[1:]
+		default:
+			local = decoded
+		}
+	}
+
+	windows := isDrivePath(local) || strings.HasPrefix(local, `\\`) || strings.HasPrefix(local, "//") ||
+		strings.Contains(local, `\`) && !strings.HasPrefix(local, "/")
+	if windows {
+		if serverOS != "" && serverOS != "windows" {
+			return "", fmt.Errorf("%w: %q is a Windows path, and the server runs on %s", ErrUnportablePath, p, serverOS)
+		}
+		local = strings.ReplaceAll(local, `\`, "/")
+		switch {
+		case strings.HasPrefix(local, "//"):
+			// Keep the leading // of UNC paths, which path.Clean would collapse
+			return "/" + path.Clean(local[1:]), nil
+		case !isDrivePath(local):
+			return "", fmt.Errorf("%w: %q is relative to the server's working directory, not the client's; use an absolute path", ErrUnportablePath, p)
+		case len(local) == 2 || local[2] != '/':
+			// C:data is relative to the current directory of drive C
+			return "", fmt.Errorf("%w: %q is relative to the server's current directory on drive %s", ErrUnportablePath, p, local[:2])
+		}
+		return path.Clean(local), nil
+	}
+
+	switch {
+	case local == "~" || strings.HasPrefix(local, "~/"):
+		return "", fmt.Errorf("%w: %q would be expanded to the home directory of the server's user", ErrUnportablePath, p)
+	case !strings.HasPrefix(local, "/"):
+		return "", fmt.Errorf("%w: %q is relative to the server's working directory, not the client's; use an absolute path", ErrUnportablePath, p)
+	case serverOS == "windows":
+		return "", fmt.Errorf("%w: %q has no drive letter, and the server runs on windows", ErrUnportablePath, p)
+	}
+	return path.Clean(local), nil
+}
+
+// isDrivePath reports whether p starts with a Windows drive letter, e.g. C:.
+func isDrivePath(p string) bool {
+	return len(p) >= 2 && p[1] == ':' && (p[0] >= 'a' && p[0] <= 'z' || p[0] >= 'A' && p[0] <= 'Z')
+}
+
+// serverPlatforms maps the operating systems of the server's platform names to
+// GOOS values.
+var serverPlatforms = map[string]string{
+	"osx":     "darwin",
+	"windows": "windows",
+	"linux":   "linux",
+	"freebsd": "freebsd",
+}
+
+// ServerOS returns the operating system the server runs on, as a GOOS value such
+// as "linux" or "windows", for NormalizeFilePath. It's read from the platform
+// the server reports, e.g. linux_amd64.
+func ServerOS(ctx context.Context, db *sql.DB) (string, error) {
+	var platform string
+	if err := db.QueryRowContext(ctx, "SELECT platform FROM pragma_platform()").Scan(&platform); err != nil {
+		return "", err
+	}
+	name, _, _ := strings.Cut(platform, "_")
+	if goos, ok := serverPlatforms[name]; ok {
+		return goos, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,89 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFilePath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		serverOS string
+		expected string
+		err      string
+	}{
+		{path: "/data/2024/*.parquet", expected: "/data/2024/*.parquet"},
+		{path: "/data/../data/./x.csv", serverOS: "linux", expected: "/data/x.csv"},
+		{path: "s3://bucket/prefix/*.parquet", serverOS: "windows", expected: "s3://bucket/prefix/*.parquet"},
+		{path: "file:///data/a%20b/x?.csv", expected: "/data/a b/x?.csv"},
+		{path: "FILE://localhost/data/x.csv", serverOS: "linux", expected: "/data/x.csv"},
+		{path: "file:///C:/data/x.csv", serverOS: "windows", expected: "C:/data/x.csv"},
+		{path: `C:\data\2024\*.parquet`, expected: "C:/data/2024/*.parquet"},
+		{path: `\\fileserver\share\x.csv`, serverOS: "windows", expected: "//fileserver/share/x.csv"},
+		{path: "file://fileserver/share/x.csv", serverOS: "windows", expected: "//fileserver/share/x.csv"},
+		{path: `C:\data\x.csv`, serverOS: "linux", err: "is a Windows path, and the server runs on linux"},
+		{path: "C:data.csv", serverOS: "windows", err: "relative to the server's current directory on drive C:"},
+		{path: `data\x.csv`, serverOS: "windows", err: "relative to the server's working directory"},
+		{path: "data/x.csv", err: "relative to the server's working directory"},
+		{path: "~/x.csv", err: "home directory"},
+		{path: "/data/x.csv", serverOS: "windows", err: "has no drive letter"},
+		{path: "file:///data/%zz", err: "invalid file URL"},
+	}
+
+	for _, tc := range testCases {
+		got, err := NormalizeFilePath(tc.path, tc.serverOS)
+		if tc.err != "" {
+			if !errors.Is(err, ErrUnportablePath) || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("NormalizeFilePath(%q, %q): expected an error containing %q, got %q, %v", tc.path, tc.serverOS, tc.err, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("NormalizeFilePath(%q, %q) = %q, %v, expected %q", tc.path, tc.serverOS, got, err, tc.expected)
+		}
+	}
+}
+
+func TestServerOS(t *testing.T) {
+	testCases := []struct {
+		platform string
+		expected string
+	}{
+		{"linux_amd64", "linux"},
+		{"osx_arm64", "darwin"},
+		{"windows_amd64", "windows"},
+		{"wasm_eh", "wasm"},
+	}
+
+	for _, tc := range testCases {
+		addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+			for {
+				cmd, err := readCommand(reader)
+				if err != nil {
+					return
+				}
+				if cmd != "q:SELECT platform FROM pragma_platform()" {
+					conn.Write([]byte("-unexpected command\r\n"))
+					continue
+				}
+				rec := newValuesRecord(t, []string{"platform"}, []any{tc.platform})
+				writeArrowReply(conn, rec.Schema(), rec)
+				rec.Release()
+			}
+		})
+		db, err := sql.Open("luna", addr)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		got, err := ServerOS(context.Background(), db)
+		db.Close()
+		if err != nil || got != tc.expected {
+			t.Errorf("ServerOS for %s = %q, %v, expected %q", tc.platform, got, err, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package luna
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// Pipeline is a list of statements sent to the server together, without waiting
+// for the reply to each before sending the next, to save round trips when running
+// many small statements. Run it with Conn.RunPipeline or RunPipeline. The zero
+// value is an empty pipeline.
+type Pipeline struct {
+	stmts []pipelineStmt
+}
+
+type pipelineStmt struct {
+	cmd   string
+	query string
+}
+
+// Query adds a query, whose result is returned as Arrow record batches.
+func (p *Pipeline) Query(query string) {
+	p.stmts = append(p.stmts, pipelineStmt{cmd: wire.CmdQuery, query: query})
+}
+
+// Exec adds a statement executed for its side effects, e.g. an INSERT.
+func (p *Pipeline) Exec(query string) {
+	p.stmts = append(p.stmts, pipelineStmt{cmd: wire.CmdExecute, query: query})
+}
+
+// Len returns the number of statements in the pipeline.
+func (p *Pipeline) Len() int {
+	return len(p.stmts)
+}
+
+// PipelineResult is the outcome of a statement of a pipeline, as returned by
+// Conn.RunPipeline.
+type PipelineResult struct {
+	// Records is the result of a query, nil for executions and failed
+	// statements. The caller must release it.
+	Records *RecordReader
+	// RowsAffected is the number of rows changed by an execution, as reported by
+	// sql.Result.RowsAffected.
+	RowsAffected int64
+	// Err is the error of a failed statement, e.g. a *Error. The statements
+	// after it still run.
+	Err error
+
+	// Result of an execution, for ExecBatchContext.
+	result *result
+}
+
+// RunPipeline sends the statements of p in a single write, reading their
+// replies in order while it's sent, and returns a result for each statement. A statement that
+// fails, e.g. with a syntax error, doesn't stop the others: its error is in its
+// result. The error returned is for the pipeline as a whole, e.g. a broken
+// connection or a done ctx, and no results are returned with it.
+//
+// Queries can refer to the tables registered with RegisterTempTable, but unlike
+// with QueryContext, IN lists aren't split, client-side filters aren't applied
+// and statements separated by semicolons aren't split.
+func (c *Conn) RunPipeline(ctx context.Context, p *Pipeline) ([]PipelineResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tx && c.txMode == TxBatch {
+		return nil, ErrBatchedQuery
+	}
+	return c.runPipeline(ctx, p)
+}
+
+// runPipeline runs a pipeline. The caller must hold c.mu.
+func (c *Conn) runPipeline(ctx context.Context, p *Pipeline) ([]PipelineResult, error) {
+	if c.closed || c.bad {
+		return nil, errBadConn
+	}
+	if len(p.stmts) == 0 {
+		return nil, nil
+	}
+
+	c.logger.Debug("RunPipeline called", "statements", len(p.stmts))
+
+	// The first command is charged by roundTrip
+	if c.limiter != nil && len(p.stmts) > 1 {
+		if err := c.limiter.waitN(ctx, len(p.stmts)-1); err != nil {
+			return nil, err
+		}
+	}
+
+	stmts := make([]pipelineStmt, len(p.stmts))
+	queries := make([]string, len(p.stmts))
+	events := make([]QueryEvent, len(p.stmts))
+	for i, stmt := range p.stmts {
+		e, err := c.beforeQuery(ctx, "pipeline", stmt.query)
+		if err != nil {
+			return nil, err
+		}
+		stmt.query = c.dialect.translate(e.Query)
+		stmts[i], queries[i], events[i] = stmt, stmt.query, e
+	}
+
+	results := make([]PipelineResult, 0, len(p.stmts))
+	start := c.clock.Now()
+	err := c.roundTrip(ctx, "pipeline", strings.Join(queries, ";\n"), func() error {
+		var buf bytes.Buffer
+		for _, stmt := range stmts {
+			query := stmt.query
+			if stmt.cmd == wire.CmdQuery {
+				query = c.withTempTables(query)
+			}
+			wire.SendCommand(&buf, stmt.cmd, query)
+		}
+
+		// The server replies while the pipeline is still being sent, so replies
+		// are read while it's written, lest both sides block on full socket
+		// buffers
+		written := make(chan error, 1)
+		go func() {
+			_, err := c.conn.Write(buf.Bytes())
+			written <- err
+		}()
+		for _, stmt := range stmts {
+			res, err := c.readPipelineResult(ctx, stmt)
+			if err != nil {
+				c.stopPipelineWrite(written)
+				return err
+			}
+			if res.Err == nil {
+				stats := c.stats
+				if res.result != nil {
+					stats = res.result.stats
+				}
+				reportStats(ctx, stmt.query, c.clock.Now().Sub(start), stats)
+			}
+			results = append(results, res)
+		}
+		if err := <-written; err != nil {
+			return c.sendError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		releasePipelineResults(results)
+		for _, e := range events {
+			c.afterQuery(ctx, e, 0, err)
+		}
+		return nil, err
+	}
+	for i, res := range results {
+		rows := res.RowsAffected
+		if res.Records != nil {
+			rows = countRows(res.Records.records)
+		}
+		c.afterQuery(ctx, events[i], rows, res.Err)
+	}
+	return results, nil
+}
+
+// stopPipelineWrite waits for the write of a pipeline whose replies can't be
+// read anymore, interrupting it if it's still blocked. A pipeline cut short
+// leaves a partial command on the connection, which can't be used anymore.
+func (c *Conn) stopPipelineWrite(written <-chan error) {
+	select {
+	case <-written:
+	default:
+		c.conn.SetWriteDeadline(time.Now())
+		<-written
+		c.bad = true
+	}
+}
+
+// readPipelineResult reads the reply to a statement of a pipeline. It returns an
+// error only if the connection can't read the following replies.
+func (c *Conn) readPipelineResult(ctx context.Context, stmt pipelineStmt) (PipelineResult, error) {
+	received := c.counter.count()
+	if stmt.cmd == wire.CmdExecute {
+		res, err := c.readExecResults(ctx, stmt.query)
+		if err != nil {
+			return PipelineResult{Err: err}, c.pipelineError(err)
+		}
+		c.tables.record(stmt.query, res.rowsAffected, c.counter.count()-received)
+		return PipelineResult{RowsAffected: res.rowsAffected, result: res}, nil
+	}
+
+	c.stats, c.batches = noStats, nil
+	c.resultRows, c.resultBytes = 0, 0
+	schema, records, err := c.readQueryResult(ctx, stmt.query, c.mem)
+	// A statement of several statements has the result of the last one
+	for n := len(splitSQLStatements(stmt.query)); n > 1 && err == nil; n-- {
+		wire.ReleaseRecords(records)
+		c.stats, c.batches = noStats, nil
+		schema, records, err = c.readQueryResult(ctx, stmt.query, c.mem)
+	}
+	if err != nil {
+		// Records sent before a failure partway are dropped
+		wire.ReleaseRecords(records)
+		var partial *partialResultError
+		if errors.As(err, &partial) {
+			err = partial.err
+		}
+		return PipelineResult{Err: err}, c.pipelineError(err)
+	}
+	c.tables.record(stmt.query, countRows(records), c.counter.count()-received)
+	if err := c.checkSchema(stmt.query, schema); err != nil {
+		wire.ReleaseRecords(records)
+		return PipelineResult{Err: err}, nil
+	}
+	return PipelineResult{Records: newRecordReader(schema, records)}, nil
+}
+
+// pipelineError returns err if the failure of a statement leaves the connection
+// unable to read the replies to the statements after it, and nil otherwise.
+func (c *Conn) pipelineError(err error) error {
+	if c.bad || isNetworkError(err) {
+		return err
+	}
+	return nil
+}
+
+func releasePipelineResults(results []PipelineResult) {
+	for _, res := range results {
+		if res.Records != nil {
+			res.Records.Release()
+		}
+	}
+}
+
+// BatchResult is the outcome of a statement run with ExecBatchContext.
+type BatchResult struct {
+	// Result is the result of the statement, nil if it failed.
+	Result sql.Result
+	// Err is the error of a failed statement, e.g. a *Error.
+	Err error
+}
+
+// ExecBatchContext executes statements in a single round trip, sending them as a
+// pipeline of executions, and returns a result for each, e.g. for migration tools
+// and bulk DDL. A statement that fails doesn't stop the others: its error is in
+// its result, and the statements after it still run. For all-or-nothing batches,
+// run ExecBatchContext in a transaction in TxBatch mode, where the statements are
+// buffered until Commit like those run with ExecContext. The error returned is
+// for the batch as a whole, as with RunPipeline.
+func (c *Conn) ExecBatchContext(ctx context.Context, statements []string) ([]BatchResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.bad {
+		return nil, errBadConn
+	}
+
+	results := make([]BatchResult, len(statements))
+	if c.tx && c.txMode == TxBatch {
+		for i, stmt := range statements {
+			e, err := c.beforeQuery(ctx, "pipeline", stmt)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			c.batch = append(c.batch, c.dialect.translate(e.Query))
+			c.batchEvents = append(c.batchEvents, e)
+			results[i].Result = batchedResult{}
+		}
+		return results, nil
+	}
+
+	var p Pipeline
+	for _, stmt := range statements {
+		p.Exec(stmt)
+	}
+	piped, err := c.runPipeline(ctx, &p)
+	if err != nil {
+		return nil, err
+	}
+	for i, res := range piped {
+		if res.Err != nil {
+			results[i].Err = res.Err
+		} else {
+			results[i].Result = res.result
+		}
+	}
+	return results, nil
+}
+
+// ExecBatchContext executes statements in a single round trip on a connection
+// from a database/sql pool opened with the luna driver. See
+// Conn.ExecBatchContext.
+func ExecBatchContext(ctx context.Context, conn *sql.Conn, statements []string) ([]BatchResult, error) {
+	var results []BatchResult
+	err := conn.Raw(func(dc any) error {
+		c, ok := dc.(*Conn)
+		if !ok {
+			return fmt.Errorf("luna: ExecBatchContext needs a luna connection, got %T", dc)
+		}
+
+		var err error
+		results, err = c.ExecBatchContext(ctx, statements)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// RunPipeline runs a pipeline on a connection from a database/sql pool opened
+// with the luna driver. See Conn.RunPipeline.
+func RunPipeline(ctx context.Context, conn *sql.Conn, p *Pipeline) ([]PipelineResult, error) {
+	var results []PipelineResult
+	err := conn.Raw(func(dc any) error {
+		c, ok := dc.(*Conn)
+		if !ok {
+			return fmt.Errorf("luna: RunPipeline needs a luna connection, got %T", dc)
+		}
+
+		var err error
+		results, err = c.RunPipeline(ctx, p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
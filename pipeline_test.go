@@ -0,0 +1,260 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestPipeline(t *testing.T) {
+	replies := map[string][]byte{
+		"q:SELECT n FROM t":          arrowReply(t, "n", 1, 2),
+		"x:INSERT INTO t VALUES (3)": []byte(":1\r\n"),
+		"q:SELEC n FROM t":           []byte("-Parser Error: syntax error at or near \"SELEC\"\r\n"),
+		"x:DELETE FROM t":            arrowReply(t, "Count", 3),
+	}
+	order := []string{"q:SELECT n FROM t", "x:INSERT INTO t VALUES (3)", "q:SELEC n FROM t", "x:DELETE FROM t"}
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			// All commands arrive before the first reply is sent
+			var cmds []string
+			for range order {
+				cmd, err := readCommand(reader)
+				if err != nil {
+					return
+				}
+				cmds = append(cmds, cmd)
+			}
+			if !reflect.DeepEqual(cmds, order) {
+				t.Errorf("expected commands %q, got %q", order, cmds)
+			}
+			for _, cmd := range cmds {
+				conn.Write(replies[cmd])
+			}
+		}
+	})
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	connector, err := NewConnectorWithOptions(addr, WithAllocator(mem))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	var p Pipeline
+	p.Query("SELECT n FROM t")
+	p.Exec("INSERT INTO t VALUES (3)")
+	p.Query("SELEC n FROM t")
+	p.Exec("DELETE FROM t")
+
+	for run := 0; run < 2; run++ {
+		results, err := RunPipeline(context.Background(), conn, &p)
+		if err != nil {
+			t.Fatalf("RunPipeline failed: %v", err)
+		}
+		if len(results) != p.Len() {
+			t.Fatalf("expected %d results, got %d", p.Len(), len(results))
+		}
+
+		var values []int64
+		for results[0].Records.Next() {
+			values = append(values, results[0].Records.Record().Column(0).(*array.Int64).Int64Values()...)
+		}
+		results[0].Records.Release()
+		if !reflect.DeepEqual(values, []int64{1, 2}) || results[0].Err != nil {
+			t.Errorf("expected values [1 2], got %v, %v", values, results[0].Err)
+		}
+
+		if results[1].RowsAffected != 1 || results[1].Err != nil || results[1].Records != nil {
+			t.Errorf("expected 1 row affected, got %+v", results[1])
+		}
+
+		// A failed statement doesn't stop the ones after it
+		if !errors.Is(results[2].Err, ErrSyntax) || results[2].Records != nil {
+			t.Errorf("expected a syntax error, got %+v", results[2])
+		}
+
+		if results[3].RowsAffected != 3 || results[3].Err != nil {
+			t.Errorf("expected 3 rows affected, got %+v", results[3])
+		}
+	}
+
+	// Empty pipelines don't send anything
+	if results, err := RunPipeline(context.Background(), conn, &Pipeline{}); err != nil || results != nil {
+		t.Errorf("expected no results, got %v, %v", results, err)
+	}
+}
+
+func TestPipelineConnClosed(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for range 2 {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+		}
+		// The connection breaks after the first reply
+		conn.Write(arrowReply(t, "n", 1))
+		conn.Close()
+	})
+
+	conn := connectFake(t, addr)
+
+	var p Pipeline
+	p.Query("SELECT n FROM t")
+	p.Query("SELECT n FROM u")
+	results, err := conn.RunPipeline(context.Background(), &p)
+	if !errors.Is(err, ErrConnClosed) || results != nil {
+		t.Errorf("expected ErrConnClosed and no results, got %v, %v", results, err)
+	}
+	if conn.IsValid() {
+		t.Error("expected connection to be invalid")
+	}
+}
+
+func TestLargePipeline(t *testing.T) {
+	// Replies are sent as each command is read, and both the commands and the
+	// replies are far larger than the socket buffers
+	values := make([]int64, 512)
+	reply := arrowReply(t, "n", values...)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	var p Pipeline
+	padding := strings.Repeat(" ", 4096)
+	for range 4000 {
+		p.Query("SELECT n FROM t" + padding)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	results, err := conn.RunPipeline(ctx, &p)
+	if err != nil {
+		t.Fatalf("RunPipeline failed: %v", err)
+	}
+	defer releasePipelineResults(results)
+	if len(results) != p.Len() {
+		t.Fatalf("expected %d results, got %d", p.Len(), len(results))
+	}
+	for i, res := range results {
+		if res.Err != nil {
+			t.Fatalf("statement %d failed: %v", i, res.Err)
+		}
+	}
+}
+
+func TestExecBatchContext(t *testing.T) {
+	replies := map[string][]byte{
+		"x:DROP TABLE missing":       []byte("-Catalog Error: Table with name missing does not exist!\r\n"),
+		"x:INSERT INTO a VALUES (1)": []byte(":1\r\n"),
+	}
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			for _, stmt := range statementCommands(cmd) {
+				reply, ok := replies[stmt]
+				if !ok {
+					reply = []byte("+OK\r\n")
+				}
+				conn.Write(reply)
+				if reply[0] == '-' {
+					break
+				}
+			}
+		}
+	})
+
+	db, err := sql.Open("luna", addr+"?tx_mode=batch")
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	statements := []string{"CREATE TABLE a (id INT)", "DROP TABLE missing", "INSERT INTO a VALUES (1)"}
+	results, err := ExecBatchContext(context.Background(), conn, statements)
+	if err != nil {
+		t.Fatalf("ExecBatchContext failed: %v", err)
+	}
+	if len(results) != len(statements) {
+		t.Fatalf("expected %d results, got %d", len(statements), len(results))
+	}
+	if n, err := results[0].Result.RowsAffected(); err != nil || n != 0 || results[0].Err != nil {
+		t.Errorf("expected 0 rows affected, got %d, %v, %v", n, err, results[0].Err)
+	}
+	var lerr *Error
+	if !errors.As(results[1].Err, &lerr) || lerr.Code != "Catalog" || results[1].Result != nil {
+		t.Errorf("expected a Catalog error, got %+v", results[1])
+	}
+	if n, err := results[2].Result.RowsAffected(); err != nil || n != 1 {
+		t.Errorf("expected 1 row affected, got %d, %v", n, err)
+	}
+	for range statements {
+		<-commands
+	}
+
+	// In a batched transaction, the statements run on Commit
+	err = conn.Raw(func(dc any) error {
+		c := dc.(*Conn)
+		tx, err := c.BeginTx(context.Background(), driver.TxOptions{})
+		if err != nil {
+			return err
+		}
+		results, err := c.ExecBatchContext(context.Background(), statements[:1])
+		if err != nil {
+			return err
+		}
+		if _, err := results[0].Result.RowsAffected(); err == nil {
+			t.Error("expected rows affected to be unknown before Commit")
+		}
+		select {
+		case cmd := <-commands:
+			t.Errorf("expected nothing to be sent before Commit, got %q", cmd)
+		default:
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		t.Fatalf("batched transaction failed: %v", err)
+	}
+	if cmd := <-commands; cmd != "x:"+batchCommand([]string{"BEGIN TRANSACTION", statements[0]}) {
+		t.Errorf("unexpected batch command %q", cmd)
+	}
+}
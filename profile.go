@@ -0,0 +1,253 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// defaultProfileBuckets is the number of histogram buckets of numeric columns
+// when ProfileOptions.Buckets isn't set.
+const defaultProfileBuckets = 10
+
+// ProfileOptions selects what ProfileTable computes.
+type ProfileOptions struct {
+	// Columns are the columns to profile, all of them if empty.
+	Columns []string
+	// SampleRows profiles a random sample of that many rows rather than the
+	// whole table, if positive.
+	SampleRows int64
+	// Buckets is the number of equal-width histogram buckets of numeric
+	// columns (default 10). Negative values disable histograms.
+	Buckets int
+}
+
+// TableProfile holds the statistics of the columns of a table, as computed by
+// ProfileTable.
+type TableProfile struct {
+	Table string
+	// Rows is the number of rows profiled, the sample size if sampled.
+	Rows    int64
+	Columns []ColumnProfile
+}
+
+// ColumnProfile holds the statistics of a column.
+type ColumnProfile struct {
+	Name string
+	// Type is the Arrow type of the column's values.
+	Type arrow.DataType
+	// NullFraction is the fraction of the rows profiled where the column is
+	// NULL, 0 if there were none.
+	NullFraction float64
+	// DistinctEstimate is the server's HyperLogLog estimate of the number of
+	// distinct non-NULL values.
+	DistinctEstimate int64
+	// Min and Max are the smallest and largest values, as text. They're NULL if
+	// all values are NULL, and for nested columns.
+	Min sql.NullString
+	Max sql.NullString
+	// Histogram divides the range of a numeric column into equal-width
+	// buckets, nil for other columns.
+	Histogram []HistogramBucket
+}
+
+// HistogramBucket is a bucket of a column's histogram, counting the values in
+// [Lower, Upper), or [Lower, Upper] for the last bucket.
+type HistogramBucket struct {
+	Lower float64
+	Upper float64
+	Count int64
+}
+
+// ProfileTable computes per-column statistics of a table with generated SQL, as
+// a building block for data-quality checks: the fraction of NULLs, an estimate
+// of the number of distinct values, the minimum and maximum, and a histogram of
+// numeric columns. It runs one query for the column types, one for the
+// statistics and one per histogram, on the same connection. With
+// opts.SampleRows, each query draws its own sample, so histogram counts are
+// estimates that may not add up to the non-NULL rows of the statistics.
+func ProfileTable(ctx context.Context, db *sql.DB, table string, opts ProfileOptions) (*TableProfile, error) {
+	name, err := quoteTableName(table)
+	if err != nil {
+		return nil, err
+	}
+	source := "SELECT * FROM " + name
+	if opts.SampleRows > 0 {
+		source += fmt.Sprintf(" USING SAMPLE %d ROWS", opts.SampleRows)
+	}
+	buckets := opts.Buckets
+	if buckets == 0 {
+		buckets = defaultProfileBuckets
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	columns, err := profileColumns(ctx, conn, name, opts.Columns)
+	if err != nil {
+		return nil, err
+	}
+	profile := &TableProfile{Table: table, Columns: columns}
+	if err := profileStats(ctx, conn, source, profile); err != nil {
+		return nil, err
+	}
+
+	for i := range profile.Columns {
+		col := &profile.Columns[i]
+		if buckets < 0 || !isNumericType(col.Type) {
+			continue
+		}
+		if col.Histogram, err = profileHistogram(ctx, conn, source, col, buckets); err != nil {
+			return nil, fmt.Errorf("luna: histogram of %s: %w", col.Name, err)
+		}
+	}
+	return profile, nil
+}
+
+// quoteTableName quotes each part of a possibly qualified table name, e.g.
+// sales.orders.
+func quoteTableName(table string) (string, error) {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		if !isSQLIdentifier(part) {
+			return "", fmt.Errorf("luna: invalid table name %q", table)
+		}
+		parts[i] = quoteSQLIdentifier(part)
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// profileColumns returns the columns of table named in names, all of them if
+// names is empty, with their types taken from the schema of an empty result.
+func profileColumns(ctx context.Context, conn *sql.Conn, table string, names []string) ([]ColumnProfile, error) {
+	reader, err := QueryArrow(ctx, conn, "SELECT * FROM "+table+" LIMIT 0")
+	if err != nil {
+		return nil, err
+	}
+	schema := reader.Schema()
+	reader.Release()
+	if schema == nil {
+		return nil, fmt.Errorf("luna: no schema for table %s", table)
+	}
+
+	if len(names) == 0 {
+		columns := make([]ColumnProfile, schema.NumFields())
+		for i, f := range schema.Fields() {
+			columns[i] = ColumnProfile{Name: f.Name, Type: valueType(f.Type)}
+		}
+		return columns, nil
+	}
+
+	columns := make([]ColumnProfile, len(names))
+	for i, name := range names {
+		idx := schema.FieldIndices(name)
+		if len(idx) == 0 {
+			return nil, fmt.Errorf("luna: table %s has no column %q", table, name)
+		}
+		columns[i] = ColumnProfile{Name: name, Type: valueType(schema.Field(idx[0]).Type)}
+	}
+	return columns, nil
+}
+
+// profileStats fills in the row count and the statistics of the columns of
+// profile, with a single aggregate query over source.
+func profileStats(ctx context.Context, conn *sql.Conn, source string, profile *TableProfile) error {
+	exprs := []string{"count(*)"}
+	for _, col := range profile.Columns {
+		c := quoteSQLIdentifier(col.Name)
+		minMax := fmt.Sprintf("min(%s)::VARCHAR, max(%s)::VARCHAR", c, c)
+		if arrow.IsNested(col.Type.ID()) {
+			minMax = "NULL::VARCHAR, NULL::VARCHAR"
+		}
+		exprs = append(exprs, fmt.Sprintf("count(%s), approx_count_distinct(%s), %s", c, c, minMax))
+	}
+	query := "SELECT " + strings.Join(exprs, ", ") + " FROM (" + source + ")"
+
+	nonNull := make([]int64, len(profile.Columns))
+	dest := []any{&profile.Rows}
+	for i := range profile.Columns {
+		col := &profile.Columns[i]
+		dest = append(dest, &nonNull[i], &col.DistinctEstimate, &col.Min, &col.Max)
+	}
+	if err := conn.QueryRowContext(ctx, query).Scan(dest...); err != nil {
+		return err
+	}
+
+	for i := range profile.Columns {
+		if profile.Rows > 0 {
+			profile.Columns[i].NullFraction = float64(profile.Rows-nonNull[i]) / float64(profile.Rows)
+		}
+	}
+	return nil
+}
+
+// profileHistogram counts the values of a numeric column in buckets of equal
+// width between its minimum and maximum. It returns nil if the column has no
+// finite range.
+func profileHistogram(ctx context.Context, conn *sql.Conn, source string, col *ColumnProfile, buckets int) ([]HistogramBucket, error) {
+	if !col.Min.Valid || !col.Max.Valid {
+		return nil, nil
+	}
+	lo, err1 := strconv.ParseFloat(col.Min.String, 64)
+	hi, err2 := strconv.ParseFloat(col.Max.String, 64)
+	if err1 != nil || err2 != nil || math.IsInf(lo, 0) || math.IsInf(hi, 0) || math.IsNaN(lo) || math.IsNaN(hi) {
+		return nil, nil
+	}
+	if lo == hi {
+		buckets = 1
+	}
+
+	width := (hi - lo) / float64(buckets)
+	histogram := make([]HistogramBucket, buckets)
+	for i := range histogram {
+		histogram[i].Lower = lo + float64(i)*width
+		histogram[i].Upper = lo + float64(i+1)*width
+	}
+	histogram[buckets-1].Upper = hi
+
+	c := quoteSQLIdentifier(col.Name)
+	bucket := "0"
+	if width > 0 {
+		// Sampled values can fall outside the range of another sample
+		bucket = fmt.Sprintf("greatest(0, least(%d, CAST(floor((CAST(%s AS DOUBLE) - %s) / %s) AS BIGINT)))",
+			buckets-1, c, formatSQLFloat(lo), formatSQLFloat(width))
+	}
+	query := fmt.Sprintf("SELECT %s AS bucket, count(*) FROM (%s) WHERE %s IS NOT NULL GROUP BY bucket ORDER BY bucket", bucket, source, c)
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i, count int64
+		if err := rows.Scan(&i, &count); err != nil {
+			return nil, err
+		}
+		if i >= 0 && i < int64(buckets) {
+			histogram[i].Count = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return histogram, nil
+}
+
+// formatSQLFloat formats f as an SQL DOUBLE literal.
+func formatSQLFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64) + "::DOUBLE"
+}
+
+func isNumericType(dt arrow.DataType) bool {
+	id := dt.ID()
+	return arrow.IsInteger(id) || arrow.IsFloating(id) || arrow.IsDecimal(id)
+}
@@ -0,0 +1,104 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+func newProfileServer(t *testing.T) (addr string, commands chan string) {
+	return newReplyServer(t,
+		fakeReply{"LIMIT 0", schemaReply(t, arrow.NewSchema([]arrow.Field{
+			{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		}, nil))},
+		fakeReply{"approx_count_distinct", recordReply(t,
+			[]string{"rows", "id_count", "id_distinct", "id_min", "id_max", "name_count", "name_distinct", "name_min", "name_max"},
+			[]any{int64(100), int64(100), int64(98), "0", "40", int64(75), int64(3), "ann", "zoe"},
+		)},
+		fakeReply{"GROUP BY bucket", recordReply(t, []string{"bucket", "count"},
+			[]any{int64(0), int64(60)},
+			[]any{int64(3), int64(40)},
+		)},
+	)
+}
+
+func TestProfileTable(t *testing.T) {
+	addr, commands := newProfileServer(t)
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	profile, err := ProfileTable(context.Background(), db, "sales.people", ProfileOptions{SampleRows: 100, Buckets: 4})
+	if err != nil {
+		t.Fatalf("ProfileTable failed: %v", err)
+	}
+
+	expected := &TableProfile{
+		Table: "sales.people",
+		Rows:  100,
+		Columns: []ColumnProfile{
+			{
+				Name:             "id",
+				Type:             arrow.PrimitiveTypes.Int64,
+				DistinctEstimate: 98,
+				Min:              sql.NullString{String: "0", Valid: true},
+				Max:              sql.NullString{String: "40", Valid: true},
+				Histogram: []HistogramBucket{
+					{Lower: 0, Upper: 10, Count: 60},
+					{Lower: 10, Upper: 20},
+					{Lower: 20, Upper: 30},
+					{Lower: 30, Upper: 40, Count: 40},
+				},
+			},
+			{
+				Name:             "name",
+				Type:             arrow.BinaryTypes.String,
+				NullFraction:     0.25,
+				DistinctEstimate: 3,
+				Min:              sql.NullString{String: "ann", Valid: true},
+				Max:              sql.NullString{String: "zoe", Valid: true},
+			},
+		},
+	}
+	if !reflect.DeepEqual(profile, expected) {
+		t.Errorf("expected %+v, got %+v", expected, profile)
+	}
+
+	// Table names are quoted, and the sample is drawn by every query
+	close(commands)
+	var queries []string
+	for cmd := range commands {
+		queries = append(queries, cmd)
+	}
+	if len(queries) != 3 {
+		t.Fatalf("expected 3 queries, got %q", queries)
+	}
+	for _, q := range queries[1:] {
+		if !strings.Contains(q, `FROM (SELECT * FROM "sales"."people" USING SAMPLE 100 ROWS)`) {
+			t.Errorf("expected a sampled query, got %q", q)
+		}
+	}
+}
+
+func TestProfileTableErrors(t *testing.T) {
+	addr, _ := newProfileServer(t)
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := ProfileTable(context.Background(), db, "people; DROP TABLE people", ProfileOptions{}); err == nil {
+		t.Error("expected an error for an invalid table name")
+	}
+	if _, err := ProfileTable(context.Background(), db, "people", ProfileOptions{Columns: []string{"age"}}); err == nil || !strings.Contains(err.Error(), `no column "age"`) {
+		t.Errorf("expected an error for a missing column, got %v", err)
+	}
+}
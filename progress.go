@@ -0,0 +1,101 @@
+package luna
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// Progress is how much of a result has been received, as passed to the function
+// set with WithProgress.
+type Progress struct {
+	// Batches is the number of record batches received so far.
+	Batches int
+	// Bytes is the number of bytes of the result's Arrow stream received so far.
+	Bytes int64
+	// Rows is the number of rows of the record batches received so far.
+	Rows int64
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes the statements run with it call fn
+// between the record batches of their results, as they're received. Large
+// results arrive over many reads, and fn can report how far along they are. It's
+// called from the goroutine running the statement, which waits for it, and must
+// not use the connection. Queries split in several report the progress of each
+// part separately.
+//
+// The context is also checked between batches, so cancelling it stops reading a
+// result right away, even one whose batches keep arriving. The connection is then
+// discarded, since the rest of the result is left unread.
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// checkpoint returns the function called between the messages of a result's
+// Arrow stream, which reports progress to the function set in ctx with
+// WithProgress and stops reading once ctx is done.
+func (c *Conn) checkpoint(ctx context.Context) wire.Checkpoint {
+	fn, _ := ctx.Value(progressKey{}).(func(Progress))
+	if fn == nil && ctx.Done() == nil {
+		return nil
+	}
+	return func(p wire.Progress) error {
+		if fn != nil {
+			fn(Progress(p))
+		}
+		return ctx.Err()
+	}
+}
+
+// stallWatch interrupts a command whose response stops arriving for the stall
+// timeout, by expiring the connection's deadline. Waiting for the first byte of
+// the response isn't a stall: the server may take any time to run the command,
+// which is bounded by the query timeout instead. Stalls are detected between one
+// and two stall timeouts after the last byte arrived.
+type stallWatch struct {
+	c       *Conn
+	mu      sync.Mutex
+	timer   Timer
+	start   int64 // bytes received when the command was sent
+	last    int64 // bytes received at the previous check
+	stopped bool
+	stalled bool
+}
+
+// watchStall starts watching the response of the command about to be sent.
+func (c *Conn) watchStall() *stallWatch {
+	n := c.counter.count()
+	w := &stallWatch{c: c, start: n, last: n}
+	w.timer = c.clock.AfterFunc(c.stallTimeout, w.check)
+	return w
+}
+
+func (w *stallWatch) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return
+	}
+	if n := w.c.counter.count(); n == w.start || n != w.last {
+		w.last = n
+		w.timer = w.c.clock.AfterFunc(w.c.stallTimeout, w.check)
+		return
+	}
+	w.stalled = true
+	w.c.conn.SetDeadline(time.Now())
+}
+
+// stop stops watching and reports whether the response stalled.
+func (w *stallWatch) stop() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	w.timer.Stop()
+	return w.stalled
+}
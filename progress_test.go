@@ -0,0 +1,198 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// batchesReply encodes a reply with a record batch of one row for each value.
+func batchesReply(t *testing.T, values ...int64) []byte {
+	t.Helper()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	var recs []arrow.Record
+	for _, v := range values {
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		b.Field(0).(*array.Int64Builder).Append(v)
+		recs = append(recs, b.NewRecord())
+		b.Release()
+	}
+	defer func() {
+		for _, rec := range recs {
+			rec.Release()
+		}
+	}()
+
+	var buf bytes.Buffer
+	if err := writeArrowReply(&buf, schema, recs...); err != nil {
+		t.Fatalf("failed to encode reply: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProgress(t *testing.T) {
+	reply := batchesReply(t, 1, 2, 3)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write(reply)
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	var progress []Progress
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		progress = append(progress, p)
+	})
+	rows, err := conn.QueryContext(ctx, "SELECT n FROM t", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows.Close()
+
+	// Called before each batch and before the end of the stream
+	if len(progress) != 4 {
+		t.Fatalf("expected 4 progress calls, got %+v", progress)
+	}
+	for i, p := range progress {
+		if p.Batches != i {
+			t.Errorf("call %d: expected %d batches, got %d", i, i, p.Batches)
+		}
+		if i > 0 && p.Bytes <= progress[i-1].Bytes {
+			t.Errorf("call %d: expected more than %d bytes, got %d", i, progress[i-1].Bytes, p.Bytes)
+		}
+	}
+	if last := progress[len(progress)-1].Bytes; last >= int64(len(reply)) {
+		t.Errorf("expected fewer bytes than the whole reply before its end, got %d", last)
+	}
+}
+
+func TestProgressCancel(t *testing.T) {
+	reply := batchesReply(t, 1, 2, 3)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write(reply)
+		}
+	})
+
+	conn := connectFake(t, addr)
+
+	// Cancelling stops reading at the next batch, although the reply has arrived
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	ctx = WithProgress(ctx, func(p Progress) {
+		calls++
+		if p.Batches == 1 {
+			cancel()
+		}
+	})
+	if _, err := conn.QueryContext(ctx, "SELECT n FROM t", nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 progress calls, got %d", calls)
+	}
+	if conn.IsValid() {
+		t.Error("expected connection to be invalid")
+	}
+}
+
+func TestStallTimeout(t *testing.T) {
+	reply := batchesReply(t, 1, 2, 3)
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		if _, err := readCommand(reader); err != nil {
+			return
+		}
+		// The first query is slow to start replying, the second stops halfway
+		received <- struct{}{}
+		<-release
+		conn.Write(reply)
+
+		if _, err := readCommand(reader); err != nil {
+			return
+		}
+		conn.Write(reply[:len(reply)/2])
+		received <- struct{}{}
+		reader.ReadByte()
+	})
+
+	connector, err := NewConnector(addr+"?stall_timeout=10s", nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	conn := dc.(*Conn)
+
+	// Waiting for the first byte isn't a stall
+	errc := make(chan error, 1)
+	go func() {
+		rows, err := conn.QueryContext(context.Background(), "SELECT n FROM slow", nil)
+		if err == nil {
+			rows.Close()
+		}
+		errc <- err
+	}()
+	<-received
+	for range 3 {
+		clock.Advance(10 * time.Second)
+	}
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatalf("slow query failed: %v", err)
+	}
+	if n := clock.Pending(); n != 0 {
+		t.Errorf("expected no pending timers, got %d", n)
+	}
+
+	start := conn.counter.count()
+	go func() {
+		_, err := conn.QueryContext(context.Background(), "SELECT n FROM stalled", nil)
+		errc <- err
+	}()
+	<-received
+	for conn.counter.count() == start {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Bytes arrived since the watch started, so the first check re-arms it
+	clock.Advance(10 * time.Second)
+	select {
+	case err := <-errc:
+		t.Fatalf("expected the query to still be reading, got %v", err)
+	default:
+	}
+	clock.Advance(10 * time.Second)
+
+	err = <-errc
+	if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "response stalled for 10s") {
+		t.Errorf("expected a stall timeout error, got %v", err)
+	}
+	if conn.IsValid() {
+		t.Error("expected connection to be invalid")
+	}
+}
@@ -0,0 +1,16 @@
+package luna
+
+import "syscall"
+
+// setQuickAck enables TCP_QUICKACK, so that the kernel acknowledges received
+// segments right away rather than delaying acknowledgements.
+func setQuickAck(rc syscall.RawConn) error {
+	var err error
+	ctrlErr := rc.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, 1)
+	})
+	if ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
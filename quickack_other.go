@@ -0,0 +1,10 @@
+//go:build !linux
+
+package luna
+
+import "syscall"
+
+// setQuickAck does nothing: TCP_QUICKACK is specific to Linux.
+func setQuickAck(rc syscall.RawConn) error {
+	return nil
+}
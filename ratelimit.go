@@ -0,0 +1,135 @@
+package luna
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit caps the load a Connector puts on the server, across all of its connections.
+type RateLimit struct {
+	// Maximum number of commands sent per second, including pings (0 means no limit).
+	QueriesPerSecond float64
+	// Maximum number of response bytes received per second (0 means no limit).
+	BytesPerSecond int
+}
+
+// tokenBucket refills at rate tokens per second, up to burst tokens.
+// Reservations may take more tokens than available, leaving the bucket in debt.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// reserve takes n tokens and returns how long to wait until the bucket is out of debt.
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter enforces a RateLimit. It's shared by all the connections of a Connector.
+type rateLimiter struct {
+	mu      sync.Mutex
+	clock   Clock
+	queries *tokenBucket // nil if commands aren't limited
+	bytes   *tokenBucket // nil if response bytes aren't limited
+}
+
+// newRateLimiter returns a limiter for limit, or nil if limit doesn't limit anything.
+func newRateLimiter(limit RateLimit, clk Clock) *rateLimiter {
+	if limit.QueriesPerSecond <= 0 && limit.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	now := clk.Now()
+	l := &rateLimiter{clock: clk}
+	if limit.QueriesPerSecond > 0 {
+		l.queries = newTokenBucket(limit.QueriesPerSecond, now)
+	}
+	if limit.BytesPerSecond > 0 {
+		l.bytes = newTokenBucket(float64(limit.BytesPerSecond), now)
+	}
+	return l
+}
+
+// wait blocks until a command may be sent: a command token is available and
+// the bytes received by earlier commands have been paid off. It returns early
+// with ctx.Err() if ctx is done first.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	return l.waitN(ctx, 1)
+}
+
+// waitN is like wait, for n commands sent together, e.g. in a pipeline.
+func (l *rateLimiter) waitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	var delay time.Duration
+	if l.queries != nil {
+		delay = l.queries.reserve(now, float64(n))
+	}
+	if l.bytes != nil {
+		delay = max(delay, l.bytes.reserve(now, 0))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	ready := make(chan struct{})
+	timer := l.clock.AfterFunc(delay, func() { close(ready) })
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+}
+
+// received charges the limiter for n response bytes. The sizes of responses
+// aren't known in advance, so they delay the following commands instead.
+func (l *rateLimiter) received(n int64) {
+	if l.bytes == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bytes.reserve(l.clock.Now(), float64(n))
+}
+
+// countingReader counts the bytes read from r. The count may be read while a
+// command is in flight, e.g. by the stall watchdog.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// count returns the number of bytes read so far.
+func (r *countingReader) count() int64 {
+	return r.n.Load()
+}
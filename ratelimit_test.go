@@ -0,0 +1,144 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newTokenBucket(2, now)
+
+	// The bucket starts full with a burst of one second's worth of tokens
+	for i := 0; i < 2; i++ {
+		if d := b.reserve(now, 1); d != 0 {
+			t.Fatalf("reservation %d: expected no wait, got %v", i, d)
+		}
+	}
+	if d := b.reserve(now, 1); d != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms, got %v", d)
+	}
+
+	// Refills never exceed the burst
+	now = now.Add(time.Hour)
+	if d := b.reserve(now, 2); d != 0 {
+		t.Errorf("expected no wait after refill, got %v", d)
+	}
+	if d := b.reserve(now, 4); d != 2*time.Second {
+		t.Errorf("expected to wait 2s for a reservation larger than the burst, got %v", d)
+	}
+}
+
+func TestNewRateLimiterUnlimited(t *testing.T) {
+	if l := newRateLimiter(RateLimit{}, newFakeClock()); l != nil {
+		t.Errorf("expected no limiter without limits, got %+v", l)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	clock := newFakeClock()
+	l := newRateLimiter(RateLimit{QueriesPerSecond: 1}, clock)
+
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatalf("expected first command to proceed, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- l.wait(context.Background()) }()
+
+	waitForTimers(t, clock, 1)
+	select {
+	case err := <-done:
+		t.Fatalf("expected second command to wait, got %v", err)
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("expected second command to proceed, got %v", err)
+	}
+}
+
+func TestRateLimiterBytes(t *testing.T) {
+	clock := newFakeClock()
+	l := newRateLimiter(RateLimit{BytesPerSecond: 1000}, clock)
+
+	// A large response puts the limiter in debt for the following commands
+	l.received(3000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.wait(ctx) }()
+
+	waitForTimers(t, clock, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n := clock.Pending(); n != 0 {
+		t.Errorf("expected the timer to be stopped, %d pending", n)
+	}
+
+	clock.Advance(2 * time.Second)
+	if err := l.wait(context.Background()); err != nil {
+		t.Errorf("expected command to proceed once the debt is paid, got %v", err)
+	}
+}
+
+func TestConnectorRateLimit(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	connector, err := NewConnectorWithOptions(addr, WithRateLimit(RateLimit{QueriesPerSecond: 1}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	// The limit is shared by all connections of the connector
+	var conns []*Conn
+	for i := 0; i < 2; i++ {
+		dc, err := connector.Connect(context.Background())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer dc.Close()
+		conns = append(conns, dc.(*Conn))
+	}
+
+	rows, err := conns[0].QueryContext(context.Background(), "SELECT 0 AS n", nil)
+	if err != nil {
+		t.Fatalf("first query failed: %v", err)
+	}
+	rows.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		rows, err := conns[1].QueryContext(context.Background(), "SELECT 0 AS n", nil)
+		if err == nil {
+			rows.Close()
+		}
+		done <- err
+	}()
+
+	waitForTimers(t, clock, 1)
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("second query failed: %v", err)
+	}
+}
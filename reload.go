@@ -0,0 +1,175 @@
+package luna
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ConfigUpdate holds new values for the settings of a connector that can change
+// while it's in use, for Connector.UpdateConfig. Nil fields are left unchanged.
+type ConfigUpdate struct {
+	// Logger replaces the logger, e.g. one with a different level. A logger whose
+	// handler uses a slog.LevelVar can also change level without an update.
+	Logger *slog.Logger
+	// LogLevel replaces the level below which records are dropped, see
+	// WithLogLevel.
+	LogLevel slog.Leveler
+	// QueryTimeout replaces the default time limit for a single command.
+	QueryTimeout *time.Duration
+	// StallTimeout replaces the time limit without receiving any bytes once a
+	// response has started.
+	StallTimeout *time.Duration
+	// MaxInList replaces the size above which IN lists are split.
+	MaxInList *int
+	// InListOrder replaces whether queries with an ORDER BY have their IN lists
+	// split too.
+	InListOrder *bool
+	// RetryDecode replaces whether read-only queries whose result fails to
+	// decode are re-issued, see WithDecodeRetry.
+	RetryDecode *bool
+	// RateLimit replaces the rate limit. The new limit starts with full buckets,
+	// without the debt of the bytes received under the old one.
+	RateLimit *RateLimit
+	// SlowQueryThreshold replaces the duration from which statements are slow,
+	// 0 to stop checking them.
+	SlowQueryThreshold *time.Duration
+	// OnSlowQuery replaces the function called with slow statements.
+	OnSlowQuery SlowQueryFunc
+}
+
+// liveConfig is the part of a connector's configuration that UpdateConfig can
+// change while its connections are in use. It's replaced as a whole, never
+// changed in place.
+type liveConfig struct {
+	logger       *slog.Logger
+	queryTimeout time.Duration
+	stallTimeout time.Duration
+	maxInList    int
+	inListOrder  bool
+	retryDecode  bool
+	// Statements that take at least this long are passed to onSlowQuery, or
+	// logged without it.
+	slowQueryThreshold time.Duration
+	onSlowQuery        SlowQueryFunc
+	// Rate limiter shared by the connections, nil if there's no rate limit.
+	limiter *rateLimiter
+}
+
+// liveConfig returns the current live settings. They're created on first use,
+// once the options are applied and the clock is set.
+func (c *Connector) liveConfig() *liveConfig {
+	if lc := c.live.Load(); lc != nil {
+		return lc
+	}
+
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	return c.liveConfigLocked()
+}
+
+// liveConfigLocked is like liveConfig. The caller must hold c.cfgMu.
+func (c *Connector) liveConfigLocked() *liveConfig {
+	if lc := c.live.Load(); lc != nil {
+		return lc
+	}
+
+	lc := &liveConfig{
+		logger:       leveledLogger(c.cfg.Logger, c.cfg.LogLevel),
+		queryTimeout: c.cfg.QueryTimeout,
+		stallTimeout: c.cfg.StallTimeout,
+		maxInList:    c.cfg.MaxInList,
+		inListOrder:  c.cfg.InListOrder,
+		retryDecode:  c.cfg.RetryDecode,
+		limiter:      newRateLimiter(c.cfg.RateLimit, c.clock),
+
+		slowQueryThreshold: c.cfg.SlowQueryThreshold,
+		onSlowQuery:        c.cfg.OnSlowQuery,
+	}
+	c.live.Store(lc)
+	return lc
+}
+
+// UpdateConfig changes settings of a connector in use, e.g. from feature flags
+// in a long-lived service, without recreating its pool. New connections use the
+// new settings, and pooled connections pick them up the next time the pool hands
+// them out; a connection held with sql.Conn keeps its settings until it's
+// returned. Config reflects the changes. Invalid values are reported as errors,
+// and nothing is changed then.
+func (c *Connector) UpdateConfig(u ConfigUpdate) error {
+	switch {
+	case u.QueryTimeout != nil && *u.QueryTimeout < 0:
+		return fmt.Errorf("luna: invalid query timeout %v: must not be negative", *u.QueryTimeout)
+	case u.StallTimeout != nil && *u.StallTimeout < 0:
+		return fmt.Errorf("luna: invalid stall timeout %v: must not be negative", *u.StallTimeout)
+	case u.MaxInList != nil && *u.MaxInList < 0:
+		return fmt.Errorf("luna: invalid max IN list size %d: must not be negative", *u.MaxInList)
+	case u.RateLimit != nil && (u.RateLimit.QueriesPerSecond < 0 || u.RateLimit.BytesPerSecond < 0):
+		return fmt.Errorf("luna: invalid rate limit %+v: must not be negative", *u.RateLimit)
+	case u.SlowQueryThreshold != nil && *u.SlowQueryThreshold < 0:
+		return fmt.Errorf("luna: invalid slow query threshold %v: must not be negative", *u.SlowQueryThreshold)
+	}
+
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+
+	lc := *c.liveConfigLocked()
+	if u.Logger != nil {
+		c.cfg.Logger = u.Logger
+	}
+	if u.LogLevel != nil {
+		c.cfg.LogLevel = u.LogLevel
+	}
+	if u.Logger != nil || u.LogLevel != nil {
+		lc.logger = leveledLogger(c.cfg.Logger, c.cfg.LogLevel)
+	}
+	if u.QueryTimeout != nil {
+		c.cfg.QueryTimeout, lc.queryTimeout = *u.QueryTimeout, *u.QueryTimeout
+	}
+	if u.StallTimeout != nil {
+		c.cfg.StallTimeout, lc.stallTimeout = *u.StallTimeout, *u.StallTimeout
+	}
+	if u.MaxInList != nil {
+		c.cfg.MaxInList, lc.maxInList = *u.MaxInList, *u.MaxInList
+	}
+	if u.InListOrder != nil {
+		c.cfg.InListOrder, lc.inListOrder = *u.InListOrder, *u.InListOrder
+	}
+	if u.RetryDecode != nil {
+		c.cfg.RetryDecode, lc.retryDecode = *u.RetryDecode, *u.RetryDecode
+	}
+	if u.RateLimit != nil {
+		c.cfg.RateLimit = *u.RateLimit
+		lc.limiter = newRateLimiter(*u.RateLimit, c.clock)
+	}
+	if u.SlowQueryThreshold != nil {
+		c.cfg.SlowQueryThreshold, lc.slowQueryThreshold = *u.SlowQueryThreshold, *u.SlowQueryThreshold
+	}
+	if u.OnSlowQuery != nil {
+		c.cfg.OnSlowQuery, lc.onSlowQuery = u.OnSlowQuery, u.OnSlowQuery
+	}
+	c.live.Store(&lc)
+
+	lc.logger.Info("configuration updated")
+	return nil
+}
+
+// applyLiveConfig copies the connector's live settings, if they changed since
+// the connection last did. The caller must hold c.mu.
+func (c *Conn) applyLiveConfig() {
+	lc := c.liveConfig()
+	if lc == c.applied {
+		return
+	}
+
+	c.applied = lc
+	c.logger = lc.logger
+	c.queryTimeout = lc.queryTimeout
+	c.stallTimeout = lc.stallTimeout
+	c.maxInList = lc.maxInList
+	c.inListOrder = lc.inListOrder
+	c.retryDecode = lc.retryDecode
+	c.limiter = lc.limiter
+	c.slowQueryThreshold = lc.slowQueryThreshold
+	c.onSlowQuery = lc.onSlowQuery
+}
@@ -0,0 +1,138 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			conn.Write(arrowReply(t, "n", 1))
+		}
+	})
+
+	connector, err := NewConnectorWithOptions(addr)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	query := "SELECT n FROM t WHERE id IN (1, 2, 3)"
+	count := func() int {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+
+		n := len(commands)
+		for range n {
+			<-commands
+		}
+		return n
+	}
+
+	if n := count(); n != 1 {
+		t.Fatalf("expected 1 command, got %d", n)
+	}
+
+	// Invalid updates change nothing
+	negative := -time.Second
+	maxInList := 2
+	if err := connector.UpdateConfig(ConfigUpdate{QueryTimeout: &negative, MaxInList: &maxInList}); err == nil {
+		t.Error("expected an error for a negative query timeout")
+	}
+	if cfg := connector.Config(); cfg.MaxInList != 0 {
+		t.Errorf("expected MaxInList to be unchanged, got %d", cfg.MaxInList)
+	}
+
+	timeout := time.Minute
+	if err := connector.UpdateConfig(ConfigUpdate{QueryTimeout: &timeout, MaxInList: &maxInList}); err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if cfg := connector.Config(); cfg.MaxInList != 2 || cfg.QueryTimeout != time.Minute {
+		t.Errorf("expected the updated settings, got MaxInList %d, QueryTimeout %v", cfg.MaxInList, cfg.QueryTimeout)
+	}
+
+	// The pooled connection splits the IN list from now on
+	if n := count(); n != 2 {
+		t.Errorf("expected 2 commands, got %d", n)
+	}
+}
+
+func TestUpdateConfigSlowQuery(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			conn.Write(arrowReply(t, "n", 1))
+		}
+	})
+
+	var logs bytes.Buffer
+	connector, err := NewConnectorWithOptions(addr, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))), WithLogLevel(slog.LevelError))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	query := func() {
+		var n int64
+		if err := db.QueryRow("SELECT n FROM t").Scan(&n); err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+	}
+	query()
+
+	negative := -time.Second
+	if err := connector.UpdateConfig(ConfigUpdate{SlowQueryThreshold: &negative}); err == nil {
+		t.Error("expected an error for a negative slow query threshold")
+	}
+
+	// The pooled connection logs slow statements from now on
+	threshold := 10 * time.Millisecond
+	if err := connector.UpdateConfig(ConfigUpdate{SlowQueryThreshold: &threshold, LogLevel: slog.LevelWarn}); err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	if cfg := connector.Config(); cfg.SlowQueryThreshold != threshold || cfg.LogLevel != slog.LevelWarn {
+		t.Errorf("expected the updated settings, got SlowQueryThreshold %v, LogLevel %v", cfg.SlowQueryThreshold, cfg.LogLevel)
+	}
+	query()
+	if out := logs.String(); strings.Count(out, `msg="slow query"`) != 1 {
+		t.Errorf("expected the second query to be logged as slow, got %q", out)
+	}
+
+	// Or passes them to the new function
+	var slow []string
+	if err := connector.UpdateConfig(ConfigUpdate{OnSlowQuery: func(ctx context.Context, query string, dur time.Duration, rows int64) {
+		slow = append(slow, query)
+	}}); err != nil {
+		t.Fatalf("UpdateConfig failed: %v", err)
+	}
+	query()
+	if len(slow) != 1 || slow[0] != "SELECT n FROM t" {
+		t.Errorf("expected the query to be passed to OnSlowQuery, got %q", slow)
+	}
+}
@@ -1,18 +1,121 @@
 package luna
 
-import "database/sql/driver"
+import (
+	"database/sql/driver"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
 
 type result struct {
 	rowsAffected int64
+	// Value returned by an INSERT ... RETURNING run with ExecContext, nil if
+	// there's none.
+	lastInsertID *int64
+	// Metadata the server sent with the reply.
+	stats ResultStats
 }
 
-// Implements the driver.Result interface.
+// Implements the driver.Result interface. Only statements with a RETURNING
+// clause producing a single integer value, e.g. INSERT ... RETURNING id, have a
+// last insert ID.
 func (r *result) LastInsertId() (int64, error) {
-	// Luna doesn't support last insert ID
-	return 0, driver.ErrSkip
+	if r.lastInsertID == nil {
+		return 0, driver.ErrSkip
+	}
+	return *r.lastInsertID, nil
 }
 
 // Implements the driver.Result interface.
 func (r *result) RowsAffected() (int64, error) {
 	return r.rowsAffected, nil
 }
+
+// followedBy returns the result of a command whose statements had the results r
+// and then next: their rows affected add up, and next's last insert ID, if it
+// has one, and stats replace r's. r may be nil, for the first statement.
+func (r *result) followedBy(next *result) *result {
+	if r == nil {
+		return next
+	}
+
+	combined := *next
+	combined.rowsAffected += r.rowsAffected
+	if combined.lastInsertID == nil {
+		combined.lastInsertID = r.lastInsertID
+	}
+	return &combined
+}
+
+// execResult builds the result of a statement run with ExecContext from the
+// Arrow records of its reply: a single row with a single integer column named
+// Count, or the rows of a RETURNING clause, which count as the rows affected.
+// A RETURNING clause producing a single integer value also sets the last insert
+// ID. Other replies have no rows affected.
+func execResult(query string, records []arrow.Record) *result {
+	var rows int64
+	for _, rec := range records {
+		rows += rec.NumRows()
+	}
+
+	if hasReturning(query) {
+		res := &result{rowsAffected: rows}
+		if rows == 1 {
+			res.lastInsertID = singleInt(records)
+		}
+		return res
+	}
+
+	if n := singleInt(records); n != nil && strings.EqualFold(records[0].ColumnName(0), "Count") {
+		return &result{rowsAffected: *n}
+	}
+	return &result{}
+}
+
+// hasReturning reports whether query has a RETURNING clause.
+func hasReturning(query string) bool {
+	for _, w := range scanSQLWords(query) {
+		if w.text == "RETURNING" {
+			return true
+		}
+	}
+	return false
+}
+
+// singleInt returns the value of a result with a single row and a single
+// integer column, or nil if the result has another shape or the value is NULL.
+func singleInt(records []arrow.Record) *int64 {
+	for _, rec := range records {
+		if rec.NumRows() == 0 {
+			continue
+		}
+		if rec.NumRows() != 1 || rec.NumCols() != 1 || rec.Column(0).IsNull(0) {
+			return nil
+		}
+
+		var n int64
+		switch col := rec.Column(0).(type) {
+		case *array.Int64:
+			n = col.Value(0)
+		case *array.Int32:
+			n = int64(col.Value(0))
+		case *array.Int16:
+			n = int64(col.Value(0))
+		case *array.Int8:
+			n = int64(col.Value(0))
+		case *array.Uint64:
+			n = int64(col.Value(0))
+		case *array.Uint32:
+			n = int64(col.Value(0))
+		case *array.Uint16:
+			n = int64(col.Value(0))
+		case *array.Uint8:
+			n = int64(col.Value(0))
+		default:
+			return nil
+		}
+		return &n
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// arrowReply encodes an Arrow reply with a single Int64 column holding values.
+func arrowReply(t *testing.T, column string, values ...int64) []byte {
+	t.Helper()
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+		{Name: column, Type: arrow.PrimitiveTypes.Int64},
+	}, nil))
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).AppendValues(values, nil)
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	if err := writeArrowReply(&buf, rec.Schema(), rec); err != nil {
+		t.Fatalf("failed to encode reply: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExecRowsAffected(t *testing.T) {
+	replies := map[string][]byte{
+		"x:DELETE FROM int":                            []byte(":3\r\n"),
+		"x:DELETE FROM count":                          arrowReply(t, "Count", 5),
+		"x:CREATE TABLE t":                             []byte("+OK\r\n"),
+		"x:INSERT INTO returns":                        arrowReply(t, "id", 7, 8),
+		"x:DELETE FROM bad":                            []byte(":many\r\n"),
+		"x:INSERT INTO t VALUES (1) RETURNING id":      arrowReply(t, "id", 7),
+		"x:INSERT INTO t VALUES (1), (2) RETURNING id": arrowReply(t, "id", 8, 9),
+	}
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			conn.Write(replies[cmd])
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	testCases := []struct {
+		query        string
+		expected     int64
+		err          bool
+		lastInsertID int64 // 0 if there's none
+	}{
+		{query: "DELETE FROM int", expected: 3},
+		{query: "DELETE FROM count", expected: 5},
+		{query: "CREATE TABLE t", expected: 0},
+		{query: "INSERT INTO returns", expected: 0},
+		{query: "DELETE FROM bad", err: true},
+		// The connection is still usable after an invalid integer reply
+		{query: "DELETE FROM int", expected: 3},
+		{query: "INSERT INTO t VALUES (1) RETURNING id", expected: 1, lastInsertID: 7},
+		{query: "INSERT INTO t VALUES (1), (2) RETURNING id", expected: 2},
+	}
+
+	for _, tc := range testCases {
+		res, err := db.Exec(tc.query)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: exec failed: %v", tc.query, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			t.Fatalf("%s: RowsAffected failed: %v", tc.query, err)
+		}
+		if n != tc.expected {
+			t.Errorf("%s: expected %d rows affected, got %d", tc.query, tc.expected, n)
+		}
+
+		id, err := res.LastInsertId()
+		if tc.lastInsertID == 0 {
+			if !errors.Is(err, driver.ErrSkip) {
+				t.Errorf("%s: expected no last insert ID, got %d, %v", tc.query, id, err)
+			}
+		} else if err != nil || id != tc.lastInsertID {
+			t.Errorf("%s: expected last insert ID %d, got %d, %v", tc.query, tc.lastInsertID, id, err)
+		}
+	}
+}
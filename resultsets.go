@@ -0,0 +1,192 @@
+package luna
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// resultSet is the result of a statement of a multi-statement query, waiting to
+// be read after the result sets before it.
+type resultSet struct {
+	schema  *arrow.Schema
+	records []arrow.Record
+	mem     *trackingAllocator
+	stats   ResultStats
+	batches []BatchStat
+	err     error
+}
+
+func releaseResultSets(sets []resultSet) {
+	for _, rs := range sets {
+		wire.ReleaseRecords(rs.records)
+	}
+}
+
+// statementResult is the reply to a statement of a query, before the checks and
+// client-side processing of its result set. err is a failure partway, after the
+// records.
+type statementResult struct {
+	resultSet
+	query string
+	start time.Time
+}
+
+func releaseStatementResults(results []statementResult) {
+	for _, r := range results {
+		wire.ReleaseRecords(r.records)
+	}
+}
+
+// queryStatements runs a query and returns the reply to each of its statements.
+// A query of several statements is sent as a single command, and the server
+// replies to each statement in turn, up to the first that fails. Each result set
+// is decoded with an allocator wrapping queryMem.
+func (c *Conn) queryStatements(ctx context.Context, query string, queryMem *trackingAllocator) ([]statementResult, error) {
+	statements := splitSQLStatements(query)
+	if len(statements) <= 1 {
+		r := statementResult{query: query, start: c.clock.Now()}
+		r.mem = newTrackingAllocator(queryMem)
+		received := c.counter.count()
+		schema, records, err := c.queryArrow(ctx, query, r.mem)
+		var partial *partialResultError
+		if errors.As(err, &partial) {
+			r.err = partial.err
+		} else if err != nil {
+			return nil, err
+		}
+		r.schema, r.records, r.stats, r.batches = schema, records, c.stats, c.batches
+		c.tables.record(query, countRows(records), c.counter.count()-received)
+		return []statementResult{r}, nil
+	}
+
+	// IN lists aren't split, since the statements are sent together
+	c.resultRows, c.resultBytes = 0, 0
+	script := c.scriptWithTempTables(query, statements)
+	cmd := commandPrefix(ctx, wire.CmdQuery)
+	var results []statementResult
+	err := c.roundTrip(ctx, "query", query, func() error {
+		// Each statement starts when the reply to the one before it ends
+		start := c.clock.Now()
+		if err := wire.SendCommand(c.conn, cmd, script); err != nil {
+			return c.sendError(err)
+		}
+
+		for _, stmt := range statements {
+			r := statementResult{query: stmt, start: start}
+			r.mem = newTrackingAllocator(queryMem)
+			c.stats, c.batches = noStats, nil
+			received := c.counter.count()
+			// Errors refer to the command as a whole
+			schema, records, err := c.readQueryResult(ctx, query, r.mem)
+			var partial *partialResultError
+			if errors.As(err, &partial) {
+				r.err = partial.err
+			} else if err != nil {
+				return err
+			}
+			r.schema, r.records, r.stats, r.batches = schema, records, c.stats, c.batches
+			c.tables.record(stmt, countRows(records), c.counter.count()-received)
+			results = append(results, r)
+			start = c.clock.Now()
+			if r.err != nil {
+				// The statements after a failed one aren't run, and don't reply
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		releaseStatementResults(results)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// scriptWithTempTables returns the multi-statement query made of statements with
+// the registered temp tables each of them uses, or query itself if none does.
+func (c *Conn) scriptWithTempTables(query string, statements []string) string {
+	parts := make([]string, len(statements))
+	changed := false
+	for i, stmt := range statements {
+		parts[i] = c.withTempTables(stmt)
+		changed = changed || parts[i] != stmt
+	}
+	if !changed {
+		return query
+	}
+	// Semicolons go on lines of their own, as in batchCommand
+	return strings.Join(parts, "\n;\n")
+}
+
+// splitSQLStatements splits query into the statements separated by its
+// semicolons, skipping the semicolons in quoted strings, quoted identifiers and
+// comments. Statements without any keyword, e.g. the empty one after a trailing
+// semicolon, are dropped.
+func splitSQLStatements(query string) []string {
+	var statements []string
+	add := func(s string) {
+		if len(scanSQLWords(s)) > 0 {
+			statements = append(statements, strings.TrimSpace(s))
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == '\'' || c == '"':
+			i = skipSQLQuoted(query, i)
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(query)
+			}
+		case c == ';':
+			add(query[start:i])
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	add(query[start:])
+
+	return statements
+}
+
+// Implements the driver.RowsNextResultSet interface.
+func (r *Rows) HasNextResultSet() bool {
+	return !r.closed && len(r.next) > 0
+}
+
+// Implements the driver.RowsNextResultSet interface. The records of the current
+// result set are released.
+func (r *Rows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+
+	wire.ReleaseRecords(r.records)
+	rs := r.next[0]
+	r.next = r.next[1:]
+
+	next := newRowsFromArrow(rs.schema, rs.records)
+	r.records, r.schema, r.columns = next.records, next.schema, next.columns
+	r.recordIdx, r.rowIdx = 0, 0
+	r.mem = rs.mem
+	r.stats = rs.stats
+	r.batches = rs.batches
+	r.err = rs.err
+	return nil
+}
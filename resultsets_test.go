@@ -0,0 +1,224 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1;", []string{"SELECT 1"}},
+		{"SELECT 1; SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"SELECT ';'; SELECT \"a;b\" FROM t", []string{"SELECT ';'", `SELECT "a;b" FROM t`}},
+		{"SELECT 1 -- one; two\n; SELECT 2", []string{"SELECT 1 -- one; two", "SELECT 2"}},
+		{"SELECT /* ; */ 1;; ;SELECT 2", []string{"SELECT /* ; */ 1", "SELECT 2"}},
+		{"SELECT 1; -- done", []string{"SELECT 1"}},
+		{"SELECT 'it''s; fine'", []string{"SELECT 'it''s; fine'"}},
+		{"", nil},
+	}
+
+	for _, tc := range testCases {
+		if got := splitSQLStatements(tc.query); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("splitSQLStatements(%q): expected %q, got %q", tc.query, tc.expected, got)
+		}
+	}
+}
+
+// newEchoServer starts a fake server that replies to each statement with a
+// single row holding the statement text, and fails statements containing
+// "fail". The commands are sent to the returned channel, which holds up to 100.
+func newEchoServer(t *testing.T) (addr string, commands chan string) {
+	commands = make(chan string, 100)
+	addr = newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+
+			for _, stmt := range statementCommands(cmd) {
+				if strings.Contains(stmt, "fail") {
+					conn.Write([]byte("-Binder Error: fail\r\n"))
+					break
+				}
+
+				b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+					{Name: "query", Type: arrow.BinaryTypes.String},
+				}, nil))
+				b.Field(0).(*array.StringBuilder).Append(strings.TrimPrefix(stmt, "q:"))
+				rec := b.NewRecord()
+				writeArrowReply(conn, rec.Schema(), rec)
+				rec.Release()
+				b.Release()
+			}
+		}
+	})
+	return addr, commands
+}
+
+func TestMultipleResultSets(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	addr, commands := newEchoServer(t)
+	connector, err := NewConnectorWithOptions(addr, WithAllocator(mem))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	query := "SELECT 1; SELECT ';' -- x;\n; SELECT 3;"
+	rows, err := db.QueryContext(context.Background(), query)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	// The statements are sent together, in a single command
+	if cmd := <-commands; cmd != "q:"+query {
+		t.Errorf("expected a single command with the whole query, got %q", cmd)
+	}
+
+	var got []string
+	for {
+		for rows.Next() {
+			var q string
+			if err := rows.Scan(&q); err != nil {
+				t.Fatalf("scan failed: %v", err)
+			}
+			got = append(got, q)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows failed: %v", err)
+	}
+	rows.Close()
+
+	expected := []string{"SELECT 1", "SELECT ';' -- x;", "SELECT 3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected result sets %q, got %q", expected, got)
+	}
+
+	// Closing before reading the other result sets releases them too
+	rows, err = db.QueryContext(context.Background(), "SELECT 1; SELECT 2")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows.Close()
+
+	// A failing statement fails the whole query
+	_, err = db.QueryContext(context.Background(), "SELECT 1; SELECT fail; SELECT 3")
+	if err == nil || !strings.Contains(err.Error(), "Binder Error") {
+		t.Errorf("expected the error of the second statement, got %v", err)
+	}
+
+	mem.AssertSize(t, 0)
+}
+
+func TestMultiStatementExec(t *testing.T) {
+	addr, commands := newReplyServer(t,
+		fakeReply{match: "INTO a", reply: []byte(":1\r\n")},
+		fakeReply{match: "INTO b", reply: []byte(":2\r\n")},
+		fakeReply{match: "CREATE", reply: []byte("+OK\r\n")},
+	)
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	// The rows affected by each statement add up
+	query := "CREATE TABLE a (n INT); INSERT INTO a VALUES (1); INSERT INTO b VALUES (2), (3)"
+	res, err := db.Exec(query)
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 3 {
+		t.Errorf("expected 3 rows affected, got %d, %v", n, err)
+	}
+	if cmd := <-commands; cmd != "x:"+query {
+		t.Errorf("expected a single command with the whole query, got %q", cmd)
+	}
+
+	// The statements after a failing one aren't run
+	_, err = db.Exec("INSERT INTO a VALUES (1); DROP TABLE c; INSERT INTO b VALUES (2)")
+	if err == nil || !strings.Contains(err.Error(), "unexpected command") {
+		t.Errorf("expected the error of the second statement, got %v", err)
+	}
+	<-commands
+
+	// The connection is still in sync with the server
+	if _, err := db.Exec("INSERT INTO a VALUES (1)"); err != nil {
+		t.Errorf("exec failed: %v", err)
+	}
+}
+
+func TestMultiStatementQueryArrow(t *testing.T) {
+	addr, commands := newEchoServer(t)
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get a connection: %v", err)
+	}
+	defer conn.Close()
+
+	// The result is that of the last statement
+	reader, err := QueryArrow(context.Background(), conn, "SELECT 1; SELECT 2")
+	if err != nil {
+		t.Fatalf("QueryArrow failed: %v", err)
+	}
+	var got []string
+	for reader.Next() {
+		col := reader.Record().Column(0).(*array.String)
+		for i := 0; i < col.Len(); i++ {
+			got = append(got, col.Value(i))
+		}
+	}
+	reader.Release()
+	if !reflect.DeepEqual(got, []string{"SELECT 2"}) {
+		t.Errorf("expected the result of the last statement, got %q", got)
+	}
+	if cmd := <-commands; cmd != "q:SELECT 1; SELECT 2" {
+		t.Errorf("expected a single command with the whole query, got %q", cmd)
+	}
+
+	_, err = QueryArrow(context.Background(), conn, "SELECT fail; SELECT 2")
+	if err == nil || !strings.Contains(err.Error(), "Binder Error") {
+		t.Errorf("expected the error of the first statement, got %v", err)
+	}
+}
+
+func TestSingleStatementSentAsIs(t *testing.T) {
+	addr, _ := newEchoServer(t)
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	var q string
+	if err := db.QueryRow("SELECT 1;").Scan(&q); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if q != "SELECT 1;" {
+		t.Errorf("expected the query text unchanged, got %q", q)
+	}
+}
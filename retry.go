@@ -0,0 +1,80 @@
+package luna
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// Leading keywords of read-only statements.
+var readOnlyFirstWords = map[string]bool{
+	"SELECT":    true,
+	"WITH":      true,
+	"FROM":      true,
+	"VALUES":    true,
+	"TABLE":     true,
+	"SHOW":      true,
+	"DESCRIBE":  true,
+	"SUMMARIZE": true,
+}
+
+// Keywords that make a statement write or change the session, anywhere in it,
+// e.g. in a data-modifying CTE.
+var writeWords = map[string]bool{
+	"INSERT":     true,
+	"UPDATE":     true,
+	"DELETE":     true,
+	"MERGE":      true,
+	"TRUNCATE":   true,
+	"CREATE":     true,
+	"DROP":       true,
+	"ALTER":      true,
+	"COPY":       true,
+	"EXPORT":     true,
+	"IMPORT":     true,
+	"ATTACH":     true,
+	"DETACH":     true,
+	"INSTALL":    true,
+	"LOAD":       true,
+	"SET":        true,
+	"RESET":      true,
+	"CALL":       true,
+	"PRAGMA":     true,
+	"CHECKPOINT": true,
+	"VACUUM":     true,
+	"INTO":       true,
+	"NEXTVAL":    true,
+}
+
+// isReadOnlySQL reports whether query only reads data, so that running it again
+// has no side effects. It's conservative: queries it can't tell apart, such as
+// ones mentioning a column named like a write keyword, are reported as writes.
+// Queries of several statements are never reported as read-only, since a retry
+// would run the statements before the failing one again.
+func isReadOnlySQL(query string) bool {
+	if len(splitSQLStatements(query)) > 1 {
+		return false
+	}
+	words := scanSQLWords(query)
+	if len(words) == 0 || !readOnlyFirstWords[words[0].text] {
+		return false
+	}
+	for _, w := range words {
+		if writeWords[w.text] {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeError converts a failure to decode the Arrow data of a query result into
+// a Go error, like readError. With decode retries enabled, failures of read-only
+// queries also match driver.ErrBadConn, so that database/sql re-issues the query
+// on another connection.
+func (c *Conn) decodeError(query string, err error) error {
+	err = c.readError("failed to parse Arrow IPC", err)
+	if c.retryDecode && isReadOnlySQL(query) {
+		c.logger.Warn("failed to decode query result, retrying on another connection", "error", err)
+		return fmt.Errorf("%w: %w", err, driver.ErrBadConn)
+	}
+	return err
+}
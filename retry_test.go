@@ -0,0 +1,100 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+func TestIsReadOnlySQL(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected bool
+	}{
+		{"SELECT * FROM t", true},
+		{"  select count(*) from t where s = 'INSERT'", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"FROM t LIMIT 10", true},
+		{"SHOW TABLES", true},
+		{"-- DELETE\nSELECT 1", true},
+		{"INSERT INTO t VALUES (1) RETURNING id", false},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", false},
+		{"SELECT nextval('seq')", false},
+		{"CREATE TABLE t AS SELECT 1", false},
+		{"COPY (SELECT 1) TO 'out.csv'", false},
+		{"EXPLAIN ANALYZE SELECT 1", false},
+		{"SELECT 1; SELECT 2", false},
+		{"SELECT 1;", true},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		if got := isReadOnlySQL(tc.query); got != tc.expected {
+			t.Errorf("isReadOnlySQL(%q): expected %v, got %v", tc.query, tc.expected, got)
+		}
+	}
+}
+
+func TestDecodeRetry(t *testing.T) {
+	rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+	defer rec.Release()
+	var reply bytes.Buffer
+	writeArrowReply(&reply, rec.Schema(), rec)
+
+	// The first connection sends a truncated result and hangs up
+	var accepted atomic.Int64
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		n := accepted.Add(1)
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			if n == 1 {
+				conn.Write(reply.Bytes()[:reply.Len()-20])
+				return
+			}
+			conn.Write(reply.Bytes())
+		}
+	})
+
+	testCases := []struct {
+		name     string
+		dsn      string
+		query    string
+		conns    int64
+		expected bool
+	}{
+		{name: "disabled", dsn: addr, query: "SELECT n FROM t", conns: 1},
+		{name: "read-only query", dsn: addr + "?retry_decode=1", query: "SELECT n FROM t", conns: 2, expected: true},
+		{name: "write", dsn: addr + "?retry_decode=1", query: "INSERT INTO t VALUES (1) RETURNING n", conns: 1},
+		{name: "multiple statements", dsn: addr + "?retry_decode=1", query: "SELECT n FROM t; SELECT n FROM t", conns: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			accepted.Store(0)
+			db, err := sql.Open("luna", tc.dsn)
+			if err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+
+			var n int64
+			err = db.QueryRow(tc.query).Scan(&n)
+			if tc.expected && err != nil {
+				t.Errorf("expected the query to be retried, got %v", err)
+			}
+			if !tc.expected && err == nil {
+				t.Error("expected the decode error")
+			}
+			if got := accepted.Load(); got != tc.conns {
+				t.Errorf("expected %d connections, got %d", tc.conns, got)
+			}
+		})
+	}
+}
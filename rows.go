@@ -4,24 +4,66 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"io"
+	"reflect"
+	"time"
 
 	"github.com/apache/arrow/go/v17/arrow"
 	"github.com/apache/arrow/go/v17/arrow/array"
 )
 
+// valueOptions control how getValueFromColumn converts Arrow values.
+type valueOptions struct {
+	// How list, struct and map values are returned.
+	nestedMode NestedMode
+	// How decimals are returned.
+	decimalMode DecimalMode
+	// How UUIDs are returned.
+	uuidMode UUIDMode
+	// How strings that aren't valid UTF-8 are handled.
+	utf8Mode UTF8Mode
+	// Location of the returned TIMESTAMPTZ values, nil to use the time zone of
+	// their column.
+	location *time.Location
+}
+
 type Rows struct {
 	records   []arrow.Record
+	schema    *arrow.Schema
 	recordIdx int
 	rowIdx    int64
 	columns   []string
 	closed    bool
+	// How values are converted for database/sql.
+	valueOptions
+	// Allocator the records were decoded with, if memory is tracked.
+	mem *trackingAllocator
+	// Allocator the records of all the result sets were decoded with, for the
+	// query hooks, nil if memory isn't tracked.
+	queryMem *trackingAllocator
+	// Result sets of the following statements of a multi-statement query.
+	next []resultSet
+	// Metadata the server sent with the result.
+	stats ResultStats
+	// Stats of the record batches received for the result.
+	batches []BatchStat
+	// Error that ended the result partway, returned by Next after the rows sent
+	// before it.
+	err error
+	// Number of rows returned by Next, across result sets.
+	read int64
+	// Called by Close with the number of rows read, nil if there are no hooks.
+	onClose func(read int64)
 }
 
-// newRowsFromArrow creates a new Rows from Arrow records
-func newRowsFromArrow(records []arrow.Record) *Rows {
+// newRowsFromArrow creates a new Rows from Arrow records. If schema is nil,
+// the schema of the first record is used.
+func newRowsFromArrow(schema *arrow.Schema, records []arrow.Record) *Rows {
+	if schema == nil && len(records) > 0 {
+		schema = records[0].Schema()
+	}
+
 	var columns []string
-	if len(records) > 0 && records[0].Schema() != nil {
-		schema := records[0].Schema()
+	if schema != nil {
 		for i := 0; i < int(schema.NumFields()); i++ {
 			columns = append(columns, schema.Field(i).Name)
 		}
@@ -29,9 +71,11 @@ func newRowsFromArrow(records []arrow.Record) *Rows {
 
 	return &Rows{
 		records:   records,
+		schema:    schema,
 		recordIdx: 0,
 		rowIdx:    0,
 		columns:   columns,
+		stats:     noStats,
 	}
 }
 
@@ -39,6 +83,101 @@ func (r *Rows) Columns() []string {
 	return r.columns
 }
 
+// ColumnTypeScanType implements the driver.RowsColumnTypeScanType interface.
+// It returns the Go type that Next produces for the column at index.
+func (r *Rows) ColumnTypeScanType(index int) reflect.Type {
+	if r.schema == nil || index < 0 || index >= r.schema.NumFields() {
+		return scanTypeAny
+	}
+
+	dt := valueType(r.schema.Field(index).Type)
+	if st := nestedScanType(dt, r.nestedMode); st != nil {
+		return st
+	}
+	if st := decimalScanType(dt, r.decimalMode); st != nil {
+		return st
+	}
+	if st := fixedSizeBinaryScanType(dt, r.uuidMode); st != nil {
+		return st
+	}
+	return scanTypeOf(dt)
+}
+
+// ColumnTypeNullable implements the driver.RowsColumnTypeNullable interface.
+// Nullability is taken from the Arrow field of the result schema.
+func (r *Rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if r.schema == nil || index < 0 || index >= r.schema.NumFields() {
+		return false, false
+	}
+
+	return r.schema.Field(index).Nullable, true
+}
+
+// ColumnTypePrecisionScale implements the driver.RowsColumnTypePrecisionScale interface.
+// Only Decimal128 and Decimal256 columns report a precision and scale.
+func (r *Rows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if r.schema == nil || index < 0 || index >= r.schema.NumFields() {
+		return 0, 0, false
+	}
+
+	switch dt := valueType(r.schema.Field(index).Type).(type) {
+	case *arrow.Decimal128Type:
+		return int64(dt.Precision), int64(dt.Scale), true
+	case *arrow.Decimal256Type:
+		return int64(dt.Precision), int64(dt.Scale), true
+	default:
+		return 0, 0, false
+	}
+}
+
+// valueType returns the type of the values of a column of type dt, which differs
+// from dt for dictionary-encoded columns.
+func valueType(dt arrow.DataType) arrow.DataType {
+	if dict, ok := dt.(*arrow.DictionaryType); ok {
+		return dict.ValueType
+	}
+	return dt
+}
+
+// Schema returns the Arrow schema of the result, or nil if the server didn't send one.
+func (r *Rows) Schema() *arrow.Schema {
+	return r.schema
+}
+
+// MemoryStats reports the Arrow memory allocated to decode this result and how much
+// of it has been released. It's available through sql.Conn.Raw, and after Close the
+// stats reflect the released record buffers.
+func (r *Rows) MemoryStats() MemoryStats {
+	if r.mem == nil {
+		return MemoryStats{}
+	}
+
+	return r.mem.stats()
+}
+
+// RecordReader returns a reader over the record batches of the result that
+// haven't been read with Next, e.g. to pipe a result obtained through
+// sql.Conn.Raw into an ipc.Writer. Reading from it doesn't advance the rows.
+// The caller must release the reader; it stays valid after the rows are closed.
+func (r *Rows) RecordReader() *RecordReader {
+	var records []arrow.Record
+	for i := r.recordIdx; i < len(r.records); i++ {
+		rec := r.records[i]
+		if i == r.recordIdx && r.rowIdx > 0 {
+			// Skip the rows of the current record already read with Next
+			if r.rowIdx >= rec.NumRows() {
+				continue
+			}
+			records = append(records, rec.NewSlice(r.rowIdx, rec.NumRows()))
+			continue
+		}
+		rec.Retain()
+		records = append(records, rec)
+	}
+
+	return newRecordReader(r.schema, records)
+}
+
 func (r *Rows) Next(dest []driver.Value) error {
 	if r.closed {
 		return io.EOF
@@ -52,13 +191,14 @@ func (r *Rows) Next(dest []driver.Value) error {
 			// Extract values from current row
 			for i := 0; i < int(record.NumCols()); i++ {
 				col := record.Column(i)
-				val, err := getValueFromColumn(col, int(r.rowIdx))
+				val, err := getValueFromColumn(col, int(r.rowIdx), r.valueOptions)
 				if err != nil {
 					return err
 				}
 				dest[i] = val
 			}
 			r.rowIdx++
+			r.read++
 			return nil
 		}
 
@@ -67,9 +207,22 @@ func (r *Rows) Next(dest []driver.Value) error {
 		r.rowIdx = 0
 	}
 
+	if r.err != nil {
+		// The query failed after sending these rows
+		return r.err
+	}
 	return io.EOF
 }
 
+// numRows returns the number of rows of all the result sets.
+func (r *Rows) numRows() int64 {
+	n := countRows(r.records)
+	for _, set := range r.next {
+		n += countRows(set.records)
+	}
+	return n
+}
+
 func (r *Rows) Close() error {
 	if r.closed {
 		return nil
@@ -82,12 +235,83 @@ func (r *Rows) Close() error {
 		record.Release()
 	}
 	r.records = nil
+	releaseResultSets(r.next)
+	r.next = nil
 
+	if r.onClose != nil {
+		r.onClose(r.read)
+	}
 	return nil
 }
 
-// getValueFromColumn extracts a value from an Arrow column at the given row index
-func getValueFromColumn(col arrow.Array, rowIdx int) (interface{}, error) {
+var (
+	scanTypeAny      = reflect.TypeOf((*any)(nil)).Elem()
+	scanTypeBool     = reflect.TypeOf(false)
+	scanTypeInt8     = reflect.TypeOf(int8(0))
+	scanTypeInt16    = reflect.TypeOf(int16(0))
+	scanTypeInt32    = reflect.TypeOf(int32(0))
+	scanTypeInt64    = reflect.TypeOf(int64(0))
+	scanTypeUint8    = reflect.TypeOf(uint8(0))
+	scanTypeUint16   = reflect.TypeOf(uint16(0))
+	scanTypeUint32   = reflect.TypeOf(uint32(0))
+	scanTypeUint64   = reflect.TypeOf(uint64(0))
+	scanTypeFloat32  = reflect.TypeOf(float32(0))
+	scanTypeFloat64  = reflect.TypeOf(float64(0))
+	scanTypeString   = reflect.TypeOf("")
+	scanTypeBytes    = reflect.TypeOf([]byte(nil))
+	scanTypeTime     = reflect.TypeOf(time.Time{})
+	scanTypeDuration = reflect.TypeOf(time.Duration(0))
+	scanTypeInterval = reflect.TypeOf(Interval{})
+)
+
+// scanTypeOf maps an Arrow data type to the Go type returned by getValueFromColumn.
+// Keep this in sync with the type switch in getValueFromColumn.
+func scanTypeOf(dt arrow.DataType) reflect.Type {
+	switch dt.ID() {
+	case arrow.BOOL:
+		return scanTypeBool
+	case arrow.INT8:
+		return scanTypeInt8
+	case arrow.INT16:
+		return scanTypeInt16
+	case arrow.INT32:
+		return scanTypeInt32
+	case arrow.INT64:
+		return scanTypeInt64
+	case arrow.UINT8:
+		return scanTypeUint8
+	case arrow.UINT16:
+		return scanTypeUint16
+	case arrow.UINT32:
+		return scanTypeUint32
+	case arrow.UINT64:
+		return scanTypeUint64
+	case arrow.FLOAT32:
+		return scanTypeFloat32
+	case arrow.FLOAT64:
+		return scanTypeFloat64
+	case arrow.STRING, arrow.LARGE_STRING, arrow.STRING_VIEW:
+		return scanTypeString
+	case arrow.BINARY, arrow.LARGE_BINARY, arrow.BINARY_VIEW:
+		return scanTypeBytes
+	case arrow.DATE32, arrow.DATE64, arrow.TIMESTAMP:
+		return scanTypeTime
+	case arrow.TIME32, arrow.TIME64, arrow.DURATION:
+		// Times of day are returned as the time since midnight
+		return scanTypeDuration
+	case arrow.INTERVAL_MONTHS, arrow.INTERVAL_DAY_TIME, arrow.INTERVAL_MONTH_DAY_NANO:
+		return scanTypeInterval
+	case arrow.DECIMAL128, arrow.DECIMAL256:
+		// Decimals are returned as their string representation by default
+		return scanTypeString
+	default:
+		return scanTypeAny
+	}
+}
+
+// getValueFromColumn extracts a value from an Arrow column at the given row index.
+// List, struct, map, decimal, UUID and timestamp values are returned according to opts.
+func getValueFromColumn(col arrow.Array, rowIdx int, opts valueOptions) (interface{}, error) {
 	if col.IsNull(rowIdx) {
 		return nil, nil
 	}
@@ -116,20 +340,46 @@ func getValueFromColumn(col arrow.Array, rowIdx int) (interface{}, error) {
 	case *array.Float64:
 		return arr.Value(rowIdx), nil
 	case *array.String:
-		return arr.Value(rowIdx), nil
+		return stringValue(arr.Value(rowIdx), opts.utf8Mode)
+	case *array.LargeString:
+		return stringValue(arr.Value(rowIdx), opts.utf8Mode)
+	case *array.StringView:
+		return stringValue(arr.Value(rowIdx), opts.utf8Mode)
 	case *array.Binary:
 		return arr.Value(rowIdx), nil
+	case *array.LargeBinary:
+		return arr.Value(rowIdx), nil
+	case *array.BinaryView:
+		return arr.Value(rowIdx), nil
+	case *array.FixedSizeBinary:
+		return fixedSizeBinaryValue(arr.Value(rowIdx), opts.uuidMode), nil
 	case *array.Date32:
 		return arr.Value(rowIdx).ToTime(), nil
 	case *array.Date64:
 		return arr.Value(rowIdx).ToTime(), nil
 	case *array.Timestamp:
-		return arr.Value(rowIdx).ToTime(arr.DataType().(*arrow.TimestampType).Unit), nil
-	case *array.Decimal128:
-		// Convert to string for simplicity
-		return arr.Value(rowIdx).ToString(int32(arr.DataType().(*arrow.Decimal128Type).Scale)), nil
-	case *array.Decimal256:
-		return arr.Value(rowIdx).ToString(int32(arr.DataType().(*arrow.Decimal256Type).Scale)), nil
+		return timestampValue(arr, rowIdx, opts.location)
+	case *array.Time32:
+		return time.Duration(arr.Value(rowIdx)) * arr.DataType().(*arrow.Time32Type).Unit.Multiplier(), nil
+	case *array.Time64:
+		return time.Duration(arr.Value(rowIdx)) * arr.DataType().(*arrow.Time64Type).Unit.Multiplier(), nil
+	case *array.Duration:
+		return time.Duration(arr.Value(rowIdx)) * arr.DataType().(*arrow.DurationType).Unit.Multiplier(), nil
+	case *array.MonthInterval:
+		return Interval{Months: int32(arr.Value(rowIdx))}, nil
+	case *array.DayTimeInterval:
+		v := arr.Value(rowIdx)
+		return Interval{Days: v.Days, Nanoseconds: int64(v.Milliseconds) * int64(time.Millisecond)}, nil
+	case *array.MonthDayNanoInterval:
+		v := arr.Value(rowIdx)
+		return Interval{Months: v.Months, Days: v.Days, Nanoseconds: v.Nanoseconds}, nil
+	case *array.Decimal128, *array.Decimal256:
+		return decimalValue(arr, rowIdx, opts.decimalMode), nil
+	case *array.Dictionary:
+		// Low-cardinality columns hold indices into a dictionary of values
+		return getValueFromColumn(arr.Dictionary(), arr.GetValueIndex(rowIdx), opts)
+	case *array.List, *array.LargeList, *array.FixedSizeList, *array.Struct, *array.Map:
+		return nestedValue(arr, rowIdx, opts)
 	default:
 		return nil, fmt.Errorf("unsupported Arrow type: %T", arr)
 	}
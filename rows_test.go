@@ -0,0 +1,297 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// newTestRecord builds a single-row record with one column per field, using
+// each builder's zero value (or null, when the field is nullable).
+func newTestRecord(t *testing.T, fields ...arrow.Field) arrow.Record {
+	t.Helper()
+	schema := arrow.NewSchema(fields, nil)
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+	for i, f := range fields {
+		if f.Nullable {
+			b.Field(i).AppendNull()
+		} else {
+			b.Field(i).AppendEmptyValue()
+		}
+	}
+	return b.NewRecord()
+}
+
+func TestRowsColumnTypeScanType(t *testing.T) {
+	rec := newTestRecord(t,
+		arrow.Field{Name: "b", Type: arrow.FixedWidthTypes.Boolean},
+		arrow.Field{Name: "i32", Type: arrow.PrimitiveTypes.Int32},
+		arrow.Field{Name: "i64", Type: arrow.PrimitiveTypes.Int64},
+		arrow.Field{Name: "f64", Type: arrow.PrimitiveTypes.Float64},
+		arrow.Field{Name: "s", Type: arrow.BinaryTypes.String},
+		arrow.Field{Name: "bin", Type: arrow.BinaryTypes.Binary},
+		arrow.Field{Name: "ls", Type: arrow.BinaryTypes.LargeString},
+		arrow.Field{Name: "sv", Type: arrow.BinaryTypes.StringView},
+		arrow.Field{Name: "lbin", Type: arrow.BinaryTypes.LargeBinary},
+		arrow.Field{Name: "bv", Type: arrow.BinaryTypes.BinaryView},
+		arrow.Field{Name: "d", Type: arrow.FixedWidthTypes.Date32},
+		arrow.Field{Name: "ts", Type: arrow.FixedWidthTypes.Timestamp_us},
+		arrow.Field{Name: "dec", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}},
+	)
+	rows := newRowsFromArrow(rec.Schema(), []arrow.Record{rec})
+	defer rows.Close()
+
+	expected := []reflect.Type{
+		reflect.TypeOf(false),
+		reflect.TypeOf(int32(0)),
+		reflect.TypeOf(int64(0)),
+		reflect.TypeOf(float64(0)),
+		reflect.TypeOf(""),
+		reflect.TypeOf([]byte(nil)),
+		reflect.TypeOf(""),
+		reflect.TypeOf(""),
+		reflect.TypeOf([]byte(nil)),
+		reflect.TypeOf([]byte(nil)),
+		reflect.TypeOf(time.Time{}),
+		reflect.TypeOf(time.Time{}),
+		reflect.TypeOf(""),
+	}
+
+	for i, want := range expected {
+		if got := rows.ColumnTypeScanType(i); got != want {
+			t.Errorf("column %d: expected scan type %v, got %v", i, want, got)
+		}
+	}
+
+	// Out of range columns fall back to any
+	if got := rows.ColumnTypeScanType(len(expected)); got != scanTypeAny {
+		t.Errorf("expected scan type any for unknown column, got %v", got)
+	}
+}
+
+func TestRowsColumnTypeNullableAndPrecisionScale(t *testing.T) {
+	rec := newTestRecord(t,
+		arrow.Field{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		arrow.Field{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		arrow.Field{Name: "price", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}},
+		arrow.Field{Name: "big", Type: &arrow.Decimal256Type{Precision: 40, Scale: 5}, Nullable: true},
+	)
+	rows := newRowsFromArrow(rec.Schema(), []arrow.Record{rec})
+	defer rows.Close()
+
+	nullables := []bool{false, true, false, true}
+	for i, want := range nullables {
+		nullable, ok := rows.ColumnTypeNullable(i)
+		if !ok || nullable != want {
+			t.Errorf("column %d: expected nullable=%v ok=true, got nullable=%v ok=%v", i, want, nullable, ok)
+		}
+	}
+
+	if _, ok := rows.ColumnTypeNullable(len(nullables)); ok {
+		t.Error("expected ok=false for unknown column")
+	}
+
+	if _, _, ok := rows.ColumnTypePrecisionScale(0); ok {
+		t.Error("expected ok=false for non-decimal column")
+	}
+
+	precision, scale, ok := rows.ColumnTypePrecisionScale(2)
+	if !ok || precision != 10 || scale != 2 {
+		t.Errorf("expected (10, 2, true), got (%d, %d, %v)", precision, scale, ok)
+	}
+
+	precision, scale, ok = rows.ColumnTypePrecisionScale(3)
+	if !ok || precision != 40 || scale != 5 {
+		t.Errorf("expected (40, 5, true), got (%d, %d, %v)", precision, scale, ok)
+	}
+}
+
+func TestRowsLargeAndViewLayouts(t *testing.T) {
+	// Views store values of up to 12 bytes inline, and longer ones in data buffers
+	long := "a string longer than twelve bytes"
+	values := []string{"short", long, ""}
+	valid := []bool{true, true, false}
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "ls", Type: arrow.BinaryTypes.LargeString, Nullable: true},
+		{Name: "sv", Type: arrow.BinaryTypes.StringView, Nullable: true},
+		{Name: "lbin", Type: arrow.BinaryTypes.LargeBinary, Nullable: true},
+		{Name: "bv", Type: arrow.BinaryTypes.BinaryView, Nullable: true},
+	}, nil)
+
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+			b.Field(0).(*array.LargeStringBuilder).AppendValues(values, valid)
+			b.Field(1).(*array.StringViewBuilder).AppendValues(values, valid)
+			for i, v := range values {
+				if !valid[i] {
+					b.Field(2).AppendNull()
+					b.Field(3).AppendNull()
+					continue
+				}
+				b.Field(2).(*array.BinaryBuilder).Append([]byte(v))
+				b.Field(3).(*array.BinaryViewBuilder).Append([]byte(v))
+			}
+			rec := b.NewRecord()
+			b.Release()
+			writeArrowReply(conn, schema, rec)
+			rec.Release()
+		}
+	})
+
+	conn := connectFake(t, addr)
+	rows, err := conn.QueryContext(context.Background(), "SELECT * FROM t", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	for i, v := range values {
+		dest := make([]driver.Value, 4)
+		if err := rows.Next(dest); err != nil {
+			t.Fatalf("row %d: Next failed: %v", i, err)
+		}
+
+		var expected []driver.Value
+		if valid[i] {
+			expected = []driver.Value{v, v, []byte(v), []byte(v)}
+		} else {
+			expected = []driver.Value{nil, nil, nil, nil}
+		}
+		if !reflect.DeepEqual(dest, expected) {
+			t.Errorf("row %d: expected %#v, got %#v", i, expected, dest)
+		}
+	}
+}
+
+func TestRowsTimeDurationAndInterval(t *testing.T) {
+	mem := memory.NewGoAllocator()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "t32", Type: arrow.FixedWidthTypes.Time32ms},
+		{Name: "t64", Type: arrow.FixedWidthTypes.Time64us},
+		{Name: "dur", Type: arrow.FixedWidthTypes.Duration_s},
+		{Name: "months", Type: arrow.FixedWidthTypes.MonthInterval},
+		{Name: "daytime", Type: arrow.FixedWidthTypes.DayTimeInterval},
+		{Name: "mdn", Type: arrow.FixedWidthTypes.MonthDayNanoInterval, Nullable: true},
+	}, nil)
+	b := array.NewRecordBuilder(mem, schema)
+	defer b.Release()
+
+	b.Field(0).(*array.Time32Builder).Append(arrow.Time32((13*3600 + 30*60) * 1000))
+	b.Field(1).(*array.Time64Builder).Append(arrow.Time64(1500))
+	b.Field(2).(*array.DurationBuilder).Append(arrow.Duration(90))
+	b.Field(3).(*array.MonthIntervalBuilder).Append(arrow.MonthInterval(14))
+	b.Field(4).(*array.DayTimeIntervalBuilder).Append(arrow.DayTimeInterval{Days: 2, Milliseconds: 500})
+	b.Field(5).(*array.MonthDayNanoIntervalBuilder).Append(arrow.MonthDayNanoInterval{Months: 1, Days: 2, Nanoseconds: 3})
+	rec := b.NewRecord()
+
+	rows := newRowsFromArrow(rec.Schema(), []arrow.Record{rec})
+	defer rows.Close()
+
+	expectedTypes := []reflect.Type{
+		reflect.TypeOf(time.Duration(0)),
+		reflect.TypeOf(time.Duration(0)),
+		reflect.TypeOf(time.Duration(0)),
+		reflect.TypeOf(Interval{}),
+		reflect.TypeOf(Interval{}),
+		reflect.TypeOf(Interval{}),
+	}
+	for i, want := range expectedTypes {
+		if got := rows.ColumnTypeScanType(i); got != want {
+			t.Errorf("column %d: expected scan type %v, got %v", i, want, got)
+		}
+	}
+
+	dest := make([]driver.Value, len(schema.Fields()))
+	if err := rows.Next(dest); err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	expected := []driver.Value{
+		13*time.Hour + 30*time.Minute,
+		1500 * time.Microsecond,
+		90 * time.Second,
+		Interval{Months: 14},
+		Interval{Days: 2, Nanoseconds: int64(500 * time.Millisecond)},
+		Interval{Months: 1, Days: 2, Nanoseconds: 3},
+	}
+	if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("expected %#v, got %#v", expected, dest)
+	}
+}
+
+func TestRowsDictionaryColumns(t *testing.T) {
+	dictType := &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int8, ValueType: arrow.BinaryTypes.String}
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "status", Type: dictType, Nullable: true},
+		{Name: "tags", Type: arrow.ListOf(dictType)},
+	}, nil)
+
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+			status := b.Field(0).(*array.BinaryDictionaryBuilder)
+			status.AppendString("active")
+			status.AppendString("closed")
+			status.AppendNull()
+			status.AppendString("active")
+
+			tags := b.Field(1).(*array.ListBuilder)
+			tagValues := tags.ValueBuilder().(*array.BinaryDictionaryBuilder)
+			for i := 0; i < 4; i++ {
+				tags.Append(true)
+				tagValues.AppendString("x")
+			}
+
+			rec := b.NewRecord()
+			b.Release()
+			writeArrowReply(conn, schema, rec)
+			rec.Release()
+		}
+	})
+
+	conn := connectFake(t, addr)
+	dr, err := conn.QueryContext(context.Background(), "SELECT status, tags FROM t", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer dr.Close()
+
+	rows := dr.(*Rows)
+	if got := rows.ColumnTypeScanType(0); got != scanTypeString {
+		t.Errorf("expected the scan type of the dictionary values, got %v", got)
+	}
+
+	expected := [][]driver.Value{
+		{"active", `["x"]`},
+		{"closed", `["x"]`},
+		{nil, `["x"]`},
+		{"active", `["x"]`},
+	}
+	for i, want := range expected {
+		dest := make([]driver.Value, 2)
+		if err := rows.Next(dest); err != nil {
+			t.Fatalf("row %d: Next failed: %v", i, err)
+		}
+		if !reflect.DeepEqual(dest, want) {
+			t.Errorf("row %d: expected %#v, got %#v", i, want, dest)
+		}
+	}
+}
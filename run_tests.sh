@@ -32,7 +32,7 @@ echo ""
 # Run unit tests (no server required)
 echo "🧪 Running unit tests (no server required)..."
 echo "=========================================="
-go test -v -run "^TestDriver|^TestConnector|^TestResult|^TestArgs" 2>&1 | grep -E "^(===|---|\s+driver_test)" || true
+go test -v -race -run "^TestDriver|^TestConnector|^TestResult|^TestArgs|^TestRows|^TestPool|^TestBadConn|^TestClose|^TestRaw|^TestBeginTx|^TestBatchTransaction" 2>&1 | grep -E "^(===|---|\s+driver_test)" || true
 echo ""
 
 if [ "$LUNA_RUNNING" = true ]; then
@@ -0,0 +1,203 @@
+package luna
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// ErrSchemaDrift is matched by the *SchemaDriftError returned when the result of
+// a query has another schema than in its previous runs.
+var ErrSchemaDrift = errors.New("luna: result schema changed")
+
+// SchemaDriftMode selects what happens when the result of a query has another
+// schema than the last time it ran through the connector, e.g. because the
+// Parquet files a scheduled extract reads gained or retyped a column.
+type SchemaDriftMode int
+
+const (
+	// SchemaDriftOff doesn't track result schemas. This is the default.
+	SchemaDriftOff SchemaDriftMode = iota
+	// SchemaDriftWarn passes a Notice for the query to the notice handler, or
+	// logs a warning without one, and returns the result. The new schema is
+	// the one later runs are compared with.
+	SchemaDriftWarn
+	// SchemaDriftFail fails the query with a *SchemaDriftError. The schema of
+	// the first run stays the expected one until Connector.ForgetSchema.
+	SchemaDriftFail
+)
+
+// schemaDriftModes maps the values of the schema_drift DSN parameter to modes.
+var schemaDriftModes = map[string]SchemaDriftMode{
+	"off":   SchemaDriftOff,
+	"warn":  SchemaDriftWarn,
+	"error": SchemaDriftFail,
+}
+
+func parseSchemaDriftParam(v string, dst *SchemaDriftMode) error {
+	mode, ok := schemaDriftModes[v]
+	if !ok {
+		return fmt.Errorf("must be off, warn or error")
+	}
+	*dst = mode
+	return nil
+}
+
+// SchemaDriftError is returned in SchemaDriftFail mode when the result of a
+// query has another schema than expected. It matches ErrSchemaDrift.
+type SchemaDriftError struct {
+	// Query is the statement whose result changed.
+	Query string
+	// Expected is the schema of the query's earlier results.
+	Expected *arrow.Schema
+	// Actual is the schema of the result that was dropped.
+	Actual *arrow.Schema
+}
+
+func (e *SchemaDriftError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrSchemaDrift, describeSchemaDrift(e.Expected, e.Actual))
+}
+
+func (e *SchemaDriftError) Is(target error) bool {
+	return target == ErrSchemaDrift
+}
+
+// describeSchemaDrift lists the columns added, removed and retyped between
+// two schemas, e.g. "column total changed from int32 to decimal(18, 2)".
+func describeSchemaDrift(expected, actual *arrow.Schema) string {
+	var changes []string
+	for _, f := range expected.Fields() {
+		idx := actual.FieldIndices(f.Name)
+		switch {
+		case len(idx) == 0:
+			changes = append(changes, fmt.Sprintf("column %s removed", f.Name))
+		case !arrow.TypeEqual(f.Type, actual.Field(idx[0]).Type):
+			changes = append(changes, fmt.Sprintf("column %s changed from %s to %s", f.Name, f.Type, actual.Field(idx[0]).Type))
+		}
+	}
+	for _, f := range actual.Fields() {
+		if !expected.HasField(f.Name) {
+			changes = append(changes, fmt.Sprintf("column %s added", f.Name))
+		}
+	}
+	if len(changes) == 0 {
+		// Same columns and types, in another order or nullability
+		return fmt.Sprintf("columns changed from %s to %s", schemaColumns(expected), schemaColumns(actual))
+	}
+	return strings.Join(changes, ", ")
+}
+
+func schemaColumns(schema *arrow.Schema) string {
+	names := make([]string, schema.NumFields())
+	for i, f := range schema.Fields() {
+		names[i] = f.Name
+		if f.Nullable {
+			names[i] += "?"
+		}
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// schemaTracker holds the expected result schema of each query run through a
+// connector, by query fingerprint. A nil schemaTracker doesn't track anything.
+type schemaTracker struct {
+	mode    SchemaDriftMode
+	mu      sync.Mutex
+	schemas map[string]*arrow.Schema
+}
+
+func newSchemaTracker(mode SchemaDriftMode) *schemaTracker {
+	if mode == SchemaDriftOff {
+		return nil
+	}
+	return &schemaTracker{mode: mode, schemas: make(map[string]*arrow.Schema)}
+}
+
+// check compares schema with the one expected for query, recording it if the
+// query hasn't run before. It returns a *SchemaDriftError if they differ.
+func (t *schemaTracker) check(query string, schema *arrow.Schema) error {
+	if t == nil || schema == nil {
+		return nil
+	}
+
+	key := queryFingerprint(query)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expected := t.schemas[key]
+	if expected == nil || expected.Fingerprint() == schema.Fingerprint() {
+		t.schemas[key] = schema
+		return nil
+	}
+	if t.mode == SchemaDriftWarn {
+		t.schemas[key] = schema
+	}
+	return &SchemaDriftError{Query: query, Expected: expected, Actual: schema}
+}
+
+func (t *schemaTracker) forget(query string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.schemas, queryFingerprint(query))
+}
+
+// queryFingerprint identifies the runs of a query: string literals are replaced
+// with '?' and whitespace is collapsed, so that a scheduled query reading the
+// files of another day, e.g. read_parquet('2024-06-01/*.parquet'), is still
+// the same query. Numbers are kept, since they can change a column's type.
+func queryFingerprint(query string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSQLSpace(c):
+			space = true
+			i++
+			continue
+		case space && b.Len() > 0:
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch c {
+		case '\'':
+			i = skipSQLQuoted(query, i)
+			b.WriteString("'?'")
+			continue
+		case '"':
+			end := skipSQLQuoted(query, i)
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+// checkSchema checks the schema of query's result for drift. In SchemaDriftWarn
+// mode, drift is reported as a notice and nil is returned.
+func (c *Conn) checkSchema(query string, schema *arrow.Schema) error {
+	err := c.schemas.check(query, schema)
+	var drift *SchemaDriftError
+	if c.schemas == nil || c.schemas.mode != SchemaDriftWarn || !errors.As(err, &drift) {
+		return err
+	}
+	c.notify(query, []string{drift.Error()})
+	return nil
+}
+
+// ForgetSchema drops the result schema recorded for query, so that its next
+// result is accepted whatever its schema, e.g. once downstream consumers have
+// been updated for a SchemaDriftError.
+func (c *Connector) ForgetSchema(query string) {
+	c.schemas.forget(c.cfg.Dialect.translate(query))
+}
@@ -0,0 +1,141 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestQueryFingerprint(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT *\n  FROM read_parquet('2024-06-01/*.parquet')", "SELECT * FROM read_parquet('?')"},
+		{"  SELECT 'it''s'  ", "SELECT '?'"},
+		{`SELECT "a  b" FROM t`, `SELECT "a  b" FROM t`},
+	}
+
+	for _, tc := range testCases {
+		if got := queryFingerprint(tc.query); got != tc.expected {
+			t.Errorf("queryFingerprint(%q) = %q, expected %q", tc.query, got, tc.expected)
+		}
+	}
+}
+
+// newDriftServer starts a fake server that answers the queries it receives with
+// an int64 result whose column is named after the next of columns.
+func newDriftServer(t *testing.T, columns ...string) string {
+	replies := make(chan []byte, len(columns))
+	for _, col := range columns {
+		replies <- arrowReply(t, col, 1)
+	}
+	return newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write(<-replies)
+		}
+	})
+}
+
+func TestSchemaDriftWarn(t *testing.T) {
+	addr := newDriftServer(t, "n", "m", "m")
+
+	var notices []Notice
+	connector, err := NewConnectorWithOptions(addr+"?schema_drift=warn", WithNoticeHandler(func(n Notice) {
+		notices = append(notices, n)
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Queries that differ only in their string literals are the same query
+	for _, day := range []string{"2024-06-01", "2024-06-02", "2024-06-03"} {
+		rows, err := db.Query("SELECT * FROM read_parquet('" + day + "/*.parquet')")
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		rows.Close()
+	}
+
+	if len(notices) != 1 {
+		t.Fatalf("expected 1 notice, got %+v", notices)
+	}
+	if !strings.Contains(notices[0].Message, "column n removed, column m added") || !strings.Contains(notices[0].Query, "2024-06-02") {
+		t.Errorf("unexpected notice %+v", notices[0])
+	}
+}
+
+func TestSchemaDriftFail(t *testing.T) {
+	addr := newDriftServer(t, "n", "m", "n", "m")
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	connector, err := NewConnectorWithOptions(addr, WithSchemaDrift(SchemaDriftFail), WithAllocator(mem))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	const query = "SELECT * FROM t"
+	run := func() error {
+		reader, err := QueryArrow(context.Background(), conn, query)
+		if err == nil {
+			reader.Release()
+		}
+		return err
+	}
+
+	if err := run(); err != nil {
+		t.Fatalf("first query failed: %v", err)
+	}
+
+	err = run()
+	var drift *SchemaDriftError
+	if !errors.Is(err, ErrSchemaDrift) || !errors.As(err, &drift) {
+		t.Fatalf("expected a *SchemaDriftError, got %v", err)
+	}
+	if drift.Query != query || drift.Expected.Field(0).Name != "n" || drift.Actual.Field(0).Name != "m" {
+		t.Errorf("unexpected drift %+v", drift)
+	}
+
+	// The schema of the first run stays the expected one
+	if err := run(); err != nil {
+		t.Errorf("expected the original schema to be accepted, got %v", err)
+	}
+
+	connector.ForgetSchema(query)
+	if err := run(); err != nil {
+		t.Errorf("expected the new schema to be accepted once forgotten, got %v", err)
+	}
+}
+
+func TestSchemaDriftParam(t *testing.T) {
+	cfg, err := ParseDSN("localhost:7688?schema_drift=error")
+	if err != nil || cfg.SchemaDrift != SchemaDriftFail {
+		t.Errorf("expected SchemaDriftFail, got %v, %v", cfg, err)
+	}
+	if _, err := ParseDSN("localhost:7688?schema_drift=strict"); err == nil {
+		t.Error("expected an error for an unknown schema drift mode")
+	}
+}
@@ -0,0 +1,31 @@
+package luna
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SlowQueryFunc is called with a statement that took at least the slow query
+// threshold, with how long it took and the rows it returned or affected. It's
+// called while the connection is busy, so it must not use the connection.
+type SlowQueryFunc func(ctx context.Context, query string, dur time.Duration, rows int64)
+
+// checkSlow reports query to the slow query handler, or logs it without one, if
+// it took at least the slow query threshold. The log record includes the Arrow
+// memory allocated for the result, if it's tracked. The caller must hold c.mu.
+func (c *Conn) checkSlow(ctx context.Context, query string, dur time.Duration, rows int64, mem MemoryStats) {
+	if c.slowQueryThreshold <= 0 || dur < c.slowQueryThreshold {
+		return
+	}
+
+	if c.onSlowQuery != nil {
+		c.onSlowQuery(ctx, query, dur, rows)
+		return
+	}
+	attrs := []any{c.queryAttr(query), slog.Duration("duration", dur), slog.Int64("rows", rows)}
+	if mem.Allocated > 0 {
+		attrs = append(attrs, slog.Int64("allocated_bytes", mem.Allocated), slog.Int64("in_use_bytes", mem.InUse()))
+	}
+	c.logger.Warn("slow query", attrs...)
+}
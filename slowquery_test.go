@@ -0,0 +1,123 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type slowQuery struct {
+	query string
+	dur   time.Duration
+	rows  int64
+}
+
+func TestSlowQuery(t *testing.T) {
+	clock := newFakeClock()
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			for _, stmt := range statementCommands(cmd) {
+				// Statements mentioning slow take 3s of the fake clock
+				if strings.Contains(stmt, "slow") {
+					clock.Advance(3 * time.Second)
+				}
+				if strings.HasPrefix(stmt, "x:") {
+					conn.Write([]byte(":7\r\n"))
+					continue
+				}
+				conn.Write(arrowReply(t, "n", 1, 2))
+			}
+		}
+	})
+
+	var mu sync.Mutex
+	var slow []slowQuery
+	connector, err := NewConnectorWithOptions(addr, WithClock(clock), WithSlowQuery(2*time.Second, func(ctx context.Context, query string, dur time.Duration, rows int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		slow = append(slow, slowQuery{query, dur, rows})
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, query := range []string{"SELECT n FROM fast", "SELECT n FROM slow; SELECT n FROM fast"} {
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		rows.Close()
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM slow"); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get a connection: %v", err)
+	}
+	defer conn.Close()
+	reader, err := QueryArrow(ctx, conn, "SELECT n FROM slow")
+	if err != nil {
+		t.Fatalf("QueryArrow failed: %v", err)
+	}
+	reader.Release()
+
+	expected := []slowQuery{
+		{"SELECT n FROM slow", 3 * time.Second, 2},
+		{"DELETE FROM slow", 3 * time.Second, 7},
+		{"SELECT n FROM slow", 3 * time.Second, 2},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(slow) != len(expected) {
+		t.Fatalf("expected %d slow queries, got %+v", len(expected), slow)
+	}
+	for i, s := range slow {
+		if s != expected[i] {
+			t.Errorf("slow query %d: expected %+v, got %+v", i, expected[i], s)
+		}
+	}
+}
+
+func TestSlowQueryLogged(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			conn.Write(arrowReply(t, "n", 1))
+		}
+	})
+
+	var logs bytes.Buffer
+	connector, err := NewConnectorWithOptions(addr+"?slow_query_threshold=10ms", WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	var n int64
+	if err := db.QueryRow("SELECT n FROM t WHERE id = 42").Scan(&n); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if out := logs.String(); !strings.Contains(out, `msg="slow query" query="SELECT n FROM t WHERE id = ?"`) || !strings.Contains(out, "rows=1") ||
+		!strings.Contains(out, "allocated_bytes=") || !strings.Contains(out, "in_use_bytes=") {
+		t.Errorf("expected the slow query to be logged, got %q", out)
+	}
+}
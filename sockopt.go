@@ -0,0 +1,63 @@
+package luna
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"syscall"
+)
+
+// socketControl returns the net.Dialer control function that sizes the socket
+// buffers before connecting, so that the TCP window scale negotiated in the
+// handshake allows windows as large as the buffers, or nil if there's nothing to
+// set.
+func (c *Connector) socketControl() func(ctx context.Context, network, address string, rc syscall.RawConn) error {
+	read, write := c.cfg.SocketReadBuffer, c.cfg.SocketWriteBuffer
+	if !preConnectBuffers || (read <= 0 && write <= 0) {
+		return nil
+	}
+	return func(ctx context.Context, network, address string, rc syscall.RawConn) error {
+		return setSocketBuffers(rc, read, write)
+	}
+}
+
+// tuneSocket applies the TCP options that can only be set once connected, and
+// the socket buffer sizes of connections that weren't dialed by a net.Dialer of
+// the connector. Connections that aren't TCP, e.g. from a DialFunc over an
+// in-memory network, are left alone.
+func (c *Connector) tuneSocket(nc net.Conn, dialed bool) error {
+	if tc, ok := nc.(*tls.Conn); ok {
+		nc = tc.NetConn()
+	}
+	tcp, ok := nc.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if !dialed || !preConnectBuffers {
+		if n := c.cfg.SocketReadBuffer; n > 0 {
+			if err := tcp.SetReadBuffer(n); err != nil {
+				return err
+			}
+		}
+		if n := c.cfg.SocketWriteBuffer; n > 0 {
+			if err := tcp.SetWriteBuffer(n); err != nil {
+				return err
+			}
+		}
+	}
+	// Go enables TCP_NODELAY on every TCP connection
+	if c.cfg.DisableNoDelay {
+		if err := tcp.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if c.cfg.QuickAck {
+		rc, err := tcp.SyscallConn()
+		if err != nil {
+			return err
+		}
+		return setQuickAck(rc)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package luna
+
+import "syscall"
+
+// The socket buffers are sized once connected, with net.TCPConn methods.
+const preConnectBuffers = false
+
+func setSocketBuffers(rc syscall.RawConn, read, write int) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package luna
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func getsockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	rc, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("failed to get raw connection: %v", err)
+	}
+	var v int
+	rc.Control(func(fd uintptr) {
+		v, err = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if err != nil {
+		t.Fatalf("getsockopt failed: %v", err)
+	}
+	return v
+}
+
+func TestSocketOptions(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		readCommand(reader)
+	})
+
+	testCases := []struct {
+		name string
+		dsn  string
+		opts []Option
+	}{
+		{name: "dsn", dsn: addr + "?socket_read_buffer=262144&socket_write_buffer=131072&tcp_nodelay=false&tcp_quickack=true"},
+		{name: "options", dsn: addr, opts: []Option{WithSocketBuffers(262144, 131072), WithTCPNoDelay(false), WithTCPQuickAck(true)}},
+		{name: "dial func", dsn: addr, opts: []Option{
+			WithSocketBuffers(262144, 131072), WithTCPNoDelay(false),
+			WithDialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			}),
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			connector, err := NewConnectorWithOptions(tc.dsn, tc.opts...)
+			if err != nil {
+				t.Fatalf("failed to create connector: %v", err)
+			}
+			dc, err := connector.Connect(context.Background())
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer dc.Close()
+
+			// Linux doubles the requested sizes for its bookkeeping
+			nc := dc.(*Conn).conn
+			if n := getsockopt(t, nc, syscall.SOL_SOCKET, syscall.SO_RCVBUF); n < 262144 {
+				t.Errorf("expected a receive buffer of at least 262144 bytes, got %d", n)
+			}
+			if n := getsockopt(t, nc, syscall.SOL_SOCKET, syscall.SO_SNDBUF); n < 131072 {
+				t.Errorf("expected a send buffer of at least 131072 bytes, got %d", n)
+			}
+			if n := getsockopt(t, nc, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); n != 0 {
+				t.Errorf("expected TCP_NODELAY to be disabled, got %d", n)
+			}
+		})
+	}
+
+	if _, err := ParseDSN(addr + "?socket_read_buffer=-1"); err == nil {
+		t.Error("expected an error for a negative buffer size")
+	}
+}
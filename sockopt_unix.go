@@ -0,0 +1,24 @@
+//go:build unix
+
+package luna
+
+import "syscall"
+
+// The socket buffers of dialed connections are sized before connecting.
+const preConnectBuffers = true
+
+func setSocketBuffers(rc syscall.RawConn, read, write int) error {
+	var err error
+	ctrlErr := rc.Control(func(fd uintptr) {
+		if read > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, read)
+		}
+		if err == nil && write > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, write)
+		}
+	})
+	if ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package luna
+
+import (
+	"context"
+	"time"
+)
+
+// Names of the counters of ResultStats read by its accessors. The server reports
+// them in the stats of a result's trailer, if it measures them.
+const (
+	CounterRowsScanned   = "rows_scanned"
+	CounterBytesScanned  = "bytes_scanned"
+	CounterExecutionTime = "execution_time_us"
+	CounterPeakMemory    = "peak_memory_bytes"
+)
+
+// RowsScanned returns the number of rows the server read to run the statement,
+// and whether it reported it.
+func (s ResultStats) RowsScanned() (int64, bool) {
+	n, ok := s.Counters[CounterRowsScanned]
+	return n, ok
+}
+
+// ExecutionTime returns the time the server spent running the statement, and
+// whether it reported it. Unlike StatementStats.Duration, it leaves out the
+// network and decoding.
+func (s ResultStats) ExecutionTime() (time.Duration, bool) {
+	us, ok := s.Counters[CounterExecutionTime]
+	return time.Duration(us) * time.Microsecond, ok
+}
+
+// PeakMemory returns the most memory in bytes the server used at once to run
+// the statement, and whether it reported it.
+func (s ResultStats) PeakMemory() (int64, bool) {
+	n, ok := s.Counters[CounterPeakMemory]
+	return n, ok
+}
+
+// StatementStats describes a statement that completed, as passed to the function
+// set with WithStatementStats.
+type StatementStats struct {
+	// Query is the statement, after dialect translation.
+	Query string
+	// Duration is how long the statement took on the client, from sending it to
+	// decoding its result.
+	Duration time.Duration
+	// ResultStats is the metadata the server sent with the result, with -1 row
+	// counts and no counters if it sent none.
+	ResultStats
+}
+
+type statementStatsKey struct{}
+
+// WithStatementStats returns a context that makes each statement run with it
+// call fn once it completes, with the statistics the server sent with its
+// result. Unlike Rows.Stats, it works with the *sql.Rows and sql.Result of
+// database/sql, and for executions. Each statement of a multi-statement query
+// and of a pipeline is reported on its own; queries whose IN lists are split
+// are reported once, with the counters of the parts added up. The Duration of a
+// pipeline's statement counts from sending the pipeline. Statements that fail
+// aren't reported. It's called while the connection is busy, so it must
+// not use the connection.
+func WithStatementStats(ctx context.Context, fn func(StatementStats)) context.Context {
+	return context.WithValue(ctx, statementStatsKey{}, fn)
+}
+
+// statementDone passes a statement that completed, started at start, to the slow
+// query check and the function set in ctx with WithStatementStats. mem is the
+// Arrow memory used to decode its result, zero if it isn't tracked. The caller
+// must hold c.mu.
+func (c *Conn) statementDone(ctx context.Context, query string, start time.Time, rows int64, stats ResultStats, mem MemoryStats) {
+	dur := c.clock.Now().Sub(start)
+	c.checkSlow(ctx, query, dur, rows, mem)
+	reportStats(ctx, query, dur, stats)
+}
+
+// reportStats passes the stats of a statement that completed to the function set
+// in ctx with WithStatementStats, if any.
+func reportStats(ctx context.Context, query string, dur time.Duration, stats ResultStats) {
+	if fn, _ := ctx.Value(statementStatsKey{}).(func(StatementStats)); fn != nil {
+		fn(StatementStats{Query: query, Duration: dur, ResultStats: stats})
+	}
+}
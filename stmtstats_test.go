@@ -0,0 +1,121 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatementStats(t *testing.T) {
+	replies := map[string][]byte{
+		"q:SELECT n FROM t":       trailerReply(t, "n", `{"rows":2,"stats":{"rows_scanned":1000,"execution_time_us":1500,"peak_memory_bytes":4096}}`, 1, 2),
+		"q:SELECT n FROM plain":   arrowReply(t, "n", 1),
+		"x:UPDATE t SET a = 1":    trailerReply(t, "Count", `{"rows_affected":3,"stats":{"rows_scanned":10}}`, 3),
+		"x:DELETE FROM t":         []byte(":2\r\n"),
+		"q:SELECT * FROM missing": []byte("-Catalog Error: Table with name missing does not exist!\r\n"),
+	}
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			conn.Write(replies[cmd])
+		}
+	})
+
+	connector, err := NewConnector(addr+"?result_trailers=true", nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var reported []StatementStats
+	ctx := WithStatementStats(context.Background(), func(s StatementStats) {
+		reported = append(reported, s)
+	})
+
+	rows, err := db.QueryContext(ctx, "SELECT n FROM t")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows.Close()
+	if _, err := db.ExecContext(ctx, "UPDATE t SET a = 1"); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM t"); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if _, err := db.QueryContext(ctx, "SELECT * FROM missing"); err == nil {
+		t.Fatal("expected the query to fail")
+	}
+	// Statements run without the context aren't reported
+	if _, err := db.Exec("DELETE FROM t"); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(dc any) error {
+		reader, err := dc.(*Conn).QueryArrow(ctx, "SELECT n FROM plain")
+		if err != nil {
+			return err
+		}
+		reader.Release()
+
+		var p Pipeline
+		p.Exec("UPDATE t SET a = 1")
+		p.Query("SELECT n FROM t")
+		results, err := dc.(*Conn).RunPipeline(ctx, &p)
+		if err != nil {
+			return err
+		}
+		releasePipelineResults(results)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("raw calls failed: %v", err)
+	}
+
+	selectStats := ResultStats{Rows: 2, RowsAffected: -1, Counters: map[string]int64{
+		CounterRowsScanned: 1000, CounterExecutionTime: 1500, CounterPeakMemory: 4096,
+	}}
+	updateStats := ResultStats{Rows: -1, RowsAffected: 3, Counters: map[string]int64{CounterRowsScanned: 10}}
+	expected := []StatementStats{
+		{Query: "SELECT n FROM t", ResultStats: selectStats},
+		{Query: "UPDATE t SET a = 1", ResultStats: updateStats},
+		{Query: "DELETE FROM t", ResultStats: noStats},
+		{Query: "SELECT n FROM plain", ResultStats: noStats},
+		{Query: "UPDATE t SET a = 1", ResultStats: updateStats},
+		{Query: "SELECT n FROM t", ResultStats: selectStats},
+	}
+	for i := range reported {
+		reported[i].Duration = 0
+	}
+	if !reflect.DeepEqual(reported, expected) {
+		t.Errorf("expected stats:\n%+v\ngot:\n%+v", expected, reported)
+	}
+
+	s := expected[0]
+	if n, ok := s.RowsScanned(); !ok || n != 1000 {
+		t.Errorf("expected 1000 rows scanned, got %d %v", n, ok)
+	}
+	if d, ok := s.ExecutionTime(); !ok || d != 1500*time.Microsecond {
+		t.Errorf("expected an execution time of 1.5ms, got %v %v", d, ok)
+	}
+	if n, ok := s.PeakMemory(); !ok || n != 4096 {
+		t.Errorf("expected a peak memory of 4096, got %d %v", n, ok)
+	}
+	if _, ok := noStats.ExecutionTime(); ok {
+		t.Error("expected no execution time without a trailer")
+	}
+}
@@ -0,0 +1,156 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DatabaseSize holds the storage statistics of an attached database, as reported
+// by PRAGMA database_size. In-memory databases have no blocks.
+type DatabaseSize struct {
+	Database string
+	// Size of the database file in bytes, BlockSize × TotalBlocks.
+	Size        int64
+	BlockSize   int64
+	TotalBlocks int64
+	UsedBlocks  int64
+	FreeBlocks  int64
+	// Size of the write-ahead log in bytes.
+	WALSize int64
+	// Memory used by the database, and the server's memory limit, in bytes.
+	MemoryUsage int64
+	MemoryLimit int64
+}
+
+// TableSize holds the storage statistics of a table.
+type TableSize struct {
+	Database string
+	Schema   string
+	Table    string
+	// Rows is the server's estimate of the number of rows.
+	Rows int64
+	// Blocks is the number of persistent blocks holding the table's data, and
+	// Size their size in bytes. Blocks may be shared with other tables, so sizes
+	// are estimates that may add up to more than the database size.
+	Blocks int64
+	Size   int64
+}
+
+// Byte size units used by the server, e.g. in "1.5 MiB".
+var byteSizeUnits = map[string]float64{
+	"bytes": 1,
+	"B":     1,
+	"KB":    1e3,
+	"MB":    1e6,
+	"GB":    1e9,
+	"TB":    1e12,
+	"PB":    1e15,
+	"KiB":   1 << 10,
+	"MiB":   1 << 20,
+	"GiB":   1 << 30,
+	"TiB":   1 << 40,
+	"PiB":   1 << 50,
+}
+
+// parseByteSize parses a human-readable size reported by the server, e.g.
+// "512 bytes" or "1.5 MiB", into a number of bytes.
+func parseByteSize(s string) (int64, error) {
+	number, unit, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		number, unit = s, "bytes"
+	}
+
+	factor, ok := byteSizeUnits[strings.TrimSpace(unit)]
+	if !ok {
+		return 0, fmt.Errorf("luna: unknown size unit in %q", s)
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("luna: invalid size %q", s)
+	}
+	return int64(f * factor), nil
+}
+
+// DatabaseSizes returns the storage statistics of every database attached to
+// the server, for capacity dashboards, with the sizes the server reports as text
+// converted to bytes.
+func DatabaseSizes(ctx context.Context, db *sql.DB) ([]DatabaseSize, error) {
+	rows, err := db.QueryContext(ctx, "SELECT database_name, block_size, total_blocks, used_blocks, free_blocks, wal_size, memory_usage, memory_limit FROM pragma_database_size()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sizes []DatabaseSize
+	for rows.Next() {
+		var s DatabaseSize
+		var wal, usage, limit string
+		if err := rows.Scan(&s.Database, &s.BlockSize, &s.TotalBlocks, &s.UsedBlocks, &s.FreeBlocks, &wal, &usage, &limit); err != nil {
+			return nil, err
+		}
+		s.Size = s.BlockSize * s.TotalBlocks
+
+		for _, f := range []struct {
+			text string
+			dst  *int64
+		}{{wal, &s.WALSize}, {usage, &s.MemoryUsage}, {limit, &s.MemoryLimit}} {
+			if *f.dst, err = parseByteSize(f.text); err != nil {
+				return nil, err
+			}
+		}
+		sizes = append(sizes, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sizes, nil
+}
+
+// TableSizes returns the storage statistics of the tables of every attached
+// database, temporary tables excepted. It runs a query per table to count its
+// blocks, so it's meant for periodic collection rather than hot paths.
+func TableSizes(ctx context.Context, db *sql.DB) ([]TableSize, error) {
+	dbSizes, err := DatabaseSizes(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	blockSizes := make(map[string]int64, len(dbSizes))
+	for _, s := range dbSizes {
+		blockSizes[s.Database] = s.BlockSize
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT database_name, schema_name, table_name, estimated_size FROM duckdb_tables() WHERE NOT temporary ORDER BY database_name, schema_name, table_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sizes []TableSize
+	for rows.Next() {
+		var s TableSize
+		if err := rows.Scan(&s.Database, &s.Schema, &s.Table, &s.Rows); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range sizes {
+		s := &sizes[i]
+		name := quoteSQLIdentifier(s.Database) + "." + quoteSQLIdentifier(s.Schema) + "." + quoteSQLIdentifier(s.Table)
+		query := "SELECT count(DISTINCT block_id) FROM pragma_storage_info('" + strings.ReplaceAll(name, "'", "''") + "') WHERE persistent"
+		if err := db.QueryRowContext(ctx, query).Scan(&s.Blocks); err != nil {
+			return nil, fmt.Errorf("luna: storage info of %s: %w", name, err)
+		}
+		s.Size = s.Blocks * blockSizes[s.Database]
+	}
+
+	return sizes, nil
+}
@@ -0,0 +1,128 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected int64
+		err      bool
+	}{
+		{in: "0 bytes", expected: 0},
+		{in: "512 bytes", expected: 512},
+		{in: "1.5 MiB", expected: 1572864},
+		{in: "2 GB", expected: 2000000000},
+		{in: "3.2 GiB", expected: 3435973836},
+		{in: "42", expected: 42},
+		{in: "1 XB", err: true},
+		{in: "lots bytes", err: true},
+		{in: "-1 KiB", err: true},
+		{in: "", err: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseByteSize(tc.in)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseByteSize(%q): expected an error, got %d", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseByteSize(%q) failed: %v", tc.in, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("parseByteSize(%q): expected %d, got %d", tc.in, tc.expected, got)
+		}
+	}
+}
+
+func newStorageServer(t *testing.T) (addr string, commands chan string) {
+	return newReplyServer(t,
+		fakeReply{"pragma_database_size", recordReply(t,
+			[]string{"database_name", "block_size", "total_blocks", "used_blocks", "free_blocks", "wal_size", "memory_usage", "memory_limit"},
+			[]any{"main", int64(262144), int64(40), int64(36), int64(4), "1.5 MiB", "64 MiB", "8 GiB"},
+			[]any{"memory", int64(0), int64(0), int64(0), int64(0), "0 bytes", "0 bytes", "8 GiB"},
+		)},
+		fakeReply{"duckdb_tables", recordReply(t,
+			[]string{"database_name", "schema_name", "table_name", "estimated_size"},
+			[]any{"main", "main", "events", int64(1000000)},
+			[]any{"main", "sales", "it's", int64(12)},
+		)},
+		fakeReply{`pragma_storage_info('"main"."sales"`, recordReply(t, []string{"blocks"}, []any{int64(1)})},
+		fakeReply{"pragma_storage_info", recordReply(t, []string{"blocks"}, []any{int64(30)})},
+	)
+}
+
+func TestDatabaseSizes(t *testing.T) {
+	addr, _ := newStorageServer(t)
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	sizes, err := DatabaseSizes(context.Background(), db)
+	if err != nil {
+		t.Fatalf("DatabaseSizes failed: %v", err)
+	}
+
+	expected := []DatabaseSize{
+		{
+			Database:    "main",
+			Size:        40 * 262144,
+			BlockSize:   262144,
+			TotalBlocks: 40,
+			UsedBlocks:  36,
+			FreeBlocks:  4,
+			WALSize:     1572864,
+			MemoryUsage: 64 << 20,
+			MemoryLimit: 8 << 30,
+		},
+		{Database: "memory", MemoryLimit: 8 << 30},
+	}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, sizes)
+	}
+}
+
+func TestTableSizes(t *testing.T) {
+	addr, commands := newStorageServer(t)
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	sizes, err := TableSizes(context.Background(), db)
+	if err != nil {
+		t.Fatalf("TableSizes failed: %v", err)
+	}
+
+	expected := []TableSize{
+		{Database: "main", Schema: "main", Table: "events", Rows: 1000000, Blocks: 30, Size: 30 * 262144},
+		{Database: "main", Schema: "sales", Table: "it's", Rows: 12, Blocks: 1, Size: 262144},
+	}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, sizes)
+	}
+
+	// Table names are quoted, and escaped in the string literal
+	close(commands)
+	var found bool
+	for cmd := range commands {
+		if strings.Contains(cmd, `pragma_storage_info('"main"."sales"."it''s"')`) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected a pragma_storage_info query for main.sales.it's")
+	}
+}
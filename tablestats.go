@@ -0,0 +1,96 @@
+package luna
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// TableAccess is the use of a table by the statements run through a connector,
+// as reported by Connector.TableStats.
+type TableAccess struct {
+	// Table is the name of the table, as written in the statements, e.g.
+	// "sales.orders".
+	Table string
+	// Queries is the number of statements that referred to the table.
+	Queries int64
+	// Rows is the number of rows returned or changed by those statements.
+	Rows int64
+	// Bytes is the number of response bytes received for those statements.
+	Bytes int64
+}
+
+// tableStats aggregates the TableAccess of the tables referred to by statements.
+// A nil tableStats discards them.
+type tableStats struct {
+	mu     sync.Mutex
+	tables map[string]*TableAccess
+}
+
+func newTableStats() *tableStats {
+	return &tableStats{tables: make(map[string]*TableAccess)}
+}
+
+// record adds a statement that returned or changed rows and received bytes to
+// the stats of each table it refers to. Statements that refer to several tables
+// count fully towards each of them.
+func (s *tableStats) record(query string, rows, bytes int64) {
+	if s == nil {
+		return
+	}
+
+	tables := Classify(query).Tables
+	if len(tables) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range tables {
+		t := s.tables[name]
+		if t == nil {
+			t = &TableAccess{Table: name}
+			s.tables[name] = t
+		}
+		t.Queries++
+		t.Rows += rows
+		t.Bytes += bytes
+	}
+}
+
+// snapshot returns the stats of each table, sorted by table name.
+func (s *tableStats) snapshot() []TableAccess {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tables := make([]TableAccess, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, *t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Table < tables[j].Table })
+	return tables
+}
+
+// TableStats returns the number of statements, rows and response bytes of each
+// table referred to by the statements run through the connector so far, sorted
+// by table name. Tables are found with Classify, so the counts are best effort.
+// It returns nil unless table statistics are enabled with WithTableStats or the
+// table_stats DSN parameter.
+func (c *Connector) TableStats() []TableAccess {
+	if !c.cfg.TableStats {
+		return nil
+	}
+	return c.tables.snapshot()
+}
+
+func countRows(records []arrow.Record) int64 {
+	var n int64
+	for _, rec := range records {
+		n += rec.NumRows()
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package luna
+
+import (
+	"bufio"
+	"database/sql"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTableStats(t *testing.T) {
+	reply := arrowReply(t, "n", 1, 2, 3)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(cmd, "x:") {
+				conn.Write([]byte(":2\r\n"))
+				continue
+			}
+			conn.Write(reply)
+		}
+	})
+
+	testCases := []struct {
+		name     string
+		enabled  bool
+		expected []TableAccess
+	}{
+		{
+			name:    "enabled",
+			enabled: true,
+			expected: []TableAccess{
+				{Table: "events", Queries: 2, Rows: 6, Bytes: 2 * int64(len(reply))},
+				{Table: "sales.orders", Queries: 2, Rows: 5, Bytes: int64(len(reply)) + 4},
+			},
+		},
+		{name: "disabled"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			connector, err := NewConnectorWithOptions(addr, WithTableStats(tc.enabled))
+			if err != nil {
+				t.Fatalf("failed to create connector: %v", err)
+			}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+
+			for _, query := range []string{
+				"SELECT n FROM events",
+				"SELECT e.n FROM events e JOIN sales.orders o ON o.id = e.id",
+				"SELECT 1",
+			} {
+				rows, err := db.Query(query)
+				if err != nil {
+					t.Fatalf("%s: query failed: %v", query, err)
+				}
+				rows.Close()
+			}
+			if _, err := db.Exec(`DELETE FROM "sales"."orders" WHERE id = 1`); err != nil {
+				t.Fatalf("exec failed: %v", err)
+			}
+
+			if got := connector.TableStats(); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+package luna
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL types of the Go kinds that can be registered with RegisterTempTable.
+var tempTableKinds = map[reflect.Kind]string{
+	reflect.Bool:    "BOOLEAN",
+	reflect.Int:     "BIGINT",
+	reflect.Int8:    "TINYINT",
+	reflect.Int16:   "SMALLINT",
+	reflect.Int32:   "INTEGER",
+	reflect.Int64:   "BIGINT",
+	reflect.Uint:    "UBIGINT",
+	reflect.Uint8:   "UTINYINT",
+	reflect.Uint16:  "USMALLINT",
+	reflect.Uint32:  "UINTEGER",
+	reflect.Uint64:  "UBIGINT",
+	reflect.Float32: "FLOAT",
+	reflect.Float64: "DOUBLE",
+	reflect.String:  "VARCHAR",
+}
+
+// SQL types of other Go types that can be registered with RegisterTempTable.
+var tempTableTypes = map[reflect.Type]string{
+	reflect.TypeOf([]byte(nil)):       "BLOB",
+	reflect.TypeOf(time.Time{}):       "TIMESTAMP",
+	reflect.TypeOf(sql.NullBool{}):    "BOOLEAN",
+	reflect.TypeOf(sql.NullByte{}):    "UTINYINT",
+	reflect.TypeOf(sql.NullInt16{}):   "SMALLINT",
+	reflect.TypeOf(sql.NullInt32{}):   "INTEGER",
+	reflect.TypeOf(sql.NullInt64{}):   "BIGINT",
+	reflect.TypeOf(sql.NullFloat64{}): "DOUBLE",
+	reflect.TypeOf(sql.NullString{}):  "VARCHAR",
+	reflect.TypeOf(sql.NullTime{}):    "TIMESTAMP",
+}
+
+// DuckDB's reserved keywords, and the keywords that can't name a table, which a
+// temp table can't be named after: it couldn't be told apart from the keyword.
+var sqlReservedWords = map[string]bool{
+	"ALL": true, "ANALYSE": true, "ANALYZE": true, "AND": true, "ANY": true,
+	"ARRAY": true, "AS": true, "ASC": true, "ASYMMETRIC": true, "BOTH": true,
+	"CASE": true, "CAST": true, "CHECK": true, "COLLATE": true, "COLUMN": true,
+	"CONSTRAINT": true, "CREATE": true, "DEFAULT": true, "DEFERRABLE": true,
+	"DESC": true, "DESCRIBE": true, "DISTINCT": true, "DO": true, "ELSE": true,
+	"END": true, "EXCEPT": true, "FALSE": true, "FETCH": true, "FOR": true,
+	"FOREIGN": true, "FROM": true, "GRANT": true, "GROUP": true, "HAVING": true,
+	"IN": true, "INITIALLY": true, "INTERSECT": true, "INTO": true,
+	"LATERAL": true, "LEADING": true, "LIMIT": true, "NOT": true, "NULL": true,
+	"OFFSET": true, "ON": true, "ONLY": true, "OR": true, "ORDER": true,
+	"PIVOT": true, "PIVOT_LONGER": true, "PIVOT_WIDER": true, "PLACING": true,
+	"PRIMARY": true, "QUALIFY": true, "REFERENCES": true, "RETURNING": true,
+	"SELECT": true, "SHOW": true, "SOME": true, "SUMMARIZE": true,
+	"SYMMETRIC": true, "TABLE": true, "THEN": true, "TO": true, "TRAILING": true,
+	"TRUE": true, "UNION": true, "UNIQUE": true, "UNPIVOT": true, "USING": true,
+	"VARIADIC": true, "WHEN": true, "WHERE": true, "WINDOW": true, "WITH": true,
+
+	"ANTI": true, "ASOF": true, "AUTHORIZATION": true, "BINARY": true,
+	"COLLATION": true, "CONCURRENTLY": true, "CROSS": true, "FREEZE": true,
+	"FULL": true, "GENERATED": true, "GLOB": true, "ILIKE": true, "INNER": true,
+	"IS": true, "ISNULL": true, "JOIN": true, "LEFT": true, "LIKE": true,
+	"MAP": true, "NATURAL": true, "NOTNULL": true, "OUTER": true,
+	"OVERLAPS": true, "POSITIONAL": true, "RIGHT": true, "SEMI": true,
+	"SIMILAR": true, "STRUCT": true, "TABLESAMPLE": true, "TRY_CAST": true,
+	"VERBOSE": true,
+}
+
+func tempTableType(t reflect.Type) (string, bool) {
+	if sqlType, ok := tempTableTypes[t]; ok {
+		return sqlType, true
+	}
+	if isUUIDType(t) {
+		return "UUID", true
+	}
+	sqlType, ok := tempTableKinds[t.Kind()]
+	return sqlType, ok
+}
+
+type tempTableColumn struct {
+	name    string
+	index   int
+	sqlType string
+}
+
+// RegisterTempTable makes a slice of structs queryable as a table named name, e.g.
+// to join against a small in-app dataset. Since the server doesn't keep temporary
+// tables between commands, the rows are sent along with every query on this
+// connection that refers to name, as a WITH clause over a VALUES list.
+//
+// Each exported field is a column, named after its `luna:"name"` tag or else the
+// field name; fields tagged `luna:"-"` are skipped. Fields may be booleans, integers,
+// floats, strings, []byte, time.Time, sql.Null* types, or pointers to them, where
+// nil pointers are NULL. Registering the same name again replaces the rows. Only
+// queries see the table, not statements run with ExecContext, and only where the
+// name is in table position: right after FROM or JOIN, or in the comma-separated
+// list of a FROM clause, without a schema. The name can't be a reserved word.
+func (c *Conn) RegisterTempTable(ctx context.Context, name string, rows any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !isSQLIdentifier(name) {
+		return fmt.Errorf("luna: invalid temp table name %q", name)
+	}
+	if sqlReservedWords[strings.ToUpper(name)] {
+		return fmt.Errorf("luna: temp table name %q is a reserved word", name)
+	}
+
+	query, err := valuesQuery(rows)
+	if err != nil {
+		return fmt.Errorf("luna: can't register temp table %s: %w", name, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tempTables == nil {
+		c.tempTables = make(map[string]tempTable)
+	}
+	c.tempTables[strings.ToUpper(name)] = tempTable{name: name, query: query}
+	return nil
+}
+
+// RegisterTempTable registers a temp table on a connection from a database/sql pool
+// opened with the luna driver. See Conn.RegisterTempTable.
+func RegisterTempTable(ctx context.Context, conn *sql.Conn, name string, rows any) error {
+	return conn.Raw(func(dc any) error {
+		c, ok := dc.(*Conn)
+		if !ok {
+			return fmt.Errorf("luna: RegisterTempTable needs a luna connection, got %T", dc)
+		}
+		return c.RegisterTempTable(ctx, name, rows)
+	})
+}
+
+// tempTable is a table registered with RegisterTempTable.
+type tempTable struct {
+	name  string
+	query string // SELECT statement producing the rows
+}
+
+// withTempTables prepends the registered temp tables that query refers to as
+// common table expressions, merging them into the query's own WITH clause.
+func (c *Conn) withTempTables(query string) string {
+	if len(c.tempTables) == 0 {
+		return query
+	}
+
+	words := scanSQLWords(query)
+	var ctes []string
+	used := make(map[string]bool)
+	for i, w := range words {
+		t, ok := c.tempTables[w.text]
+		if !ok || used[w.text] || !isTableReference(query, words, i) {
+			continue
+		}
+		used[w.text] = true
+		ctes = append(ctes, t.name+" AS ("+t.query+")")
+	}
+
+	if len(ctes) == 0 {
+		return query
+	}
+
+	if len(words) > 0 && words[0].text == "WITH" {
+		at := words[0].end
+		if len(words) > 1 && words[1].text == "RECURSIVE" {
+			at = words[1].end
+		}
+		return query[:at] + " " + strings.Join(ctes, ", ") + "," + query[at:]
+	}
+
+	return "WITH " + strings.Join(ctes, ", ") + " " + query
+}
+
+// Keywords starting a clause with a comma-separated list other than the tables
+// of a FROM clause.
+var otherClauseWords = map[string]bool{
+	"WITH":      true,
+	"SELECT":    true,
+	"WHERE":     true,
+	"GROUP":     true,
+	"HAVING":    true,
+	"WINDOW":    true,
+	"QUALIFY":   true,
+	"ORDER":     true,
+	"LIMIT":     true,
+	"VALUES":    true,
+	"SET":       true,
+	"RETURNING": true,
+	"UNION":     true,
+	"EXCEPT":    true,
+	"INTERSECT": true,
+}
+
+// isTableReference reports whether words[i] names a table of query without a
+// schema: it's right after FROM or JOIN, or after a comma in the list of tables
+// of a FROM clause, and isn't followed by a dot. Column references, e.g. SELECT
+// ids.id, and schemas, e.g. FROM ids.t, aren't.
+func isTableReference(query string, words []sqlWord, i int) bool {
+	w := words[i]
+	if strings.HasPrefix(strings.TrimLeft(query[w.end:], " \t\r\n"), ".") {
+		return false
+	}
+
+	before := strings.TrimRight(query[:w.start], " \t\r\n")
+	switch {
+	case i > 0 && len(before) == words[i-1].end:
+		return words[i-1].text == "FROM" || words[i-1].text == "JOIN"
+	case strings.HasSuffix(before, ","):
+		// The list is a FROM clause if the closest clause keyword at the same
+		// depth is FROM or JOIN, e.g. not in SELECT a, ids
+		for j := i - 1; j >= 0 && words[j].depth >= w.depth; j-- {
+			if words[j].depth > w.depth {
+				continue
+			}
+			if text := words[j].text; text == "FROM" || text == "JOIN" {
+				return true
+			} else if otherClauseWords[text] {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// valuesQuery builds a SELECT statement over a VALUES list that produces the
+// elements of rows, a slice of structs or of pointers to structs.
+func valuesQuery(rows any) (string, error) {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("expected a slice of structs, got %T", rows)
+	}
+
+	elem := v.Type().Elem()
+	if elem.Kind() == reflect.Pointer {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return "", fmt.Errorf("expected a slice of structs, got %T", rows)
+	}
+
+	columns, err := tempTableColumns(elem)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	for i, col := range columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		source := "NULL"
+		if v.Len() > 0 {
+			source = "c" + strconv.Itoa(i+1)
+		}
+		fmt.Fprintf(&b, "CAST(%s AS %s) AS %s", source, col.sqlType, quoteSQLIdentifier(col.name))
+	}
+
+	// A VALUES list can't be empty
+	if v.Len() == 0 {
+		b.WriteString(" WHERE false")
+		return b.String(), nil
+	}
+
+	b.WriteString(" FROM (VALUES ")
+	for i := 0; i < v.Len(); i++ {
+		row := v.Index(i)
+		if row.Kind() == reflect.Pointer {
+			if row.IsNil() {
+				return "", fmt.Errorf("row %d is nil", i)
+			}
+			row = row.Elem()
+		}
+
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for j, col := range columns {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			lit, err := sqlLiteral(row.Field(col.index))
+			if err != nil {
+				return "", fmt.Errorf("row %d, column %s: %w", i, col.name, err)
+			}
+			b.WriteString(lit)
+		}
+		b.WriteString(")")
+	}
+
+	b.WriteString(") AS v(")
+	for i := range columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("c" + strconv.Itoa(i+1))
+	}
+	b.WriteString(")")
+
+	return b.String(), nil
+}
+
+func tempTableColumns(t reflect.Type) ([]tempTableColumn, error) {
+	var columns []tempTableColumn
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("luna")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		sqlType, ok := tempTableType(ft)
+		if !ok {
+			return nil, fmt.Errorf("unsupported type %s of field %s", f.Type, f.Name)
+		}
+
+		name := f.Name
+		if tag != "" {
+			name = tag
+		}
+		columns = append(columns, tempTableColumn{name: name, index: i, sqlType: sqlType})
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%s has no exported fields", t)
+	}
+	return columns, nil
+}
+
+// sqlLiteral formats a field value as a SQL literal.
+func sqlLiteral(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "NULL", nil
+		}
+		v = v.Elem()
+	}
+
+	// sql.Null* types
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return "", err
+		}
+		if value == nil {
+			return "NULL", nil
+		}
+		v = reflect.ValueOf(value)
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		return "'" + t.UTC().Format("2006-01-02 15:04:05.999999") + "'", nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return formatFloat(v.Float(), v.Type().Bits()), nil
+	case reflect.String:
+		return "'" + strings.ReplaceAll(v.String(), "'", "''") + "'", nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		if v.IsNil() {
+			return "NULL", nil
+		}
+		var b strings.Builder
+		b.WriteString("'")
+		for _, c := range v.Bytes() {
+			fmt.Fprintf(&b, `\x%02X`, c)
+		}
+		// Without the cast, the escapes would be the text of a VARCHAR
+		b.WriteString("'::BLOB")
+		return b.String(), nil
+	}
+
+	if isUUIDType(v.Type()) {
+		var u [16]byte
+		reflect.Copy(reflect.ValueOf(&u).Elem(), v)
+		return "'" + formatUUID(u) + "'", nil
+	}
+
+	return "", fmt.Errorf("unsupported value %v (%s)", v, v.Type())
+}
+
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "'NaN'"
+	case math.IsInf(f, 1):
+		return "'Infinity'"
+	case math.IsInf(f, -1):
+		return "'-Infinity'"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize)
+}
+
+func isSQLIdentifier(name string) bool {
+	if name == "" || !isSQLWordStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isSQLWordStart(name[i]) && (name[i] < '0' || name[i] > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func quoteSQLIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
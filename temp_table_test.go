@@ -0,0 +1,256 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValuesQuery(t *testing.T) {
+	type user struct {
+		ID       int64 `luna:"id"`
+		Name     string
+		Score    *float64
+		Note     sql.NullString
+		Internal string `luna:"-"`
+		hidden   int
+	}
+
+	score := 1.5
+	tests := []struct {
+		name     string
+		rows     any
+		expected string
+		err      string
+	}{
+		{
+			name: "rows",
+			rows: []user{
+				{ID: 1, Name: "ann", Score: &score, Note: sql.NullString{String: "it's", Valid: true}},
+				{ID: 2, Name: "bob"},
+			},
+			expected: `SELECT CAST(c1 AS BIGINT) AS "id", CAST(c2 AS VARCHAR) AS "Name", CAST(c3 AS DOUBLE) AS "Score", CAST(c4 AS VARCHAR) AS "Note" ` +
+				`FROM (VALUES (1, 'ann', 1.5, 'it''s'), (2, 'bob', NULL, NULL)) AS v(c1, c2, c3, c4)`,
+		},
+		{
+			name:     "pointers to structs",
+			rows:     []*user{{ID: 3, Name: "cy"}},
+			expected: `SELECT CAST(c1 AS BIGINT) AS "id", CAST(c2 AS VARCHAR) AS "Name", CAST(c3 AS DOUBLE) AS "Score", CAST(c4 AS VARCHAR) AS "Note" FROM (VALUES (3, 'cy', NULL, NULL)) AS v(c1, c2, c3, c4)`,
+		},
+		{
+			name:     "empty",
+			rows:     []user{},
+			expected: `SELECT CAST(NULL AS BIGINT) AS "id", CAST(NULL AS VARCHAR) AS "Name", CAST(NULL AS DOUBLE) AS "Score", CAST(NULL AS VARCHAR) AS "Note" WHERE false`,
+		},
+		{
+			name: "other types",
+			rows: []struct {
+				OK   bool
+				Data []byte
+				At   time.Time
+				N    uint8
+			}{{true, []byte{0x01, 0xab}, time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), 7}},
+			expected: `SELECT CAST(c1 AS BOOLEAN) AS "OK", CAST(c2 AS BLOB) AS "Data", CAST(c3 AS TIMESTAMP) AS "At", CAST(c4 AS UTINYINT) AS "N" ` +
+				`FROM (VALUES (TRUE, '\x01\xAB'::BLOB, '2024-05-01 12:30:00', 7)) AS v(c1, c2, c3, c4)`,
+		},
+		{
+			name: "not a slice",
+			rows: user{},
+			err:  "expected a slice of structs",
+		},
+		{
+			name: "not structs",
+			rows: []int{1, 2},
+			err:  "expected a slice of structs",
+		},
+		{
+			name: "unsupported field",
+			rows: []struct{ Tags []string }{},
+			err:  "unsupported type []string of field Tags",
+		},
+		{
+			name: "no fields",
+			rows: []struct{ hidden int }{},
+			err:  "no exported fields",
+		},
+		{
+			name: "nil row",
+			rows: []*user{nil},
+			err:  "row 0 is nil",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := valuesQuery(tt.rows)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if query != tt.expected {
+				t.Errorf("unexpected query:\n got: %s\nwant: %s", query, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWithTempTables(t *testing.T) {
+	c := &Conn{tempTables: map[string]tempTable{
+		"IDS":   {name: "ids", query: "SELECT 1 AS id"},
+		"NAMES": {name: "names", query: "SELECT 'a' AS name"},
+	}}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "unreferenced",
+			query:    "SELECT * FROM users",
+			expected: "SELECT * FROM users",
+		},
+		{
+			name:     "referenced",
+			query:    "SELECT * FROM users JOIN ids USING (id)",
+			expected: "WITH ids AS (SELECT 1 AS id) SELECT * FROM users JOIN ids USING (id)",
+		},
+		{
+			name:     "case insensitive, once each",
+			query:    "SELECT * FROM IDS, Names WHERE ids.id IN (SELECT id FROM ids)",
+			expected: "WITH ids AS (SELECT 1 AS id), names AS (SELECT 'a' AS name) SELECT * FROM IDS, Names WHERE ids.id IN (SELECT id FROM ids)",
+		},
+		{
+			name:     "merged into WITH",
+			query:    "WITH u AS (SELECT * FROM users) SELECT * FROM u JOIN ids USING (id)",
+			expected: "WITH ids AS (SELECT 1 AS id), u AS (SELECT * FROM users) SELECT * FROM u JOIN ids USING (id)",
+		},
+		{
+			name:     "merged into WITH RECURSIVE",
+			query:    "with recursive r(n) AS (SELECT id FROM ids) SELECT * FROM r",
+			expected: "with recursive ids AS (SELECT 1 AS id), r(n) AS (SELECT id FROM ids) SELECT * FROM r",
+		},
+		{
+			name:     "string literals ignored",
+			query:    "SELECT 'ids' AS name",
+			expected: "SELECT 'ids' AS name",
+		},
+		{
+			name:     "join and FROM list",
+			query:    "SELECT * FROM users u LEFT JOIN t ON t.id = u.id, names AS n",
+			expected: "WITH names AS (SELECT 'a' AS name) SELECT * FROM users u LEFT JOIN t ON t.id = u.id, names AS n",
+		},
+		{
+			name:     "columns ignored",
+			query:    "SELECT ids, names.first FROM users WHERE ids > 0 ORDER BY names, ids",
+			expected: "SELECT ids, names.first FROM users WHERE ids > 0 ORDER BY names, ids",
+		},
+		{
+			name:     "schemas ignored",
+			query:    "SELECT * FROM main.ids JOIN ids.names USING (id)",
+			expected: "SELECT * FROM main.ids JOIN ids.names USING (id)",
+		},
+		{
+			name:     "CTE names ignored",
+			query:    "WITH a AS (SELECT 1), ids AS (SELECT 2) SELECT * FROM a",
+			expected: "WITH a AS (SELECT 1), ids AS (SELECT 2) SELECT * FROM a",
+		},
+		{
+			name:     "function arguments ignored",
+			query:    "SELECT coalesce(a, ids) FROM users",
+			expected: "SELECT coalesce(a, ids) FROM users",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.withTempTables(tt.query); got != tt.expected {
+				t.Errorf("unexpected query:\n got: %s\nwant: %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRegisterTempTable(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			if strings.HasPrefix(cmd, "x:") {
+				conn.Write([]byte("+OK\r\n"))
+				continue
+			}
+			conn.Write([]byte("$-1\r\n"))
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	if err := RegisterTempTable(ctx, conn, "bad name", []struct{ ID int }{}); err == nil {
+		t.Error("expected an error for an invalid table name")
+	}
+	if err := RegisterTempTable(ctx, conn, "order", []struct{ ID int }{}); err == nil || !strings.Contains(err.Error(), "reserved word") {
+		t.Errorf("expected an error for a reserved word, got %v", err)
+	}
+	if err := RegisterTempTable(ctx, conn, "ids", []struct{ ID int }{{1}, {2}}); err != nil {
+		t.Fatalf("RegisterTempTable failed: %v", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT * FROM users WHERE id IN (SELECT ID FROM ids)")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows.Close()
+
+	expected := `q:WITH ids AS (SELECT CAST(c1 AS BIGINT) AS "ID" FROM (VALUES (1), (2)) AS v(c1)) SELECT * FROM users WHERE id IN (SELECT ID FROM ids)`
+	if got := <-commands; got != expected {
+		t.Errorf("unexpected command:\n got: %s\nwant: %s", got, expected)
+	}
+
+	// Statements don't see temp tables
+	if _, err := conn.ExecContext(ctx, "INSERT INTO t SELECT * FROM ids"); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if got := <-commands; got != "x:INSERT INTO t SELECT * FROM ids" {
+		t.Errorf("unexpected command %q", got)
+	}
+
+	// Registering the same name replaces the rows
+	if err := RegisterTempTable(ctx, conn, "IDS", []struct{ ID int }{{3}}); err != nil {
+		t.Fatalf("RegisterTempTable failed: %v", err)
+	}
+	rows, err = conn.QueryContext(ctx, "SELECT * FROM ids")
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	rows.Close()
+
+	expected = `q:WITH IDS AS (SELECT CAST(c1 AS BIGINT) AS "ID" FROM (VALUES (3)) AS v(c1)) SELECT * FROM ids`
+	if got := <-commands; got != expected {
+		t.Errorf("unexpected command:\n got: %s\nwant: %s", got, expected)
+	}
+}
@@ -0,0 +1,48 @@
+package luna
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+)
+
+func parseTimeZoneParam(v string, dst **time.Location) error {
+	if v == "" || v == "Local" {
+		return fmt.Errorf("must be an IANA time zone name, such as UTC or Europe/Paris")
+	}
+	loc, err := time.LoadLocation(v)
+	if err != nil {
+		return err
+	}
+	*dst = loc
+	return nil
+}
+
+// setTimeZoneStatement returns the statement that sets the session time zone of
+// the server to loc.
+func setTimeZoneStatement(loc *time.Location) string {
+	return "SET TimeZone = '" + strings.ReplaceAll(loc.String(), "'", "''") + "'"
+}
+
+// timestampValue extracts the timestamp at rowIdx. Timestamps with a time zone
+// (TIMESTAMPTZ) are returned in loc, or in the time zone of their column if loc
+// is nil. Timestamps without one are returned in UTC.
+func timestampValue(arr *array.Timestamp, rowIdx int, loc *time.Location) (time.Time, error) {
+	dt := arr.DataType().(*arrow.TimestampType)
+	t := arr.Value(rowIdx).ToTime(dt.Unit)
+	if dt.TimeZone == "" {
+		return t, nil
+	}
+
+	if loc == nil {
+		// The location is loaded once per column type
+		var err error
+		if loc, err = dt.GetZone(); err != nil {
+			return time.Time{}, fmt.Errorf("luna: invalid time zone %q of timestamp column: %w", dt.TimeZone, err)
+		}
+	}
+	return t.In(loc), nil
+}
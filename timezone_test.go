@@ -0,0 +1,112 @@
+package luna
+
+import (
+	"bufio"
+	"database/sql"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func newTimestampArray(t *testing.T, tz string) *array.Timestamp {
+	t.Helper()
+	b := array.NewTimestampBuilder(memory.NewGoAllocator(), &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: tz})
+	defer b.Release()
+	// 2025-03-01 12:00:00 UTC
+	b.Append(arrow.Timestamp(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC).UnixMicro()))
+	return b.NewTimestampArray()
+}
+
+func TestTimestampValue(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		tz       string
+		loc      *time.Location
+		expected string
+		err      bool
+	}{
+		{name: "without time zone", tz: "", expected: "2025-03-01T12:00:00Z"},
+		{name: "without time zone ignores session", tz: "", loc: paris, expected: "2025-03-01T12:00:00Z"},
+		{name: "column time zone", tz: "America/New_York", expected: "2025-03-01T07:00:00-05:00"},
+		{name: "offset time zone", tz: "+05:30", expected: "2025-03-01T17:30:00+05:30"},
+		{name: "session time zone", tz: "America/New_York", loc: paris, expected: "2025-03-01T13:00:00+01:00"},
+		{name: "invalid time zone", tz: "Mars/Olympus", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arr := newTimestampArray(t, tc.tz)
+			defer arr.Release()
+
+			got, err := timestampValue(arr, 0, tc.loc)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("timestampValue failed: %v", err)
+			}
+			if s := got.Format(time.RFC3339); s != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, s)
+			}
+		})
+	}
+}
+
+func TestTimeZoneSetOnConnect(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Paris"); err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			if strings.HasPrefix(cmd, "x:") {
+				conn.Write([]byte("+OK\r\n"))
+				continue
+			}
+
+			arr := newTimestampArray(t, "UTC")
+			schema := arrow.NewSchema([]arrow.Field{{Name: "ts", Type: arr.DataType()}}, nil)
+			rec := array.NewRecord(schema, []arrow.Array{arr}, 1)
+			writeArrowReply(conn, schema, rec)
+			rec.Release()
+			arr.Release()
+		}
+	})
+
+	db, err := sql.Open("luna", addr+"?timezone=Europe/Paris")
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	var ts time.Time
+	if err := db.QueryRow("SELECT ts FROM t").Scan(&ts); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	if got := <-commands; got != "x:SET TimeZone = 'Europe/Paris'" {
+		t.Errorf("expected the time zone to be set first, got %q", got)
+	}
+	if s := ts.Format(time.RFC3339); s != "2025-03-01T13:00:00+01:00" {
+		t.Errorf("expected the timestamp in the session time zone, got %s", s)
+	}
+}
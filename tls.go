@@ -0,0 +1,86 @@
+package luna
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// tlsScheme is the DSN scheme that enables TLS, e.g. luna+tls://host:7688.
+const tlsScheme = "luna+tls"
+
+// tlsConfigFromDSN builds the TLS configuration described by the DSN, or returns
+// nil if TLS isn't enabled. TLS is enabled by the luna+tls scheme or ?tls=true,
+// and configured with the following query parameters:
+//
+//   - tls_ca: PEM file with the CA certificates used to verify the server
+//   - tls_cert, tls_key: PEM files with the client certificate and key
+//   - tls_server_name: server name to verify, defaults to the DSN host
+//   - tls_insecure_skip_verify: skip server certificate verification
+func tlsConfigFromDSN(u *url.URL) (*tls.Config, error) {
+	q := u.Query()
+	enabled := u.Scheme == tlsScheme
+	if v := q.Get("tls"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("luna: invalid tls %q", v)
+		}
+		if u.Scheme == tlsScheme && !b {
+			return nil, fmt.Errorf("luna: tls=%s conflicts with the %s scheme", v, tlsScheme)
+		}
+		enabled = b
+	}
+
+	if !enabled {
+		for _, key := range []string{"tls_ca", "tls_cert", "tls_key", "tls_server_name", "tls_insecure_skip_verify"} {
+			if q.Has(key) {
+				return nil, fmt.Errorf("luna: %s requires TLS to be enabled", key)
+			}
+		}
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName: q.Get("tls_server_name"),
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+
+	if v := q.Get("tls_insecure_skip_verify"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("luna: invalid tls_insecure_skip_verify %q", v)
+		}
+		cfg.InsecureSkipVerify = b
+	}
+
+	if path := q.Get("tls_ca"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("luna: failed to read tls_ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("luna: no certificates found in tls_ca %s", path)
+		}
+		cfg.RootCAs = pool
+	}
+
+	certFile, keyFile := q.Get("tls_cert"), q.Get("tls_key")
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("luna: tls_cert and tls_key must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("luna: failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,178 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// newTestCertificate creates a self-signed certificate for 127.0.0.1 and writes it
+// to a PEM file that can be used as tls_ca.
+func newTestCertificate(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "luna test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func TestTLSConnection(t *testing.T) {
+	cert, caFile := newTestCertificate(t)
+	addr := newFakeTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}}, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+
+			rec := newTestRecord(t, arrow.Field{Name: "n", Type: arrow.PrimitiveTypes.Int64})
+			writeArrowReply(conn, rec.Schema(), rec)
+			rec.Release()
+		}
+	})
+
+	testCases := []struct {
+		name string
+		dsn  string
+	}{
+		{"tls scheme", "luna+tls://" + addr + "?tls_ca=" + url.QueryEscape(caFile)},
+		{"tls parameter", "luna://" + addr + "?tls=true&tls_ca=" + url.QueryEscape(caFile)},
+		{"skip verify", addr + "?tls=1&tls_insecure_skip_verify=true"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := connectFake(t, tc.dsn)
+			if _, ok := conn.conn.(*tls.Conn); !ok {
+				t.Fatalf("expected a TLS connection, got %T", conn.conn)
+			}
+
+			rows, err := conn.QueryContext(context.Background(), "SELECT 0 AS n", nil)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			rows.Close()
+		})
+	}
+
+	// The handshake runs over connections from a dial func, verifying the host of
+	// the configured address
+	_, port, _ := net.SplitHostPort(addr)
+	connector, err := NewConnectorWithOptions("luna+tls://127.0.0.1:1?tls_ca="+url.QueryEscape(caFile),
+		WithDialFunc(func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, net.JoinHostPort("127.0.0.1", port))
+		}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect through the dial func: %v", err)
+	}
+	if _, ok := dc.(*Conn).conn.(*tls.Conn); !ok {
+		t.Errorf("expected a TLS connection, got %T", dc.(*Conn).conn)
+	}
+	dc.Close()
+
+	// Without the CA, the self-signed certificate is rejected
+	connector, err = NewConnector("luna+tls://"+addr, nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	if _, err := connector.Connect(context.Background()); err == nil {
+		t.Error("expected certificate verification to fail")
+	}
+}
+
+func TestTLSConfigFromDSN(t *testing.T) {
+	_, caFile := newTestCertificate(t)
+
+	testCases := []struct {
+		name        string
+		dsn         string
+		enabled     bool
+		shouldError bool
+	}{
+		{"disabled", "luna://localhost:7688", false, false},
+		{"tls scheme", "luna+tls://localhost:7688", true, false},
+		{"tls parameter", "luna://localhost:7688?tls=true", true, false},
+		{"tls parameter off", "luna://localhost:7688?tls=0", false, false},
+		{"conflicting", "luna+tls://localhost:7688?tls=false", false, true},
+		{"invalid tls", "luna://localhost:7688?tls=maybe", false, true},
+		{"options without tls", "luna://localhost:7688?tls_server_name=luna", false, true},
+		{"ca file", "luna+tls://localhost:7688?tls_ca=" + url.QueryEscape(caFile), true, false},
+		{"missing ca file", "luna+tls://localhost:7688?tls_ca=/does/not/exist.pem", false, true},
+		{"cert without key", "luna+tls://localhost:7688?tls_cert=cert.pem", false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.dsn)
+			if err != nil {
+				t.Fatalf("invalid DSN: %v", err)
+			}
+
+			cfg, err := tlsConfigFromDSN(u)
+			if tc.shouldError {
+				if err == nil {
+					t.Errorf("expected error for DSN %s", tc.dsn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if enabled := cfg != nil; enabled != tc.enabled {
+				t.Errorf("expected enabled=%v, got %v", tc.enabled, enabled)
+			}
+		})
+	}
+
+	u, _ := url.Parse("luna+tls://luna.example.com:7688?tls_server_name=luna.internal")
+	cfg, err := tlsConfigFromDSN(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ServerName != "luna.internal" {
+		t.Errorf("expected server name luna.internal, got %s", cfg.ServerName)
+	}
+}
@@ -0,0 +1,114 @@
+package luna
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// ResultStats is the metadata the server sent with a result, in a trailer
+// following its Arrow stream, as returned by Rows.Stats. Trailers are only read
+// with WithResultTrailers, and their format is provisional.
+type ResultStats struct {
+	// Rows is the number of rows the server reported for the result, or -1 if
+	// it didn't report one.
+	Rows int64
+	// RowsAffected is the number of rows changed by the statement, or -1 if the
+	// server didn't report one.
+	RowsAffected int64
+	// Warnings are the warnings raised by the statement, also passed to the
+	// notice handler.
+	Warnings []string
+	// Counters are the other statistics the server reported, e.g.
+	// "bytes_scanned", by name.
+	Counters map[string]int64
+}
+
+// noStats are the stats of a result without a trailer.
+var noStats = ResultStats{Rows: -1, RowsAffected: -1}
+
+// add adds the metadata of a trailer to s, e.g. for the results of a query split
+// in several.
+func (s ResultStats) add(t *wire.Trailer) ResultStats {
+	if t.Rows != nil {
+		s.Rows = max(s.Rows, 0) + *t.Rows
+	}
+	if t.RowsAffected != nil {
+		s.RowsAffected = max(s.RowsAffected, 0) + *t.RowsAffected
+	}
+	s.Warnings = append(s.Warnings, t.Warnings...)
+	for name, n := range t.Stats {
+		if s.Counters == nil {
+			s.Counters = make(map[string]int64, len(t.Stats))
+		}
+		s.Counters[name] += n
+	}
+	return s
+}
+
+// Stats returns the metadata the server sent with the current result set, with
+// -1 row counts if it didn't send any. It's available through sql.Conn.Raw.
+func (r *Rows) Stats() ResultStats {
+	return r.stats
+}
+
+// Notice is a warning the server raised for a statement.
+type Notice struct {
+	// Query is the statement that raised the warning.
+	Query string
+	// Message is the text of the warning.
+	Message string
+}
+
+// readTrailer reads the trailer following the Arrow stream of query's result,
+// if trailers are enabled and its schema announced one, and passes its warnings
+// to the notice handler. It returns nil if there's no trailer.
+func (c *Conn) readTrailer(query string, schema *arrow.Schema) (*wire.Trailer, error) {
+	if !c.resultTrailers || !wire.HasTrailer(schema) {
+		return nil, nil
+	}
+	t, err := wire.ReadTrailer(c.reader)
+	if err != nil {
+		return nil, c.readError("failed to read trailer", err)
+	}
+	if t != nil {
+		c.notify(query, t.Warnings)
+	}
+	return t, nil
+}
+
+// readReply reads the reply to query. Trailers whose Arrow stream didn't
+// announce them are skipped, passing their warnings to the notice handler;
+// they can't be attributed to a statement anymore. With trailers disabled, a
+// trailer frame is an unknown response.
+func (c *Conn) readReply(query string) (string, []byte, error) {
+	for {
+		respType, data, err := wire.ReadResponse(c.reader)
+		if err != nil || respType != wire.RespTrailer {
+			return respType, data, err
+		}
+		if !c.resultTrailers {
+			return "", nil, fmt.Errorf("unknown response type: %c", wire.TrailerMarker)
+		}
+
+		t, err := wire.ParseTrailer(data)
+		if err != nil {
+			c.logger.Warn("dropping malformed trailer", "error", err)
+			continue
+		}
+		c.notify("", t.Warnings)
+	}
+}
+
+// notify passes the warnings raised by query to the notice handler, or logs them
+// if there's none.
+func (c *Conn) notify(query string, warnings []string) {
+	for _, msg := range warnings {
+		if c.noticeHandler != nil {
+			c.noticeHandler(Notice{Query: query, Message: msg})
+		} else {
+			c.logger.Warn("server warning", c.queryAttr(query), "warning", msg)
+		}
+	}
+}
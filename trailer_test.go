@@ -0,0 +1,246 @@
+package luna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/flowerinthenight/luna-go/internal/wire"
+)
+
+// trailerFrame encodes a trailer frame holding the JSON data.
+func trailerFrame(data string) []byte {
+	return []byte(fmt.Sprintf("|%d\r\n%s\r\n", len(data), data))
+}
+
+// announceTrailer returns schema with the metadata announcing a trailer after
+// the stream.
+func announceTrailer(schema *arrow.Schema) *arrow.Schema {
+	md := arrow.NewMetadata([]string{wire.TrailerMetadataKey}, []string{"true"})
+	return arrow.NewSchema(schema.Fields(), &md)
+}
+
+// trailerReply encodes an Arrow stream of a single int64 column, announcing and
+// followed by a trailer frame holding the JSON data.
+func trailerReply(t *testing.T, column, data string, values ...int64) []byte {
+	t.Helper()
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), arrow.NewSchema([]arrow.Field{
+		{Name: column, Type: arrow.PrimitiveTypes.Int64},
+	}, nil))
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).AppendValues(values, nil)
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	if err := writeArrowReply(&buf, announceTrailer(rec.Schema()), rec); err != nil {
+		t.Fatalf("failed to encode reply: %v", err)
+	}
+	buf.Write(trailerFrame(data))
+	return buf.Bytes()
+}
+
+func TestQueryTrailer(t *testing.T) {
+	replies := map[string][]byte{
+		"q:SELECT n FROM t":     trailerReply(t, "n", `{"rows":2,"warnings":["column n is deprecated"],"stats":{"bytes_scanned":128}}`, 1, 2),
+		"q:SELECT n FROM plain": arrowReply(t, "n", 1),
+	}
+	// The trailer of a slow query arrives after its stream
+	slow := trailerReply(t, "n", `{"rows":1}`, 7)
+	slowTrailer := len(slow) - len(trailerFrame(`{"rows":1}`))
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			if cmd == "q:SELECT n FROM slow" {
+				conn.Write(slow[:slowTrailer])
+				time.Sleep(50 * time.Millisecond)
+				conn.Write(slow[slowTrailer:])
+				continue
+			}
+			conn.Write(replies[cmd])
+		}
+	})
+
+	var mu sync.Mutex
+	var notices []Notice
+	connector, err := NewConnectorWithOptions(addr, WithResultTrailers(true), WithNoticeHandler(func(n Notice) {
+		mu.Lock()
+		defer mu.Unlock()
+		notices = append(notices, n)
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	testCases := []struct {
+		query    string
+		expected ResultStats
+	}{
+		{
+			query: "SELECT n FROM t",
+			expected: ResultStats{
+				Rows:         2,
+				RowsAffected: -1,
+				Warnings:     []string{"column n is deprecated"},
+				Counters:     map[string]int64{"bytes_scanned": 128},
+			},
+		},
+		{query: "SELECT n FROM plain", expected: noStats},
+		{query: "SELECT n FROM slow", expected: ResultStats{Rows: 1, RowsAffected: -1}},
+	}
+
+	for _, tc := range testCases {
+		err := conn.Raw(func(driverConn any) error {
+			rows, err := driverConn.(driver.QueryerContext).QueryContext(context.Background(), tc.query, nil)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			if got := rows.(*Rows).Stats(); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("%s: expected stats %+v, got %+v", tc.query, tc.expected, got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: query failed: %v", tc.query, err)
+		}
+	}
+
+	// The trailer doesn't leave bytes behind for the next query
+	var n int64
+	if err := conn.QueryRowContext(context.Background(), "SELECT n FROM plain").Scan(&n); err != nil || n != 1 {
+		t.Errorf("expected 1 from the next query, got %d, %v", n, err)
+	}
+
+	expected := []Notice{{Query: "SELECT n FROM t", Message: "column n is deprecated"}}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(notices, expected) {
+		t.Errorf("expected notices %+v, got %+v", expected, notices)
+	}
+}
+
+func TestExecTrailer(t *testing.T) {
+	replies := map[string][]byte{
+		"x:UPDATE t SET a = 1": trailerReply(t, "Count", `{"rows_affected":4}`, 3),
+		// A trailer that arrived after its stream was read is skipped by the next command
+		"x:DELETE FROM t": append(trailerFrame(`{"warnings":["late"]}`), ":2\r\n"...),
+	}
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			conn.Write(replies[cmd])
+		}
+	})
+
+	var notices []Notice
+	connector, err := NewConnectorWithOptions(addr, WithResultTrailers(true), WithNoticeHandler(func(n Notice) {
+		notices = append(notices, n)
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	testCases := []struct {
+		query    string
+		expected int64
+	}{
+		{"UPDATE t SET a = 1", 4},
+		{"DELETE FROM t", 2},
+	}
+
+	for _, tc := range testCases {
+		res, err := db.Exec(tc.query)
+		if err != nil {
+			t.Fatalf("%s: exec failed: %v", tc.query, err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n != tc.expected {
+			t.Errorf("%s: expected %d rows affected, got %d, %v", tc.query, tc.expected, n, err)
+		}
+	}
+
+	if expected := []Notice{{Message: "late"}}; !reflect.DeepEqual(notices, expected) {
+		t.Errorf("expected notices %+v, got %+v", expected, notices)
+	}
+}
+
+func TestMalformedTrailer(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			if _, err := readCommand(reader); err != nil {
+				return
+			}
+			conn.Write(trailerReply(t, "n", `{"rows":`, 1))
+		}
+	})
+
+	conn := connectFake(t, addr+"?result_trailers=true")
+
+	if _, err := conn.QueryContext(context.Background(), "SELECT n FROM t", nil); err == nil {
+		t.Fatal("expected an error for a malformed trailer")
+	}
+	if conn.IsValid() {
+		t.Error("expected connection to be invalid")
+	}
+}
+
+func TestResultTrailersDisabled(t *testing.T) {
+	// The stream announces a trailer that the server never sends
+	announced := announceTrailer(arrow.NewSchema([]arrow.Field{{Name: "n", Type: arrow.PrimitiveTypes.Int64}}, nil))
+	rec := newTestRecord(t, announced.Field(0))
+	defer rec.Release()
+	var reply bytes.Buffer
+	writeArrowReply(&reply, announced, rec)
+
+	addr, _ := newReplyServer(t,
+		fakeReply{match: "SELECT", reply: reply.Bytes()},
+		fakeReply{match: "DELETE", reply: append(trailerFrame(`{"rows_affected":2}`), ":2\r\n"...)},
+	)
+	conn := connectFake(t, addr)
+
+	// The announcement is ignored
+	dr, err := conn.QueryContext(context.Background(), "SELECT n FROM t", nil)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if stats := dr.(*Rows).Stats(); !reflect.DeepEqual(stats, noStats) {
+		t.Errorf("expected no stats, got %+v", stats)
+	}
+	dr.Close()
+
+	// A trailer frame is an unknown response
+	if _, err := conn.ExecContext(context.Background(), "DELETE FROM t", nil); err == nil || !strings.Contains(err.Error(), "unknown response type: |") {
+		t.Errorf("expected an unknown response error, got %v", err)
+	}
+}
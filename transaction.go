@@ -1,20 +1,141 @@
 package luna
 
-import "context"
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TxMode selects how transactions are run.
+type TxMode int
+
+const (
+	// TxServer sends BEGIN, each statement and COMMIT as separate commands. The
+	// server evaluates commands independently, so the transaction doesn't span
+	// them. This is the default.
+	TxServer TxMode = iota
+	// TxBatch buffers the statements executed in a transaction and sends them on
+	// Commit as a single command, wrapped in BEGIN and COMMIT, so that they're
+	// applied atomically. Rollback drops them without sending anything.
+	TxBatch
+)
+
+// txModes maps the values of the tx_mode DSN parameter to transaction modes.
+var txModes = map[string]TxMode{
+	"server": TxServer,
+	"batch":  TxBatch,
+}
+
+func parseTxModeParam(v string, dst *TxMode) error {
+	mode, ok := txModes[v]
+	if !ok {
+		return fmt.Errorf("must be server or batch")
+	}
+	*dst = mode
+	return nil
+}
+
+// ErrBatchedQuery is returned for queries run in a transaction in TxBatch mode,
+// whose statements aren't sent before Commit.
+var ErrBatchedQuery = errors.New("luna: queries can't run in a batched transaction")
+
+// batchedResult is the result of a statement buffered by a transaction in
+// TxBatch mode, which hasn't run yet.
+type batchedResult struct{}
+
+func (batchedResult) LastInsertId() (int64, error) {
+	return 0, errors.New("luna: the statement runs when the batched transaction commits")
+}
+
+func (batchedResult) RowsAffected() (int64, error) {
+	return 0, errors.New("luna: the statement runs when the batched transaction commits")
+}
+
+// batchCommand joins the statements of a batched transaction into a single
+// command. Semicolons go on lines of their own, so that a statement ending with
+// a comment can't swallow them.
+func batchCommand(statements []string) string {
+	parts := make([]string, 0, len(statements)+1)
+	for _, s := range statements {
+		parts = append(parts, splitSQLStatements(s)...)
+	}
+	parts = append(parts, "COMMIT")
+	return strings.Join(parts, "\n;\n")
+}
+
+// commitBatch sends the statements buffered by a transaction in TxBatch mode as
+// a single command, and passes its outcome to the AfterQuery hooks of each of
+// them. The statements went through the BeforeQuery hooks and dialect
+// translation when they were buffered, so the command goes through neither.
+// The caller must hold c.mu.
+func (c *Conn) commitBatch(ctx context.Context) error {
+	// The batch starts with its BEGIN statement
+	batch, events := c.batch, c.batchEvents
+	c.batch, c.batchEvents = nil, nil
+	if len(batch) <= 1 {
+		// Nothing is sent, so schedule the probe skipped during the transaction
+		c.armIdleProbe()
+		return nil
+	}
+	if c.closed || c.bad {
+		return errBadConn
+	}
+
+	_, err := c.sendExec(ctx, batchCommand(batch))
+	for _, e := range events {
+		// The server only reports the rows affected by the batch as a whole
+		c.afterQuery(ctx, e, 0, err)
+	}
+	return err
+}
+
+// ErrIsolationLevel is returned by BeginTx for isolation levels the server
+// doesn't provide.
+var ErrIsolationLevel = errors.New("luna: unsupported isolation level")
+
+// beginStatement returns the statement starting a transaction with opts. The
+// server runs every transaction at snapshot isolation, where a write conflict
+// fails the transaction, so it's used for the default, snapshot and
+// serializable levels; the other levels are refused.
+func beginStatement(opts driver.TxOptions) (string, error) {
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelSnapshot, sql.LevelSerializable:
+	default:
+		return "", fmt.Errorf("%w: %v", ErrIsolationLevel, sql.IsolationLevel(opts.Isolation))
+	}
+
+	if opts.ReadOnly {
+		return "BEGIN TRANSACTION READ ONLY", nil
+	}
+	return "BEGIN TRANSACTION", nil
+}
 
 type tx struct {
 	c *Conn
 }
 
-// TODO: Since Luna server may not support transactions, we might need to simulate them client-side.
 // Implements the driver.Tx interface.
 func (t *tx) Commit() error {
-	if t.c == nil || !t.c.tx {
+	if t.c == nil {
+		panic("database/sql/driver: misuse of duckdb driver: extra Commit")
+	}
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.tx {
 		panic("database/sql/driver: misuse of duckdb driver: extra Commit")
 	}
 
-	t.c.tx = false
-	_, err := t.c.ExecContext(context.Background(), "COMMIT TRANSACTION", nil)
+	c.tx = false
+	var err error
+	if c.txMode == TxBatch {
+		err = c.commitBatch(context.Background())
+	} else {
+		_, err = c.hookedExec(context.Background(), "COMMIT TRANSACTION")
+	}
 	t.c = nil
 
 	return err
@@ -22,12 +143,25 @@ func (t *tx) Commit() error {
 
 // Implements the driver.Tx interface.
 func (t *tx) Rollback() error {
-	if t.c == nil || !t.c.tx {
+	if t.c == nil {
+		panic("database/sql/driver: misuse of duckdb driver: extra Rollback")
+	}
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.tx {
 		panic("database/sql/driver: misuse of duckdb driver: extra Rollback")
 	}
 
-	t.c.tx = false
-	_, err := t.c.ExecContext(context.Background(), "ROLLBACK", nil)
+	c.tx = false
+	var err error
+	if c.txMode == TxBatch {
+		c.batch, c.batchEvents = nil, nil
+		// Nothing is sent, so schedule the probe skipped during the transaction
+		c.armIdleProbe()
+	} else {
+		_, err = c.hookedExec(context.Background(), "ROLLBACK")
+	}
 	t.c = nil
 
 	return err
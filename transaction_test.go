@@ -0,0 +1,294 @@
+package luna
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBeginTxOptions(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			for range statementCommands(cmd) {
+				conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	})
+
+	db, err := sql.Open("luna", addr)
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	testCases := []struct {
+		name     string
+		opts     *sql.TxOptions
+		expected string
+		err      bool
+	}{
+		{name: "default", opts: nil, expected: "x:BEGIN TRANSACTION"},
+		{name: "snapshot", opts: &sql.TxOptions{Isolation: sql.LevelSnapshot}, expected: "x:BEGIN TRANSACTION"},
+		{name: "serializable", opts: &sql.TxOptions{Isolation: sql.LevelSerializable}, expected: "x:BEGIN TRANSACTION"},
+		{name: "read only", opts: &sql.TxOptions{ReadOnly: true}, expected: "x:BEGIN TRANSACTION READ ONLY"},
+		{name: "read committed", opts: &sql.TxOptions{Isolation: sql.LevelReadCommitted}, err: true},
+		{name: "linearizable", opts: &sql.TxOptions{Isolation: sql.LevelLinearizable, ReadOnly: true}, err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx, err := db.BeginTx(context.Background(), tc.opts)
+			if tc.err {
+				if !errors.Is(err, ErrIsolationLevel) {
+					t.Fatalf("expected ErrIsolationLevel, got %v", err)
+				}
+				select {
+				case cmd := <-commands:
+					t.Errorf("expected no command, got %q", cmd)
+				default:
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BeginTx failed: %v", err)
+			}
+			if cmd := <-commands; cmd != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, cmd)
+			}
+
+			if err := tx.Rollback(); err != nil {
+				t.Fatalf("Rollback failed: %v", err)
+			}
+			if cmd := <-commands; cmd != "x:ROLLBACK" {
+				t.Errorf("expected x:ROLLBACK, got %q", cmd)
+			}
+		})
+	}
+}
+
+func TestBatchTransaction(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			for range statementCommands(cmd) {
+				conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	})
+
+	db, err := sql.Open("luna", addr+"?tx_mode=batch")
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Nothing is sent before Commit
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	for _, query := range []string{
+		"INSERT INTO t VALUES (1);",
+		"UPDATE t SET a = 2 -- bump",
+	} {
+		res, err := tx.Exec(query)
+		if err != nil {
+			t.Fatalf("%s: Exec failed: %v", query, err)
+		}
+		if _, err := res.RowsAffected(); err == nil {
+			t.Errorf("%s: expected rows affected to be unknown before Commit", query)
+		}
+	}
+	if _, err := tx.Query("SELECT a FROM t"); !errors.Is(err, ErrBatchedQuery) {
+		t.Errorf("expected ErrBatchedQuery, got %v", err)
+	}
+	select {
+	case cmd := <-commands:
+		t.Fatalf("expected no command before Commit, got %q", cmd)
+	default:
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	expected := "x:BEGIN TRANSACTION\n;\nINSERT INTO t VALUES (1)\n;\nUPDATE t SET a = 2 -- bump\n;\nCOMMIT"
+	if cmd := <-commands; cmd != expected {
+		t.Errorf("expected %q, got %q", expected, cmd)
+	}
+
+	// Rollback drops the statements, and empty transactions send nothing
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM t"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Statements outside transactions still run right away
+	if _, err := db.Exec("DELETE FROM t"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if cmd := <-commands; cmd != "x:DELETE FROM t" {
+		t.Errorf("expected x:DELETE FROM t, got %q", cmd)
+	}
+}
+
+func TestBatchTransactionIdlePing(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			commands <- cmd
+			for range statementCommands(cmd) {
+				conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	})
+
+	connector, err := NewConnector(addr+"?tx_mode=batch&idle_ping_interval=30s", nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	conn := dc.(*Conn)
+	ctx := context.Background()
+
+	// The connection idles between BeginTx and Commit without being pinged,
+	// which would fail with ErrBatchedQuery and discard the transaction
+	tx, err := conn.BeginTx(ctx, driver.TxOptions{})
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO t VALUES (1)", nil); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	clock.Advance(time.Minute)
+	if n := clock.Pending(); n != 0 {
+		t.Errorf("expected no probe to be scheduled in the transaction, %d pending", n)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	expected := "x:BEGIN TRANSACTION\n;\nINSERT INTO t VALUES (1)\n;\nCOMMIT"
+	if cmd := <-commands; cmd != expected {
+		t.Errorf("expected %q, got %q", expected, cmd)
+	}
+	if !conn.IsValid() {
+		t.Error("expected the connection to stay valid")
+	}
+
+	// A rolled back transaction sends nothing, but still schedules the next probe
+	tx, err = conn.BeginTx(ctx, driver.TxOptions{})
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	clock.Advance(time.Minute)
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if n := clock.Pending(); n != 1 {
+		t.Fatalf("expected a probe after the transaction, %d pending", n)
+	}
+	clock.Advance(30 * time.Second)
+	if cmd := <-commands; cmd != "q:SELECT 1" {
+		t.Errorf("expected a ping, got %q", cmd)
+	}
+}
+
+func TestBatchTransactionConcurrentProbe(t *testing.T) {
+	addr := newFakeServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			cmd, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			for range statementCommands(cmd) {
+				conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	})
+
+	connector, err := NewConnector(addr+"?tx_mode=batch&idle_ping_interval=30s", nil)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	clock := newFakeClock()
+	connector.clock = clock
+
+	dc, err := connector.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer dc.Close()
+	conn := dc.(*Conn)
+	ctx := context.Background()
+
+	// Idle probes fire while transactions begin and end, which the race
+	// detector checks
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			clock.Advance(30 * time.Second)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		tx, err := conn.BeginTx(ctx, driver.TxOptions{})
+		if err != nil {
+			t.Fatalf("BeginTx failed: %v", err)
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO t VALUES (1)", nil); err != nil {
+			t.Fatalf("Exec failed: %v", err)
+		}
+		if i%2 == 0 {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatalf("transaction %d failed: %v", i, err)
+		}
+	}
+	<-done
+	if !conn.IsValid() {
+		t.Error("expected the connection to stay valid")
+	}
+}
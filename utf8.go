@@ -0,0 +1,57 @@
+package luna
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// UTF8Mode selects how Rows handles string values that aren't valid UTF-8, e.g.
+// from read_csv over dirty files.
+type UTF8Mode int
+
+const (
+	// UTF8Unchecked returns strings as the server sent them, valid or not. This is
+	// the default.
+	UTF8Unchecked UTF8Mode = iota
+	// UTF8Strict fails Next with an error on the first invalid string.
+	UTF8Strict
+	// UTF8Replace replaces each run of invalid bytes with the replacement
+	// character U+FFFD.
+	UTF8Replace
+)
+
+// utf8Modes maps the values of the utf8_mode DSN parameter to UTF-8 modes.
+var utf8Modes = map[string]UTF8Mode{
+	"off":     UTF8Unchecked,
+	"strict":  UTF8Strict,
+	"replace": UTF8Replace,
+}
+
+func parseUTF8ModeParam(v string, dst *UTF8Mode) error {
+	mode, ok := utf8Modes[v]
+	if !ok {
+		return fmt.Errorf("must be off, strict or replace")
+	}
+	*dst = mode
+	return nil
+}
+
+// stringValue checks or sanitizes a string value according to mode.
+func stringValue(s string, mode UTF8Mode) (string, error) {
+	if mode == UTF8Unchecked || utf8.ValidString(s) {
+		return s, nil
+	}
+
+	if mode == UTF8Replace {
+		return strings.ToValidUTF8(s, string(utf8.RuneError)), nil
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return "", fmt.Errorf("luna: string isn't valid UTF-8 at byte %d", i)
+		}
+		i += size
+	}
+	return s, nil
+}
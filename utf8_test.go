@@ -0,0 +1,86 @@
+package luna
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestStringValue(t *testing.T) {
+	testCases := []struct {
+		in       string
+		mode     UTF8Mode
+		expected string
+		err      string
+	}{
+		{in: "caf\xe9", mode: UTF8Unchecked, expected: "caf\xe9"},
+		{in: "café", mode: UTF8Strict, expected: "café"},
+		{in: "caf\xe9 ok", mode: UTF8Strict, err: "at byte 3"},
+		{in: "�\xff", mode: UTF8Strict, err: "at byte 3"},
+		{in: "caf\xe9 ok", mode: UTF8Replace, expected: "caf� ok"},
+		{in: "a\xff\xfeb", mode: UTF8Replace, expected: "a�b"},
+	}
+
+	for _, tc := range testCases {
+		got, err := stringValue(tc.in, tc.mode)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("stringValue(%q, %v): expected an error containing %q, got %v", tc.in, tc.mode, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("stringValue(%q, %v) failed: %v", tc.in, tc.mode, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("stringValue(%q, %v): expected %q, got %q", tc.in, tc.mode, tc.expected, got)
+		}
+	}
+}
+
+func TestRowsUTF8Mode(t *testing.T) {
+	newRows := func(mode UTF8Mode) *Rows {
+		schema := arrow.NewSchema([]arrow.Field{
+			{Name: "s", Type: arrow.BinaryTypes.String},
+			{Name: "ls", Type: arrow.BinaryTypes.LargeString},
+			{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+		}, nil)
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		defer b.Release()
+		b.Field(0).(*array.StringBuilder).Append("ok")
+		b.Field(1).(*array.LargeStringBuilder).Append("bad\xff")
+		lb := b.Field(2).(*array.ListBuilder)
+		lb.Append(true)
+		lb.ValueBuilder().(*array.StringBuilder).Append("x\xfe")
+
+		rec := b.NewRecord()
+		rows := newRowsFromArrow(rec.Schema(), []arrow.Record{rec})
+		rows.utf8Mode = mode
+		rows.nestedMode = NestedAsGo
+		return rows
+	}
+
+	rows := newRows(UTF8Strict)
+	defer rows.Close()
+	dest := make([]driver.Value, 3)
+	if err := rows.Next(dest); err == nil || !strings.Contains(err.Error(), "valid UTF-8") {
+		t.Errorf("strict: expected an invalid UTF-8 error, got %v", err)
+	}
+
+	rows = newRows(UTF8Replace)
+	defer rows.Close()
+	if err := rows.Next(dest); err != nil {
+		t.Fatalf("replace: Next failed: %v", err)
+	}
+	if dest[0] != "ok" || dest[1] != "bad�" {
+		t.Errorf("replace: expected ok and bad�, got %q and %q", dest[0], dest[1])
+	}
+	if tags, ok := dest[2].([]any); !ok || len(tags) != 1 || tags[0] != "x�" {
+		t.Errorf("replace: expected nested strings to be replaced too, got %#v", dest[2])
+	}
+}
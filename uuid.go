@@ -0,0 +1,115 @@
+package luna
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/arrow/go/v17/arrow"
+)
+
+// UUIDMode selects how Rows returns the values of UUID columns, which arrive as
+// 16-byte fixed-size binary columns.
+type UUIDMode int
+
+const (
+	// UUIDAsString returns each UUID in its canonical text form, e.g.
+	// "f81d4fae-7dec-11d0-a765-00a0c91e6bf6", which scans into a string or a
+	// github.com/google/uuid.UUID. This is the default.
+	UUIDAsString UUIDMode = iota
+	// UUIDAsBytes returns each UUID as a [16]byte.
+	UUIDAsBytes
+)
+
+// uuidModes maps the values of the uuid_mode DSN parameter to UUID modes.
+var uuidModes = map[string]UUIDMode{
+	"string": UUIDAsString,
+	"bytes":  UUIDAsBytes,
+}
+
+var scanTypeUUID = reflect.TypeOf([16]byte{})
+
+func parseUUIDModeParam(v string, dst *UUIDMode) error {
+	mode, ok := uuidModes[v]
+	if !ok {
+		return fmt.Errorf("must be string or bytes")
+	}
+	*dst = mode
+	return nil
+}
+
+// isUUIDType reports whether values of type t are UUIDs: a [16]byte, or a type
+// based on it such as github.com/google/uuid.UUID.
+func isUUIDType(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Len() == 16 && t.Elem().Kind() == reflect.Uint8
+}
+
+// formatUUID returns the canonical text form of a UUID.
+func formatUUID(u [16]byte) string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// fixedSizeBinaryScanType returns the Go type of the values of a fixed-size
+// binary column, which are UUIDs if they're 16 bytes long, or nil if dt isn't a
+// fixed-size binary type.
+func fixedSizeBinaryScanType(dt arrow.DataType, mode UUIDMode) reflect.Type {
+	fsb, ok := dt.(*arrow.FixedSizeBinaryType)
+	if !ok {
+		return nil
+	}
+	if fsb.ByteWidth != 16 {
+		return scanTypeBytes
+	}
+	if mode == UUIDAsBytes {
+		return scanTypeUUID
+	}
+	return scanTypeString
+}
+
+// fixedSizeBinaryValue converts a fixed-size binary value, returning 16-byte
+// values as UUIDs in the given mode.
+func fixedSizeBinaryValue(b []byte, mode UUIDMode) any {
+	if len(b) != 16 {
+		return b
+	}
+
+	u := [16]byte(b)
+	if mode == UUIDAsBytes {
+		return u
+	}
+	return formatUUID(u)
+}
+
+// CheckNamedValue implements the driver.NamedValueChecker interface. It accepts
+// UUIDs given as a [16]byte, or a type based on it without a Value method, as
+// their canonical text form, and leaves other arguments to the default
+// database/sql conversions.
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nv.Value == nil {
+		return driver.ErrSkip
+	}
+	if _, ok := nv.Value.(driver.Valuer); ok {
+		return driver.ErrSkip
+	}
+
+	v := reflect.ValueOf(nv.Value)
+	if !isUUIDType(v.Type()) {
+		return driver.ErrSkip
+	}
+
+	var u [16]byte
+	reflect.Copy(reflect.ValueOf(&u).Elem(), v)
+	nv.Value = formatUUID(u)
+	return nil
+}
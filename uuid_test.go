@@ -0,0 +1,127 @@
+package luna
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+var testUUID = [16]byte{0xf8, 0x1d, 0x4f, 0xae, 0x7d, 0xec, 0x11, 0xd0, 0xa7, 0x65, 0x00, 0xa0, 0xc9, 0x1e, 0x6b, 0xf6}
+
+const testUUIDString = "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"
+
+func TestRowsUUIDColumns(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: &arrow.FixedSizeBinaryType{ByteWidth: 16}, Nullable: true},
+		{Name: "code", Type: &arrow.FixedSizeBinaryType{ByteWidth: 4}},
+	}, nil)
+
+	testCases := []struct {
+		name      string
+		mode      UUIDMode
+		scanTypes []reflect.Type
+		expected  [][]driver.Value
+	}{
+		{
+			name:      "string",
+			mode:      UUIDAsString,
+			scanTypes: []reflect.Type{scanTypeString, scanTypeBytes},
+			expected: [][]driver.Value{
+				{testUUIDString, []byte("abcd")},
+				{nil, []byte("wxyz")},
+			},
+		},
+		{
+			name:      "bytes",
+			mode:      UUIDAsBytes,
+			scanTypes: []reflect.Type{scanTypeUUID, scanTypeBytes},
+			expected: [][]driver.Value{
+				{testUUID, []byte("abcd")},
+				{nil, []byte("wxyz")},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+			b.Field(0).(*array.FixedSizeBinaryBuilder).AppendValues([][]byte{testUUID[:], nil}, []bool{true, false})
+			b.Field(1).(*array.FixedSizeBinaryBuilder).AppendValues([][]byte{[]byte("abcd"), []byte("wxyz")}, nil)
+			rec := b.NewRecord()
+			b.Release()
+
+			rows := newRowsFromArrow(schema, []arrow.Record{rec})
+			rows.uuidMode = tc.mode
+			defer rows.Close()
+
+			for i, expected := range tc.scanTypes {
+				if got := rows.ColumnTypeScanType(i); got != expected {
+					t.Errorf("column %d: expected scan type %v, got %v", i, expected, got)
+				}
+			}
+
+			for i, expected := range tc.expected {
+				dest := make([]driver.Value, len(expected))
+				if err := rows.Next(dest); err != nil {
+					t.Fatalf("row %d: Next failed: %v", i, err)
+				}
+				if !reflect.DeepEqual(dest, expected) {
+					t.Errorf("row %d: expected %v, got %v", i, expected, dest)
+				}
+			}
+		})
+	}
+}
+
+type testUUIDType [16]byte
+
+type testUUIDValuer [16]byte
+
+func (u testUUIDValuer) Value() (driver.Value, error) {
+	return "valuer", nil
+}
+
+func TestCheckNamedValueUUID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    any
+		expected any
+		err      error
+	}{
+		{name: "array", value: testUUID, expected: testUUIDString},
+		{name: "named type", value: testUUIDType(testUUID), expected: testUUIDString},
+		{name: "valuer", value: testUUIDValuer(testUUID), err: driver.ErrSkip},
+		{name: "other array", value: [4]byte{}, err: driver.ErrSkip},
+		{name: "string", value: "x", err: driver.ErrSkip},
+		{name: "nil", value: nil, err: driver.ErrSkip},
+	}
+
+	conn := &Conn{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nv := &driver.NamedValue{Ordinal: 1, Value: tc.value}
+			err := conn.CheckNamedValue(nv)
+			if err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if err == nil && nv.Value != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, nv.Value)
+			}
+		})
+	}
+}
+
+func TestTempTableUUIDField(t *testing.T) {
+	query, err := valuesQuery([]struct{ ID testUUIDType }{{testUUIDType(testUUID)}})
+	if err != nil {
+		t.Fatalf("valuesQuery failed: %v", err)
+	}
+	if !strings.Contains(query, "CAST(c1 AS UUID)") || !strings.Contains(query, "'"+testUUIDString+"'") {
+		t.Errorf("unexpected query %s", query)
+	}
+}